@@ -0,0 +1,190 @@
+package llmcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	anthropicBaseURL          = "https://api.anthropic.com/v1"
+	anthropicVersion          = "2023-06-01"
+	anthropicDefaultMaxTokens = 1024
+)
+
+// ProviderCacheUsage records the provider-side prompt caching reported for a
+// live response, so local and provider caching can be reasoned about together.
+type ProviderCacheUsage struct {
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// anthropicClient sends chat completion requests for Claude models to the
+// Anthropic Messages API, translating requests and responses to and from the
+// OpenAI types used everywhere else.
+type anthropicClient struct {
+	apiKey     string
+	baseURL    string
+	httpClient *http.Client
+	// cacheSystemPrompt marks the system prompt with cache_control so that
+	// Anthropic caches it provider-side.
+	cacheSystemPrompt bool
+}
+
+func newAnthropicClient(apiKey string) *anthropicClient {
+	return &anthropicClient{apiKey: apiKey, baseURL: anthropicBaseURL, httpClient: http.DefaultClient}
+}
+
+func isAnthropicModel(model string) bool {
+	return strings.HasPrefix(model, "claude")
+}
+
+type anthropicCacheControl struct {
+	Type string `json:"type"`
+}
+
+type anthropicTextBlock struct {
+	Type         string                 `json:"type"`
+	Text         string                 `json:"text"`
+	CacheControl *anthropicCacheControl `json:"cache_control,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type anthropicRequest struct {
+	Model         string               `json:"model"`
+	MaxTokens     int                  `json:"max_tokens"`
+	System        []anthropicTextBlock `json:"system,omitempty"`
+	Messages      []anthropicMessage   `json:"messages"`
+	Temperature   *float32             `json:"temperature,omitempty"`
+	TopP          float32              `json:"top_p,omitempty"`
+	StopSequences []string             `json:"stop_sequences,omitempty"`
+}
+
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+type anthropicResponse struct {
+	ID         string               `json:"id"`
+	Model      string               `json:"model"`
+	Content    []anthropicTextBlock `json:"content"`
+	StopReason string               `json:"stop_reason"`
+	Usage      anthropicUsage       `json:"usage"`
+}
+
+type anthropicErrorResponse struct {
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (a *anthropicClient) translateRequest(req openai.ChatCompletionRequest) anthropicRequest {
+	out := anthropicRequest{
+		Model:         req.Model,
+		MaxTokens:     req.MaxTokens,
+		TopP:          req.TopP,
+		StopSequences: req.Stop,
+	}
+	if out.MaxTokens == 0 {
+		out.MaxTokens = anthropicDefaultMaxTokens
+	}
+	if req.Temperature != 0 {
+		temperature := req.Temperature
+		out.Temperature = &temperature
+	}
+	for _, m := range req.Messages {
+		if m.Role == openai.ChatMessageRoleSystem {
+			out.System = append(out.System, anthropicTextBlock{Type: "text", Text: m.Content})
+			continue
+		}
+		out.Messages = append(out.Messages, anthropicMessage{Role: m.Role, Content: m.Content})
+	}
+	if a.cacheSystemPrompt && len(out.System) > 0 {
+		out.System[len(out.System)-1].CacheControl = &anthropicCacheControl{Type: "ephemeral"}
+	}
+	return out
+}
+
+func translateAnthropicResponse(resp anthropicResponse) openai.ChatCompletionResponse {
+	var text strings.Builder
+	for _, block := range resp.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+	finish := openai.FinishReasonStop
+	if resp.StopReason == "max_tokens" {
+		finish = openai.FinishReasonLength
+	}
+	return openai.ChatCompletionResponse{
+		ID:     resp.ID,
+		Object: "chat.completion",
+		Model:  resp.Model,
+		Choices: []openai.ChatCompletionChoice{{
+			Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: text.String()},
+			FinishReason: finish,
+		}},
+		Usage: openai.Usage{
+			PromptTokens:     resp.Usage.InputTokens,
+			CompletionTokens: resp.Usage.OutputTokens,
+			TotalTokens:      resp.Usage.InputTokens + resp.Usage.OutputTokens,
+		},
+	}
+}
+
+// createChatCompletion sends req to the Messages API and returns the response
+// in OpenAI form, along with the provider-side cache usage Anthropic reported.
+func (a *anthropicClient) createChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, *ProviderCacheUsage, error) {
+	body, err := json.Marshal(a.translateRequest(req))
+	if err != nil {
+		return openai.ChatCompletionResponse{}, nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, a.baseURL+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return openai.ChatCompletionResponse{}, nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", a.apiKey)
+	httpReq.Header.Set("anthropic-version", anthropicVersion)
+
+	httpResp, err := a.httpClient.Do(httpReq)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, nil, err
+	}
+	defer httpResp.Body.Close()
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, nil, err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		var apiErr anthropicErrorResponse
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Message != "" {
+			return openai.ChatCompletionResponse{}, nil, &openai.APIError{HTTPStatusCode: httpResp.StatusCode, Type: apiErr.Error.Type, Message: apiErr.Error.Message}
+		}
+		return openai.ChatCompletionResponse{}, nil, fmt.Errorf("anthropic: unexpected status %s", httpResp.Status)
+	}
+
+	var resp anthropicResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return openai.ChatCompletionResponse{}, nil, fmt.Errorf("anthropic: parsing response: %w", err)
+	}
+	usage := &ProviderCacheUsage{
+		CacheCreationInputTokens: resp.Usage.CacheCreationInputTokens,
+		CacheReadInputTokens:     resp.Usage.CacheReadInputTokens,
+	}
+	return translateAnthropicResponse(resp), usage, nil
+}
@@ -0,0 +1,60 @@
+package llmcache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnthropicAdapterRecordsProviderCache(t *testing.T) {
+	var got anthropicRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/messages", r.URL.Path)
+		assert.Equal(t, "test-anthropic-key", r.Header.Get("x-api-key"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		json.NewEncoder(w).Encode(anthropicResponse{
+			ID:         "msg_1",
+			Model:      "claude-3-5-haiku-20241022",
+			Content:    []anthropicTextBlock{{Type: "text", Text: "Paris."}},
+			StopReason: "end_turn",
+			Usage:      anthropicUsage{InputTokens: 12, OutputTokens: 3, CacheCreationInputTokens: 1500, CacheReadInputTokens: 0},
+		})
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, nil)
+	client.anthropic = &anthropicClient{apiKey: "test-anthropic-key", baseURL: server.URL, httpClient: server.Client(), cacheSystemPrompt: true}
+
+	req := openai.ChatCompletionRequest{
+		Model: "claude-3-5-haiku-20241022",
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: "You are a geography tutor."},
+			{Role: openai.ChatMessageRoleUser, Content: "Capital of France?"},
+		},
+	}
+	response, cached, err := client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, "Paris.", response)
+
+	assert.Equal(t, anthropicDefaultMaxTokens, got.MaxTokens)
+	assert.Len(t, got.Messages, 1)
+	if assert.Len(t, got.System, 1) {
+		assert.Equal(t, &anthropicCacheControl{Type: "ephemeral"}, got.System[0].CacheControl)
+	}
+
+	stats := client.Stats()
+	assert.Equal(t, 12, stats.PromptTokens)
+	assert.Equal(t, 1500, stats.ProviderCacheCreationTokens)
+
+	cache, err := client.store.Load()
+	assert.NoError(t, err)
+	hash, err := generateHash(req)
+	assert.NoError(t, err)
+	assert.Equal(t, &ProviderCacheUsage{CacheCreationInputTokens: 1500}, cache.Responses[hash].ProviderCache)
+}
@@ -0,0 +1,194 @@
+package llmcache
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// archiveStore is a file store that is restored from a gzipped tarball
+// before its first use, unless the cache file already exists, and saved back
+// to it when closed. CI systems that persist single files between runs, such
+// as the GitHub Actions cache or build artifacts, can then carry the cache
+// from one run to the next.
+type archiveStore struct {
+	files    *fileStore
+	archive  string
+	restored bool
+}
+
+func newArchiveStore(archive, path string) *archiveStore {
+	return &archiveStore{files: newFileStore(path), archive: archive}
+}
+
+// restore extracts the cache file from the archive, once. A missing archive,
+// on the first run, is not an error.
+func (s *archiveStore) restore() error {
+	if s.restored {
+		return nil
+	}
+	s.restored = true
+	if _, err := os.Stat(s.files.path); err == nil {
+		return nil
+	}
+	f, err := os.Open(s.archive)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	data, err := readArchivedCache(f, filepath.Base(s.files.path))
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrCacheCorrupt, s.archive, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(s.files.path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.files.path, data, 0644)
+}
+
+// readArchivedCache returns the file called name in the gzipped tarball r.
+func readArchivedCache(r io.Reader, name string) ([]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no %s in the archive", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg && filepath.Base(header.Name) == name {
+			return io.ReadAll(tr)
+		}
+	}
+}
+
+// save writes the cache file to the archive, as a gzipped tarball holding it
+// alone.
+func (s *archiveStore) save() error {
+	info, err := os.Stat(s.files.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(s.files.path)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	header := &tar.Header{Name: filepath.Base(s.files.path), Mode: 0644, Size: int64(len(data)), ModTime: info.ModTime()}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := tw.Write(data); err != nil {
+		return err
+	}
+	if err := errors.Join(tw.Close(), gz.Close()); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.archive), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(s.archive, buf.Bytes(), 0644)
+}
+
+func (s *archiveStore) Load() (*Cache, error) {
+	if err := s.restore(); err != nil {
+		return nil, err
+	}
+	return s.files.Load()
+}
+
+func (s *archiveStore) Save(cache *Cache) error {
+	if err := s.restore(); err != nil {
+		return err
+	}
+	return s.files.Save(cache)
+}
+
+func (s *archiveStore) Flush() error {
+	return s.files.Flush()
+}
+
+// Close saves the archive, unless the store is read-only or was never used,
+// and releases the file store.
+func (s *archiveStore) Close() error {
+	var err error
+	if s.restored && !s.files.readOnly && !s.files.closed {
+		err = s.save()
+	}
+	return errors.Join(err, s.files.Close())
+}
+
+// manifestKey returns a cache key for CI caches that changes whenever one of
+// the files at paths, such as suite manifests, does: prefix followed by a
+// hash of their names and contents.
+func manifestKey(prefix string, paths []string) (string, error) {
+	sorted := append([]string{}, paths...)
+	sort.Strings(sorted)
+	hash := sha256.New()
+	for _, path := range sorted {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00", filepath.ToSlash(path), len(data))
+		hash.Write(data)
+	}
+	return prefix + "-" + hex.EncodeToString(hash.Sum(nil))[:16], nil
+}
+
+// runCacheKey prints the CI cache key of the given suite manifests, and sets
+// it as the key output of a GitHub Actions step.
+func runCacheKey(args []string) error {
+	fs := flag.NewFlagSet("cache-key", flag.ContinueOnError)
+	prefix := fs.String("prefix", "llm-test-cache", "Start the key with this, for restore-keys to fall back on")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: llm-test-cache cache-key [flags] SUITE.json...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("cache-key needs at least one suite")
+	}
+	key, err := manifestKey(*prefix, fs.Args())
+	if err != nil {
+		return err
+	}
+	if output := os.Getenv("GITHUB_OUTPUT"); output != "" {
+		f, err := os.OpenFile(output, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(f, "key=%s\n", key)
+		if err := errors.Join(err, f.Close()); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(console, key)
+	return report(map[string]string{"key": key})
+}
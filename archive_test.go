@@ -0,0 +1,77 @@
+package llmcache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiveStoreRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cache", "cache.json")
+	archive := filepath.Join(dir, "ci", "cache.tar.gz")
+
+	store := newArchiveStore(archive, path)
+	cache, err := store.Load()
+	assert.NoError(t, err)
+	assert.Empty(t, cache.Responses)
+	cache.Responses["abc"] = CacheEntry{Response: "hello"}
+	assert.NoError(t, store.Save(cache))
+	assert.NoError(t, store.Close())
+	assert.FileExists(t, archive)
+
+	// A fresh checkout restores the archive.
+	assert.NoError(t, os.Remove(path))
+	restored := newArchiveStore(archive, path)
+	cache, err = restored.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", cache.Responses["abc"].Response)
+	assert.NoError(t, restored.Close())
+
+	// An existing cache file wins over the archive.
+	assert.NoError(t, saveCacheTo(path, &Cache{Responses: map[string]CacheEntry{"def": {Response: "local"}}}))
+	local := newArchiveStore(archive, path)
+	cache, err = local.Load()
+	assert.NoError(t, err)
+	assert.NotContains(t, cache.Responses, "abc")
+	assert.NoError(t, local.Close())
+}
+
+func TestArchiveStoreRejectsCorruptArchive(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "cache.tar.gz")
+	assert.NoError(t, os.WriteFile(archive, []byte("not gzip"), 0644))
+	store := newArchiveStore(archive, filepath.Join(dir, "cache.json"))
+	_, err := store.Load()
+	assert.ErrorIs(t, err, ErrCacheCorrupt)
+	assert.NoError(t, store.Close())
+}
+
+func TestCacheKey(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.json"), filepath.Join(dir, "b.json")
+	assert.NoError(t, os.WriteFile(a, []byte(`{"cases":[]}`), 0644))
+	assert.NoError(t, os.WriteFile(b, []byte(`{"cases":[1]}`), 0644))
+
+	key, err := manifestKey("llm", []string{a, b})
+	assert.NoError(t, err)
+	assert.Regexp(t, `^llm-[0-9a-f]{16}$`, key)
+	swapped, err := manifestKey("llm", []string{b, a})
+	assert.NoError(t, err)
+	assert.Equal(t, key, swapped)
+
+	assert.NoError(t, os.WriteFile(b, []byte(`{"cases":[2]}`), 0644))
+	changed, err := manifestKey("llm", []string{a, b})
+	assert.NoError(t, err)
+	assert.NotEqual(t, key, changed)
+
+	output := filepath.Join(dir, "github-output")
+	t.Setenv("GITHUB_OUTPUT", output)
+	captureOutput(t)
+	assert.NoError(t, runCacheKey([]string{"-prefix", "llm", a, b}))
+	data, err := os.ReadFile(output)
+	assert.NoError(t, err)
+	assert.Equal(t, "key="+changed+"\n", string(data))
+}
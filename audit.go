@@ -0,0 +1,94 @@
+package llmcache
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// auditPromptLimit bounds how much of each prompt is written to the audit log.
+const auditPromptLimit = 200
+
+// auditRecord is one line of the audit log.
+type auditRecord struct {
+	Time             time.Time `json:"time"`
+	Event            string    `json:"event"`
+	Hash             string    `json:"hash"`
+	Model            string    `json:"model"`
+	Namespace        string    `json:"namespace,omitempty"`
+	Label            string    `json:"label,omitempty"`
+	Hit              bool      `json:"hit"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	LatencyMS        int64     `json:"latency_ms"`
+	Prompt           string    `json:"prompt"`
+	Error            string    `json:"error,omitempty"`
+}
+
+// auditLog appends a JSON line per request to a file, for compliance review
+// of what was sent to the API during test runs.
+type auditLog struct {
+	file *os.File
+	enc  *json.Encoder
+	err  error
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{file: file, enc: json.NewEncoder(file)}, nil
+}
+
+func truncatePrompt(prompt string, limit int) string {
+	runes := []rune(prompt)
+	if len(runes) <= limit {
+		return prompt
+	}
+	return string(runes[:limit]) + "…"
+}
+
+func (a *auditLog) record(e Event) {
+	if e.Kind == EntryEvicted || a.err != nil {
+		return
+	}
+	record := auditRecord{
+		Time:             e.Time,
+		Event:            e.Kind.String(),
+		Hash:             e.Hash,
+		Model:            e.Model,
+		Namespace:        e.Namespace,
+		Label:            e.Label,
+		Hit:              e.Kind == EntryServed,
+		PromptTokens:     e.Usage.PromptTokens,
+		CompletionTokens: e.Usage.CompletionTokens,
+		LatencyMS:        e.Latency.Milliseconds(),
+		Prompt:           truncatePrompt(e.Prompt, auditPromptLimit),
+	}
+	if e.Err != nil {
+		record.Error = e.Err.Error()
+	}
+	// Remember the first write error and report it from Close, since event
+	// callbacks have no way to return one.
+	a.err = a.enc.Encode(record)
+}
+
+func (a *auditLog) Close() error {
+	if err := a.file.Close(); a.err == nil {
+		a.err = err
+	}
+	return a.err
+}
+
+// EnableAuditLog appends a JSON line for every request the client answers or
+// fails to answer to the file at path. The file is closed by Close.
+func (c *CachingClient) EnableAuditLog(path string) error {
+	audit, err := openAuditLog(path)
+	if err != nil {
+		return err
+	}
+	c.audit = audit
+	c.OnEvent(audit.record)
+	return nil
+}
@@ -0,0 +1,41 @@
+package llmcache
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditLog(t *testing.T) {
+	req := openai.ChatCompletionRequest{
+		Model:    "gpt-3.5-turbo-0125",
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: strings.Repeat("long prompt ", 50)}},
+	}
+	hash, err := generateHash(req)
+	assert.NoError(t, err)
+	client := newTestClient(t, &Cache{Responses: map[string]CacheEntry{hash: {Response: "cached"}}})
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	assert.NoError(t, client.EnableAuditLog(path))
+	_, _, err = client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.NoError(t, client.Close())
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	assert.Len(t, lines, 1)
+
+	var record auditRecord
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &record))
+	assert.Equal(t, hash, record.Hash)
+	assert.Equal(t, "served", record.Event)
+	assert.True(t, record.Hit)
+	assert.Equal(t, auditPromptLimit+1, len([]rune(record.Prompt)), "prompt is truncated with a marker")
+}
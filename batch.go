@@ -0,0 +1,216 @@
+package llmcache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const batchEndpoint = "/v1/chat/completions"
+
+// batchRequest is one line of an OpenAI Batch API input file. The custom ID is
+// the cache key, so results can be matched back to their requests.
+type batchRequest struct {
+	CustomID string                       `json:"custom_id"`
+	Method   string                       `json:"method"`
+	URL      string                       `json:"url"`
+	Body     openai.ChatCompletionRequest `json:"body"`
+}
+
+// batchResult is one line of a Batch API output file.
+type batchResult struct {
+	CustomID string `json:"custom_id"`
+	Response *struct {
+		StatusCode int                           `json:"status_code"`
+		Body       openai.ChatCompletionResponse `json:"body"`
+	} `json:"response"`
+	Error *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// writeBatchRequests writes runs as Batch API input, one request per line.
+func writeBatchRequests(w io.Writer, runs []suiteRun) error {
+	enc := json.NewEncoder(w)
+	for _, run := range runs {
+		if err := enc.Encode(batchRequest{CustomID: run.Hash, Method: "POST", URL: batchEndpoint, Body: run.Request}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readBatchRequests(r io.Reader) (map[string]openai.ChatCompletionRequest, error) {
+	requests := make(map[string]openai.ChatCompletionRequest)
+	dec := json.NewDecoder(r)
+	for {
+		var req batchRequest
+		if err := dec.Decode(&req); err == io.EOF {
+			return requests, nil
+		} else if err != nil {
+			return nil, err
+		}
+		requests[req.CustomID] = req.Body
+	}
+}
+
+// importBatchResults adds the successful results read from r to cache, taking
+// each request from requests by its custom ID. Entries are built as a
+// recording by the client would be: normalized, fitted to the maximum entry
+// size and signed. Failed results and results for unknown requests are
+// reported and skipped.
+func (c *CachingClient) importBatchResults(cache *Cache, requests map[string]openai.ChatCompletionRequest, r io.Reader) (imported int, failures []string, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var result batchResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			return imported, failures, err
+		}
+		req, found := requests[result.CustomID]
+		switch {
+		case !found:
+			failures = append(failures, fmt.Sprintf("%s: not in the batch input", result.CustomID))
+			continue
+		case result.Error != nil:
+			failures = append(failures, fmt.Sprintf("%s: %s", result.CustomID, result.Error.Message))
+			continue
+		case result.Response == nil || result.Response.StatusCode != 200 || len(result.Response.Body.Choices) == 0:
+			failures = append(failures, fmt.Sprintf("%s: no successful response", result.CustomID))
+			continue
+		}
+		resp := result.Response.Body
+		c.normalizeChoices(&resp)
+		stored, ok := c.fitEntry(resp.Choices[0].Message.Content)
+		if !ok {
+			failures = append(failures, fmt.Sprintf("%s: response larger than the maximum entry size", result.CustomID))
+			continue
+		}
+		entry, err := c.newEntry(cache, c.namespace, req, stored, resp.Model)
+		if err != nil {
+			return imported, failures, err
+		}
+		if cache.Responses[result.CustomID], err = c.signed(result.CustomID, entry); err != nil {
+			return imported, failures, err
+		}
+		imported++
+	}
+	return imported, failures, scanner.Err()
+}
+
+func runBatch(args []string) error {
+	usage := "usage: llm-test-cache batch export [flags] SUITE.json OUT.jsonl | batch import [flags] IN.jsonl RESULTS.jsonl"
+	if len(args) == 0 || (args[0] != "export" && args[0] != "import") {
+		return errors.New(usage)
+	}
+	fs := flag.NewFlagSet("batch "+args[0], flag.ContinueOnError)
+	flags := addClientFlags(fs, true)
+	fs.Usage = func() {
+		if args[0] == "export" {
+			fmt.Fprintln(fs.Output(), "Usage: llm-test-cache batch export [flags] SUITE.json OUT.jsonl")
+		} else {
+			fmt.Fprintln(fs.Output(), "Usage: llm-test-cache batch import [flags] IN.jsonl RESULTS.jsonl")
+		}
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New(usage)
+	}
+	flags.keyOptional = true
+	client, err := flags.newClient(context.Background())
+	if err != nil {
+		return err
+	}
+	// Only the store is closed: closing the client would print the summary of
+	// an empty run.
+	defer client.store.Close()
+	if args[0] == "export" {
+		return exportBatch(client, fs.Arg(0), fs.Arg(1))
+	}
+	return importBatch(client, fs.Arg(0), fs.Arg(1))
+}
+
+// exportBatch writes the requests of the suite at suitePath that client hasn't
+// cached yet to out as Batch API input. The requests are written as client
+// would send them, redacted as its secret policy says, and a request with a
+// secret the policy blocks fails the export.
+func exportBatch(client *CachingClient, suitePath, out string) error {
+	suite, err := loadSuite(suitePath)
+	if err != nil {
+		return err
+	}
+	_, missing, err := client.planSuite(context.Background(), suite)
+	if err != nil {
+		return err
+	}
+	for i, run := range missing {
+		missing[i].Request = client.redactSecrets(run.Request)
+		if err := client.checkSecrets(missing[i].Request); err != nil {
+			return fmt.Errorf("case %s: %w", run.Case.Name, err)
+		}
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if err := writeBatchRequests(f, missing); err != nil {
+		f.Close()
+		return err
+	}
+	fmt.Fprintf(console, "Wrote %d requests to %s\n", len(missing), out)
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return report(map[string]any{"requests": len(missing), "file": out})
+}
+
+// importBatch caches the results of a batch created from the input file in,
+// as client would have recorded them.
+func importBatch(client *CachingClient, in, results string) error {
+	f, err := os.Open(in)
+	if err != nil {
+		return err
+	}
+	requests, err := readBatchRequests(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %w", in, err)
+	}
+
+	cache, err := client.store.Load()
+	if err != nil {
+		return err
+	}
+	rf, err := os.Open(results)
+	if err != nil {
+		return err
+	}
+	defer rf.Close()
+	imported, failures, err := client.importBatchResults(cache, requests, rf)
+	if err != nil {
+		return fmt.Errorf("%s: %w", results, err)
+	}
+	for _, failure := range failures {
+		fmt.Fprintf(console, "skipped %s\n", failure)
+	}
+	fmt.Fprintf(console, "Imported %d of %d requests\n", imported, len(requests))
+	if err := client.store.Save(cache); err != nil {
+		return err
+	}
+	return report(map[string]any{"imported": imported, "requests": len(requests), "skipped": append([]string{}, failures...)})
+}
@@ -0,0 +1,298 @@
+package llmcache
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const bedrockService = "bedrock"
+
+// awsCredentials are the static credentials requests are signed with.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// bedrockClient sends chat completion requests to the AWS Bedrock Converse
+// API. Requests are authenticated with a Bedrock API key when one is set, and
+// signed with Signature Version 4 otherwise.
+type bedrockClient struct {
+	region     string
+	baseURL    string
+	apiKey     string
+	creds      awsCredentials
+	httpClient *http.Client
+	now        func() time.Time
+}
+
+// newBedrockClientFromEnv returns a Bedrock client configured from the
+// standard AWS environment variables, or nil if no region or credentials are
+// set.
+func newBedrockClientFromEnv() *bedrockClient {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	b := &bedrockClient{
+		region:  region,
+		baseURL: fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", region),
+		apiKey:  os.Getenv("AWS_BEARER_TOKEN_BEDROCK"),
+		creds: awsCredentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		},
+		httpClient: http.DefaultClient,
+		now:        time.Now,
+	}
+	if region == "" || (b.apiKey == "" && (b.creds.AccessKeyID == "" || b.creds.SecretAccessKey == "")) {
+		return nil
+	}
+	return b
+}
+
+type bedrockContentBlock struct {
+	Text string `json:"text"`
+}
+
+type bedrockMessage struct {
+	Role    string                `json:"role"`
+	Content []bedrockContentBlock `json:"content"`
+}
+
+type bedrockInferenceConfig struct {
+	MaxTokens     int      `json:"maxTokens,omitempty"`
+	Temperature   *float32 `json:"temperature,omitempty"`
+	TopP          float32  `json:"topP,omitempty"`
+	StopSequences []string `json:"stopSequences,omitempty"`
+}
+
+type bedrockRequest struct {
+	Messages        []bedrockMessage        `json:"messages"`
+	System          []bedrockContentBlock   `json:"system,omitempty"`
+	InferenceConfig *bedrockInferenceConfig `json:"inferenceConfig,omitempty"`
+}
+
+type bedrockResponse struct {
+	Output struct {
+		Message bedrockMessage `json:"message"`
+	} `json:"output"`
+	StopReason string `json:"stopReason"`
+	Usage      struct {
+		InputTokens           int `json:"inputTokens"`
+		OutputTokens          int `json:"outputTokens"`
+		CacheReadInputTokens  int `json:"cacheReadInputTokens"`
+		CacheWriteInputTokens int `json:"cacheWriteInputTokens"`
+	} `json:"usage"`
+}
+
+func translateBedrockRequest(req openai.ChatCompletionRequest) bedrockRequest {
+	var out bedrockRequest
+	for _, m := range req.Messages {
+		if m.Role == openai.ChatMessageRoleSystem {
+			out.System = append(out.System, bedrockContentBlock{Text: m.Content})
+			continue
+		}
+		out.Messages = append(out.Messages, bedrockMessage{Role: m.Role, Content: []bedrockContentBlock{{Text: m.Content}}})
+	}
+	config := bedrockInferenceConfig{MaxTokens: req.MaxTokens, TopP: req.TopP, StopSequences: req.Stop}
+	if req.Temperature != 0 {
+		temperature := req.Temperature
+		config.Temperature = &temperature
+	}
+	if config.MaxTokens != 0 || config.Temperature != nil || config.TopP != 0 || len(config.StopSequences) > 0 {
+		out.InferenceConfig = &config
+	}
+	return out
+}
+
+func translateBedrockResponse(model string, resp bedrockResponse) openai.ChatCompletionResponse {
+	var text strings.Builder
+	for _, block := range resp.Output.Message.Content {
+		text.WriteString(block.Text)
+	}
+	finish := openai.FinishReasonStop
+	if resp.StopReason == "max_tokens" {
+		finish = openai.FinishReasonLength
+	}
+	return openai.ChatCompletionResponse{
+		Object: "chat.completion",
+		Model:  model,
+		Choices: []openai.ChatCompletionChoice{{
+			Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: text.String()},
+			FinishReason: finish,
+		}},
+		Usage: openai.Usage{
+			PromptTokens:     resp.Usage.InputTokens,
+			CompletionTokens: resp.Usage.OutputTokens,
+			TotalTokens:      resp.Usage.InputTokens + resp.Usage.OutputTokens,
+		},
+	}
+}
+
+// createChatCompletion sends req to the Converse API of the model named in
+// req.Model, e.g. "anthropic.claude-3-haiku-20240307-v1:0".
+func (b *bedrockClient) createChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, *ProviderCacheUsage, error) {
+	body, err := json.Marshal(translateBedrockRequest(req))
+	if err != nil {
+		return openai.ChatCompletionResponse{}, nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, b.baseURL+"/model/"+req.Model+"/converse", bytes.NewReader(body))
+	if err != nil {
+		return openai.ChatCompletionResponse{}, nil, err
+	}
+	// Model IDs contain colons, which Bedrock expects percent-encoded.
+	httpReq.URL.RawPath = "/model/" + awsURIEncode(req.Model) + "/converse"
+	httpReq.Header.Set("Content-Type", "application/json")
+	if b.apiKey != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+b.apiKey)
+	} else {
+		signV4(httpReq, body, b.creds, b.region, bedrockService, b.now())
+	}
+
+	httpResp, err := b.httpClient.Do(httpReq)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, nil, err
+	}
+	defer httpResp.Body.Close()
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, nil, err
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Message != "" {
+			return openai.ChatCompletionResponse{}, nil, &openai.APIError{HTTPStatusCode: httpResp.StatusCode, Type: httpResp.Header.Get("X-Amzn-ErrorType"), Message: apiErr.Message}
+		}
+		return openai.ChatCompletionResponse{}, nil, fmt.Errorf("bedrock: unexpected status %s", httpResp.Status)
+	}
+
+	var resp bedrockResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return openai.ChatCompletionResponse{}, nil, fmt.Errorf("bedrock: parsing response: %w", err)
+	}
+	var usage *ProviderCacheUsage
+	if resp.Usage.CacheReadInputTokens > 0 || resp.Usage.CacheWriteInputTokens > 0 {
+		usage = &ProviderCacheUsage{
+			CacheCreationInputTokens: resp.Usage.CacheWriteInputTokens,
+			CacheReadInputTokens:     resp.Usage.CacheReadInputTokens,
+		}
+	}
+	return translateBedrockResponse(req.Model, resp), usage, nil
+}
+
+// awsURIEncode percent-encodes everything but the unreserved characters, as
+// Signature Version 4 requires.
+func awsURIEncode(s string) string {
+	var out strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_' || ch == '.' || ch == '~' {
+			out.WriteByte(ch)
+			continue
+		}
+		fmt.Fprintf(&out, "%%%02X", ch)
+	}
+	return out.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// signV4 signs req, whose body is body, with AWS Signature Version 4. The host,
+// Content-Type and X-Amz-* headers are signed.
+func signV4(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if lower == "content-type" || strings.HasPrefix(lower, "x-amz-") {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	// Services other than S3 expect every path segment to be encoded twice.
+	segments := strings.Split(req.URL.EscapedPath(), "/")
+	for i, segment := range segments {
+		segments[i] = awsURIEncode(segment)
+	}
+	canonicalURI := strings.Join(segments, "/")
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var params []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			params = append(params, awsURIEncode(key)+"="+awsURIEncode(value))
+		}
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		strings.Join(params, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
@@ -0,0 +1,72 @@
+package llmcache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSignV4 checks the signer against the get-vanilla case of the AWS
+// Signature Version 4 test suite.
+func TestSignV4(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	assert.NoError(t, err)
+	creds := awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signV4(req, nil, creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	assert.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, "+
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		req.Header.Get("Authorization"))
+}
+
+func TestBedrockAdapter(t *testing.T) {
+	var got bedrockRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/model/anthropic.claude-3-haiku-20240307-v1%3A0/converse", r.URL.EscapedPath())
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDTEST/"))
+		assert.Equal(t, "session", r.Header.Get("X-Amz-Security-Token"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.Write([]byte(`{"output":{"message":{"role":"assistant","content":[{"text":"Paris."}]}},"stopReason":"end_turn","usage":{"inputTokens":10,"outputTokens":2}}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, nil)
+	client.bedrock = &bedrockClient{
+		region:     "us-east-1",
+		baseURL:    server.URL,
+		creds:      awsCredentials{AccessKeyID: "AKIDTEST", SecretAccessKey: "secret", SessionToken: "session"},
+		httpClient: server.Client(),
+		now:        time.Now,
+	}
+
+	req := openai.ChatCompletionRequest{
+		Model: "bedrock/anthropic.claude-3-haiku-20240307-v1:0",
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: "You are a geography tutor."},
+			{Role: openai.ChatMessageRoleUser, Content: "Capital of France?"},
+		},
+		MaxTokens: 50,
+	}
+	response, cached, err := client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, "Paris.", response)
+	assert.Equal(t, []bedrockContentBlock{{Text: "You are a geography tutor."}}, got.System)
+	if assert.NotNil(t, got.InferenceConfig) {
+		assert.Equal(t, 50, got.InferenceConfig.MaxTokens)
+	}
+	assert.Equal(t, 10, client.Stats().PromptTokens)
+	assert.Greater(t, client.Stats().EstimatedCost, 0.0)
+
+	_, cached, err = client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.True(t, cached)
+}
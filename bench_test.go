@@ -0,0 +1,175 @@
+package llmcache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// benchSizes are the cache sizes the benchmarks run at. The million entry
+// caches take gigabytes and minutes, and are skipped with -short.
+var benchSizes = []struct {
+	name string
+	n    int
+}{{"1k", 1_000}, {"100k", 100_000}, {"1M", 1_000_000}}
+
+// forEachSize runs bench as a sub-benchmark with a cache of every size. Each
+// entry holds a 100 byte response, and the entry at "hit" the request
+// benchRequest.
+func forEachSize(b *testing.B, bench func(b *testing.B, cache *Cache)) {
+	for _, size := range benchSizes {
+		b.Run(size.name, func(b *testing.B) {
+			if size.n > 100_000 && testing.Short() {
+				b.Skip("skipping the largest cache with -short")
+			}
+			bench(b, benchCache(b, size.n))
+		})
+	}
+}
+
+var benchRequest = openai.ChatCompletionRequest{Model: "gpt-4o", Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "What is the capital of France?"}}}
+
+func benchCache(b *testing.B, n int) *Cache {
+	b.Helper()
+	cache := &Cache{Responses: make(map[string]CacheEntry, n)}
+	for i := 0; i < n-1; i++ {
+		cache.Responses[fmt.Sprintf("%064x", i)] = CacheEntry{Response: strings.Repeat("x", 100), Access: uint64(i + 1)}
+	}
+	hash, err := generateHash(benchRequest)
+	if err != nil {
+		b.Fatal(err)
+	}
+	req := benchRequest
+	cache.Responses[hash] = CacheEntry{Response: "Paris", Request: &req, Access: uint64(n)}
+	cache.Sequence = uint64(n)
+	return cache
+}
+
+func BenchmarkGenerateKey(b *testing.B) {
+	long := openai.ChatCompletionRequest{Model: "gpt-4o"}
+	for i := 0; i < 50; i++ {
+		long.Messages = append(long.Messages, openai.ChatCompletionMessage{Role: "user", Content: strings.Repeat("a long turn of a conversation ", 20)})
+	}
+	for _, bench := range []struct {
+		name string
+		req  openai.ChatCompletionRequest
+	}{{"short", benchRequest}, {"50-turns", long}} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := generateKey("", bench.req); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSave(b *testing.B) {
+	forEachSize(b, func(b *testing.B, cache *Cache) {
+		path := filepath.Join(b.TempDir(), "cache.json")
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := writeCache(path, cache, true); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkLoad(b *testing.B) {
+	forEachSize(b, func(b *testing.B, cache *Cache) {
+		path := filepath.Join(b.TempDir(), "cache.json")
+		if err := writeCache(path, cache, true); err != nil {
+			b.Fatal(err)
+		}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := loadCacheFrom(path); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkHit measures serving a cached request through the client, with
+// the store loaded and, unless the cache is touched, saved on every hit.
+func BenchmarkHit(b *testing.B) {
+	for _, noTouch := range []bool{false, true} {
+		name := "touch"
+		if noTouch {
+			name = "no-touch"
+		}
+		b.Run(name, func(b *testing.B) {
+			forEachSize(b, func(b *testing.B, cache *Cache) {
+				path := filepath.Join(b.TempDir(), "cache.json")
+				if err := writeCache(path, cache, true); err != nil {
+					b.Fatal(err)
+				}
+				client := NewCachingClient("test-key", true, 0)
+				client.store = newFileStore(path)
+				client.SetCompact(true)
+				client.SetNoTouch(noTouch)
+				// Keep the run summary out of the benchmark's results.
+				console = io.Discard
+				b.Cleanup(func() {
+					client.Close()
+					console = os.Stdout
+				})
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					_, cached, err := client.getResponse(context.Background(), benchRequest)
+					if err != nil || !cached {
+						b.Fatalf("cached %v, err %v", cached, err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkEviction measures choosing the entries to evict from a cache twice
+// the size limit.
+func BenchmarkEviction(b *testing.B) {
+	forEachSize(b, func(b *testing.B, cache *Cache) {
+		client := &CachingClient{cacheSizeLimit: int64(len(cache.Responses)) * 50}
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if len(client.evictions(cache)) == 0 {
+				b.Fatal("nothing evicted")
+			}
+		}
+	})
+}
+
+// The allocations of the hot paths don't depend on timing, so unlike their
+// speed they can be guarded by tests.
+
+func TestGenerateKeyAllocations(t *testing.T) {
+	allocs := testing.AllocsPerRun(100, func() { generateKey("", benchRequest) })
+	if allocs > 12 {
+		t.Errorf("generating a key takes %v allocations, want at most 12", allocs)
+	}
+}
+
+func TestEvictionAllocationsDontGrowWithTheCache(t *testing.T) {
+	cache := &Cache{Responses: make(map[string]CacheEntry)}
+	for i := 0; i < 10_000; i++ {
+		cache.Responses[fmt.Sprintf("%064x", i)] = CacheEntry{Response: strings.Repeat("x", 100), Access: uint64(i + 1)}
+	}
+	client := &CachingClient{cacheSizeLimit: 500_000}
+	allocs := testing.AllocsPerRun(5, func() { client.evictions(cache) })
+	if allocs > 64 {
+		t.Errorf("choosing evictions from 10,000 entries takes %v allocations, want at most 64", allocs)
+	}
+}
@@ -0,0 +1,191 @@
+package llmcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// minBlobSize is the length from which responses shared by several entries
+// are stored once, as a blob. Shorter responses take less space inline than a
+// reference to a blob does.
+const minBlobSize = 128
+
+// blobKey returns the content address of a response.
+func blobKey(response string) string {
+	sum := sha256.Sum256([]byte(response))
+	return hex.EncodeToString(sum[:])
+}
+
+// packResponses returns cache as it is written to a file: responses shared by
+// several entries, common with temperature 0 across near-identical requests,
+// are moved to Blobs and referenced by the entries. The blobs are rebuilt
+// from the entries on every save, so a blob no entry references anymore is
+// dropped with the last entry that did. cache itself is left alone.
+func packResponses(cache *Cache) *Cache {
+	uses := make(map[string]int)
+	for _, entry := range cache.Responses {
+		if len(entry.Response) >= minBlobSize {
+			uses[entry.Response]++
+		}
+	}
+	packed := *cache
+	packed.Blobs = nil
+	for _, n := range uses {
+		if n > 1 {
+			packed.Blobs = make(map[string]string)
+			break
+		}
+	}
+	if packed.Blobs == nil {
+		return &packed
+	}
+	packed.Responses = make(map[string]CacheEntry, len(cache.Responses))
+	for hash, entry := range cache.Responses {
+		if uses[entry.Response] > 1 {
+			key := blobKey(entry.Response)
+			packed.Blobs[key] = entry.Response
+			entry.Response, entry.ResponseRef = "", key
+		}
+		packed.Responses[hash] = entry
+	}
+	return &packed
+}
+
+// unpackResponses resolves the blob references of the entries of a cache read
+// from a file, so that every entry holds its response again.
+func unpackResponses(cache *Cache) error {
+	for hash, entry := range cache.Responses {
+		if entry.ResponseRef == "" {
+			continue
+		}
+		response, ok := cache.Blobs[entry.ResponseRef]
+		if !ok {
+			return fmt.Errorf("entry %s references missing blob %s", hash, entry.ResponseRef)
+		}
+		entry.Response, entry.ResponseRef = response, ""
+		cache.Responses[hash] = entry
+	}
+	cache.Blobs = nil
+	return nil
+}
+
+// orphanedBlobs returns the keys of the blobs of the cache file data that no
+// entry of its responses, sessions or embeddings references, and their size
+// in bytes. Saving rebuilds the blobs from the entries, but a file edited by
+// hand or merged by git can keep blobs nothing uses anymore.
+func orphanedBlobs(data []byte) ([]string, int64, error) {
+	var file struct {
+		Responses  map[string]json.RawMessage `json:"responses"`
+		Sessions   map[string]json.RawMessage `json:"sessions"`
+		Embeddings map[string]json.RawMessage `json:"embeddings"`
+		Blobs      map[string]string          `json:"blobs"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, 0, err
+	}
+	used := make(map[string]bool)
+	for _, entries := range []map[string]json.RawMessage{file.Responses, file.Sessions, file.Embeddings} {
+		for _, raw := range entries {
+			var v any
+			if err := json.Unmarshal(raw, &v); err != nil {
+				return nil, 0, err
+			}
+			collectBlobRefs(v, used)
+		}
+	}
+	var orphans []string
+	var size int64
+	for key, blob := range file.Blobs {
+		if !used[key] {
+			orphans = append(orphans, key)
+			size += int64(len(blob))
+		}
+	}
+	sort.Strings(orphans)
+	return orphans, size, nil
+}
+
+// collectBlobRefs adds the blobs referenced anywhere in v, a decoded entry,
+// to used.
+func collectBlobRefs(v any, used map[string]bool) {
+	switch v := v.(type) {
+	case map[string]any:
+		for name, value := range v {
+			if ref, ok := value.(string); ok && name == "response_ref" {
+				used[ref] = true
+				continue
+			}
+			collectBlobRefs(value, used)
+		}
+	case []any:
+		for _, value := range v {
+			collectBlobRefs(value, used)
+		}
+	}
+}
+
+// runGC deletes the blobs of a cache file that no entry references, and
+// reports the bytes that reclaims.
+func runGC(args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Only list the blobs that would be deleted")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: llm-test-cache gc [-dry-run] [CACHE]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("gc takes at most one cache")
+	}
+	path := cacheFile
+	if fs.NArg() == 1 {
+		path = fs.Arg(0)
+	}
+	store := newFileStore(path)
+	store.readOnly = *dryRun
+	defer store.Close()
+	cache, err := store.Load()
+	if err != nil {
+		return err
+	}
+	var orphans []string
+	var reclaimed int64
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		if orphans, reclaimed, err = orphanedBlobs(data); err != nil {
+			return fmt.Errorf("%w: %s: %w", ErrCacheCorrupt, path, err)
+		}
+	}
+	for _, key := range orphans {
+		fmt.Fprintln(console, key)
+	}
+	result := struct {
+		Deleted   []string `json:"deleted"`
+		Reclaimed int64    `json:"reclaimed_bytes"`
+		DryRun    bool     `json:"dry_run"`
+	}{Deleted: append([]string{}, orphans...), Reclaimed: reclaimed, DryRun: *dryRun}
+	if *dryRun {
+		fmt.Fprintf(console, "Would delete %d orphaned blobs, reclaiming %d bytes\n", len(orphans), reclaimed)
+		return report(result)
+	}
+	fmt.Fprintf(console, "Deleted %d orphaned blobs, reclaiming %d bytes\n", len(orphans), reclaimed)
+	if len(orphans) > 0 {
+		// Saving rebuilds the blobs from the entries, leaving the orphans out.
+		if err := store.Save(cache); err != nil {
+			return err
+		}
+	}
+	return report(result)
+}
@@ -0,0 +1,97 @@
+package llmcache
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuplicateResponsesAreStoredOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	shared := strings.Repeat("The same long answer. ", 20)
+	cache := &Cache{Responses: map[string]CacheEntry{
+		"a":     {Response: shared},
+		"b":     {Response: shared},
+		"short": {Response: "yes"},
+		"other": {Response: "yes"},
+	}}
+	assert.NoError(t, saveCacheTo(path, cache))
+	assert.Equal(t, shared, cache.Responses["a"].Response, "saving leaves the cache alone")
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), shared))
+	var raw Cache
+	assert.NoError(t, json.Unmarshal(data, &raw))
+	assert.Equal(t, map[string]string{blobKey(shared): shared}, raw.Blobs)
+	assert.Equal(t, blobKey(shared), raw.Responses["a"].ResponseRef)
+	assert.Empty(t, raw.Responses["a"].Response)
+	assert.Equal(t, "yes", raw.Responses["short"].Response, "short responses stay inline")
+
+	loaded, err := loadCacheFrom(path)
+	assert.NoError(t, err)
+	assert.Equal(t, cache.Responses, loaded.Responses)
+	assert.Nil(t, loaded.Blobs)
+
+	// A blob only one entry uses goes back inline.
+	delete(loaded.Responses, "b")
+	assert.NoError(t, saveCacheTo(path, loaded))
+	data, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), `"blobs"`)
+	assert.NotContains(t, string(data), `"response_ref"`)
+}
+
+func TestMissingBlobIsCorrupt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"responses": {"a": {"response": "", "response_ref": "abc"}}}`), 0644))
+	_, err := loadCacheFrom(path)
+	assert.ErrorIs(t, err, ErrCacheCorrupt)
+	assert.ErrorContains(t, err, "missing blob abc")
+}
+
+func TestGCDeletesOrphanedBlobs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	shared := strings.Repeat("The same long answer. ", 20)
+	assert.NoError(t, saveCacheTo(path, &Cache{Responses: map[string]CacheEntry{
+		"a": {Response: shared},
+		"b": {Response: shared},
+	}}))
+	// Plant a blob no entry references, as a merge of two branches can.
+	var raw map[string]any
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(data, &raw))
+	orphan := strings.Repeat("An answer nobody asks for anymore. ", 10)
+	raw["blobs"].(map[string]any)[blobKey(orphan)] = orphan
+	data, err = json.Marshal(raw)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(path, data, 0644))
+
+	orphans, size, err := orphanedBlobs(data)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{blobKey(orphan)}, orphans)
+	assert.Equal(t, int64(len(orphan)), size)
+
+	assert.NoError(t, runGC([]string{"-dry-run", path}))
+	after, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, data, after, "a dry run leaves the file alone")
+
+	assert.NoError(t, runGC([]string{path}))
+	after, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(after), orphan)
+	assert.Equal(t, 1, strings.Count(string(after), shared), "blobs still in use are kept")
+	orphans, _, err = orphanedBlobs(after)
+	assert.NoError(t, err)
+	assert.Empty(t, orphans)
+	cache, err := loadCacheFrom(path)
+	assert.NoError(t, err)
+	assert.Equal(t, shared, cache.Responses["a"].Response)
+	assert.Equal(t, shared, cache.Responses["b"].Response)
+}
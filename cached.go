@@ -0,0 +1,184 @@
+package llmcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// CachedCall caches the results of a deterministic, expensive call other than
+// a chat completion, e.g. to an internal model gateway or a reranking API,
+// with the persistence, eviction and modes of a CachingClient. Results are
+// stored as JSON, so Resp must round-trip through encoding/json.
+type CachedCall[Req, Resp any] struct {
+	client *CachingClient
+	key    func(Req) (string, error)
+	fetch  func(context.Context, Req) (Resp, error)
+}
+
+// Cached returns a CachedCall persisting results in store, such as a
+// NewFileStore, or in cache/response-cache.json if store is nil. key returns
+// what identifies a request, e.g. its canonical JSON; requests with the same
+// key share a result. fetch makes the call on a miss. The call must be closed
+// with Close.
+//
+// Every save writes the whole cache, so the store must not be used by a
+// CachingClient or another CachedCall at the same time: their saves would
+// overwrite each other's entries.
+func Cached[Req, Resp any](store Store, key func(Req) (string, error), fetch func(context.Context, Req) (Resp, error), opts ...Option) *CachedCall[Req, Resp] {
+	if store == nil {
+		store = newFileStore(cacheFile)
+	}
+	c := &CachingClient{
+		cacheEnabled:   true,
+		cacheSizeLimit: DefaultCacheSizeLimit,
+		store:          store,
+		clock:          systemClock{},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return &CachedCall[Req, Resp]{client: c, key: key, fetch: fetch}
+}
+
+// WithTTL makes entries expire ttl after they were recorded, as SetTTL does.
+func WithTTL(ttl time.Duration) Option {
+	return func(c *CachingClient) {
+		c.ttl = ttl
+	}
+}
+
+// callKey returns the cache key of a call identified by key within namespace.
+// It is prefixed so that it can't collide with the key of a chat completion.
+func callKey(namespace, key string) string {
+	hash := sha256.Sum256([]byte("call\x00" + namespace + "\x00" + key))
+	return hex.EncodeToString(hash[:])
+}
+
+// Call returns the result for req, from the cache if it was recorded and
+// otherwise by calling fetch, as the mode of ctx allows. It reports whether the
+// result came from the cache. Namespaces and labels set on ctx apply as they
+// do to chat completions.
+func (f *CachedCall[Req, Resp]) Call(ctx context.Context, req Req) (Resp, bool, error) {
+	var zero Resp
+	c := f.client
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return zero, false, ErrClientClosed
+	}
+	mode, explicit := modeFrom(ctx)
+	if mode == Shadow && skipCacheFrom(ctx) {
+		c.stats.Shadowed++
+		return zero, false, fmt.Errorf("%w: the request skips the cache", ErrShadowed)
+	}
+	if skipCacheFrom(ctx) || (!c.cacheEnabled && !explicit) {
+		c.stats.Misses++
+		var resp Resp
+		var err error
+		c.unlocked(func() { resp, err = f.fetch(ctx, req) })
+		return resp, false, err
+	}
+
+	key, err := f.key(req)
+	if err != nil {
+		return zero, false, err
+	}
+	cache, err := c.store.Load()
+	if err != nil {
+		return zero, false, err
+	}
+	namespace := namespaceFrom(ctx)
+	label := labelFrom(ctx)
+	hash := callKey(namespace, key)
+
+	if mode != Record {
+		entry, err := lookup(cache, hash)
+		if err == nil && c.expired(entry) {
+			err = fmt.Errorf("%w: %s expired", ErrCacheMiss, hash)
+		}
+		if err == nil {
+			var resp Resp
+			if err := json.Unmarshal([]byte(entry.Response), &resp); err != nil {
+				return zero, false, fmt.Errorf("%w: entry %s: %w", ErrCacheCorrupt, hash, err)
+			}
+			if !c.noTouch {
+				entry.Timestamp = c.now()
+				entry.Access = cache.nextAccess()
+				entry.LastHit = entry.Timestamp
+				entry.Hits++
+			}
+			if label != "" {
+				entry.Label = label
+			}
+			if !c.readOnly {
+				cache.Responses[hash] = entry
+				if err := c.saveCache(cache); err != nil {
+					return zero, false, err
+				}
+			}
+			c.stats.Hits++
+			return resp, true, nil
+		}
+		if mode == Shadow {
+			c.stats.Shadowed++
+			return zero, false, fmt.Errorf("%w: %s", ErrShadowed, hash)
+		}
+		if mode == Replay {
+			return zero, false, err
+		}
+	}
+
+	if c.readOnly {
+		return zero, false, fmt.Errorf("%w: not recording %s", ErrReadOnly, hash)
+	}
+	c.stats.Misses++
+	var resp Resp
+	c.unlocked(func() { resp, err = f.fetch(ctx, req) })
+	if err != nil {
+		return zero, false, err
+	}
+	// Other goroutines may have saved the cache while fetch ran.
+	if cache, err = c.store.Load(); err != nil {
+		return zero, false, err
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return zero, false, err
+	}
+	now := c.now()
+	cache.Responses[hash] = CacheEntry{
+		Response:  string(data),
+		Timestamp: now,
+		Access:    cache.nextAccess(),
+		Recorded:  now,
+		Namespace: namespace,
+		Label:     label,
+	}
+	if err := c.evictIfNeeded(cache); err != nil {
+		return zero, false, err
+	}
+	if err := c.saveCache(cache); err != nil {
+		return zero, false, err
+	}
+	return resp, false, nil
+}
+
+// Stats returns the hits and misses of the call since it was created.
+func (f *CachedCall[Req, Resp]) Stats() RunStats {
+	return f.client.Stats()
+}
+
+// Flush makes sure every result cached so far has been written durably.
+func (f *CachedCall[Req, Resp]) Flush() error {
+	return f.client.Flush()
+}
+
+// Close flushes and releases the store and prints a summary of the hits and
+// misses. The call can't be used after Close.
+func (f *CachedCall[Req, Resp]) Close() error {
+	return f.client.Close()
+}
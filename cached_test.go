@@ -0,0 +1,91 @@
+package llmcache
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type rerankRequest struct {
+	Query     string
+	Documents []string
+}
+
+type rerankResult struct {
+	Order []int `json:"order"`
+}
+
+func TestCachedCall(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	calls := 0
+	rerank := Cached(newFileStore(path),
+		func(req rerankRequest) (string, error) {
+			return req.Query + "\x00" + strings.Join(req.Documents, "\x00"), nil
+		},
+		func(ctx context.Context, req rerankRequest) (rerankResult, error) {
+			calls++
+			return rerankResult{Order: []int{1, 0}}, nil
+		})
+	defer rerank.Close()
+
+	req := rerankRequest{Query: "cats", Documents: []string{"dogs", "cats"}}
+	for i := 0; i < 2; i++ {
+		result, cached, err := rerank.Call(context.Background(), req)
+		assert.NoError(t, err)
+		assert.Equal(t, i == 1, cached)
+		assert.Equal(t, []int{1, 0}, result.Order)
+	}
+	assert.Equal(t, 1, calls)
+
+	other := rerankRequest{Query: "dogs", Documents: req.Documents}
+	_, _, err := rerank.Call(WithMode(context.Background(), Replay), other)
+	assert.True(t, errors.Is(err, ErrCacheMiss))
+	_, cached, err := rerank.Call(WithMode(context.Background(), Record), req)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, 2, calls)
+
+	stats := rerank.Stats()
+	assert.Equal(t, 1, stats.Hits)
+	assert.Equal(t, 2, stats.Misses)
+}
+
+func TestCachedCallEvicts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	clock := NewFrozenClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	echo := Cached(newFileStore(path),
+		func(s string) (string, error) { return s, nil },
+		func(ctx context.Context, s string) (string, error) { return strings.Repeat(s, 10), nil },
+		WithCacheSizeLimit(30), WithClock(clock))
+	defer echo.Close()
+
+	for _, s := range []string{"a", "b", "c"} {
+		_, _, err := echo.Call(context.Background(), s)
+		assert.NoError(t, err)
+		clock.Advance(time.Minute)
+	}
+	assert.NoError(t, echo.Flush())
+	cache, err := loadCacheFrom(path)
+	assert.NoError(t, err)
+	assert.Len(t, cache.Responses, 2)
+	assert.NotContains(t, cache.Responses, callKey("", "a"))
+}
+
+func TestCachedCallDefaultsToTheCacheFile(t *testing.T) {
+	echo := Cached[string, string](nil, func(req string) (string, error) { return req, nil },
+		func(ctx context.Context, req string) (string, error) { return req, nil })
+	assert.Equal(t, cacheFile, echo.client.store.(*fileStore).path)
+
+	path := filepath.Join(t.TempDir(), "cache.json")
+	echo = Cached[string, string](NewFileStore(path), func(req string) (string, error) { return req, nil },
+		func(ctx context.Context, req string) (string, error) { return req, nil })
+	defer echo.Close()
+	_, _, err := echo.Call(context.Background(), "hi")
+	assert.NoError(t, err)
+	assert.FileExists(t, path)
+}
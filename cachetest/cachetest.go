@@ -0,0 +1,139 @@
+// Package cachetest has assertions for tests of code that calls a language
+// model through a llmcache.CachingClient: golden files, similarity and facts
+// checks, and clients with caches of their own for parallel tests.
+package cachetest
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+
+	llmcache "llm-test-cache"
+)
+
+var update = flag.Bool("update", false, "Rewrite golden files with the current responses")
+
+const goldenDir = "testdata/golden"
+
+// goldenNormalization is how golden files are normalized before they are
+// compared with responses.
+var goldenNormalization = llmcache.Normalization{Unicode: true, LineEndings: true, StripBOM: true}
+
+// AssertGolden fetches the response for req through the cache and compares it
+// with testdata/golden/<name>.golden. Run the tests with -update to write the
+// golden files from the current responses instead.
+func AssertGolden(t testing.TB, client *llmcache.CachingClient, req openai.ChatCompletionRequest, name string) bool {
+	t.Helper()
+	result, err := client.Complete(context.Background(), req)
+	if !assert.NoError(t, err, "fetching response for golden %q", name) {
+		return false
+	}
+	return assertGoldenFile(t, filepath.Join(goldenDir, name+".golden"), result.Response)
+}
+
+func assertGoldenFile(t testing.TB, path, got string) bool {
+	t.Helper()
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("creating golden directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatalf("writing golden file: %v", err)
+		}
+		return true
+	}
+
+	want, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		t.Errorf("golden file %s does not exist; run the tests with -update to create it", path)
+		return false
+	}
+	if err != nil {
+		t.Fatalf("reading golden file: %v", err)
+	}
+	// A golden file checked out with CRLF line endings, or saved with a byte
+	// order mark, still matches.
+	return assert.Equal(t, goldenNormalization.Apply(string(want)), got, "response does not match golden file %s", path)
+}
+
+// AssertSimilarGolden is like AssertGolden but passes when metric scores the
+// response at least threshold against the golden file, for responses where an
+// exact match is too brittle.
+func AssertSimilarGolden(t testing.TB, client *llmcache.CachingClient, req openai.ChatCompletionRequest, name string, metric llmcache.SimilarityMetric, threshold float64) bool {
+	t.Helper()
+	result, err := client.Complete(context.Background(), req)
+	if !assert.NoError(t, err, "fetching response for golden %q", name) {
+		return false
+	}
+	path := filepath.Join(goldenDir, name+".golden")
+	if *update {
+		return assertGoldenFile(t, path, result.Response)
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Errorf("reading golden file %s: %v; run the tests with -update to create it", path, err)
+		return false
+	}
+	return assertSimilar(t, result.Response, string(want), metric, threshold)
+}
+
+func assertSimilar(t testing.TB, got, want string, metric llmcache.SimilarityMetric, threshold float64) bool {
+	t.Helper()
+	score := metric(got, want)
+	return assert.GreaterOrEqual(t, score, threshold, "similarity %.3f below threshold %.3f\ngot:  %s\nwant: %s", score, threshold, got, want)
+}
+
+// AssertEmbeddingSimilar passes when the embeddings of got and want, computed
+// with model, have a cosine similarity of at least threshold.
+func AssertEmbeddingSimilar(t testing.TB, client *llmcache.CachingClient, model openai.EmbeddingModel, got, want string, threshold float64) bool {
+	t.Helper()
+	score, err := client.EmbeddingSimilarity(context.Background(), model, got, want)
+	if !assert.NoError(t, err, "computing embedding similarity") {
+		return false
+	}
+	return assert.GreaterOrEqual(t, score, threshold, "embedding similarity %.3f below threshold %.3f", score, threshold)
+}
+
+// AssertFacts passes when response matches every fact, each a
+// case-insensitive regular expression.
+func AssertFacts(t testing.TB, response string, facts ...string) bool {
+	t.Helper()
+	missing, err := llmcache.MissingFacts(response, facts)
+	if !assert.NoError(t, err) {
+		return false
+	}
+	return assert.Empty(t, missing, "response is missing required facts\nresponse: %s", response)
+}
+
+// Isolated returns a client with its own empty cache in a temporary directory,
+// so that tests using it can run with t.Parallel() without contending for the
+// shared cache file. The client is closed when the test finishes.
+func Isolated(t testing.TB) *llmcache.CachingClient {
+	t.Helper()
+	return IsolatedFrom(t, "")
+}
+
+// IsolatedFrom is like Isolated but seeds the cache with a copy of the cache
+// file at seed, which is never written to. An empty seed starts empty.
+func IsolatedFrom(t testing.TB, seed string) *llmcache.CachingClient {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cache.json")
+	if seed != "" {
+		data, err := os.ReadFile(seed)
+		if err == nil {
+			err = os.WriteFile(path, data, 0644)
+		}
+		if err != nil {
+			t.Fatalf("seeding isolated cache: %v", err)
+		}
+	}
+	client := llmcache.NewCachingClient(os.Getenv("OPENAI_API_KEY"), true, llmcache.DefaultCacheSizeLimit)
+	client.SetCacheFile(path)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
@@ -0,0 +1,102 @@
+package cachetest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+
+	llmcache "llm-test-cache"
+)
+
+// failureRecorder captures assertion failures so helpers can be tested for
+// the failures they report.
+type failureRecorder struct {
+	testing.TB
+	failed bool
+}
+
+func (r *failureRecorder) Helper() {}
+
+func (r *failureRecorder) Errorf(format string, args ...any) { r.failed = true }
+
+func TestAssertGoldenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "answer.golden")
+
+	defer func(previous bool) { *update = previous }(*update)
+	*update = true
+	assertGoldenFile(t, path, "Paris")
+	*update = false
+
+	assert.True(t, assertGoldenFile(t, path, "Paris"))
+
+	recorder := &failureRecorder{TB: t}
+	assert.False(t, assertGoldenFile(recorder, path, "Lyon"))
+	assert.True(t, recorder.failed)
+
+	recorder = &failureRecorder{TB: t}
+	assert.False(t, assertGoldenFile(recorder, filepath.Join(t.TempDir(), "missing.golden"), "Paris"))
+	assert.True(t, recorder.failed)
+}
+
+func TestAssertSimilar(t *testing.T) {
+	want := "The capital of France is Paris."
+	assert.True(t, assertSimilar(t, "Paris is the capital of France.", want, llmcache.RougeL, 0.5))
+
+	recorder := &failureRecorder{TB: t}
+	assert.False(t, assertSimilar(recorder, "I like turtles.", want, llmcache.RougeL, 0.5))
+	assert.True(t, recorder.failed)
+}
+
+func TestAssertFacts(t *testing.T) {
+	assert.True(t, AssertFacts(t, "The capital of France is Paris.", "paris", `capital\s+of\s+france`))
+
+	recorder := &failureRecorder{TB: t}
+	assert.False(t, AssertFacts(recorder, "The capital of France is Paris.", "berlin"))
+	assert.True(t, recorder.failed)
+}
+
+// helloModel answers every request with "Hello".
+type helloModel struct {
+	llmcache.Client
+}
+
+func (helloModel) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	return openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{
+		Message:      openai.ChatCompletionMessage{Role: "assistant", Content: "Hello"},
+		FinishReason: openai.FinishReasonStop,
+	}}}, nil
+}
+
+func TestIsolatedFrom(t *testing.T) {
+	req := openai.ChatCompletionRequest{
+		Model:    "gpt-3.5-turbo-0125",
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}},
+	}
+	seed := filepath.Join(t.TempDir(), "seed.json")
+	recorder := llmcache.WrapClient(helloModel{}, llmcache.WithCacheFile(seed))
+	_, err := recorder.Complete(context.Background(), req)
+	assert.NoError(t, err)
+	assert.NoError(t, recorder.Close())
+	before, err := os.ReadFile(seed)
+	assert.NoError(t, err)
+
+	for _, name := range []string{"a", "b", "c"} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			client := IsolatedFrom(t, seed)
+			result, err := client.Complete(llmcache.WithMode(context.Background(), llmcache.Replay), req)
+			assert.NoError(t, err)
+			assert.True(t, result.Cached)
+			assert.Equal(t, "Hello", result.Response)
+		})
+	}
+	t.Cleanup(func() {
+		after, err := os.ReadFile(seed)
+		assert.NoError(t, err)
+		assert.Equal(t, before, after, "the seed cache must not be written to")
+	})
+}
@@ -0,0 +1,99 @@
+package llmcache
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ModelCapabilities describes what a model accepts, as far as a request can
+// be checked before it is sent.
+type ModelCapabilities struct {
+	// ContextWindow is the number of tokens the prompt and the response
+	// share.
+	ContextWindow int
+	// MaxOutputTokens is the largest max_tokens the model accepts.
+	MaxOutputTokens int
+	// Images and Tools tell whether messages may hold images and whether
+	// the request may declare tools or functions.
+	Images bool
+	Tools  bool
+}
+
+// modelCapabilities holds the capabilities of common models. Models are
+// matched like prices, by the longest name they contain, so dated snapshots
+// and provider prefixes such as "bedrock/" share the entry of their model.
+var modelCapabilities = map[string]ModelCapabilities{
+	"gpt-3.5-turbo":     {ContextWindow: 16385, MaxOutputTokens: 4096, Tools: true},
+	"gpt-4":             {ContextWindow: 8192, MaxOutputTokens: 8192, Tools: true},
+	"gpt-4-32k":         {ContextWindow: 32768, MaxOutputTokens: 32768, Tools: true},
+	"gpt-4-vision":      {ContextWindow: 128000, MaxOutputTokens: 4096, Images: true},
+	"gpt-4-turbo":       {ContextWindow: 128000, MaxOutputTokens: 4096, Images: true, Tools: true},
+	"gpt-4o":            {ContextWindow: 128000, MaxOutputTokens: 16384, Images: true, Tools: true},
+	"gpt-4o-2024-05-13": {ContextWindow: 128000, MaxOutputTokens: 4096, Images: true, Tools: true},
+	"claude-3":          {ContextWindow: 200000, MaxOutputTokens: 4096, Images: true, Tools: true},
+	"claude-3-5-sonnet": {ContextWindow: 200000, MaxOutputTokens: 8192, Images: true, Tools: true},
+	"claude-3-5-haiku":  {ContextWindow: 200000, MaxOutputTokens: 8192, Tools: true},
+	"claude-sonnet":     {ContextWindow: 200000, MaxOutputTokens: 64000, Images: true, Tools: true},
+	"claude-opus":       {ContextWindow: 200000, MaxOutputTokens: 32000, Images: true, Tools: true},
+	"gemini-1.5":        {ContextWindow: 1000000, MaxOutputTokens: 8192, Images: true, Tools: true},
+	"gemini-2.0":        {ContextWindow: 1000000, MaxOutputTokens: 8192, Images: true, Tools: true},
+}
+
+// lookupCapabilities returns the capabilities of model, if known.
+func lookupCapabilities(model string) (ModelCapabilities, bool) {
+	best := ""
+	for name := range modelCapabilities {
+		if strings.Contains(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return ModelCapabilities{}, false
+	}
+	return modelCapabilities[best], true
+}
+
+// contextWindow returns the context window of model, if known.
+func contextWindow(model string) (int, bool) {
+	caps, known := lookupCapabilities(model)
+	return caps.ContextWindow, known
+}
+
+// checkCapabilities checks req against the capabilities of its model, so that
+// a request the provider would reject with a 400 fails before it is sent.
+// Requests for models missing from the table are let through.
+func checkCapabilities(req openai.ChatCompletionRequest) error {
+	caps, known := lookupCapabilities(req.Model)
+	if !known {
+		return nil
+	}
+	if caps.MaxOutputTokens > 0 && req.MaxTokens > caps.MaxOutputTokens {
+		return fmt.Errorf("%w: max_tokens %d is over the %d output tokens of %s; lower it to at most %d", ErrRequestInvalid, req.MaxTokens, caps.MaxOutputTokens, req.Model, caps.MaxOutputTokens)
+	}
+	if !caps.Images && hasImages(req) {
+		return fmt.Errorf("%w: %s doesn't accept images; send them to a model that does, such as gpt-4o", ErrRequestInvalid, req.Model)
+	}
+	if !caps.Tools && (len(req.Tools) > 0 || len(req.Functions) > 0) {
+		return fmt.Errorf("%w: %s doesn't support tools or functions", ErrRequestInvalid, req.Model)
+	}
+	if tokens, err := countPromptTokens(req); err == nil && tokens+req.MaxTokens > caps.ContextWindow {
+		if req.MaxTokens == 0 || tokens >= caps.ContextWindow {
+			return fmt.Errorf("%w: the prompt of about %d tokens doesn't fit the %d token context window of %s; shorten it", ErrRequestInvalid, tokens, caps.ContextWindow, req.Model)
+		}
+		return fmt.Errorf("%w: about %d prompt tokens plus max_tokens %d exceed the %d token context window of %s; lower max_tokens to at most %d", ErrRequestInvalid, tokens, req.MaxTokens, caps.ContextWindow, req.Model, caps.ContextWindow-tokens)
+	}
+	return nil
+}
+
+func hasImages(req openai.ChatCompletionRequest) bool {
+	for _, m := range req.Messages {
+		for _, part := range m.MultiContent {
+			if part.Type == openai.ChatMessagePartTypeImageURL {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package llmcache
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckCapabilities(t *testing.T) {
+	user := func(content string) []openai.ChatCompletionMessage {
+		return []openai.ChatCompletionMessage{{Role: "user", Content: content}}
+	}
+	image := []openai.ChatCompletionMessage{{Role: "user", MultiContent: []openai.ChatMessagePart{
+		{Type: openai.ChatMessagePartTypeText, Text: "What is this?"},
+		{Type: openai.ChatMessagePartTypeImageURL, ImageURL: &openai.ChatMessageImageURL{URL: "https://example.com/cat.png"}},
+	}}}
+	long := strings.Repeat("word ", 20000)
+	tools := []openai.Tool{{Type: openai.ToolTypeFunction, Function: &openai.FunctionDefinition{Name: "lookup"}}}
+
+	for _, tc := range []struct {
+		name string
+		req  openai.ChatCompletionRequest
+		err  string
+	}{
+		{"fits", openai.ChatCompletionRequest{Model: "gpt-4o-mini", Messages: user("Hi"), MaxTokens: 1000}, ""},
+		{"unknown model", openai.ChatCompletionRequest{Model: "llama3", Messages: user(long), MaxTokens: 1 << 20}, ""},
+		{"too many output tokens", openai.ChatCompletionRequest{Model: "gpt-3.5-turbo-0125", Messages: user("Hi"), MaxTokens: 8000}, "lower it to at most 4096"},
+		{"dated snapshot", openai.ChatCompletionRequest{Model: "gpt-4o-2024-05-13", Messages: user("Hi"), MaxTokens: 8000}, "over the 4096 output tokens"},
+		{"prompt over window", openai.ChatCompletionRequest{Model: "gpt-3.5-turbo", Messages: user(long)}, "doesn't fit the 16385 token context window"},
+		{"prompt plus output over window", openai.ChatCompletionRequest{Model: "gpt-4", Messages: user(strings.Repeat("word ", 7000)), MaxTokens: 2000}, "lower max_tokens to at most"},
+		{"images", openai.ChatCompletionRequest{Model: "gpt-3.5-turbo", Messages: image}, "doesn't accept images"},
+		{"images supported", openai.ChatCompletionRequest{Model: "gpt-4o", Messages: image}, ""},
+		{"tools", openai.ChatCompletionRequest{Model: "gpt-4-vision-preview", Messages: user("Hi"), Tools: tools}, "doesn't support tools"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := checkCapabilities(tc.req)
+			if tc.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrRequestInvalid)
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestInvalidRequestsAreNotSent(t *testing.T) {
+	fake := newFakeOpenAI(t)
+	client := fake.newClient(t)
+	req := openai.ChatCompletionRequest{Model: openai.GPT3Dot5Turbo, MaxTokens: 10000, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+
+	_, _, err := client.getResponse(context.Background(), req)
+	assert.ErrorIs(t, err, ErrRequestInvalid)
+	assert.Equal(t, 0, fake.Calls())
+	cache, err := client.store.Load()
+	assert.NoError(t, err)
+	assert.Empty(t, cache.Responses, "the request shouldn't be cached")
+
+	client.validateRequests = false
+	_, _, err = client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, fake.Calls())
+}
@@ -0,0 +1,184 @@
+package llmcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ChunkOptions control how a long document is split.
+type ChunkOptions struct {
+	// MaxTokens is the most tokens of a chunk, counted with the tokenizer of
+	// the model the chunks are sent to.
+	MaxTokens int
+	// Overlap is how many tokens at the end of a chunk the next one repeats,
+	// so that text cut at a boundary appears whole in one of them.
+	Overlap int
+}
+
+func (o ChunkOptions) validate() error {
+	if o.MaxTokens <= 0 {
+		return errors.New("chunks need a positive MaxTokens")
+	}
+	if o.Overlap < 0 || o.Overlap >= o.MaxTokens {
+		return fmt.Errorf("overlap of %d tokens must be at least 0 and less than MaxTokens (%d)", o.Overlap, o.MaxTokens)
+	}
+	return nil
+}
+
+// DocumentChunk is one chunk of a split document.
+type DocumentChunk struct {
+	Index  int    `json:"index"`
+	Text   string `json:"text"`
+	Tokens int    `json:"tokens"`
+	// Embedding is set by EmbedDocument.
+	Embedding []float32 `json:"embedding,omitempty"`
+}
+
+// SplitByTokens splits text into chunks of at most opts.MaxTokens tokens of
+// model, on token boundaries that never cut a character in two. The same
+// text always splits the same way, so its chunks hit the cache on every run.
+func SplitByTokens(model, text string, opts ChunkOptions) ([]DocumentChunk, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	enc, err := encodingFor(model)
+	if err != nil {
+		return nil, err
+	}
+	// Tokens that end inside a character are joined with the tokens that
+	// complete it, so a piece can count more than one token.
+	type piece struct {
+		text   string
+		tokens int
+	}
+	var pieces []piece
+	var pending []byte
+	n := 0
+	for _, token := range enc.Encode(text, nil, nil) {
+		pending = append(pending, enc.Decode([]int{token})...)
+		n++
+		if utf8.Valid(pending) {
+			pieces = append(pieces, piece{string(pending), n})
+			pending, n = nil, 0
+		}
+	}
+	if len(pending) > 0 {
+		pieces = append(pieces, piece{string(pending), n})
+	}
+
+	var chunks []DocumentChunk
+	for start := 0; start < len(pieces); {
+		var b strings.Builder
+		tokens, end := 0, start
+		for end < len(pieces) && (end == start || tokens+pieces[end].tokens <= opts.MaxTokens) {
+			b.WriteString(pieces[end].text)
+			tokens += pieces[end].tokens
+			end++
+		}
+		chunks = append(chunks, DocumentChunk{Index: len(chunks), Text: b.String(), Tokens: tokens})
+		if end == len(pieces) {
+			break
+		}
+		// Start the next chunk opts.Overlap tokens back, but always past
+		// the start of this one.
+		next, overlap := end, 0
+		for next-1 > start && overlap+pieces[next-1].tokens <= opts.Overlap {
+			next--
+			overlap += pieces[next].tokens
+		}
+		start = next
+	}
+	return chunks, nil
+}
+
+// EmbedDocument splits text by the tokens of model and embeds every chunk in
+// one request through the embeddings cache, so a document that changed in
+// one place only sends the chunks that changed.
+func (c *CachingClient) EmbedDocument(ctx context.Context, model openai.EmbeddingModel, text string, opts ChunkOptions) ([]DocumentChunk, error) {
+	chunks, err := SplitByTokens(string(model), text, opts)
+	if err != nil || len(chunks) == 0 {
+		return chunks, err
+	}
+	inputs := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		inputs[i] = chunk.Text
+	}
+	resp, err := c.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{Model: model, Input: inputs})
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range resp.Data {
+		chunks[e.Index].Embedding = e.Embedding
+	}
+	return chunks, nil
+}
+
+// MapReduceOptions configure MapReduce.
+type MapReduceOptions struct {
+	Chunk ChunkOptions
+	// MapPrompt is sent with every chunk, which follows it after a blank
+	// line.
+	MapPrompt string
+	// ReducePrompt is sent with the answers for all chunks, separated by
+	// blank lines, to combine them into the result. Without one, the
+	// answers are joined by blank lines instead.
+	ReducePrompt string
+}
+
+// MapReduceResult is what MapReduce did with a document.
+type MapReduceResult struct {
+	Chunks []DocumentChunk
+	// Answers are the responses for the chunks, in order.
+	Answers []string
+	Result  string
+	// Cached counts the requests, map and reduce, answered from the cache.
+	Cached int
+}
+
+// MapReduce runs a long document through the cached completions path: it
+// splits text by the tokens of req.Model, sends each chunk with
+// opts.MapPrompt as a user message appended to the messages of req, and
+// reduces the answers with opts.ReducePrompt the same way. Every request is
+// cached on its own, so a rerun only sends the chunks that changed, and the
+// reduce step if any answer did.
+func (c *CachingClient) MapReduce(ctx context.Context, req openai.ChatCompletionRequest, text string, opts MapReduceOptions) (MapReduceResult, error) {
+	var result MapReduceResult
+	chunks, err := SplitByTokens(req.Model, text, opts.Chunk)
+	if err != nil {
+		return result, err
+	}
+	result.Chunks = chunks
+	ask := func(prompt, content string) (string, error) {
+		if prompt != "" {
+			content = prompt + "\n\n" + content
+		}
+		r := req
+		r.Messages = append(append([]openai.ChatCompletionMessage(nil), req.Messages...), openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: content})
+		response, cached, err := c.getResponse(ctx, r)
+		if cached {
+			result.Cached++
+		}
+		return response, err
+	}
+	for _, chunk := range chunks {
+		answer, err := ask(opts.MapPrompt, chunk.Text)
+		if err != nil {
+			return result, fmt.Errorf("chunk %d of %d: %w", chunk.Index+1, len(chunks), err)
+		}
+		result.Answers = append(result.Answers, answer)
+	}
+	joined := strings.Join(result.Answers, "\n\n")
+	if opts.ReducePrompt == "" {
+		result.Result = joined
+		return result, nil
+	}
+	if result.Result, err = ask(opts.ReducePrompt, joined); err != nil {
+		return result, fmt.Errorf("reducing %d answers: %w", len(result.Answers), err)
+	}
+	return result, nil
+}
@@ -0,0 +1,66 @@
+package llmcache
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitByTokens(t *testing.T) {
+	text := strings.Repeat("Grüße aus Köln 🌍, and the quick brown fox jumps over the lazy dog. ", 20)
+	chunks, err := SplitByTokens("gpt-4o-mini", text, ChunkOptions{MaxTokens: 25})
+	assert.NoError(t, err)
+	assert.Greater(t, len(chunks), 1)
+	var joined strings.Builder
+	for i, chunk := range chunks {
+		assert.Equal(t, i, chunk.Index)
+		assert.LessOrEqual(t, chunk.Tokens, 25)
+		assert.True(t, utf8.ValidString(chunk.Text), "chunk %d cuts a character", i)
+		joined.WriteString(chunk.Text)
+	}
+	assert.Equal(t, text, joined.String())
+
+	overlapping, err := SplitByTokens("gpt-4o-mini", text, ChunkOptions{MaxTokens: 25, Overlap: 5})
+	assert.NoError(t, err)
+	assert.Greater(t, len(overlapping), len(chunks))
+	tail := overlapping[0].Text[len(overlapping[0].Text)-5:]
+	assert.True(t, strings.Contains(overlapping[1].Text, tail), "the next chunk repeats the end of the previous one")
+
+	_, err = SplitByTokens("gpt-4o-mini", text, ChunkOptions{MaxTokens: 10, Overlap: 10})
+	assert.Error(t, err)
+}
+
+func TestMapReduceIsCached(t *testing.T) {
+	client, calls := newEchoClient(t)
+	seed := 1
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Seed: &seed, Messages: []openai.ChatCompletionMessage{{Role: "system", Content: "Be brief."}}}
+	text := strings.Repeat("The report covers quarterly sales in every region. ", 30)
+	opts := MapReduceOptions{Chunk: ChunkOptions{MaxTokens: 100}, MapPrompt: "Summarise:", ReducePrompt: "Combine these summaries:"}
+
+	result, err := client.MapReduce(context.Background(), req, text, opts)
+	assert.NoError(t, err)
+	assert.Len(t, result.Answers, len(result.Chunks))
+	assert.Equal(t, "reply to 2 messages", result.Result)
+	assert.Equal(t, len(result.Chunks)+1, *calls, "one request per chunk and one to reduce")
+
+	again, err := client.MapReduce(context.Background(), req, text, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, result.Result, again.Result)
+	assert.Equal(t, len(result.Chunks)+1, again.Cached)
+	assert.Equal(t, len(result.Chunks)+1, *calls, "the rerun is served from the cache")
+}
+
+func TestEmbedDocument(t *testing.T) {
+	client, sent := newEmbeddingClient(t)
+	chunks, err := client.EmbedDocument(context.Background(), openai.SmallEmbedding3, strings.Repeat("one two three four ", 10), ChunkOptions{MaxTokens: 8})
+	assert.NoError(t, err)
+	assert.Greater(t, len(chunks), 1)
+	for _, chunk := range chunks {
+		assert.Equal(t, []float32{float32(len(chunk.Text))}, chunk.Embedding)
+	}
+	assert.Len(t, *sent, 1, "all chunks are embedded in one request")
+}
@@ -0,0 +1,206 @@
+package llmcache
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// command is a subcommand of the CLI.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+	// usage is printed by help for commands without flags; commands with
+	// flags print their own usage when run with -h.
+	usage string
+}
+
+// commands are the subcommands, in the order help lists them. It is filled
+// in by init, since help and completion refer to it.
+var commands []command
+
+func init() {
+	commands = []command{
+		{name: "record", summary: "Run the built-in examples or a suite, re-recording every response", run: runRecord},
+		{name: "replay", summary: "Run the built-in examples or a suite from the cache only, failing on misses", run: runReplay},
+		{name: "shadow", summary: "Run the built-in examples or a suite without calling the API, capturing the requests that would be", run: runShadow},
+		{name: "warm", summary: "Record the uncached requests of a captured manifest", run: runWarm},
+		{name: "run-suite", summary: "Run a suite, replaying cached responses and recording misses", run: runSuiteCommand},
+		{name: "serve", summary: "Serve the OpenAI chat completions API from the cache", run: runServe},
+		{name: "ls", summary: "List cached entries", run: runList},
+		{name: "search", summary: "Search cached entries by prompt or label", run: runSearch},
+		{name: "show", summary: "Print a cached entry as JSON", run: runShow, usage: "llm-test-cache show KEY [CACHE|@SNAPSHOT]"},
+		{name: "explain-key", summary: "Show how the cache key of a request is derived", run: runExplainKey},
+		{name: "stats", summary: "Show how often each entry is used", run: runEntryStats, usage: "llm-test-cache stats [CACHE|@SNAPSHOT]"},
+		{name: "savings", summary: "Show the tokens and dollars the cache saved per day or week", run: runSavingsReport},
+		{name: "prune", summary: "Delete entries a marked test run didn't use", run: runPrune},
+		{name: "evict", summary: "Evict entries down to a size limit, or report which would be", run: runEvict},
+		{name: "gc", summary: "Delete blobs no entry references anymore, reporting the bytes reclaimed", run: runGC},
+		{name: "pin", summary: "Pin entries so they are never evicted", run: runPin},
+		{name: "diff", summary: "Compare two caches or snapshots, or a cache against the live API", run: runDiff},
+		{name: "snapshot", summary: "Create, list, roll back to and delete cache snapshots", run: runSnapshot, usage: "llm-test-cache snapshot create|rollback|delete NAME | snapshot list"},
+		{name: "eval", summary: "Judge cached responses against criteria with a model", run: runEval},
+		{name: "compare", summary: "Send one prompt to several models and compare the responses", run: runCompare},
+		{name: "import", summary: "Import recordings from go-vcr cassettes or HAR files", run: runImport},
+		{name: "export", summary: "Export the cache as a HAR file", run: runExport},
+		{name: "codegen", summary: "Generate a Go package of fixtures from cached entries", run: runCodegen},
+		{name: "cache-key", summary: "Print a CI cache key derived from the suite manifests", run: runCacheKey},
+		{name: "batch", summary: "Record a suite through the OpenAI Batch API", run: runBatch, usage: "llm-test-cache batch export [flags] SUITE.json OUT.jsonl | batch import [flags] IN.jsonl RESULTS.jsonl"},
+		{name: "sanitize", summary: "Check that cache files contain no API keys, emails or other secrets, e.g. before committing", run: runSanitize},
+		{name: "sign", summary: "Sign cache entries, or generate a signing key pair", run: runSign},
+		{name: "verify", summary: "Check that every entry is signed by a key", run: runVerify},
+		{name: "verify-portable", summary: "Check that the cache file reads and rewrites identically on every platform", run: runVerifyPortable},
+		{name: "doctor", summary: "Check the configuration, cache and API connection", run: runDoctor},
+		{name: "help", summary: "Show help for a command", run: runHelp, usage: "llm-test-cache help [COMMAND]"},
+		{name: "completion", summary: "Print a shell completion script", run: runCompletion, usage: "llm-test-cache completion bash|zsh|fish"},
+	}
+}
+
+func lookupCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func isHelpFlag(arg string) bool {
+	return arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+func printCommands(w io.Writer) {
+	fmt.Fprintln(w, "Usage: llm-test-cache COMMAND [flags] [args]")
+	fmt.Fprintln(w, "       llm-test-cache [flags]   (runs the built-in examples)")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-15s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Every command also accepts -quiet, which prints only errors, and -output=json,")
+	fmt.Fprintln(w, "which prints the command's result as a JSON document for scripts.")
+	fmt.Fprintln(w, `Run "llm-test-cache help COMMAND" for a command's flags.`)
+}
+
+// runHelp prints the commands, or the usage of one.
+func runHelp(args []string) error {
+	if len(args) == 0 {
+		printCommands(os.Stdout)
+		return nil
+	}
+	cmd, ok := lookupCommand(args[0])
+	if !ok {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	if cmd.usage == "" {
+		return cmd.run([]string{"-h"})
+	}
+	fmt.Printf("%s\n\nUsage: %s\n", cmd.summary, cmd.usage)
+	return nil
+}
+
+// runCommand runs the command named by args[0] with the remaining arguments.
+// It reports false if there is no such command.
+func runCommand(args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	if isHelpFlag(args[0]) {
+		return true, runHelp(nil)
+	}
+	cmd, ok := lookupCommand(args[0])
+	if !ok {
+		return false, nil
+	}
+	if cmd.usage != "" && len(args) > 1 && isHelpFlag(args[1]) {
+		return true, runHelp(args[:1])
+	}
+	return true, cmd.run(args[1:])
+}
+
+func runRecord(args []string) error {
+	return runModeCommand("record", Record, args)
+}
+
+func runReplay(args []string) error {
+	return runModeCommand("replay", Replay, args)
+}
+
+// runModeCommand runs the built-in examples, or the suite named in args, in
+// mode.
+func runModeCommand(name string, mode Mode, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags := addClientFlags(fs, true)
+	filter := fs.String("filter", "", filterUsage)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: llm-test-cache %s [flags] [SUITE.json]\n", name)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return fmt.Errorf("%s takes at most one suite", name)
+	}
+	suite := defaultSuite()
+	var err error
+	if fs.NArg() == 1 {
+		if suite, err = loadSuite(fs.Arg(0)); err != nil {
+			return err
+		}
+	}
+	if suite.Filter, err = parseRunFilter(*filter); err != nil {
+		return err
+	}
+	// Replaying and shadowing never call the API, so they don't need a key.
+	flags.keyOptional = mode == Replay || mode == Shadow
+	return runSuiteWithFlags(WithMode(interruptContext(), mode), flags, suite)
+}
+
+// runCompletion prints a completion script for the named shell, completing
+// command names and then files.
+func runCompletion(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: llm-test-cache completion bash|zsh|fish")
+	}
+	names := make([]string, 0, len(commands))
+	for _, cmd := range commands {
+		names = append(names, cmd.name)
+	}
+	switch args[0] {
+	case "bash":
+		fmt.Printf(`_llm_test_cache() {
+    if [ "$COMP_CWORD" -eq 1 ]; then
+        COMPREPLY=($(compgen -W "%s" -- "${COMP_WORDS[1]}"))
+    else
+        COMPREPLY=($(compgen -f -- "${COMP_WORDS[COMP_CWORD]}"))
+    fi
+}
+complete -o filenames -F _llm_test_cache llm-test-cache
+`, strings.Join(names, " "))
+	case "zsh":
+		fmt.Printf(`#compdef llm-test-cache
+_llm_test_cache() {
+    if (( CURRENT == 2 )); then
+        compadd %s
+    else
+        _files
+    fi
+}
+compdef _llm_test_cache llm-test-cache
+`, strings.Join(names, " "))
+	case "fish":
+		for _, cmd := range commands {
+			fmt.Printf("complete -c llm-test-cache -n __fish_use_subcommand -a %s -d %q\n", cmd.name, cmd.summary)
+		}
+	default:
+		return fmt.Errorf("unknown shell %q: use bash, zsh or fish", args[0])
+	}
+	return nil
+}
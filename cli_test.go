@@ -0,0 +1,28 @@
+package llmcache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandTable(t *testing.T) {
+	seen := map[string]bool{}
+	for _, cmd := range commands {
+		assert.False(t, seen[cmd.name], "command %s is listed twice", cmd.name)
+		seen[cmd.name] = true
+		assert.NotEmpty(t, cmd.summary, cmd.name)
+		assert.NotNil(t, cmd.run, cmd.name)
+	}
+	for _, name := range []string{"record", "replay", "serve", "stats", "prune", "export", "help", "completion"} {
+		_, ok := lookupCommand(name)
+		assert.True(t, ok, name)
+	}
+
+	ran, err := runCommand([]string{"-cache-requests"})
+	assert.False(t, ran, "flags without a command run the built-in examples")
+	assert.NoError(t, err)
+	ran, err = runCommand([]string{"completion", "tcsh"})
+	assert.True(t, ran)
+	assert.Error(t, err)
+}
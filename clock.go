@@ -0,0 +1,73 @@
+package llmcache
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the client what time it is. Entry timestamps, expiry and
+// eviction order all come from the client's clock, so tests can control them
+// without sleeping and replays can run at a fixed point in time.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// FrozenClock is a Clock that only moves when told to.
+type FrozenClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewFrozenClock(now time.Time) *FrozenClock {
+	return &FrozenClock{now: now}
+}
+
+func (f *FrozenClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Advance moves the clock forward by d.
+func (f *FrozenClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+// SetClock replaces the system clock the client uses.
+func (c *CachingClient) SetClock(clock Clock) {
+	c.clock = clock
+}
+
+func (c *CachingClient) now() time.Time {
+	if c.clock == nil {
+		return time.Now()
+	}
+	return c.clock.Now()
+}
+
+// SetTTL makes entries expire ttl after they were recorded. Expired entries are
+// treated as misses and recorded again. A ttl of 0 means entries never expire.
+func (c *CachingClient) SetTTL(ttl time.Duration) {
+	c.ttl = ttl
+}
+
+// expired reports whether entry was recorded more than the TTL ago. Entries
+// recorded before recording times were kept fall back to their last use.
+func (c *CachingClient) expired(entry CacheEntry) bool {
+	if c.ttl <= 0 {
+		return false
+	}
+	recorded := entry.Recorded
+	if recorded.IsZero() {
+		recorded = entry.Timestamp
+	}
+	return c.now().Sub(recorded) > c.ttl
+}
@@ -0,0 +1,40 @@
+package llmcache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTTLWithFrozenClock(t *testing.T) {
+	req := openai.ChatCompletionRequest{
+		Model:    "gpt-3.5-turbo-0125",
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}},
+	}
+	hash, err := generateHash(req)
+	assert.NoError(t, err)
+	recorded := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	client := newTestClient(t, &Cache{Responses: map[string]CacheEntry{
+		hash: {Response: "Hello", Timestamp: recorded, Recorded: recorded},
+	}})
+	clock := NewFrozenClock(recorded.Add(30 * time.Minute))
+	client.SetClock(clock)
+	client.SetTTL(time.Hour)
+	ctx := WithMode(context.Background(), Replay)
+
+	_, cached, err := client.getResponse(ctx, req)
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	assert.NoError(t, client.Flush())
+	cache, err := client.store.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, clock.Now(), cache.Responses[hash].Timestamp, "a hit is stamped with the client's clock")
+
+	// Using the entry doesn't extend its lifetime.
+	clock.Advance(time.Hour)
+	_, _, err = client.getResponse(ctx, req)
+	assert.ErrorIs(t, err, ErrCacheMiss)
+}
@@ -0,0 +1,9 @@
+// Command llm-test-cache records, replays and manages the response caches of
+// package llmcache from the command line.
+package main
+
+import llmcache "llm-test-cache"
+
+func main() {
+	llmcache.Main()
+}
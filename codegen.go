@@ -0,0 +1,319 @@
+package llmcache
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// fixtureName returns the exported Go identifier of a fixture: its label in
+// CamelCase, or its abbreviated key for unlabelled entries.
+func fixtureName(label, hash string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range label {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if b.Len() == 0 && unicode.IsDigit(r) {
+			b.WriteString("Fixture")
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 || !token.IsExported(b.String()) {
+		return "Fixture" + strings.ToUpper(abbreviate(hash)[:8])
+	}
+	return b.String()
+}
+
+// writeFixtures writes the recorded requests and responses of entries as the
+// Go source of package pkg, which needs nothing but the standard library. With
+// stub, the package also has an httptest server replaying them.
+func writeFixtures(w io.Writer, pkg string, entries []listedEntry, stub bool) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, `// Code generated by llm-test-cache codegen; DO NOT EDIT.
+
+// Package %[1]s holds chat completions recorded by llm-test-cache, frozen as
+// fixtures that need no cache at run time.
+package %[1]s
+`, pkg)
+	if stub {
+		b.WriteString(`
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+`)
+	}
+	b.WriteString(`
+// Message is a message of a recorded request.
+type Message struct {
+	Role    string ` + "`json:\"role\"`" + `
+	Content string ` + "`json:\"content\"`" + `
+}
+
+// Fixture is a recorded request and its response.
+type Fixture struct {
+	// Key is the cache key the response was recorded under.
+	Key      string
+	Label    string
+	Model    string
+	Messages []Message
+	Response string
+	// Chunks are the chunks the response was streamed in, if it was.
+	Chunks []string
+}
+
+`)
+
+	// Fixtures may not take the names the package declares itself.
+	names := map[string]bool{"Message": true, "Fixture": true, "Fixtures": true, "Lookup": true, "ByLabel": true, "ByKey": true, "Handler": true, "NewServer": true}
+	var vars []string
+	for _, e := range entries {
+		name := fixtureName(e.Entry.Label, e.Hash)
+		if names[name] {
+			name += strings.ToUpper(abbreviate(e.Hash)[:8])
+		}
+		names[name] = true
+		vars = append(vars, name)
+
+		if e.Entry.Label != "" {
+			fmt.Fprintf(&b, "// %s was recorded for %s.\n", name, strings.ReplaceAll(e.Entry.Label, "\n", " "))
+		}
+		fmt.Fprintf(&b, "var %s = Fixture{\n", name)
+		fmt.Fprintf(&b, "Key: %q,\n", e.Hash)
+		if e.Entry.Label != "" {
+			fmt.Fprintf(&b, "Label: %s,\n", strconv.Quote(e.Entry.Label))
+		}
+		fmt.Fprintf(&b, "Model: %s,\n", strconv.Quote(e.Model))
+		b.WriteString("Messages: []Message{\n")
+		for _, m := range e.Entry.Request.Messages {
+			fmt.Fprintf(&b, "{Role: %s, Content: %s},\n", strconv.Quote(m.Role), strconv.Quote(m.Content))
+		}
+		b.WriteString("},\n")
+		fmt.Fprintf(&b, "Response: %s,\n", strconv.Quote(e.Entry.Response))
+		if len(e.Entry.Chunks) > 0 {
+			b.WriteString("Chunks: []string{")
+			for i, chunk := range e.Entry.Chunks {
+				if i > 0 {
+					b.WriteString(", ")
+				}
+				b.WriteString(strconv.Quote(chunk))
+			}
+			b.WriteString("},\n")
+		}
+		b.WriteString("}\n\n")
+	}
+
+	b.WriteString("// Fixtures are all the fixtures, in the order they were generated in.\nvar Fixtures = []Fixture{\n")
+	for _, name := range vars {
+		fmt.Fprintf(&b, "%s,\n", name)
+	}
+	b.WriteString(`}
+
+// Lookup returns the fixture recorded for model and messages.
+func Lookup(model string, messages ...Message) (Fixture, bool) {
+	for _, f := range Fixtures {
+		if f.Model != model || len(f.Messages) != len(messages) {
+			continue
+		}
+		match := true
+		for i, m := range messages {
+			if f.Messages[i] != m {
+				match = false
+				break
+			}
+		}
+		if match {
+			return f, true
+		}
+	}
+	return Fixture{}, false
+}
+
+// ByLabel returns the fixture recorded with label.
+func ByLabel(label string) (Fixture, bool) {
+	for _, f := range Fixtures {
+		if f.Label == label {
+			return f, true
+		}
+	}
+	return Fixture{}, false
+}
+
+// ByKey returns the fixture recorded under a cache key.
+func ByKey(key string) (Fixture, bool) {
+	for _, f := range Fixtures {
+		if f.Key == key {
+			return f, true
+		}
+	}
+	return Fixture{}, false
+}
+`)
+	if stub {
+		b.WriteString(fixtureStub)
+	}
+	src, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// fixtureStub is the httptest server of generated fixtures. It answers like
+// serve does for the requests it has fixtures for.
+const fixtureStub = `
+// Handler replays the fixtures as the OpenAI chat completions API: POST
+// requests to a path ending in /chat/completions get the response recorded
+// for their model and messages, streamed if they ask for it, and requests
+// without a fixture fail with 404.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/chat/completions") {
+			writeError(w, http.StatusNotFound, r.Method+" "+r.URL.Path+" is not supported")
+			return
+		}
+		var req struct {
+			Model    string    ` + "`json:\"model\"`" + `
+			Messages []Message ` + "`json:\"messages\"`" + `
+			Stream   bool      ` + "`json:\"stream\"`" + `
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+			return
+		}
+		f, ok := Lookup(req.Model, req.Messages...)
+		if !ok {
+			writeError(w, http.StatusNotFound, "no fixture for this request to "+req.Model)
+			return
+		}
+		if req.Stream {
+			writeStream(w, f)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"object": "chat.completion",
+			"model":  f.Model,
+			"choices": []map[string]any{{
+				"message":       map[string]string{"role": "assistant", "content": f.Response},
+				"finish_reason": "stop",
+			}},
+		})
+	})
+}
+
+// NewServer starts a server replaying the fixtures, which is closed when the
+// test ends. Point the client's base URL at its URL.
+func NewServer(t testing.TB) *httptest.Server {
+	server := httptest.NewServer(Handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+func writeStream(w http.ResponseWriter, f Fixture) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	send := func(delta map[string]string, finish any) {
+		data, _ := json.Marshal(map[string]any{
+			"object":  "chat.completion.chunk",
+			"model":   f.Model,
+			"choices": []map[string]any{{"delta": delta, "finish_reason": finish}},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	}
+	chunks := f.Chunks
+	if len(chunks) == 0 {
+		chunks = []string{f.Response}
+	}
+	send(map[string]string{"role": "assistant"}, nil)
+	for _, chunk := range chunks {
+		send(map[string]string{"content": chunk}, nil)
+	}
+	send(map[string]string{}, "stop")
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]string{"message": message, "type": "llm_test_cache_error"},
+	})
+}
+`
+
+// runCodegen writes cached entries as a Go package of fixtures, for projects
+// that want frozen responses without depending on the cache at run time.
+func runCodegen(args []string) error {
+	fs := flag.NewFlagSet("codegen", flag.ContinueOnError)
+	pkg := fs.String("package", "fixtures", "Package name of the generated file")
+	out := fs.String("out", "", "Write the generated file here instead of standard output")
+	model := fs.String("model", "", "Only generate fixtures for this model")
+	label := fs.String("label", "", "Only generate fixtures for entries whose label starts with this")
+	stub := fs.Bool("httptest", false, "Also generate an httptest server replaying the fixtures as the OpenAI chat completions API")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: llm-test-cache codegen [flags] [CACHE|@SNAPSHOT]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("codegen takes at most one cache")
+	}
+	if !token.IsIdentifier(*pkg) {
+		return fmt.Errorf("invalid package name %q", *pkg)
+	}
+	path := cacheFile
+	if fs.NArg() == 1 {
+		var err error
+		if path, err = resolveCachePath(fs.Arg(0)); err != nil {
+			return err
+		}
+	}
+	cache, err := loadCacheFrom(path)
+	if err != nil {
+		return err
+	}
+	var entries []listedEntry
+	for _, e := range listEntries(cache, entryFilter{Model: *model}) {
+		if e.Entry.Request != nil && strings.HasPrefix(e.Entry.Label, *label) {
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) == 0 {
+		return errors.New("no recorded requests to generate fixtures from")
+	}
+	if *out == "" {
+		return writeFixtures(os.Stdout, *pkg, entries, *stub)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := writeFixtures(f, *pkg, entries, *stub); err != nil {
+		f.Close()
+		return err
+	}
+	fmt.Fprintf(console, "Wrote %d fixtures to %s\n", len(entries), *out)
+	return f.Close()
+}
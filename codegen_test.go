@@ -0,0 +1,61 @@
+package llmcache
+
+import (
+	"bytes"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFixtureName(t *testing.T) {
+	assert.Equal(t, "SummarizerHappyPath", fixtureName("summarizer/happy-path", "0123456789abcdef"))
+	assert.Equal(t, "Fixture2ndTry", fixtureName("2nd try", "0123456789abcdef"))
+	assert.Equal(t, "Fixture01234567", fixtureName("", "0123456789abcdef"))
+	assert.Equal(t, "Fixture01234567", fixtureName("日本", "0123456789abcdef"), "letters without case can't be exported")
+}
+
+func TestWriteFixtures(t *testing.T) {
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Say \"hi\"\nplease"}}}
+	cache := &Cache{Responses: map[string]CacheEntry{
+		"aaaa1111bbbb2222": {Response: "hi", Request: &req, Label: "greeting/happy"},
+		"cccc3333dddd4444": {Response: "hello", Request: &req, Label: "lookup"},
+		"eeee5555ffff6666": {Response: "hey", Request: &req},
+	}}
+	var out bytes.Buffer
+	assert.NoError(t, writeFixtures(&out, "fixtures", listEntries(cache, entryFilter{}), false))
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "fixtures.go", out.Bytes(), parser.ParseComments)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = (&types.Config{}).Check("fixtures", fset, []*ast.File{file}, nil)
+	assert.NoError(t, err, "the generated package type-checks")
+	for _, name := range []string{"GreetingHappy", "LookupCCCC3333", "FixtureEEEE5555", "Fixtures", "ByLabel"} {
+		assert.NotNil(t, file.Scope.Lookup(name), name)
+	}
+	assert.Contains(t, out.String(), `"Say \"hi\"\nplease"`)
+}
+
+func TestWriteFixturesStub(t *testing.T) {
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+	cache := &Cache{Responses: map[string]CacheEntry{"aaaa1111bbbb2222": {Response: "Hello world", Request: &req, Chunks: []string{"Hel", "lo world"}}}}
+	var out bytes.Buffer
+	assert.NoError(t, writeFixtures(&out, "stub", listEntries(cache, entryFilter{}), true))
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "stub.go", out.Bytes(), 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = (&types.Config{Importer: importer.Default()}).Check("stub", fset, []*ast.File{file}, nil)
+	assert.NoError(t, err, "the generated server type-checks")
+	assert.NotNil(t, file.Scope.Lookup("NewServer"))
+	assert.Contains(t, out.String(), `[]string{"Hel", "lo world"}`)
+}
@@ -0,0 +1,118 @@
+package llmcache
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+type modelResponse struct {
+	Model    string `json:"model"`
+	Response string `json:"response"`
+	Cached   bool   `json:"cached"`
+	// RougeL is the similarity to the baseline response, for the others.
+	RougeL *float64 `json:"rouge_l,omitempty"`
+}
+
+// compareModels sends req to each of models through the cache, so repeated
+// comparisons only pay for models that haven't answered the prompt before.
+func (c *CachingClient) compareModels(ctx context.Context, req openai.ChatCompletionRequest, models []string) ([]modelResponse, error) {
+	responses := make([]modelResponse, 0, len(models))
+	for _, model := range models {
+		req.Model = model
+		response, cached, err := c.getResponse(ctx, req)
+		if err != nil {
+			return nil, fmt.Errorf("model %s: %w", model, err)
+		}
+		responses = append(responses, modelResponse{Model: model, Response: response, Cached: cached})
+	}
+	return responses, nil
+}
+
+// printComparison prints every response, then a diff and ROUGE-L score of
+// each response against the first, which serves as the baseline.
+func printComparison(responses []modelResponse) {
+	for _, r := range responses {
+		source := "API"
+		if r.Cached {
+			source = "cached"
+		}
+		fmt.Fprintf(console, "=== %s (%s)\n%s\n\n", r.Model, source, r.Response)
+	}
+	if len(responses) < 2 {
+		return
+	}
+	baseline := responses[0]
+	for _, r := range responses[1:] {
+		fmt.Fprintf(console, "--- %s vs %s: ROUGE-L %.3f\n", baseline.Model, r.Model, RougeL(r.Response, baseline.Response))
+		for _, line := range diffLines(baseline.Response, r.Response) {
+			fmt.Fprintf(console, "    %s\n", line)
+		}
+		fmt.Fprintln(console)
+	}
+}
+
+func runCompare(args []string) error {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	models := fs.String("models", "", "Comma-separated models to compare; the first is the baseline (required)")
+	prompt := fs.String("prompt", "", "Prompt to send to every model")
+	key := fs.String("key", "", "Cache key of a recorded request to re-send to every model, instead of -prompt")
+	system := fs.String("system", "", "Optional system message sent with -prompt")
+	seed := fs.Int("seed", 12345, "Seed sent with -prompt")
+	maxTokens := fs.Int("max-tokens", 0, "Maximum tokens sent with -prompt")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: llm-test-cache compare -models A,B[,...] -prompt TEXT|-key HASH")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *models == "" || (*prompt == "") == (*key == "") {
+		fs.Usage()
+		return errors.New("compare needs -models and exactly one of -prompt or -key")
+	}
+
+	var req openai.ChatCompletionRequest
+	if *key != "" {
+		cache, err := loadCache()
+		if err != nil {
+			return err
+		}
+		entry, err := lookup(cache, *key)
+		if err != nil {
+			return err
+		}
+		if entry.Request == nil {
+			return fmt.Errorf("entry %s has no recorded request", *key)
+		}
+		req = *entry.Request
+	} else {
+		req = (&Suite{Seed: seed, MaxTokens: *maxTokens}).request("", SuiteCase{System: *system, Prompt: *prompt})
+	}
+
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" {
+		return errors.New("OPENAI_API_KEY environment variable not set")
+	}
+	client := NewCachingClient(apiKey, true, DefaultCacheSizeLimit)
+	responses, err := client.compareModels(interruptContext(), req, strings.Split(*models, ","))
+	if err != nil {
+		client.Close()
+		return err
+	}
+	printComparison(responses)
+	if err := client.Close(); err != nil {
+		return err
+	}
+	for i := 1; i < len(responses); i++ {
+		score := RougeL(responses[i].Response, responses[0].Response)
+		responses[i].RougeL = &score
+	}
+	return report(responses)
+}
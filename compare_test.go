@@ -0,0 +1,31 @@
+package llmcache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareModels(t *testing.T) {
+	req := openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Capital of France?"}}}
+	cache := &Cache{Responses: map[string]CacheEntry{}}
+	for model, response := range map[string]string{"model-a": "Paris.", "model-b": "The capital is Paris."} {
+		req.Model = model
+		hash, err := generateHash(req)
+		assert.NoError(t, err)
+		cache.Responses[hash] = CacheEntry{Response: response}
+	}
+	client := newTestClient(t, cache)
+
+	responses, err := client.compareModels(WithMode(context.Background(), Replay), req, []string{"model-a", "model-b"})
+	assert.NoError(t, err)
+	assert.Equal(t, []modelResponse{
+		{Model: "model-a", Response: "Paris.", Cached: true},
+		{Model: "model-b", Response: "The capital is Paris.", Cached: true},
+	}, responses)
+
+	_, err = client.compareModels(WithMode(context.Background(), Replay), req, []string{"model-c"})
+	assert.ErrorIs(t, err, ErrCacheMiss)
+}
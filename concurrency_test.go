@@ -0,0 +1,78 @@
+package llmcache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestClientIsSafeForConcurrentUse shares one client between goroutines as a
+// test binary would share a singleton. Run it with -race.
+func TestClientIsSafeForConcurrentUse(t *testing.T) {
+	fake := newFakeOpenAI(t)
+	fake.delay = 20 * time.Millisecond
+	client := fake.newClient(t)
+	var events int
+	client.OnEvent(func(Event) { events++ })
+	seed := 1
+	ask := func(prompt string) openai.ChatCompletionRequest {
+		return openai.ChatCompletionRequest{Model: "gpt-4o-mini", Seed: &seed, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: prompt}}}
+	}
+	const workers = 16
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx := context.Background()
+			shared, err := client.Complete(ctx, ask("shared"))
+			assert.NoError(t, err)
+			assert.Equal(t, deterministicReply(ask("shared")), shared.Response)
+
+			own := ask(fmt.Sprintf("prompt %d", i))
+			result, err := client.Complete(ctx, own)
+			assert.NoError(t, err)
+			assert.Equal(t, deterministicReply(own), result.Response)
+			_, err = client.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{Model: openai.SmallEmbedding3, Input: []string{"shared", fmt.Sprintf("input %d", i)}})
+			assert.NoError(t, err)
+			client.Stats()
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1+workers, fake.Calls(), "concurrent misses of one key should share one request")
+	cache, err := client.store.Load()
+	assert.NoError(t, err)
+	assert.Len(t, cache.Responses, 1+workers, "no recording should be lost to a concurrent save")
+	assert.Len(t, cache.Embeddings, 1+workers)
+	stats := client.Stats()
+	assert.Equal(t, workers-1, stats.Hits)
+	assert.Equal(t, 1+workers, stats.Misses)
+	assert.Equal(t, events, stats.Hits+stats.Misses)
+}
+
+func TestConcurrentMissWaitsForItsContext(t *testing.T) {
+	fake := newFakeOpenAI(t)
+	fake.delay = 200 * time.Millisecond
+	client := fake.newClient(t)
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "slow"}}}
+
+	recorded := make(chan error)
+	go func() {
+		_, err := client.Complete(context.Background(), req)
+		recorded <- err
+	}()
+	assert.Eventually(t, func() bool { return fake.Calls() == 1 }, time.Second, time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := client.Complete(ctx, req)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NoError(t, <-recorded)
+	assert.Equal(t, 1, fake.Calls())
+}
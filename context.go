@@ -0,0 +1,133 @@
+package llmcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Mode selects how a request uses the cache.
+type Mode int
+
+const (
+	// ReadWrite serves recorded responses and records misses. It is the default.
+	ReadWrite Mode = iota
+	// Replay serves recorded responses only; a miss fails with ErrCacheMiss
+	// instead of calling the API.
+	Replay
+	// Record always calls the API and overwrites any recorded response.
+	Record
+	// Shadow serves recorded responses but never calls the API: a miss fails
+	// with ErrShadowed. With CaptureRequests, it lists what a run would
+	// record.
+	Shadow
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ReadWrite:
+		return "read-write"
+	case Replay:
+		return "replay"
+	case Record:
+		return "record"
+	case Shadow:
+		return "shadow"
+	}
+	return "unknown"
+}
+
+type contextKey int
+
+const (
+	modeKey contextKey = iota
+	namespaceKey
+	skipCacheKey
+	labelKey
+)
+
+// WithMode returns a context making requests use mode, regardless of whether
+// the client was created with caching enabled.
+func WithMode(ctx context.Context, mode Mode) context.Context {
+	return context.WithValue(ctx, modeKey, mode)
+}
+
+// WithNamespace returns a context whose requests are cached separately from
+// those of other namespaces, so that e.g. two teams recording the same prompt
+// don't share responses. The namespace replaces the client's own.
+func WithNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, namespaceKey, namespace)
+}
+
+// SkipCache returns a context whose requests go straight to the API without
+// reading or writing the cache.
+func SkipCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipCacheKey, true)
+}
+
+// Bypass returns a context whose requests opt out of caching: they go
+// straight to the API, like SkipCache, even on a client created with caching
+// enabled. Calling Only on the context afterwards opts back in.
+func Bypass(ctx context.Context) context.Context {
+	return SkipCache(ctx)
+}
+
+// Only returns a context whose requests require the cache: they are served
+// from recordings, and fail with ErrCacheMiss rather than call the API, even on
+// a client created with caching disabled. It is Replay mode, and undoes an
+// earlier Bypass or SkipCache.
+func Only(ctx context.Context) context.Context {
+	return WithMode(context.WithValue(ctx, skipCacheKey, false), Replay)
+}
+
+// WithLabel returns a context whose requests are labelled, e.g.
+// "summarizer/happy-path". The label is stored with the entry so listings and
+// reports can show it instead of the hash; it is not part of the cache key.
+func WithLabel(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, labelKey, label)
+}
+
+func modeFrom(ctx context.Context) (Mode, bool) {
+	mode, ok := ctx.Value(modeKey).(Mode)
+	return mode, ok
+}
+
+func namespaceFrom(ctx context.Context) string {
+	namespace, _ := ctx.Value(namespaceKey).(string)
+	return namespace
+}
+
+func skipCacheFrom(ctx context.Context) bool {
+	skip, _ := ctx.Value(skipCacheKey).(bool)
+	return skip
+}
+
+func labelFrom(ctx context.Context) string {
+	label, _ := ctx.Value(labelKey).(string)
+	return label
+}
+
+// keyedRequest returns req as it is hashed into its cache key. Whether a
+// response is streamed doesn't change it, so streamed and plain requests
+// share recordings.
+func keyedRequest(req openai.ChatCompletionRequest) openai.ChatCompletionRequest {
+	req.Stream, req.StreamOptions = false, nil
+	return req
+}
+
+// generateKey returns the cache key of req within namespace. Requests outside
+// any namespace keep the plain request hash, so existing caches stay valid.
+func generateKey(namespace string, req openai.ChatCompletionRequest) (string, error) {
+	if namespace == "" {
+		return generateHash(req)
+	}
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(append([]byte(namespace+"\x00"), data...))
+	return hex.EncodeToString(hash[:]), nil
+}
@@ -0,0 +1,164 @@
+package llmcache
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContextNamespaceAndReplay(t *testing.T) {
+	req := openai.ChatCompletionRequest{
+		Model:    "gpt-3.5-turbo-0125",
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}},
+	}
+	plain, err := generateKey("", req)
+	assert.NoError(t, err)
+	teamA, err := generateKey("teamA", req)
+	assert.NoError(t, err)
+	assert.NotEqual(t, plain, teamA)
+
+	client := newTestClient(t, &Cache{Responses: map[string]CacheEntry{
+		teamA: {Response: "Hello from team A", Namespace: "teamA"},
+	}})
+
+	ctx := WithMode(context.Background(), Replay)
+	response, cached, err := client.getResponse(WithNamespace(ctx, "teamA"), req)
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	assert.Equal(t, "Hello from team A", response)
+
+	_, _, err = client.getResponse(ctx, req)
+	assert.ErrorIs(t, err, ErrCacheMiss, "replay must not fall through to the API outside the namespace")
+}
+
+func TestReplayOverridesDisabledCache(t *testing.T) {
+	client := newTestClient(t, nil)
+	client.cacheEnabled = false
+
+	_, _, err := client.getResponse(WithMode(context.Background(), Replay), openai.ChatCompletionRequest{Model: "gpt-4o"})
+	assert.ErrorIs(t, err, ErrCacheMiss)
+}
+
+func TestBypassAndOnly(t *testing.T) {
+	client, calls := newEchoClient(t)
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+	ctx := context.Background()
+
+	_, _, err := client.getResponse(Only(ctx), req)
+	assert.ErrorIs(t, err, ErrCacheMiss, "a required cache must not fall through to the API")
+	assert.Equal(t, 0, *calls)
+
+	_, cached, err := client.getResponse(Bypass(ctx), req)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	_, _, err = client.getResponse(Only(ctx), req)
+	assert.ErrorIs(t, err, ErrCacheMiss, "a bypassed call must not be recorded")
+
+	_, cached, err = client.getResponse(ctx, req)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	_, cached, err = client.getResponse(Bypass(ctx), req)
+	assert.NoError(t, err)
+	assert.False(t, cached, "a bypassed call must not read the cache")
+	assert.Equal(t, 3, *calls)
+
+	// The last helper applied wins.
+	_, cached, err = client.getResponse(Only(Bypass(ctx)), req)
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	_, cached, err = client.getResponse(Bypass(Only(ctx)), req)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, 4, *calls)
+
+	client.cacheEnabled = false
+	response, cached, err := client.getResponse(Only(ctx), req)
+	assert.NoError(t, err)
+	assert.True(t, cached, "Only requires the cache even when the client was created without it")
+	assert.Equal(t, "reply to 1 messages", response)
+}
+
+func TestWithLabel(t *testing.T) {
+	req := openai.ChatCompletionRequest{
+		Model:    "gpt-3.5-turbo-0125",
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}},
+	}
+	hash, err := generateHash(req)
+	assert.NoError(t, err)
+	client := newTestClient(t, &Cache{Responses: map[string]CacheEntry{hash: {Response: "Hello"}}})
+	var events []Event
+	client.OnEvent(func(e Event) { events = append(events, e) })
+
+	_, cached, err := client.getResponse(WithLabel(context.Background(), "greeter/happy-path"), req)
+	assert.NoError(t, err)
+	assert.True(t, cached, "the label is not part of the key")
+
+	assert.NoError(t, client.Flush())
+	cache, err := client.store.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, "greeter/happy-path", cache.Responses[hash].Label)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "greeter/happy-path", events[0].Label)
+	}
+}
+
+// FuzzGenerateKey checks the stability of cache keys: a request always gets
+// the same key, whether or not it is streamed, and so does the copy of it a
+// cache entry stores and reads back. encoding/json replaces invalid UTF-8 with
+// U+FFFD, so prompts that aren't valid UTF-8 can't round-trip and are skipped.
+func FuzzGenerateKey(f *testing.F) {
+	f.Add("gpt-4o", "system prompt", "Hi", float32(0), 0, "")
+	f.Add("gpt-4o-mini", "", "<b>&amp;</b>  ", float32(0.7), 42, "team-a")
+	f.Add("claude-3-5-sonnet", "Answer in French.", "naïve café", float32(1.5), -1, "key-abc")
+	f.Fuzz(func(t *testing.T, model, system, prompt string, temperature float32, seed int, namespace string) {
+		for _, s := range []string{model, system, prompt, namespace} {
+			if !utf8.ValidString(s) {
+				t.Skip("not valid UTF-8")
+			}
+		}
+		if math.IsNaN(float64(temperature)) || math.IsInf(float64(temperature), 0) {
+			t.Skip("not representable in JSON")
+		}
+		req := openai.ChatCompletionRequest{Model: model, Temperature: temperature, Seed: &seed, Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: system},
+			{Role: openai.ChatMessageRoleUser, Content: prompt},
+		}}
+		key, err := generateKey(namespace, keyedRequest(req))
+		if err != nil {
+			t.Fatal(err)
+		}
+		again, err := generateKey(namespace, keyedRequest(req))
+		if err != nil || again != key {
+			t.Fatalf("the same request got keys %s and %s (%v)", key, again, err)
+		}
+
+		streamed := req
+		streamed.Stream = true
+		if k, err := generateKey(namespace, keyedRequest(streamed)); err != nil || k != key {
+			t.Fatalf("streaming changed the key from %s to %s (%v)", key, k, err)
+		}
+
+		data, err := json.Marshal(CacheEntry{Request: &req})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stored CacheEntry
+		if err := json.Unmarshal(data, &stored); err != nil {
+			t.Fatal(err)
+		}
+		if k, err := generateKey(namespace, keyedRequest(*stored.Request)); err != nil || k != key {
+			t.Fatalf("the stored request got key %s instead of %s (%v)", k, key, err)
+		}
+
+		changed := req
+		changed.Messages = []openai.ChatCompletionMessage{req.Messages[0], {Role: openai.ChatMessageRoleUser, Content: prompt + "!"}}
+		if k, _ := generateKey(namespace, keyedRequest(changed)); k == key {
+			t.Fatalf("changing the prompt kept the key %s", key)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package llmcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// turnKey identifies a request as a turn of a conversation: the key of the
+// previous turn and how many messages are new since then.
+type turnKey struct {
+	parent string
+	fresh  int
+}
+
+type conversationContextKey struct{}
+
+func withTurn(ctx context.Context, turn turnKey) context.Context {
+	return context.WithValue(ctx, conversationContextKey{}, turn)
+}
+
+func turnFrom(ctx context.Context) (turnKey, bool) {
+	turn, ok := ctx.Value(conversationContextKey{}).(turnKey)
+	return turn, ok
+}
+
+// conversationKey returns the key of req as the turn following parent, whose
+// last fresh messages are new. The key covers the parent key, the request
+// parameters and the new messages, so each turn's key depends on the whole
+// dialogue before it through the chain of parent keys.
+func conversationKey(namespace, parent string, req openai.ChatCompletionRequest, fresh int) (string, error) {
+	params := req
+	params.Messages = nil
+	paramData, err := json.Marshal(params)
+	if err != nil {
+		return "", err
+	}
+	messageData, err := json.Marshal(req.Messages[len(req.Messages)-fresh:])
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, part := range [][]byte{[]byte(namespace), []byte(parent), paramData, messageData} {
+		h.Write(part)
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Conversation is a multi-turn dialogue cached turn by turn. Each turn is
+// keyed by the key of the turn before it and the messages added since, and
+// records its parent, so the turns of a conversation form a chain.
+type Conversation struct {
+	client   *CachingClient
+	req      openai.ChatCompletionRequest
+	messages []openai.ChatCompletionMessage
+	// sent counts the messages covered by the key of the last turn.
+	sent int
+	keys []string
+}
+
+// StartConversation starts a conversation with the model and parameters of
+// req, whose messages, such as a system prompt, open the dialogue.
+func (c *CachingClient) StartConversation(req openai.ChatCompletionRequest) *Conversation {
+	return &Conversation{client: c, req: req, messages: append([]openai.ChatCompletionMessage(nil), req.Messages...)}
+}
+
+// Say adds a user message to the conversation and returns the model's reply,
+// which is added too. The boolean reports whether the reply was cached.
+func (v *Conversation) Say(ctx context.Context, content string) (string, bool, error) {
+	req := v.req
+	req.Messages = append(append([]openai.ChatCompletionMessage(nil), v.messages...),
+		openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: content})
+
+	ctx = withTurn(ctx, turnKey{parent: v.lastKey(), fresh: len(req.Messages) - v.sent})
+	reply, cached, err := v.client.getResponse(ctx, req)
+	if err != nil {
+		return "", false, err
+	}
+	// The turn is keyed as the client keyed it, with its secrets redacted.
+	key, _, err := v.client.cacheKey(ctx, v.client.redactSecrets(req))
+	if err != nil {
+		return "", false, err
+	}
+	v.messages = append(req.Messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: reply})
+	v.sent = len(v.messages)
+	v.keys = append(v.keys, key)
+	return reply, cached, nil
+}
+
+// Messages returns the dialogue so far.
+func (v *Conversation) Messages() []openai.ChatCompletionMessage {
+	return v.messages
+}
+
+// Keys returns the cache key of every turn so far.
+func (v *Conversation) Keys() []string {
+	return v.keys
+}
+
+func (v *Conversation) lastKey() string {
+	if len(v.keys) == 0 {
+		return ""
+	}
+	return v.keys[len(v.keys)-1]
+}
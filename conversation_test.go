@@ -0,0 +1,81 @@
+package llmcache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConversationKeysChain(t *testing.T) {
+	client, calls := newEchoClient(t)
+	ctx := context.Background()
+	seed := 1
+	opening := openai.ChatCompletionRequest{
+		Model:    "gpt-4o-mini",
+		Seed:     &seed,
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleSystem, Content: "Be brief."}},
+	}
+
+	talk := func(lines ...string) (*Conversation, []bool) {
+		conversation := client.StartConversation(opening)
+		var cached []bool
+		for _, line := range lines {
+			_, hit, err := conversation.Say(ctx, line)
+			assert.NoError(t, err)
+			cached = append(cached, hit)
+		}
+		return conversation, cached
+	}
+
+	first, cached := talk("Hi", "How are you?")
+	assert.Equal(t, []bool{false, false}, cached)
+	assert.Len(t, first.Messages(), 5)
+	assert.Equal(t, "reply to 4 messages", first.Messages()[4].Content)
+
+	_, cached = talk("Hi", "How are you?")
+	assert.Equal(t, []bool{true, true}, cached)
+	assert.Equal(t, 2, *calls)
+
+	changed, cached := talk("Hi", "What's up?")
+	assert.Equal(t, []bool{true, false}, cached, "changing turn 2 keeps turn 1 cached")
+	assert.Equal(t, first.Keys()[0], changed.Keys()[0])
+
+	cache, err := client.store.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, first.Keys()[0], cache.Responses[first.Keys()[1]].Parent)
+	assert.Empty(t, cache.Responses[first.Keys()[0]].Parent)
+}
+
+func TestConversationKeysAreTheCachedKeys(t *testing.T) {
+	client, calls := newEchoClient(t)
+	client.SetSecretPolicy(SecretPolicy{High: SecretRedact})
+	ctx := context.Background()
+	opening := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Stream: true}
+
+	talk := func() (*Conversation, []bool) {
+		conversation := client.StartConversation(opening)
+		var cached []bool
+		for _, line := range []string{"log in with sk-abcdefghijklmnopqrstuvwxyz1234", "Then what?"} {
+			_, hit, err := conversation.Say(ctx, line)
+			assert.NoError(t, err)
+			cached = append(cached, hit)
+		}
+		return conversation, cached
+	}
+
+	first, cached := talk()
+	assert.Equal(t, []bool{false, false}, cached)
+	cache, err := client.store.Load()
+	assert.NoError(t, err)
+	for _, key := range first.Keys() {
+		assert.Contains(t, cache.Responses, key)
+	}
+	assert.Equal(t, first.Keys()[0], cache.Responses[first.Keys()[1]].Parent)
+
+	again, cached := talk()
+	assert.Equal(t, []bool{true, true}, cached)
+	assert.Equal(t, first.Keys(), again.Keys())
+	assert.Equal(t, 2, *calls)
+}
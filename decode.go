@@ -0,0 +1,117 @@
+package llmcache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// decodeCache parses the contents of a cache file.
+func decodeCache(data []byte) (*Cache, error) {
+	return readCache(bytes.NewReader(data))
+}
+
+// readCache parses a cache file as it is read from r. Entries are decoded one
+// at a time, so loading a large cache never holds the whole file in memory
+// next to the entries decoded from it. The other fields of the cache are small
+// and decoded as usual.
+func readCache(r io.Reader) (*Cache, error) {
+	dec := json.NewDecoder(bufio.NewReaderSize(r, 64*1024))
+	cache := &Cache{Responses: make(map[string]CacheEntry)}
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+	rest := make(map[string]json.RawMessage)
+	for dec.More() {
+		name, err := readObjectKey(dec)
+		if err != nil {
+			return nil, err
+		}
+		if name != "responses" {
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return nil, err
+			}
+			rest[name] = raw
+			continue
+		}
+		if err := readEntries(dec, cache.Responses); err != nil {
+			return nil, err
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid data after the cache")
+	}
+	if len(rest) > 0 {
+		data, err := json.Marshal(rest)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, cache); err != nil {
+			return nil, err
+		}
+	}
+	if err := unpackResponses(cache); err != nil {
+		return nil, err
+	}
+	return cache, nil
+}
+
+// readEntries decodes the responses object of a cache file into responses,
+// one entry at a time. A null object holds no entries.
+func readEntries(dec *json.Decoder, responses map[string]CacheEntry) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok == nil {
+		return nil
+	}
+	if tok != json.Delim('{') {
+		return fmt.Errorf("responses: want an object, got %v", tok)
+	}
+	for dec.More() {
+		hash, err := readObjectKey(dec)
+		if err != nil {
+			return err
+		}
+		var entry CacheEntry
+		if err := dec.Decode(&entry); err != nil {
+			return fmt.Errorf("entry %s: %w", hash, err)
+		}
+		responses[hash] = entry
+	}
+	return expectDelim(dec, '}')
+}
+
+func readObjectKey(dec *json.Decoder) (string, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return "", err
+	}
+	key, ok := tok.(string)
+	if !ok {
+		return "", fmt.Errorf("want a key, got %v", tok)
+	}
+	return key, nil
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("want %v, got %v", delim, tok)
+	}
+	return nil
+}
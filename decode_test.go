@@ -0,0 +1,144 @@
+package llmcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadCacheMatchesUnmarshal(t *testing.T) {
+	shared := strings.Repeat("shared answer ", 20)
+	cache := &Cache{
+		Responses: map[string]CacheEntry{
+			"a": {Response: shared, Timestamp: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Access: 2, Hits: 1},
+			"b": {Response: shared, Label: "b"},
+			"c": {Response: "short", Request: &openai.ChatCompletionRequest{Model: "gpt-4o"}},
+		},
+		Sessions: map[string]SessionRecord{"s": {}},
+		Savings:  map[string]Savings{"2024-01-01": {Runs: 1, Hits: 2}},
+		Sequence: 2,
+	}
+	for _, compact := range []bool{true, false} {
+		data, err := encodeCache(cache, compact)
+		assert.NoError(t, err)
+		read, err := decodeCache(data)
+		assert.NoError(t, err)
+		assert.Equal(t, cache, read)
+	}
+
+	read, err := decodeCache([]byte(`{"sequence": 3, "responses": null}`))
+	assert.NoError(t, err)
+	assert.Equal(t, &Cache{Responses: map[string]CacheEntry{}, Sequence: 3}, read)
+}
+
+func TestReadCacheRejectsCorruptFiles(t *testing.T) {
+	for _, data := range []string{
+		``,
+		`[]`,
+		`{"responses": {"a": {"response": "x"}`,
+		`{"responses": {"a": {"response": 1}}}`,
+		`{"responses": []}`,
+		`{"responses": {}} {}`,
+		`{"sequence": "one"}`,
+	} {
+		_, err := decodeCache([]byte(data))
+		assert.Error(t, err, data)
+	}
+
+	path := filepath.Join(t.TempDir(), "cache.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"responses": {"a": {"response": 1}}}`), 0644))
+	_, err := loadCacheFrom(path)
+	assert.ErrorIs(t, err, ErrCacheCorrupt)
+	assert.ErrorContains(t, err, "entry a")
+}
+
+// writeLargeCache writes a cache of n entries, each with a response of a
+// kilobyte and its request, and returns its path and size.
+func writeLargeCache(b *testing.B, n int) (string, int64) {
+	b.Helper()
+	cache := &Cache{Responses: make(map[string]CacheEntry, n)}
+	for i := 0; i < n; i++ {
+		req := &openai.ChatCompletionRequest{Model: "gpt-4o", Messages: []openai.ChatCompletionMessage{{Role: "user", Content: fmt.Sprintf("question %d", i)}}}
+		cache.Responses[fmt.Sprintf("%064d", i)] = CacheEntry{Response: fmt.Sprintf("%d %s", i, strings.Repeat("x", 1024)), Request: req, Timestamp: time.Unix(int64(i), 0).UTC()}
+	}
+	path := filepath.Join(b.TempDir(), "cache.json")
+	if err := writeCache(path, cache, false); err != nil {
+		b.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return path, info.Size()
+}
+
+// BenchmarkLoadCache compares loading a large cache file with the streaming
+// decoder to reading it whole and unmarshalling it, as caches used to be
+// loaded. Compare the B/op of the two.
+func BenchmarkLoadCache(b *testing.B) {
+	path, size := writeLargeCache(b, 20000)
+	b.Run("stream", func(b *testing.B) {
+		b.SetBytes(size)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := loadCacheFrom(path); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("read-all", func(b *testing.B) {
+		b.SetBytes(size)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			var cache Cache
+			if err := json.Unmarshal(data, &cache); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// FuzzReadCache checks that no cache file, however malformed, crashes the
+// decoder, and that whatever it accepts is saved in a stable form: loading and
+// saving a saved cache writes it back byte for byte.
+func FuzzReadCache(f *testing.F) {
+	f.Add([]byte(`{"responses": {"a": {"response": "hello", "timestamp": "2024-01-01T00:00:00Z", "access": 1}}, "sequence": 1}`))
+	f.Add([]byte(`{"responses": {"a": {"response": "", "response_ref": "b"}, "c": {"response_ref": "b"}}, "blobs": {"b": "shared"}}`))
+	f.Add([]byte(`{"responses": null, "savings": {"2024-01-01": {"runs": 1}}, "sessions": {}}`))
+	f.Add([]byte(`{"responses": {"a": {"response": "x", "request": {"model": "gpt-4o", "messages": [{"role": "user", "content": "hi"}]}}}}`))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cache, err := decodeCache(data)
+		if err != nil {
+			return
+		}
+		for _, compact := range []bool{true, false} {
+			saved, err := encodeCache(cache, compact)
+			if err != nil {
+				t.Fatalf("encoding a decoded cache: %v", err)
+			}
+			reread, err := decodeCache(saved)
+			if err != nil {
+				t.Fatalf("decoding a saved cache: %v\n%s", err, saved)
+			}
+			resaved, err := encodeCache(reread, compact)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(saved, resaved) {
+				t.Fatalf("saving a saved cache rewrites it:\n%s\n%s", saved, resaved)
+			}
+		}
+	})
+}
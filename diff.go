@@ -0,0 +1,231 @@
+package llmcache
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+type diffStatus string
+
+const (
+	diffChanged diffStatus = "changed"
+	diffRemoved diffStatus = "removed"
+	diffAdded   diffStatus = "added"
+)
+
+// EntryDiff describes how the response recorded under one cache key differs
+// between two sources.
+type EntryDiff struct {
+	Hash   string     `json:"key"`
+	Model  string     `json:"model,omitempty"`
+	Status diffStatus `json:"status"`
+	Old    string     `json:"old,omitempty"`
+	New    string     `json:"new,omitempty"`
+}
+
+// diffCaches compares two caches entry-by-entry and returns the entries whose
+// responses differ, sorted by hash so the output is stable between runs.
+func diffCaches(before, after *Cache) []EntryDiff {
+	var diffs []EntryDiff
+	for hash, oldEntry := range before.Responses {
+		newEntry, found := after.Responses[hash]
+		if !found {
+			diffs = append(diffs, EntryDiff{Hash: hash, Model: entryModel(oldEntry), Status: diffRemoved, Old: oldEntry.Response})
+			continue
+		}
+		if oldEntry.Response != newEntry.Response {
+			diffs = append(diffs, EntryDiff{Hash: hash, Model: entryModel(oldEntry), Status: diffChanged, Old: oldEntry.Response, New: newEntry.Response})
+		}
+	}
+	for hash, newEntry := range after.Responses {
+		if _, found := before.Responses[hash]; !found {
+			diffs = append(diffs, EntryDiff{Hash: hash, Model: entryModel(newEntry), Status: diffAdded, New: newEntry.Response})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Hash < diffs[j].Hash
+	})
+	return diffs
+}
+
+// diffLive re-issues every recorded request in cache against the API and
+// compares the live response with the recorded one. Entries recorded before
+// requests were stored alongside responses cannot be replayed and are counted
+// as skipped.
+func (c *CachingClient) diffLive(ctx context.Context, cache *Cache) ([]EntryDiff, int, error) {
+	hashes := make([]string, 0, len(cache.Responses))
+	for hash := range cache.Responses {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	var diffs []EntryDiff
+	skipped := 0
+	for _, hash := range hashes {
+		entry := cache.Responses[hash]
+		if entry.Request == nil {
+			skipped++
+			continue
+		}
+		live, _, err := c.fetchResponse(ctx, *entry.Request)
+		if err != nil {
+			return nil, skipped, fmt.Errorf("fetching live response for %s: %w", hash, err)
+		}
+		if live != entry.Response {
+			diffs = append(diffs, EntryDiff{Hash: hash, Model: entry.Request.Model, Status: diffChanged, Old: entry.Response, New: live})
+		}
+	}
+	return diffs, skipped, nil
+}
+
+func entryModel(entry CacheEntry) string {
+	if entry.Request == nil {
+		return ""
+	}
+	return entry.Request.Model
+}
+
+// diffLines returns a line-oriented diff of a and b. Each line is prefixed
+// with "  " when unchanged, "- " when only in a and "+ " when only in b.
+func diffLines(a, b string) []string {
+	x := strings.Split(a, "\n")
+	y := strings.Split(b, "\n")
+
+	// lcs[i][j] holds the length of the longest common subsequence of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var out []string
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			out = append(out, "  "+x[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "- "+x[i])
+			i++
+		default:
+			out = append(out, "+ "+y[j])
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		out = append(out, "- "+x[i])
+	}
+	for ; j < len(y); j++ {
+		out = append(out, "+ "+y[j])
+	}
+	return out
+}
+
+func printDiffs(diffs []EntryDiff, oldName, newName string) {
+	for _, d := range diffs {
+		label := d.Hash
+		if d.Model != "" {
+			label = fmt.Sprintf("%s (%s)", d.Hash, d.Model)
+		}
+		switch d.Status {
+		case diffRemoved:
+			fmt.Fprintf(console, "- %s: only in %s\n", label, oldName)
+		case diffAdded:
+			fmt.Fprintf(console, "+ %s: only in %s\n", label, newName)
+		case diffChanged:
+			fmt.Fprintf(console, "~ %s: response changed\n", label)
+			for _, line := range diffLines(d.Old, d.New) {
+				fmt.Fprintf(console, "    %s\n", line)
+			}
+		}
+	}
+}
+
+func runDiff(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ContinueOnError)
+	live := fs.Bool("live", false, "Compare the cache against live API responses instead of a second snapshot")
+	webhook := fs.String("webhook", "", "With -live, post the drifted entries to this Slack incoming webhook or JSON endpoint")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: llm-test-cache diff OLD.json|@snapshot NEW.json|@snapshot")
+		fmt.Fprintln(fs.Output(), "       llm-test-cache diff -live [CACHE.json]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *live {
+		path := cacheFile
+		if fs.NArg() > 0 {
+			var err error
+			if path, err = resolveCachePath(fs.Arg(0)); err != nil {
+				return err
+			}
+		}
+		apiKey := os.Getenv("OPENAI_API_KEY")
+		if apiKey == "" {
+			return fmt.Errorf("OPENAI_API_KEY environment variable not set")
+		}
+		cache, err := loadCacheFrom(path)
+		if err != nil {
+			return err
+		}
+		client := NewCachingClient(apiKey, false, DefaultCacheSizeLimit)
+		diffs, skipped, err := client.diffLive(interruptContext(), cache)
+		if err != nil {
+			return err
+		}
+		if err := client.Close(); err != nil {
+			return err
+		}
+		printDiffs(diffs, path, "live")
+		fmt.Fprintf(console, "%d of %d entries drifted, %d skipped (no recorded request)\n", len(diffs), len(cache.Responses)-skipped, skipped)
+		if *webhook != "" && len(diffs) > 0 {
+			if err := notifyDrift(*webhook, diffs); err != nil {
+				return err
+			}
+		}
+		return report(map[string]any{"diffs": append([]EntryDiff{}, diffs...), "skipped": skipped})
+	}
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("diff needs exactly two cache files")
+	}
+	oldPath, err := resolveCachePath(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	newPath, err := resolveCachePath(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	oldCache, err := loadCacheFrom(oldPath)
+	if err != nil {
+		return err
+	}
+	newCache, err := loadCacheFrom(newPath)
+	if err != nil {
+		return err
+	}
+	diffs := diffCaches(oldCache, newCache)
+	printDiffs(diffs, fs.Arg(0), fs.Arg(1))
+	fmt.Fprintf(console, "%d entries differ\n", len(diffs))
+	return report(map[string]any{"diffs": append([]EntryDiff{}, diffs...)})
+}
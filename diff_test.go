@@ -0,0 +1,35 @@
+package llmcache
+
+import (
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffCaches(t *testing.T) {
+	req := &openai.ChatCompletionRequest{Model: "gpt-3.5-turbo-0125"}
+	before := &Cache{Responses: map[string]CacheEntry{
+		"a": {Response: "same", Request: req},
+		"b": {Response: "old answer", Request: req},
+		"c": {Response: "gone"},
+	}}
+	after := &Cache{Responses: map[string]CacheEntry{
+		"a": {Response: "same", Request: req},
+		"b": {Response: "new answer", Request: req},
+		"d": {Response: "fresh"},
+	}}
+
+	diffs := diffCaches(before, after)
+	assert.Len(t, diffs, 3)
+	assert.Equal(t, EntryDiff{Hash: "b", Model: req.Model, Status: diffChanged, Old: "old answer", New: "new answer"}, diffs[0])
+	assert.Equal(t, diffRemoved, diffs[1].Status)
+	assert.Equal(t, "c", diffs[1].Hash)
+	assert.Equal(t, diffAdded, diffs[2].Status)
+	assert.Equal(t, "d", diffs[2].Hash)
+}
+
+func TestDiffLines(t *testing.T) {
+	got := diffLines("one\ntwo\nthree", "one\n2\nthree\nfour")
+	assert.Equal(t, []string{"  one", "- two", "+ 2", "  three", "+ four"}, got)
+}
@@ -0,0 +1,165 @@
+package llmcache
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// doctorCheck is one check of the doctor command. A failed check says how to
+// fix it.
+type doctorCheck struct {
+	Name string
+	Err  error
+	Fix  string
+}
+
+// checkCacheWritable checks that a cache can be written at path.
+func checkCacheWritable(path string) doctorCheck {
+	check := doctorCheck{Name: "cache directory " + filepath.Dir(path) + " is writable"}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		check.Err, check.Fix = err, "create the directory or point the cache somewhere writable"
+		return check
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".doctor-*")
+	if err != nil {
+		check.Err, check.Fix = err, "fix the directory's permissions, or use -read-only for a checked-in cache"
+		return check
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return check
+}
+
+// checkCacheParses checks that the cache at path, if any, can be loaded.
+func checkCacheParses(path string) doctorCheck {
+	check := doctorCheck{Name: "cache file " + path + " parses"}
+	cache, err := loadCacheFrom(path)
+	if err != nil {
+		check.Err = err
+		check.Fix = "restore it with snapshot rollback or from version control, or delete it to start over"
+		return check
+	}
+	check.Name += fmt.Sprintf(" (%d entries)", len(cache.Responses))
+	return check
+}
+
+// checkLock checks that no live run holds the lock on the cache at path.
+// Locks left behind by crashed runs on this host are recovered automatically.
+func checkLock(path string) doctorCheck {
+	lockPath := path + ".lock"
+	check := doctorCheck{Name: "cache is not locked"}
+	pid, host, err := readLock(lockPath)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		check.Err, check.Fix = err, "remove "+lockPath+" if no other run is active, or run with -force-unlock"
+	case staleLock(lockPath):
+		check.Name = fmt.Sprintf("lock left by process %d, which is no longer running, will be recovered", pid)
+	default:
+		check.Err = fmt.Errorf("%s is held by process %d on %s", lockPath, pid, host)
+		check.Fix = "wait for that run to finish, or run with -force-unlock if it is gone"
+	}
+	return check
+}
+
+// checkAPIKey checks that an API key is set, unless the endpoint is local.
+func checkAPIKey(baseURL string) doctorCheck {
+	check := doctorCheck{Name: "OPENAI_API_KEY is set"}
+	if baseURL != "" && isLocalURL(baseURL) {
+		check.Name = "no API key needed for local endpoint " + baseURL
+		return check
+	}
+	if os.Getenv("OPENAI_API_KEY") == "" {
+		check.Err = errors.New("OPENAI_API_KEY is not set")
+		check.Fix = "export OPENAI_API_KEY, or use -read-only/replay to run from the cache alone"
+	}
+	return check
+}
+
+// checkBackend checks that the API answers, and accepts the key, by listing
+// its models.
+func checkBackend(ctx context.Context, client *CachingClient) doctorCheck {
+	check := doctorCheck{Name: "API is reachable and accepts the key"}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	_, err := client.ListModels(ctx)
+	var apiErr *openai.APIError
+	switch {
+	case err == nil:
+	case errors.As(err, &apiErr) && apiErr.HTTPStatusCode == 401:
+		check.Err, check.Fix = err, "the API rejected the key: check OPENAI_API_KEY, and -openai-org/-openai-project"
+	default:
+		check.Err, check.Fix = err, "check -base-url, your network, and -upstream-proxy or HTTPS_PROXY"
+	}
+	return check
+}
+
+func runDoctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	flags := addClientFlags(fs, true)
+	cachePath := fs.String("cache", cacheFile, "Cache file to check")
+	offline := fs.Bool("offline", false, "Skip the checks that call the API")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: llm-test-cache doctor [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	checks := []doctorCheck{
+		checkCacheWritable(*cachePath),
+		checkCacheParses(*cachePath),
+		checkLock(*cachePath),
+		checkAPIKey(*flags.baseURL),
+	}
+	if !*offline && checks[len(checks)-1].Err == nil {
+		flags.keyOptional = true
+		client, err := flags.newClient(context.Background())
+		if err != nil {
+			checks = append(checks, doctorCheck{Name: "client configuration is valid", Err: err, Fix: "fix the flags named in the error"})
+		} else {
+			// The client never opens the cache, so it isn't closed, which
+			// would print a summary of an empty run.
+			checks = append(checks, checkBackend(context.Background(), client))
+		}
+	}
+	return printChecks(checks)
+}
+
+// printChecks prints and reports the outcome of checks, failing if any of
+// them failed.
+func printChecks(checks []doctorCheck) error {
+	type checkReport struct {
+		Name  string `json:"name"`
+		OK    bool   `json:"ok"`
+		Error string `json:"error,omitempty"`
+		Fix   string `json:"fix,omitempty"`
+	}
+	reports := []checkReport{}
+	failed := 0
+	for _, check := range checks {
+		if check.Err == nil {
+			fmt.Fprintf(console, "ok    %s\n", check.Name)
+			reports = append(reports, checkReport{Name: check.Name, OK: true})
+			continue
+		}
+		failed++
+		fmt.Fprintf(console, "FAIL  %s: %v\n      fix: %s\n", check.Name, check.Err, check.Fix)
+		reports = append(reports, checkReport{Name: check.Name, Error: check.Err.Error(), Fix: check.Fix})
+	}
+	if err := report(reports); err != nil {
+		return err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
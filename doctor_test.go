@@ -0,0 +1,62 @@
+package llmcache
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoctorCacheChecks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "response-cache.json")
+	assert.NoError(t, checkCacheWritable(path).Err)
+	assert.NoError(t, checkCacheParses(path).Err, "a missing cache is an empty one")
+	assert.NoError(t, checkLock(path).Err)
+
+	assert.NoError(t, os.WriteFile(path, []byte("{not json"), 0644))
+	check := checkCacheParses(path)
+	assert.ErrorIs(t, check.Err, ErrCacheCorrupt)
+	assert.NotEmpty(t, check.Fix)
+
+	assert.NoError(t, os.WriteFile(path+".lock", []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644))
+	check = checkLock(path)
+	if assert.Error(t, check.Err) {
+		assert.Contains(t, check.Err.Error(), fmt.Sprint(os.Getpid()))
+	}
+	assert.NoError(t, os.WriteFile(path+".lock", []byte(fmt.Sprintf("%d\n", deadPID)), 0644))
+	assert.NoError(t, checkLock(path).Err, "stale locks are recovered")
+}
+
+func TestDoctorBackendCheck(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer good-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			io.WriteString(w, `{"error":{"message":"invalid key"}}`)
+			return
+		}
+		io.WriteString(w, `{"object":"list","data":[]}`)
+	}))
+	t.Cleanup(server.Close)
+	clientWith := func(baseURL, key string) *CachingClient {
+		config := openai.DefaultConfig(key)
+		config.BaseURL = baseURL
+		return &CachingClient{Client: openai.NewClientWithConfig(config)}
+	}
+
+	assert.NoError(t, checkBackend(context.Background(), clientWith(server.URL, "good-key")).Err)
+	check := checkBackend(context.Background(), clientWith(server.URL, "bad-key"))
+	if assert.Error(t, check.Err) {
+		assert.Contains(t, check.Fix, "rejected the key")
+	}
+	check = checkBackend(context.Background(), clientWith("http://127.0.0.1:1", "good-key"))
+	if assert.Error(t, check.Err) {
+		assert.Contains(t, check.Fix, "-base-url")
+	}
+}
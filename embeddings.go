@@ -0,0 +1,192 @@
+package llmcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// EmbeddingEntry is the cached embedding of one input string. Inputs are
+// cached one by one rather than per request, so batches that overlap share
+// the vectors of the inputs they have in common.
+type EmbeddingEntry struct {
+	Model      string    `json:"model"`
+	Dimensions int       `json:"dimensions,omitempty"`
+	Input      string    `json:"input"`
+	Embedding  []float32 `json:"embedding"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Recorded   time.Time `json:"recorded"`
+	Hits       int       `json:"hits,omitempty"`
+	// Signature is the signing key's signature of the other fields but Hits.
+	Signature string `json:"signature,omitempty"`
+}
+
+// embeddingKey returns the cache key of the embedding of input requested by
+// req within namespace. The encoding format isn't part of it, since vectors
+// are cached decoded.
+func embeddingKey(namespace string, req openai.EmbeddingRequest, input string) (string, error) {
+	data, err := json.Marshal(struct {
+		Model      openai.EmbeddingModel `json:"model"`
+		Dimensions int                   `json:"dimensions,omitempty"`
+		User       string                `json:"user,omitempty"`
+		Input      string                `json:"input"`
+	}{req.Model, req.Dimensions, req.User, input})
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(append([]byte(namespace+"\x00"), data...))
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// embeddingInputs returns the inputs of req if they are strings, which are
+// the only inputs cached.
+func embeddingInputs(req openai.EmbeddingRequest) ([]string, bool) {
+	switch input := req.Input.(type) {
+	case string:
+		return []string{input}, true
+	case []string:
+		return input, true
+	}
+	return nil, false
+}
+
+// CreateEmbeddings returns the embeddings of the inputs of conv, caching the
+// vector of every input string separately: only the inputs that aren't cached
+// yet are sent upstream, in one request, and the response puts cached and
+// fresh vectors back in the order of the inputs. Requests for token inputs
+// aren't cached.
+func (c *CachingClient) CreateEmbeddings(ctx context.Context, conv openai.EmbeddingRequestConverter) (openai.EmbeddingResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return openai.EmbeddingResponse{}, ErrClientClosed
+	}
+	req := c.redactEmbeddingSecrets(conv.Convert())
+	mode, explicit := modeFrom(ctx)
+	inputs, ok := embeddingInputs(req)
+	if !ok || skipCacheFrom(ctx) || (!c.cacheEnabled && !explicit) {
+		if mode == Shadow {
+			return openai.EmbeddingResponse{}, fmt.Errorf("%w: the embeddings aren't cached", ErrShadowed)
+		}
+		return c.fetchEmbeddings(ctx, req)
+	}
+
+	cache, err := c.store.Load()
+	if err != nil {
+		return openai.EmbeddingResponse{}, err
+	}
+	if cache.Embeddings == nil {
+		cache.Embeddings = make(map[string]EmbeddingEntry)
+	}
+	namespace := namespaceFrom(ctx)
+	if namespace == "" {
+		namespace = c.namespace
+	}
+
+	keys := make([]string, len(inputs))
+	var missing []string
+	sent := make(map[string]bool)
+	for i, input := range inputs {
+		if keys[i], err = embeddingKey(namespace, req, input); err != nil {
+			return openai.EmbeddingResponse{}, err
+		}
+		entry, found := cache.Embeddings[keys[i]]
+		if found && mode != Record && c.verifyKey != nil {
+			if err := verifyEmbedding(c.verifyKey, keys[i], entry); err != nil {
+				return openai.EmbeddingResponse{}, err
+			}
+		}
+		if (!found || mode == Record) && !sent[keys[i]] {
+			sent[keys[i]] = true
+			missing = append(missing, input)
+		}
+	}
+	resp := openai.EmbeddingResponse{Object: "list", Model: req.Model}
+	if len(missing) > 0 {
+		switch {
+		case mode == Replay:
+			return openai.EmbeddingResponse{}, fmt.Errorf("%w: %d of %d embeddings", ErrCacheMiss, len(missing), len(inputs))
+		case mode == Shadow:
+			return openai.EmbeddingResponse{}, fmt.Errorf("%w: %d of %d embeddings", ErrShadowed, len(missing), len(inputs))
+		case c.readOnly:
+			return openai.EmbeddingResponse{}, fmt.Errorf("%w: not recording %d embeddings", ErrReadOnly, len(missing))
+		}
+		c.stats.EmbeddingMisses += len(missing)
+		upstream := req
+		upstream.Input = missing
+		fetched, err := c.fetchEmbeddings(ctx, upstream)
+		if err != nil {
+			return openai.EmbeddingResponse{}, err
+		}
+		// Other goroutines may have saved the cache while the request was
+		// sent.
+		if cache, err = c.store.Load(); err != nil {
+			return openai.EmbeddingResponse{}, err
+		}
+		if cache.Embeddings == nil {
+			cache.Embeddings = make(map[string]EmbeddingEntry)
+		}
+		if len(fetched.Data) != len(missing) {
+			return openai.EmbeddingResponse{}, fmt.Errorf("expected %d embeddings, got %d", len(missing), len(fetched.Data))
+		}
+		now := c.now()
+		for _, e := range fetched.Data {
+			if e.Index < 0 || e.Index >= len(missing) {
+				return openai.EmbeddingResponse{}, fmt.Errorf("embedding index %d out of range", e.Index)
+			}
+			input := missing[e.Index]
+			key, _ := embeddingKey(namespace, req, input)
+			entry := EmbeddingEntry{Model: string(req.Model), Dimensions: req.Dimensions, Input: input, Embedding: e.Embedding, Namespace: namespace, Recorded: now}
+			if c.signingKey != nil {
+				if entry, err = signEmbedding(c.signingKey, key, entry); err != nil {
+					return openai.EmbeddingResponse{}, err
+				}
+			}
+			cache.Embeddings[key] = entry
+		}
+		resp.Model, resp.Usage = fetched.Model, fetched.Usage
+	}
+	c.stats.EmbeddingHits += len(inputs) - len(missing)
+
+	for i, key := range keys {
+		entry := cache.Embeddings[key]
+		if !sent[key] && !c.noTouch {
+			entry.Hits++
+			cache.Embeddings[key] = entry
+		}
+		resp.Data = append(resp.Data, openai.Embedding{Object: "embedding", Embedding: entry.Embedding, Index: i})
+	}
+	if !c.readOnly && (len(missing) > 0 || !c.noTouch) {
+		if err := c.saveCache(cache); err != nil {
+			return openai.EmbeddingResponse{}, err
+		}
+	}
+	return resp, nil
+}
+
+// fetchEmbeddings calls the embeddings API directly, refusing to once the run
+// has spent its budget or when an input holds a secret the secret policy
+// blocks. The caller holds the client's lock, which is released while the
+// request is sent.
+func (c *CachingClient) fetchEmbeddings(ctx context.Context, req openai.EmbeddingRequest) (openai.EmbeddingResponse, error) {
+	req = c.redactEmbeddingSecrets(req)
+	if c.maxCost > 0 && c.stats.EstimatedCost >= c.maxCost {
+		return openai.EmbeddingResponse{}, fmt.Errorf("%w: estimated cost $%.4f reached the limit of $%.4f", ErrBudgetExceeded, c.stats.EstimatedCost, c.maxCost)
+	}
+	if err := c.checkEmbeddingSecrets(req); err != nil {
+		return openai.EmbeddingResponse{}, err
+	}
+	var resp openai.EmbeddingResponse
+	var err error
+	c.unlocked(func() { resp, err = c.Client.CreateEmbeddings(ctx, req) })
+	if err != nil {
+		return openai.EmbeddingResponse{}, err
+	}
+	c.stats.recordUsage(string(req.Model), resp.Usage)
+	return resp, nil
+}
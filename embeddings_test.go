@@ -0,0 +1,77 @@
+package llmcache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+// newEmbeddingClient returns a test client whose API embeds every input as a
+// one-dimensional vector of its length, and records the inputs of each
+// request.
+func newEmbeddingClient(t *testing.T) (*CachingClient, *[][]string) {
+	t.Helper()
+	var sent [][]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.EmbeddingRequestStrings
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		sent = append(sent, req.Input)
+		resp := openai.EmbeddingResponse{Object: "list", Model: req.Model, Usage: openai.Usage{PromptTokens: len(req.Input)}}
+		for i, input := range req.Input {
+			resp.Data = append(resp.Data, openai.Embedding{Object: "embedding", Embedding: []float32{float32(len(input))}, Index: i})
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(server.Close)
+
+	client := newTestClient(t, nil)
+	config := openai.DefaultConfig("test-key")
+	config.BaseURL = server.URL
+	client.Client = openai.NewClientWithConfig(config)
+	return client, &sent
+}
+
+func vectors(resp openai.EmbeddingResponse) [][]float32 {
+	var vs [][]float32
+	for _, e := range resp.Data {
+		vs = append(vs, e.Embedding)
+	}
+	return vs
+}
+
+func TestEmbeddingsAreCachedPerInput(t *testing.T) {
+	client, sent := newEmbeddingClient(t)
+	ctx := context.Background()
+	resp, err := client.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{Model: openai.SmallEmbedding3, Input: []string{"a", "bb"}})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]float32{{1}, {2}}, vectors(resp))
+
+	// Only the input the overlapping batch doesn't share goes upstream, once.
+	resp, err = client.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{Model: openai.SmallEmbedding3, Input: []string{"bb", "ccc", "a", "ccc"}})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]float32{{2}, {3}, {1}, {3}}, vectors(resp))
+	for i, e := range resp.Data {
+		assert.Equal(t, i, e.Index)
+	}
+	assert.Equal(t, [][]string{{"a", "bb"}, {"ccc"}}, *sent)
+
+	resp, err = client.CreateEmbeddings(WithMode(ctx, Replay), openai.EmbeddingRequestStrings{Model: openai.SmallEmbedding3, Input: []string{"ccc"}})
+	assert.NoError(t, err)
+	assert.Equal(t, [][]float32{{3}}, vectors(resp))
+	_, err = client.CreateEmbeddings(WithMode(ctx, Replay), openai.EmbeddingRequestStrings{Model: openai.SmallEmbedding3, Input: []string{"a", "dddd"}})
+	assert.ErrorIs(t, err, ErrCacheMiss)
+
+	// Other models and dimensions are cached separately.
+	_, err = client.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{Model: openai.SmallEmbedding3, Dimensions: 256, Input: []string{"a"}})
+	assert.NoError(t, err)
+	assert.Len(t, *sent, 3)
+
+	stats := client.Stats()
+	assert.Equal(t, 4, stats.EmbeddingHits)
+	assert.Equal(t, 4, stats.EmbeddingMisses)
+}
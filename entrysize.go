@@ -0,0 +1,28 @@
+package llmcache
+
+import "unicode/utf8"
+
+// truncatedMarker ends every response truncated to fit the maximum entry size.
+const truncatedMarker = "\n[truncated by llm-test-cache]"
+
+// fitEntry applies the maximum entry size to response, returning the response
+// to store and whether to store it at all. Oversized responses are skipped
+// unless the client truncates them, in which case they are cut at a character
+// boundary and end with truncatedMarker.
+func (c *CachingClient) fitEntry(response string) (string, bool) {
+	if c.maxEntrySize <= 0 || int64(len(response)) <= c.maxEntrySize {
+		return response, true
+	}
+	c.stats.Oversized++
+	if !c.truncateOversized {
+		return "", false
+	}
+	limit := int(c.maxEntrySize) - len(truncatedMarker)
+	if limit < 0 {
+		limit = 0
+	}
+	for limit > 0 && !utf8.RuneStart(response[limit]) {
+		limit--
+	}
+	return response[:limit] + truncatedMarker, true
+}
@@ -0,0 +1,27 @@
+package llmcache
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFitEntry(t *testing.T) {
+	client := &CachingClient{maxEntrySize: 40}
+
+	stored, ok := client.fitEntry("short")
+	assert.True(t, ok)
+	assert.Equal(t, "short", stored)
+
+	_, ok = client.fitEntry(strings.Repeat("x", 41))
+	assert.False(t, ok, "oversized responses are not cached by default")
+
+	client.truncateOversized = true
+	stored, ok = client.fitEntry(strings.Repeat("é", 30))
+	assert.True(t, ok)
+	assert.LessOrEqual(t, len(stored), 40)
+	assert.True(t, strings.HasSuffix(stored, truncatedMarker))
+	assert.True(t, strings.HasPrefix(stored, "éééé"), "truncation must not split a character")
+	assert.Equal(t, 2, client.Stats().Oversized)
+}
@@ -0,0 +1,59 @@
+package llmcache
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Errors returned by the caching client. Callers should match them with
+// errors.Is, since they are usually wrapped with more context.
+var (
+	// ErrCacheMiss means no response is recorded for the request.
+	ErrCacheMiss = errors.New("cache miss")
+	// ErrCacheCorrupt means the cache file exists but can't be parsed.
+	ErrCacheCorrupt = errors.New("cache file is corrupt")
+	// ErrStoreLocked means another process holds the lock on the cache.
+	ErrStoreLocked = errors.New("cache is locked by another process")
+	// ErrBudgetExceeded means a live request was refused because the run has
+	// already spent its configured budget.
+	ErrBudgetExceeded = errors.New("budget exceeded")
+	// ErrUnstableRequest means a strict client refused to record a request
+	// whose response is unlikely to be stable.
+	ErrUnstableRequest = errors.New("request is unlikely to be cache-stable")
+	// ErrSessionDiverged means a replayed session received a request that
+	// differs from the recording, or a different number of turns.
+	ErrSessionDiverged = errors.New("session diverged from its recording")
+	// ErrUntrustedEntry means a client with a verify key found a cached
+	// response that isn't validly signed.
+	ErrUntrustedEntry = errors.New("cache entry is not trusted")
+	// ErrReadOnly means a read-only client or store was asked to record or
+	// save.
+	ErrReadOnly = errors.New("cache is read-only")
+	// ErrShadowed means a request missed the cache in Shadow mode, so it
+	// wasn't sent.
+	ErrShadowed = errors.New("not sent in shadow mode")
+	// ErrSecretBlocked means a request wasn't sent because its prompt
+	// contains a secret the client's secret policy blocks.
+	ErrSecretBlocked = errors.New("prompt contains a secret")
+	// ErrRequestInvalid means a request wasn't sent because its model
+	// couldn't serve it, e.g. because it doesn't fit the context window.
+	ErrRequestInvalid = errors.New("request exceeds the model's capabilities")
+	// ErrClientClosed means the client was used after Close.
+	ErrClientClosed = errors.New("caching client is closed")
+)
+
+// UpstreamError wraps an error returned by the API with the cache key and
+// model of the request that caused it.
+type UpstreamError struct {
+	Hash  string
+	Model string
+	Err   error
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("upstream request %s (model %s) failed: %v", e.Hash, e.Model, e.Err)
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
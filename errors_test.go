@@ -0,0 +1,44 @@
+package llmcache
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadCorruptCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	assert.NoError(t, os.WriteFile(path, []byte("{not json"), 0644))
+
+	_, err := loadCacheFrom(path)
+	assert.ErrorIs(t, err, ErrCacheCorrupt)
+}
+
+func TestLookupMiss(t *testing.T) {
+	_, err := lookup(&Cache{Responses: map[string]CacheEntry{}}, "abc")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+}
+
+func TestBudgetExceeded(t *testing.T) {
+	client := NewCachingClient("test-key", false, DefaultCacheSizeLimit)
+	client.maxCost = 0.01
+	client.stats.EstimatedCost = 0.02
+
+	_, _, err := client.getResponse(context.Background(), openai.ChatCompletionRequest{Model: "gpt-3.5-turbo-0125"})
+	assert.ErrorIs(t, err, ErrBudgetExceeded)
+}
+
+func TestUpstreamErrorUnwraps(t *testing.T) {
+	apiErr := &openai.APIError{HTTPStatusCode: 429, Message: "rate limited"}
+	err := error(&UpstreamError{Hash: "abc", Model: "gpt-4o", Err: apiErr})
+
+	var target *openai.APIError
+	assert.True(t, errors.As(err, &target))
+	assert.Equal(t, 429, target.HTTPStatusCode)
+	assert.Contains(t, err.Error(), "abc")
+}
@@ -0,0 +1,187 @@
+package llmcache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// judgeUser marks judge requests so that eval doesn't grade its own verdicts
+// when they are later found in the cache.
+const judgeUser = "llm-test-cache-judge"
+
+const judgePromptTemplate = `You are grading the response of an AI assistant.
+
+Criteria:
+%s
+
+Prompt given to the assistant:
+%s
+
+Assistant response:
+%s
+
+Decide whether the response satisfies the criteria. Reply with only a JSON object of the form {"pass": true, "score": 8, "reason": "one sentence"} where score is from 1 (worst) to 10 (best).`
+
+// EvalResult is the judge's verdict on one candidate response.
+type EvalResult struct {
+	Hash   string `json:"hash"`
+	Model  string `json:"model"`
+	Prompt string `json:"prompt"`
+	Pass   bool   `json:"pass"`
+	Score  int    `json:"score"`
+	Reason string `json:"reason"`
+}
+
+type judgeVerdict struct {
+	Pass   bool   `json:"pass"`
+	Score  int    `json:"score"`
+	Reason string `json:"reason"`
+}
+
+// parseJudgeVerdict extracts the JSON verdict from a judge reply, tolerating
+// surrounding prose or markdown fences.
+func parseJudgeVerdict(reply string) (judgeVerdict, error) {
+	start := strings.Index(reply, "{")
+	end := strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return judgeVerdict{}, fmt.Errorf("judge reply contains no JSON verdict: %q", reply)
+	}
+	var verdict judgeVerdict
+	if err := json.Unmarshal([]byte(reply[start:end+1]), &verdict); err != nil {
+		return judgeVerdict{}, fmt.Errorf("parsing judge verdict: %w", err)
+	}
+	return verdict, nil
+}
+
+// promptText returns the content of the last user message in req.
+func promptText(req openai.ChatCompletionRequest) string {
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == openai.ChatMessageRoleUser {
+			return req.Messages[i].Content
+		}
+	}
+	return ""
+}
+
+// judge asks judgeModel whether response to prompt meets criteria. The judge
+// request is seeded and run at temperature 0 so its verdict is cached like any
+// other response.
+func (c *CachingClient) judge(ctx context.Context, judgeModel, criteria, prompt, response string) (judgeVerdict, error) {
+	seed := 0
+	req := openai.ChatCompletionRequest{
+		Model: judgeModel,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleUser, Content: fmt.Sprintf(judgePromptTemplate, criteria, prompt, response)},
+		},
+		Seed: &seed,
+		User: judgeUser,
+	}
+	reply, _, err := c.getResponse(ctx, req)
+	if err != nil {
+		return judgeVerdict{}, err
+	}
+	return parseJudgeVerdict(reply)
+}
+
+// evaluate grades every recorded response in cache against criteria.
+func (c *CachingClient) evaluate(ctx context.Context, cache *Cache, judgeModel, criteria string) ([]EvalResult, error) {
+	hashes := make([]string, 0, len(cache.Responses))
+	for hash, entry := range cache.Responses {
+		if entry.Request != nil && entry.Request.User != judgeUser {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Strings(hashes)
+
+	results := make([]EvalResult, 0, len(hashes))
+	for _, hash := range hashes {
+		entry := cache.Responses[hash]
+		prompt := promptText(*entry.Request)
+		verdict, err := c.judge(ctx, judgeModel, criteria, prompt, entry.Response)
+		if err != nil {
+			return results, fmt.Errorf("judging %s: %w", hash, err)
+		}
+		results = append(results, EvalResult{
+			Hash:   hash,
+			Model:  entry.Request.Model,
+			Prompt: prompt,
+			Pass:   verdict.Pass,
+			Score:  verdict.Score,
+			Reason: verdict.Reason,
+		})
+	}
+	return results, nil
+}
+
+func runEval(args []string) error {
+	fs := flag.NewFlagSet("eval", flag.ContinueOnError)
+	criteria := fs.String("criteria", "", "What a passing response must do (required)")
+	judgeModel := fs.String("judge-model", "gpt-4o-mini", "Model used to judge responses")
+	out := fs.String("out", "", "Write results as JSON to this file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: llm-test-cache eval -criteria TEXT [flags] [CACHE.json|@snapshot]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *criteria == "" {
+		fs.Usage()
+		return errors.New("eval needs -criteria")
+	}
+	path := cacheFile
+	if fs.NArg() > 0 {
+		var err error
+		if path, err = resolveCachePath(fs.Arg(0)); err != nil {
+			return err
+		}
+	}
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" {
+		return errors.New("OPENAI_API_KEY environment variable not set")
+	}
+
+	cache, err := loadCacheFrom(path)
+	if err != nil {
+		return err
+	}
+	client := NewCachingClient(apiKey, true, DefaultCacheSizeLimit)
+	results, err := client.evaluate(interruptContext(), cache, *judgeModel, *criteria)
+	if err != nil {
+		return err
+	}
+	if err := client.Close(); err != nil {
+		return err
+	}
+
+	passed := 0
+	for _, r := range results {
+		status := "FAIL"
+		if r.Pass {
+			status = "PASS"
+			passed++
+		}
+		fmt.Fprintf(console, "%s %s (%s) score=%d: %s\n", status, r.Hash, r.Model, r.Score, r.Reason)
+	}
+	fmt.Fprintf(console, "%d of %d responses passed\n", passed, len(results))
+
+	if *out != "" {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*out, data, 0644); err != nil {
+			return err
+		}
+	}
+	return report(append([]EvalResult{}, results...))
+}
@@ -0,0 +1,26 @@
+package llmcache
+
+import (
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseJudgeVerdict(t *testing.T) {
+	verdict, err := parseJudgeVerdict("```json\n{\"pass\": true, \"score\": 9, \"reason\": \"Accurate.\"}\n```")
+	assert.NoError(t, err)
+	assert.Equal(t, judgeVerdict{Pass: true, Score: 9, Reason: "Accurate."}, verdict)
+
+	_, err = parseJudgeVerdict("I think it passes.")
+	assert.Error(t, err)
+}
+
+func TestPromptText(t *testing.T) {
+	req := openai.ChatCompletionRequest{Messages: []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "Be brief."},
+		{Role: openai.ChatMessageRoleUser, Content: "Tell me a joke."},
+		{Role: openai.ChatMessageRoleAssistant, Content: "No."},
+	}}
+	assert.Equal(t, "Tell me a joke.", promptText(req))
+}
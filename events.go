@@ -0,0 +1,113 @@
+package llmcache
+
+import (
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// EventKind identifies what happened to a cache entry.
+type EventKind int
+
+const (
+	// EntryStored is emitted after a live response has been written to the cache.
+	EntryStored EventKind = iota
+	// EntryServed is emitted when a request is answered from the cache.
+	EntryServed
+	// EntryEvicted is emitted when an entry is removed to respect the size limit.
+	EntryEvicted
+	// UpstreamFailed is emitted when a live request to the API fails.
+	UpstreamFailed
+	// LiveServed is emitted when a live response is returned without touching
+	// the cache, because caching is disabled or skipped for the request.
+	LiveServed
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EntryStored:
+		return "stored"
+	case EntryServed:
+		return "served"
+	case EntryEvicted:
+		return "evicted"
+	case UpstreamFailed:
+		return "upstream-error"
+	case LiveServed:
+		return "live"
+	}
+	return "unknown"
+}
+
+// Event describes something the client did with a cache entry, for embedding
+// applications that implement their own metrics, alerts or audit logs.
+type Event struct {
+	Kind      EventKind
+	Hash      string
+	Model     string
+	Namespace string
+	Label     string
+	Time      time.Time
+	// Prompt is the last user message of the request.
+	Prompt string
+	// Request is the chat completion request, and Response the content it
+	// was answered with, for every event but EntryEvicted; UpstreamFailed
+	// events have no Response.
+	Request  *openai.ChatCompletionRequest
+	Response string
+	// Usage is set for events involving a live API call.
+	Usage openai.Usage
+	// Latency is how long the request took to answer.
+	Latency time.Duration
+	// Err is set for UpstreamFailed events.
+	Err error
+	// Divergence is set for EntryStored events of requests that diverged
+	// from an earlier recording, when prefix matching is enabled.
+	Divergence *Divergence
+	// Relaxed is set for EntryServed events of requests answered from the
+	// recording of a different one, by match rules or truncation replay, and
+	// says how the requests differ.
+	Relaxed string
+}
+
+// OnEvent registers fn to be called synchronously for every event, in the
+// order the events happen. fn is called with the client's lock held, so it
+// must not use the client.
+func (c *CachingClient) OnEvent(fn func(Event)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.listeners = append(c.listeners, fn)
+}
+
+// Events returns a channel receiving every event. Events are dropped rather
+// than blocking the client when the channel's buffer is full. The channel is
+// closed by Close.
+func (c *CachingClient) Events(buffer int) <-chan Event {
+	ch := make(chan Event, buffer)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.eventChans = append(c.eventChans, ch)
+	return ch
+}
+
+func (c *CachingClient) emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = c.now()
+	}
+	for _, fn := range c.listeners {
+		fn(e)
+	}
+	for _, ch := range c.eventChans {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (c *CachingClient) closeEvents() {
+	for _, ch := range c.eventChans {
+		close(ch)
+	}
+	c.eventChans = nil
+}
@@ -0,0 +1,48 @@
+package llmcache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvents(t *testing.T) {
+	req := openai.ChatCompletionRequest{Model: "gpt-3.5-turbo-0125"}
+	hash, err := generateHash(req)
+	assert.NoError(t, err)
+	client := newTestClient(t, &Cache{Responses: map[string]CacheEntry{
+		hash: {Response: "cached", Request: &req},
+	}})
+
+	var events []Event
+	client.OnEvent(func(e Event) { events = append(events, e) })
+	ch := client.Events(10)
+
+	_, _, err = client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+
+	client.cacheSizeLimit = 4
+	cache := &Cache{Responses: map[string]CacheEntry{
+		"old": {Response: "aaaa", Timestamp: time.Unix(1, 0)},
+		"new": {Response: "bbbb", Timestamp: time.Unix(2, 0)},
+	}}
+	assert.NoError(t, client.evictIfNeeded(cache))
+
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, EntryServed, events[0].Kind)
+		assert.Equal(t, hash, events[0].Hash)
+		assert.Equal(t, req.Model, events[0].Model)
+		assert.Equal(t, EntryEvicted, events[1].Kind)
+		assert.Equal(t, "old", events[1].Hash)
+	}
+
+	assert.NoError(t, client.Close())
+	var received []EventKind
+	for e := range ch {
+		received = append(received, e.Kind)
+	}
+	assert.Equal(t, []EventKind{EntryServed, EntryEvicted}, received)
+}
@@ -0,0 +1,247 @@
+package llmcache
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EvictionCandidate is a cache entry considered for eviction.
+type EvictionCandidate struct {
+	Hash  string
+	Entry CacheEntry
+}
+
+// EvictionPolicy reports whether a should be evicted before b. Entries the
+// policy doesn't order are evicted in hash order, so that eviction never
+// depends on map iteration and a cache recorded twice ends up the same.
+type EvictionPolicy func(a, b EvictionCandidate) bool
+
+// LRU evicts the least recently used entries first. It is the default policy.
+// Uses are ordered by their access sequence numbers, and by timestamp among
+// entries last used before caches counted uses, which are older than the
+// rest.
+func LRU(a, b EvictionCandidate) bool {
+	if a.Entry.Access != b.Entry.Access {
+		return a.Entry.Access < b.Entry.Access
+	}
+	return a.Entry.Timestamp.Before(b.Entry.Timestamp)
+}
+
+// LFU evicts the least frequently used entries first, and the least recently
+// used of those that were used equally often.
+func LFU(a, b EvictionCandidate) bool {
+	if a.Entry.Hits != b.Entry.Hits {
+		return a.Entry.Hits < b.Entry.Hits
+	}
+	return LRU(a, b)
+}
+
+// evictionPolicies are the policies that can be chosen by name on the
+// command line.
+var evictionPolicies = map[string]EvictionPolicy{
+	"lru": LRU,
+	"lfu": LFU,
+}
+
+func lookupEvictionPolicy(name string) (EvictionPolicy, error) {
+	policy, ok := evictionPolicies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown eviction policy %q: use lru or lfu", name)
+	}
+	return policy, nil
+}
+
+// SetEvictionPolicy replaces the LRU policy the client evicts entries with.
+func (c *CachingClient) SetEvictionPolicy(policy EvictionPolicy) {
+	c.evictionPolicy = policy
+}
+
+// SetMinEntryAge exempts entries recorded less than age ago from eviction, so
+// that a long recording session can't churn its own recordings out of the
+// cache before it is over. An age of 0 exempts nothing.
+func (c *CachingClient) SetMinEntryAge(age time.Duration) {
+	c.minEntryAge = age
+}
+
+// tooYoung reports whether entry was recorded too recently to be evicted.
+func (c *CachingClient) tooYoung(entry CacheEntry) bool {
+	if c.minEntryAge <= 0 {
+		return false
+	}
+	recorded := entry.Recorded
+	if recorded.IsZero() {
+		recorded = entry.Timestamp
+	}
+	return c.now().Sub(recorded) < c.minEntryAge
+}
+
+// SetNoTouch stops hits from updating entry timestamps, for caches committed
+// as fixtures that shouldn't change whenever tests run. Entries then age by
+// when they were recorded, so LRU evicts the oldest recordings first.
+func (c *CachingClient) SetNoTouch(noTouch bool) {
+	c.noTouch = noTouch
+}
+
+// evictionOrder returns every entry in cache, in the order the client would
+// evict them.
+func (c *CachingClient) evictionOrder(cache *Cache) []EvictionCandidate {
+	policy := c.evictionPolicy
+	if policy == nil {
+		policy = LRU
+	}
+	candidates := make([]EvictionCandidate, 0, len(cache.Responses))
+	for hash, entry := range cache.Responses {
+		candidates = append(candidates, EvictionCandidate{Hash: hash, Entry: entry})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if policy(a, b) {
+			return true
+		}
+		if policy(b, a) {
+			return false
+		}
+		return a.Hash < b.Hash
+	})
+	return candidates
+}
+
+// evictions returns the entries that have to be evicted, in policy order, for
+// cache to fit the client's size limit. Pinned entries, and entries younger
+// than the minimum age, are never evicted, even if the cache doesn't fit
+// without them.
+func (c *CachingClient) evictions(cache *Cache) []EvictionCandidate {
+	if c.cacheSizeLimit <= 0 {
+		return nil
+	}
+	cacheSize := int64(0)
+	for _, entry := range cache.Responses {
+		cacheSize += int64(len(entry.Response))
+	}
+	var evicted []EvictionCandidate
+	for _, candidate := range c.evictionOrder(cache) {
+		if cacheSize <= c.cacheSizeLimit {
+			break
+		}
+		if candidate.Entry.Pinned || c.tooYoung(candidate.Entry) {
+			continue
+		}
+		cacheSize -= int64(len(candidate.Entry.Response))
+		evicted = append(evicted, candidate)
+	}
+	return evicted
+}
+
+// runEvict evicts entries from a cache until it fits a size limit, or with
+// -dry-run only reports which entries would be evicted.
+func runEvict(args []string) error {
+	fs := flag.NewFlagSet("evict", flag.ContinueOnError)
+	limit := fs.Int64("cache-size-limit", DefaultCacheSizeLimit, "Cache size limit in bytes to evict down to")
+	policyName := fs.String("eviction-policy", "lru", "Evict least recently (lru) or least frequently (lfu) used entries first")
+	dryRun := fs.Bool("dry-run", false, "Only report the entries that would be evicted, in the order they would be")
+	minAge := fs.Duration("min-entry-age", 0, "Keep entries recorded less than this long ago, e.g. 1h")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: llm-test-cache evict [-cache-size-limit BYTES] [-eviction-policy lru|lfu] [-dry-run] [CACHE|@SNAPSHOT]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("evict takes at most one cache")
+	}
+	path := cacheFile
+	if fs.NArg() == 1 {
+		if strings.HasPrefix(fs.Arg(0), "@") && !*dryRun {
+			return errors.New("snapshots can only be evicted from with -dry-run")
+		}
+		var err error
+		if path, err = resolveCachePath(fs.Arg(0)); err != nil {
+			return err
+		}
+	}
+	store := newFileStore(path)
+	store.readOnly = *dryRun
+	defer store.Close()
+	cache, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	policy, err := lookupEvictionPolicy(*policyName)
+	if err != nil {
+		return err
+	}
+	client := &CachingClient{cacheSizeLimit: *limit, store: store, evictionPolicy: policy, minEntryAge: *minAge}
+	evicted := client.evictions(cache)
+	type evictedEntry struct {
+		Key      string    `json:"key"`
+		Bytes    int       `json:"bytes"`
+		LastUsed time.Time `json:"last_used"`
+		Model    string    `json:"model"`
+	}
+	result := struct {
+		Evicted []evictedEntry `json:"evicted"`
+		Freed   int64          `json:"freed_bytes"`
+		DryRun  bool           `json:"dry_run"`
+	}{Evicted: []evictedEntry{}, DryRun: *dryRun}
+	for _, candidate := range evicted {
+		e := evictedEntry{Key: candidate.Hash, Bytes: len(candidate.Entry.Response), LastUsed: candidate.Entry.Timestamp, Model: entryModel(candidate.Entry)}
+		result.Evicted = append(result.Evicted, e)
+		result.Freed += int64(e.Bytes)
+		fmt.Fprintf(console, "%s\t%d bytes\tlast used %s\t%s\n", e.Key, e.Bytes, e.LastUsed.Format(time.RFC3339), e.Model)
+	}
+	if *dryRun {
+		fmt.Fprintf(console, "Would evict %d entries, freeing %d bytes\n", len(evicted), result.Freed)
+		return report(result)
+	}
+	if err := client.evictIfNeeded(cache); err != nil {
+		return err
+	}
+	fmt.Fprintf(console, "Evicted %d entries, freeing %d bytes\n", len(evicted), result.Freed)
+	if err := store.Save(cache); err != nil {
+		return err
+	}
+	return report(result)
+}
+
+// runPin pins entries so they are never evicted, or unpins them.
+func runPin(args []string) error {
+	fs := flag.NewFlagSet("pin", flag.ContinueOnError)
+	unpin := fs.Bool("unpin", false, "Unpin the entries instead")
+	cachePath := fs.String("cache", cacheFile, "Cache file to pin entries in")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: llm-test-cache pin [-unpin] [-cache FILE] KEY...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("pin needs at least one key")
+	}
+	store := newFileStore(*cachePath)
+	defer store.Close()
+	cache, err := store.Load()
+	if err != nil {
+		return err
+	}
+	for _, hash := range fs.Args() {
+		entry, err := lookup(cache, hash)
+		if err != nil {
+			return err
+		}
+		entry.Pinned = !*unpin
+		cache.Responses[hash] = entry
+	}
+	if err := store.Save(cache); err != nil {
+		return err
+	}
+	return report(map[string]any{"keys": fs.Args(), "pinned": !*unpin})
+}
@@ -0,0 +1,335 @@
+package llmcache
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvictsLeastRecentlyUsed(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	client := newTestClient(t, nil)
+	client.cacheSizeLimit = 10
+	cache := &Cache{Responses: map[string]CacheEntry{
+		"newer": {Response: "aaaaaa", Timestamp: base.Add(time.Minute)},
+		"older": {Response: "bbbbbb", Timestamp: base},
+	}}
+
+	assert.NoError(t, client.evictIfNeeded(cache))
+	assert.Contains(t, cache.Responses, "newer")
+	assert.NotContains(t, cache.Responses, "older")
+}
+
+func TestLFUEvictsLeastFrequentlyUsed(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	client := &CachingClient{cacheSizeLimit: 10, evictionPolicy: LFU}
+	cache := &Cache{Responses: map[string]CacheEntry{
+		"popular": {Response: "aaaaaa", Timestamp: base, Hits: 5},
+		"recent":  {Response: "bbbbbb", Timestamp: base.Add(time.Minute), Hits: 1},
+	}}
+
+	evictions := client.evictions(cache)
+	if assert.Len(t, evictions, 1) {
+		assert.Equal(t, "recent", evictions[0].Hash)
+	}
+}
+
+func TestCountsHits(t *testing.T) {
+	client, calls := newEchoClient(t)
+	seed := 1
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Seed: &seed, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+	for i := 0; i < 3; i++ {
+		_, _, err := client.getResponse(context.Background(), req)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, *calls)
+
+	assert.NoError(t, client.Flush())
+	cache, err := client.store.Load()
+	assert.NoError(t, err)
+	hash, err := generateHash(req)
+	assert.NoError(t, err)
+	entry := cache.Responses[hash]
+	assert.Equal(t, 2, entry.Hits)
+	assert.Equal(t, entry.Timestamp, entry.LastHit)
+
+	cache.Responses["unused"] = CacheEntry{Response: "never replayed"}
+	byUse := entriesByUse(cache)
+	assert.Equal(t, "unused", byUse[0].Hash)
+}
+
+func TestPinnedEntriesAreNeverEvicted(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	client := &CachingClient{cacheSizeLimit: 10}
+	cache := &Cache{Responses: map[string]CacheEntry{
+		"oldest": {Response: "aaaaaa", Timestamp: base, Pinned: true},
+		"older":  {Response: "bbbbbb", Timestamp: base.Add(time.Minute)},
+		"newest": {Response: "cccccc", Timestamp: base.Add(2 * time.Minute)},
+	}}
+
+	evictions := client.evictions(cache)
+	if assert.Len(t, evictions, 2) {
+		assert.Equal(t, "older", evictions[0].Hash)
+		assert.Equal(t, "newest", evictions[1].Hash)
+	}
+	assert.Len(t, cache.Responses, 3, "computing evictions must not evict")
+
+	assert.NoError(t, client.evictIfNeeded(cache))
+	assert.Len(t, cache.Responses, 1)
+	assert.Contains(t, cache.Responses, "oldest")
+}
+
+func TestUnlimitedSizeNeverEvicts(t *testing.T) {
+	for _, limit := range []int64{0, -1} {
+		client := newTestClient(t, nil)
+		client.cacheSizeLimit = limit
+		cache := &Cache{Responses: map[string]CacheEntry{"big": {Response: "a very large response"}}}
+
+		assert.NoError(t, client.evictIfNeeded(cache))
+		assert.Contains(t, cache.Responses, "big", "limit %d", limit)
+	}
+}
+
+func TestNoTouchKeepsTimestamps(t *testing.T) {
+	seed := 1
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Seed: &seed, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+	hash, err := generateHash(req)
+	assert.NoError(t, err)
+	recorded := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	client := newTestClient(t, &Cache{Responses: map[string]CacheEntry{hash: {Response: "cached", Timestamp: recorded}}})
+	client.SetNoTouch(true)
+
+	_, cached, err := client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	cache, err := client.store.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, recorded, cache.Responses[hash].Timestamp.UTC())
+}
+
+func TestEvictionOrderBreaksTiesOnHash(t *testing.T) {
+	stamp := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := &Cache{Responses: map[string]CacheEntry{}}
+	for _, hash := range []string{"d", "b", "e", "a", "c"} {
+		cache.Responses[hash] = CacheEntry{Response: "x", Timestamp: stamp}
+	}
+	cache.Responses["z"] = CacheEntry{Response: "x", Timestamp: stamp.Add(-time.Minute)}
+
+	client := &CachingClient{}
+	for i := 0; i < 10; i++ {
+		var order []string
+		for _, candidate := range client.evictionOrder(cache) {
+			order = append(order, candidate.Hash)
+		}
+		assert.Equal(t, []string{"z", "a", "b", "c", "d", "e"}, order)
+	}
+}
+
+func TestAccessSequenceOrdersEviction(t *testing.T) {
+	client, _ := newEchoClient(t)
+	// Every use happens at the same instant, as in a fast test run.
+	client.clock = NewFrozenClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	first := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "first"}}}
+	second := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "second"}}}
+	for _, req := range []openai.ChatCompletionRequest{first, second, first} {
+		_, _, err := client.getResponse(context.Background(), req)
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, client.Flush())
+	cache, err := client.store.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), cache.Sequence)
+	firstHash, err := generateHash(first)
+	assert.NoError(t, err)
+	secondHash, err := generateHash(second)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(3), cache.Responses[firstHash].Access)
+	order := client.evictionOrder(cache)
+	if assert.Len(t, order, 2) {
+		assert.Equal(t, secondHash, order[0].Hash)
+	}
+}
+
+func TestLRUPrefersAccessToSkewedTimestamps(t *testing.T) {
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := &Cache{Responses: map[string]CacheEntry{
+		// Used last, by a machine whose clock runs an hour behind.
+		"skewed": {Response: "x", Timestamp: base.Add(-time.Hour), Access: 2},
+		"used":   {Response: "x", Timestamp: base, Access: 1},
+		"legacy": {Response: "x", Timestamp: base.Add(time.Hour)},
+	}}
+	var order []string
+	for _, candidate := range (&CachingClient{}).evictionOrder(cache) {
+		order = append(order, candidate.Hash)
+	}
+	assert.Equal(t, []string{"legacy", "used", "skewed"}, order)
+}
+
+func TestMinEntryAgeExemptsFreshEntries(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	client := &CachingClient{cacheSizeLimit: 10, clock: NewFrozenClock(now)}
+	client.SetMinEntryAge(time.Hour)
+	cache := &Cache{Responses: map[string]CacheEntry{
+		"fresh":  {Response: "aaaaaa", Recorded: now.Add(-time.Minute), Timestamp: now.Add(-time.Minute), Access: 1},
+		"fresh2": {Response: "bbbbbb", Recorded: now.Add(-time.Minute), Timestamp: now.Add(-time.Minute), Access: 2},
+		"old":    {Response: "cccccc", Recorded: now.Add(-2 * time.Hour), Timestamp: now, Access: 3},
+	}}
+
+	// The old entry is evicted although it was used last, and the fresh
+	// ones are kept even though the cache still doesn't fit.
+	evictions := client.evictions(cache)
+	if assert.Len(t, evictions, 1) {
+		assert.Equal(t, "old", evictions[0].Hash)
+	}
+}
+
+// evictionCase is a random cache and size limit for the properties of
+// eviction, generated by testing/quick.
+type evictionCase struct {
+	Cache *Cache
+	Limit int64
+	// Now is the time of the run; entries recorded within the hour before it
+	// are exempt when MinAge is set.
+	Now    time.Time
+	MinAge bool
+}
+
+func (evictionCase) Generate(r *rand.Rand, size int) reflect.Value {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	c := evictionCase{Cache: &Cache{Responses: map[string]CacheEntry{}}, Now: now, MinAge: r.Intn(4) == 0}
+	n := r.Intn(size + 1)
+	total := int64(0)
+	for i := 0; i < n; i++ {
+		entry := CacheEntry{
+			Response: strings.Repeat("x", r.Intn(100)),
+			Access:   uint64(r.Intn(2 * n)),
+			Hits:     r.Intn(5),
+			Pinned:   r.Intn(10) == 0,
+			Recorded: now.Add(-time.Duration(r.Intn(180)) * time.Minute),
+		}
+		entry.Timestamp = entry.Recorded
+		total += int64(len(entry.Response))
+		c.Cache.Responses[fmt.Sprintf("%04x", i)] = entry
+	}
+	c.Limit = r.Int63n(total + 2)
+	return reflect.ValueOf(c)
+}
+
+func (c evictionCase) client(policy EvictionPolicy) *CachingClient {
+	client := &CachingClient{cacheSizeLimit: c.Limit, evictionPolicy: policy, clock: NewFrozenClock(c.Now)}
+	if c.MinAge {
+		client.SetMinEntryAge(time.Hour)
+	}
+	return client
+}
+
+func (c evictionCase) copyCache() *Cache {
+	copied := &Cache{Responses: make(map[string]CacheEntry, len(c.Cache.Responses))}
+	for hash, entry := range c.Cache.Responses {
+		copied.Responses[hash] = entry
+	}
+	return copied
+}
+
+func cacheBytes(cache *Cache) int64 {
+	size := int64(0)
+	for _, entry := range cache.Responses {
+		size += int64(len(entry.Response))
+	}
+	return size
+}
+
+func TestEvictionProperties(t *testing.T) {
+	for name, policy := range evictionPolicies {
+		t.Run(name, func(t *testing.T) {
+			property := func(c evictionCase) bool {
+				client := c.client(policy)
+				cache := c.copyCache()
+				assert.NoError(t, client.evictIfNeeded(cache))
+
+				// The cache fits, unless what is left can't be evicted.
+				if cacheBytes(cache) > c.Limit && c.Limit > 0 {
+					for hash, entry := range cache.Responses {
+						if !entry.Pinned && !client.tooYoung(entry) {
+							t.Logf("%s is evictable but the cache is still over the limit", hash)
+							return false
+						}
+					}
+				}
+				for hash, entry := range c.Cache.Responses {
+					_, kept := cache.Responses[hash]
+					// Pinned and young entries are never evicted.
+					if (entry.Pinned || client.tooYoung(entry)) && !kept {
+						t.Logf("exempt entry %s was evicted", hash)
+						return false
+					}
+					if kept {
+						continue
+					}
+					// Every evicted entry comes before every kept,
+					// evictable one in the policy's order.
+					for other, keptEntry := range cache.Responses {
+						if keptEntry.Pinned || client.tooYoung(keptEntry) {
+							continue
+						}
+						if policy(EvictionCandidate{Hash: other, Entry: keptEntry}, EvictionCandidate{Hash: hash, Entry: entry}) {
+							t.Logf("evicted %s before %s, which the policy evicts first", hash, other)
+							return false
+						}
+					}
+				}
+				// Eviction stops as soon as the cache fits: keeping the
+				// last entry evicted would leave it over the limit.
+				order := client.evictionOrder(c.Cache)
+				for i := len(order) - 1; i >= 0; i-- {
+					if _, kept := cache.Responses[order[i].Hash]; !kept {
+						if cacheBytes(cache)+int64(len(order[i].Entry.Response)) <= c.Limit {
+							t.Logf("evicted %s although the cache fit without evicting it", order[i].Hash)
+							return false
+						}
+						break
+					}
+				}
+				// Eviction never depends on map iteration.
+				again := c.copyCache()
+				assert.NoError(t, c.client(policy).evictIfNeeded(again))
+				return assert.Equal(t, cache.Responses, again.Responses)
+			}
+			assert.NoError(t, quick.Check(property, &quick.Config{MaxCount: 500}))
+		})
+	}
+}
+
+func TestLRUKeepsNewestAccessed(t *testing.T) {
+	property := func(c evictionCase) bool {
+		c.MinAge = false
+		cache := c.copyCache()
+		client := c.client(LRU)
+		assert.NoError(t, client.evictIfNeeded(cache))
+		// The entries kept are the most recently used ones.
+		var oldestKept uint64 = math.MaxUint64
+		for _, entry := range cache.Responses {
+			if !entry.Pinned && entry.Access < oldestKept {
+				oldestKept = entry.Access
+			}
+		}
+		for hash, entry := range c.Cache.Responses {
+			if _, kept := cache.Responses[hash]; !kept && entry.Access > oldestKept {
+				t.Logf("evicted %s, used at %d, but kept an entry used at %d", hash, entry.Access, oldestKept)
+				return false
+			}
+		}
+		return true
+	}
+	assert.NoError(t, quick.Check(property, &quick.Config{MaxCount: 500}))
+}
@@ -0,0 +1,168 @@
+package llmcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// excludedField is a field of a request left out of its cache key.
+type excludedField struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// keyExplanation says how the cache key of a request is derived.
+type keyExplanation struct {
+	Namespace string `json:"namespace,omitempty"`
+	// Canonical is the request as it is hashed.
+	Canonical json.RawMessage `json:"canonical"`
+	Included  []string        `json:"included"`
+	Excluded  []excludedField `json:"excluded,omitempty"`
+	Key       string          `json:"key"`
+	Cached    bool            `json:"cached"`
+	// Nearest are the recordings most like an uncached request.
+	Nearest []NearMiss `json:"nearest,omitempty"`
+}
+
+// requestFields returns the JSON names of the fields of a chat completion
+// request.
+func requestFields() map[string]bool {
+	fields := make(map[string]bool)
+	t := reflect.TypeOf(openai.ChatCompletionRequest{})
+	for i := 0; i < t.NumField(); i++ {
+		if name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ","); name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+// explainKey explains the cache key that the request encoded in data gets in
+// namespace.
+func explainKey(data []byte, namespace string) (keyExplanation, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return keyExplanation{}, fmt.Errorf("request: %w", err)
+	}
+	var req openai.ChatCompletionRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return keyExplanation{}, fmt.Errorf("request: %w", err)
+	}
+	keyed := keyedRequest(req)
+	canonical, err := json.Marshal(keyed)
+	if err != nil {
+		return keyExplanation{}, err
+	}
+	var included map[string]json.RawMessage
+	if err := json.Unmarshal(canonical, &included); err != nil {
+		return keyExplanation{}, err
+	}
+	key, err := generateKey(namespace, keyed)
+	if err != nil {
+		return keyExplanation{}, err
+	}
+
+	explanation := keyExplanation{Namespace: namespace, Canonical: canonical, Key: key, Included: []string{}}
+	for field := range included {
+		explanation.Included = append(explanation.Included, field)
+	}
+	sort.Strings(explanation.Included)
+	known := requestFields()
+	for field := range raw {
+		if _, ok := included[field]; ok {
+			continue
+		}
+		reason := "zero or empty, which is the same as leaving it out"
+		switch {
+		case field == "stream" || field == "stream_options":
+			reason = "how the response is delivered doesn't change it"
+		case !known[field]:
+			reason = "not a parameter the OpenAI client knows, so it is never sent"
+		}
+		explanation.Excluded = append(explanation.Excluded, excludedField{Field: field, Reason: reason})
+	}
+	sort.Slice(explanation.Excluded, func(i, j int) bool {
+		return explanation.Excluded[i].Field < explanation.Excluded[j].Field
+	})
+	return explanation, nil
+}
+
+// runExplainKey prints how the cache key of a request is derived, to debug
+// why a request misses the cache.
+func runExplainKey(args []string) error {
+	fs := flag.NewFlagSet("explain-key", flag.ContinueOnError)
+	namespace := fs.String("namespace", "", "Derive the key within this namespace")
+	cachePath := fs.String("cache", cacheFile, "Cache file or @snapshot to look the key up in")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: llm-test-cache explain-key [flags] REQUEST.json|-")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("explain-key needs a request file, or - for stdin")
+	}
+	var data []byte
+	var err error
+	if fs.Arg(0) == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(fs.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	explanation, err := explainKey(data, *namespace)
+	if err != nil {
+		return err
+	}
+	path, err := resolveCachePath(*cachePath)
+	if err != nil {
+		return err
+	}
+	cache, err := loadCacheFrom(path)
+	if err != nil {
+		return err
+	}
+	if _, explanation.Cached = cache.Responses[explanation.Key]; !explanation.Cached {
+		var req openai.ChatCompletionRequest
+		json.Unmarshal(data, &req)
+		explanation.Nearest = nearestRecordings(cache, *namespace, req, 3)
+	}
+
+	var canonical bytes.Buffer
+	json.Indent(&canonical, explanation.Canonical, "", "  ")
+	fmt.Fprintf(console, "Canonical request, hashed compactly in this field order:\n%s\n\n", canonical.String())
+	fmt.Fprintf(console, "Included: %s\n", strings.Join(explanation.Included, ", "))
+	if len(explanation.Excluded) > 0 {
+		fmt.Fprintln(console, "Excluded:")
+		for _, e := range explanation.Excluded {
+			fmt.Fprintf(console, "  %s: %s\n", e.Field, e.Reason)
+		}
+	}
+	if explanation.Namespace != "" {
+		fmt.Fprintf(console, "Namespace: %s, hashed before the request\n", explanation.Namespace)
+	}
+	fmt.Fprintf(console, "Key: %s\n", explanation.Key)
+	status := "not cached"
+	if explanation.Cached {
+		status = "cached"
+	}
+	fmt.Fprintf(console, "%s in %s\n", status, path)
+	for _, m := range explanation.Nearest {
+		fmt.Fprintf(console, "Nearest recording %s\n", m)
+	}
+	return report(explanation)
+}
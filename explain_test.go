@@ -0,0 +1,42 @@
+package llmcache
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplainKey(t *testing.T) {
+	data := []byte(`{
+		"model": "gpt-4o-mini",
+		"messages": [{"role": "user", "content": "Hi"}],
+		"temperature": 0,
+		"stream": true,
+		"reasoning_effort": "low"
+	}`)
+	explanation, err := explainKey(data, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"messages", "model"}, explanation.Included)
+	assert.Equal(t, []excludedField{
+		{Field: "reasoning_effort", Reason: "not a parameter the OpenAI client knows, so it is never sent"},
+		{Field: "stream", Reason: "how the response is delivered doesn't change it"},
+		{Field: "temperature", Reason: "zero or empty, which is the same as leaving it out"},
+	}, explanation.Excluded)
+
+	// The key is the one the client caches the request under.
+	var req openai.ChatCompletionRequest
+	assert.NoError(t, json.Unmarshal(data, &req))
+	key, err := generateKey("", keyedRequest(req))
+	assert.NoError(t, err)
+	assert.Equal(t, key, explanation.Key)
+
+	namespaced, err := explainKey(data, "team-a")
+	assert.NoError(t, err)
+	assert.NotEqual(t, explanation.Key, namespaced.Key)
+	assert.Equal(t, explanation.Canonical, namespaced.Canonical)
+
+	_, err = explainKey([]byte(`[]`), "")
+	assert.Error(t, err)
+}
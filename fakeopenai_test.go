@@ -0,0 +1,204 @@
+package llmcache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeOpenAI is an in-process stand-in for the OpenAI API, so the cache logic
+// is tested hermetically, without an API key. It answers chat completions,
+// streamed or not, embeds inputs and lists the models it has been asked for.
+type fakeOpenAI struct {
+	server *httptest.Server
+	// reply returns the answer to a request. By default it is deterministic
+	// given the model, seed and prompt, like a well-behaved API.
+	reply func(req openai.ChatCompletionRequest) string
+	// snapshot, if set, is appended to the model reported in responses, like
+	// the dated snapshots the API serves aliases from.
+	snapshot string
+	// delay, if set, is how long every response takes, so that concurrent
+	// requests overlap.
+	delay time.Duration
+
+	mu       sync.Mutex
+	calls    int
+	requests []openai.ChatCompletionRequest
+	// failures are the status codes of the next requests to fail.
+	failures []int
+}
+
+func newFakeOpenAI(t *testing.T) *fakeOpenAI {
+	t.Helper()
+	f := &fakeOpenAI{reply: deterministicReply}
+	f.server = httptest.NewServer(f)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// deterministicReply answers the last message of req, in a way that depends
+// on everything the cache key covers that a test is likely to vary.
+func deterministicReply(req openai.ChatCompletionRequest) string {
+	prompt := ""
+	if n := len(req.Messages); n > 0 {
+		prompt = req.Messages[n-1].Content
+	}
+	seed := "none"
+	if req.Seed != nil {
+		seed = fmt.Sprint(*req.Seed)
+	}
+	return fmt.Sprintf("%s (seed %s, temperature %g) answers: %s", req.Model, seed, req.Temperature, prompt)
+}
+
+// failNext makes the next requests fail with the given status codes, one
+// each, e.g. 429 to test retries.
+func (f *fakeOpenAI) failNext(statuses ...int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures = append(f.failures, statuses...)
+}
+
+// Calls returns how many chat completions the fake was asked for.
+func (f *fakeOpenAI) Calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// Requests returns the chat completion requests the fake received.
+func (f *fakeOpenAI) Requests() []openai.ChatCompletionRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]openai.ChatCompletionRequest{}, f.requests...)
+}
+
+// newClient returns a test client sending its requests to the fake.
+func (f *fakeOpenAI) newClient(t *testing.T) *CachingClient {
+	t.Helper()
+	client := newTestClient(t, nil)
+	config := openai.DefaultConfig("test-key")
+	config.BaseURL = f.server.URL
+	client.Client = openai.NewClientWithConfig(config)
+	return client
+}
+
+func (f *fakeOpenAI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/models"):
+		f.serveModels(w)
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/chat/completions"):
+		f.serveChatCompletion(w, r)
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/embeddings"):
+		f.serveEmbeddings(w, r)
+	default:
+		writeProxyError(w, http.StatusNotFound, fmt.Sprintf("%s %s is not faked", r.Method, r.URL.Path))
+	}
+}
+
+func (f *fakeOpenAI) serveModels(w http.ResponseWriter) {
+	f.mu.Lock()
+	seen := make(map[string]bool)
+	list := openai.ModelsList{Models: []openai.Model{}}
+	for _, req := range f.requests {
+		if !seen[req.Model] {
+			seen[req.Model] = true
+			list.Models = append(list.Models, openai.Model{ID: req.Model, Object: "model", OwnedBy: "fake"})
+		}
+	}
+	f.mu.Unlock()
+	json.NewEncoder(w).Encode(list)
+}
+
+func (f *fakeOpenAI) serveChatCompletion(w http.ResponseWriter, r *http.Request) {
+	var req openai.ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProxyError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	f.mu.Lock()
+	f.calls++
+	f.requests = append(f.requests, req)
+	status := 0
+	if len(f.failures) > 0 {
+		status, f.failures = f.failures[0], f.failures[1:]
+	}
+	f.mu.Unlock()
+	if status != 0 {
+		writeProxyError(w, status, http.StatusText(status))
+		return
+	}
+
+	time.Sleep(f.delay)
+	reply := f.reply(req)
+	model := req.Model + f.snapshot
+	if req.Stream {
+		writeSSE(w, model, 0, strings.SplitAfter(reply, " "))
+		return
+	}
+	usage := openai.Usage{PromptTokens: fakeTokens(req), CompletionTokens: len(strings.Fields(reply))}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	response := chatCompletion(model, 0, reply)
+	response.Usage = usage
+	json.NewEncoder(w).Encode(response)
+}
+
+// serveEmbeddings embeds every input as its length in bytes and words.
+func (f *fakeOpenAI) serveEmbeddings(w http.ResponseWriter, r *http.Request) {
+	var req openai.EmbeddingRequestStrings
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProxyError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	time.Sleep(f.delay)
+	resp := openai.EmbeddingResponse{Object: "list", Model: req.Model}
+	for i, input := range req.Input {
+		vector := []float32{float32(len(input)), float32(len(strings.Fields(input)))}
+		resp.Data = append(resp.Data, openai.Embedding{Object: "embedding", Embedding: vector, Index: i})
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// fakeTokens counts the words of the messages of req, as a stand-in for
+// their tokens.
+func fakeTokens(req openai.ChatCompletionRequest) int {
+	n := 0
+	for _, m := range req.Messages {
+		n += len(strings.Fields(m.Content))
+	}
+	return n
+}
+
+func TestFakeOpenAI(t *testing.T) {
+	fake := newFakeOpenAI(t)
+	fake.snapshot = "-2024-08-06"
+	client := fake.newClient(t)
+	seed := 1
+	req := openai.ChatCompletionRequest{Model: "gpt-4o", Seed: &seed, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi there"}}}
+
+	fake.failNext(http.StatusTooManyRequests)
+	_, _, err := client.getResponse(context.Background(), req)
+	assert.Error(t, err)
+
+	response, cached, err := client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, "gpt-4o (seed 1, temperature 0) answers: Hi there", response)
+	assert.Equal(t, 2, client.Stats().PromptTokens)
+	assert.Equal(t, 2, fake.Calls())
+	assert.Len(t, fake.Requests(), 2)
+
+	models, err := client.ListModels(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, models.Models, 1) {
+		assert.Equal(t, "gpt-4o", models.Models[0].ID)
+	}
+}
@@ -0,0 +1,66 @@
+package llmcache
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// runFilter selects the runs of a suite the way go test -run selects tests: a
+// slash-separated list of regular expressions, matched unanchored against the
+// case name, the model and the matrix parameters of each run in turn. Missing
+// elements match everything, so "greeting" selects the greeting case for every
+// model and "/^gpt-4o-mini$" every case for one model.
+type runFilter []*regexp.Regexp
+
+const filterUsage = "Only run the cases matching this go test -run style `pattern`: CASE[/MODEL[/PARAMS]] regular expressions"
+
+// parseRunFilter parses a -filter pattern. Slashes inside brackets or
+// parentheses don't separate elements.
+func parseRunFilter(pattern string) (runFilter, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	var filter runFilter
+	for _, element := range splitFilter(pattern) {
+		re, err := regexp.Compile(element)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter %q: %w", pattern, err)
+		}
+		filter = append(filter, re)
+	}
+	return filter, nil
+}
+
+func splitFilter(pattern string) []string {
+	var elements []string
+	depth, start := 0, 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '[', '(':
+			depth++
+		case ']', ')':
+			if depth > 0 {
+				depth--
+			}
+		case '/':
+			if depth == 0 {
+				elements = append(elements, pattern[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(elements, pattern[start:])
+}
+
+// match reports whether run is selected by the filter.
+func (f runFilter) match(run suiteRun) bool {
+	names := []string{run.Case.Name, run.Model, run.Params}
+	for i, re := range f {
+		if i < len(names) && !re.MatchString(names[i]) {
+			return false
+		}
+	}
+	return true
+}
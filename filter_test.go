@@ -0,0 +1,45 @@
+package llmcache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunFilter(t *testing.T) {
+	suite := &Suite{
+		Models: []string{"gpt-4o", "gpt-4o-mini"},
+		Matrix: &Matrix{Temperatures: []float32{0, 1}},
+		Cases:  []SuiteCase{{Name: "greeting", Prompt: "Hi"}, {Name: "farewell", Prompt: "Bye"}},
+	}
+	names := func(pattern string) []string {
+		filter, err := parseRunFilter(pattern)
+		assert.NoError(t, err)
+		suite.Filter = filter
+		runs, err := suite.expand()
+		if err != nil {
+			return nil
+		}
+		var names []string
+		for _, run := range runs {
+			names = append(names, run.Case.Name+"/"+run.Model+"/"+run.Params)
+		}
+		return names
+	}
+
+	assert.Len(t, names(""), 8)
+	assert.Equal(t, []string{
+		"greeting/gpt-4o-mini/temperature=0",
+		"greeting/gpt-4o-mini/temperature=1",
+	}, names("greet/mini"))
+	assert.Equal(t, []string{
+		"greeting/gpt-4o/temperature=1",
+		"farewell/gpt-4o/temperature=1",
+	}, names("/^gpt-4o$/=1"))
+	// Slashes in brackets are part of the expression.
+	assert.Len(t, names("[g/]reeting"), 4)
+	assert.Nil(t, names("nothing"))
+
+	_, err := parseRunFilter("greet/(")
+	assert.ErrorContains(t, err, "invalid filter")
+}
@@ -0,0 +1,327 @@
+package llmcache
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// clientFlags are the flags shared by every command that sends requests.
+type clientFlags struct {
+	cacheEnabled      *bool
+	cacheSizeLimit    *int64
+	baseURL           *string
+	statsPath         *string
+	auditPath         *string
+	cacheSystemPrompt *bool
+	maxCost           *float64
+	ttl               *time.Duration
+	maxEntrySize      *int64
+	hotCacheSize      *int64
+	truncateOversized *bool
+	normalize         *bool
+	stripBOM          *bool
+	strict            *bool
+	validateRequests  *bool
+	postProcess       *string
+	prefixMatch       *bool
+	signingKey        *string
+	verifyKey         *string
+	readOnly          *bool
+	noTouch           *bool
+	compact           optionalBool
+	evictionPolicy    *string
+	minEntryAge       *time.Duration
+	markUsed          *string
+	capture           *string
+	traceTo           *string
+	traceHits         *bool
+	webhook           *string
+	webhookBranches   *string
+	webhookBudget     *string
+	cacheArchive      *string
+	secrets           *string
+	maxIdleConns      *int
+	maxConns          *int
+	http2             *bool
+	upstreamProxy     *string
+	forceUnlock       *bool
+	progress          *bool
+	truncationReplay  *bool
+	matchRules        *string
+	nearestKeys       *int
+	// keyOptional lets commands that never call the API run without
+	// OPENAI_API_KEY.
+	keyOptional      bool
+	caBundle         *string
+	organization     *string
+	project          *string
+	projectNamespace *bool
+	clientCert       *string
+	clientKey        *string
+}
+
+func addClientFlags(fs *flag.FlagSet, cacheByDefault bool) *clientFlags {
+	f := &clientFlags{
+		cacheEnabled:      fs.Bool("cache-requests", cacheByDefault, "Enable caching of requests"),
+		cacheArchive:      fs.String("cache-archive", "", "Restore the cache from this .tar.gz file before the run, unless the cache file exists, and save it there afterwards, for CI caches and artifacts"),
+		cacheSizeLimit:    fs.Int64("cache-size-limit", DefaultCacheSizeLimit, "Cache size limit in bytes (0 or -1 means no limit)"),
+		baseURL:           fs.String("base-url", "", "Send requests to this OpenAI-compatible endpoint instead of OpenAI, e.g. http://localhost:11434/v1 for Ollama"),
+		statsPath:         fs.String("stats-json", "", "Write run statistics as JSON to this file"),
+		maxIdleConns:      fs.Int("max-idle-conns-per-host", DefaultTransportOptions().MaxIdleConnsPerHost, "Keep-alive connections kept open per API host between requests"),
+		maxConns:          fs.Int("max-conns-per-host", 0, "Limit the connections per API host (0 means no limit)"),
+		upstreamProxy:     fs.String("upstream-proxy", "", "Send API requests through this http, https or socks5 proxy URL instead of the one in HTTPS_PROXY/HTTP_PROXY"),
+		organization:      fs.String("openai-org", os.Getenv("OPENAI_ORG_ID"), "Send requests on behalf of this OpenAI organization ID (default $OPENAI_ORG_ID)"),
+		project:           fs.String("openai-project", os.Getenv("OPENAI_PROJECT_ID"), "Send requests on behalf of this OpenAI project ID (default $OPENAI_PROJECT_ID)"),
+		projectNamespace:  fs.Bool("namespace-by-project", false, "Cache the responses of each OpenAI organization and project separately"),
+		caBundle:          fs.String("ca-bundle", "", "Also trust the CA certificates in this PEM file for API connections, e.g. of an internal gateway"),
+		clientCert:        fs.String("client-cert", "", "Present the client certificate in this PEM file for mutual TLS (requires -client-key)"),
+		clientKey:         fs.String("client-key", "", "Private key of the -client-cert certificate"),
+		http2:             fs.Bool("http2", true, "Use HTTP/2 where the API supports it"),
+		markUsed:          fs.String("mark-used", "", "Append the keys of the cache entries used during the run to this file, for prune -unused"),
+		capture:           fs.String("capture", "", "Append every request of the run, with its key, whether it is cached and its maximum cost, to this manifest file, for warm -manifest"),
+		traceTo:           fs.String("trace-to", "", "Forward the requests sent to the API to this observability platform: helicone (needs HELICONE_API_KEY) or langsmith (needs LANGSMITH_API_KEY)"),
+		traceHits:         fs.Bool("trace-hits", false, "Also forward requests answered from the cache to the -trace-to platform, marked as hits"),
+		webhook:           fs.String("webhook", "", "Post notifications about the run to this Slack incoming webhook or JSON endpoint"),
+		webhookBranches:   fs.String("webhook-branches", "main,master", "Comma-separated branches, or patterns such as release/*, on which a run sending requests to the API is notified to -webhook"),
+		webhookBudget:     fs.String("webhook-budget", "", "Comma-separated estimated costs in US dollars, e.g. 1,5,20, each notified to -webhook once the run reaches it"),
+		auditPath:         fs.String("audit-log", "", "Append every request/response interaction to this JSONL file"),
+		cacheSystemPrompt: fs.Bool("anthropic-cache-system", false, "Ask Anthropic to cache system prompts provider-side (requires ANTHROPIC_API_KEY)"),
+		maxCost:           fs.Float64("max-cost", 0, "Refuse live requests once the estimated cost of the run reaches this many US dollars (0 means no limit)"),
+		ttl:               fs.Duration("cache-ttl", 0, "Re-record cached responses older than this, e.g. 168h (0 means entries never expire)"),
+		maxEntrySize:      fs.Int64("max-entry-size", 0, "Don't cache responses larger than this many bytes (0 means no limit)"),
+		hotCacheSize:      fs.Int64("hot-cache-size", defaultHotCacheSize, "Keep up to this many bytes of recently served responses in memory, saving their hits on flush (0 turns it off)"),
+		truncateOversized: fs.Bool("truncate-oversized", false, "Cache responses larger than -max-entry-size truncated, with a marker, instead of not at all"),
+		normalize:         fs.Bool("normalize", true, "Compose live responses to Unicode NFC and convert their line endings to LF before caching and returning them"),
+		stripBOM:          fs.Bool("strip-bom", false, "Remove byte order marks from live responses before caching and returning them"),
+		secrets:           fs.String("secrets", "high=warn", "What to do with likely secrets in prompts sent to the API, per severity: off, warn, redact or block, e.g. high=block,low=redact; keys are high, emails low"),
+		validateRequests:  fs.Bool("validate-requests", true, "Fail requests their model can't serve, e.g. over its context window or asking for more output tokens than it gives, instead of sending them"),
+		strict:            fs.Bool("strict", false, "Refuse to record requests that are unlikely to be cache-stable instead of warning about them"),
+		postProcess:       fs.String("post-process", "", "Comma-separated post-processors applied to every response: trim, strip_fences, extract_json"),
+		prefixMatch:       fs.Bool("prefix-match", false, "Report where multi-turn requests that miss the cache diverge from the recording with the longest matching prefix"),
+		signingKey:        fs.String("signing-key", "", "Sign recorded entries, sessions and embeddings with the Ed25519 private key in this file"),
+		verifyKey:         fs.String("verify-key", "", "Refuse to replay entries, sessions and embeddings not signed by the Ed25519 public key in this file"),
+		evictionPolicy:    fs.String("eviction-policy", "lru", "Evict least recently (lru) or least frequently (lfu) used entries first"),
+		minEntryAge:       fs.Duration("min-entry-age", 0, "Never evict entries recorded less than this long ago, e.g. 2h to keep a recording session from evicting its own recordings"),
+		forceUnlock:       fs.Bool("force-unlock", false, "Remove the lock on the cache left by another run before starting; only use this if no other run is active"),
+		nearestKeys:       fs.Int("nearest-keys", 0, "On a cache miss, print up to this many of the most similar recordings and how they differ from the request"),
+		matchRules:        fs.String("match-rules", "", "Reuse recordings of near-identical requests, as defined by the JSON match rules in this file"),
+		truncationReplay:  fs.Bool("replay-truncated", false, "Serve requests that only lower max_tokens from a recording with more tokens, truncated to the new limit, instead of calling the API"),
+		progress:          fs.Bool("progress", isTerminal(os.Stderr), "Draw a progress bar with the cost so far and time left on stderr while running a suite; on by default when stderr is a terminal"),
+		noTouch:           fs.Bool("no-touch", false, "Don't update the timestamps of cached entries when they are used"),
+		readOnly:          fs.Bool("read-only", false, "Never write the cache: hits don't update timestamps and requests that aren't cached fail"),
+	}
+	fs.Var(&f.compact, "compact", "Write the cache file without indentation (default: unless git tracks it, so committed fixtures keep readable diffs)")
+	return f
+}
+
+// optionalBool is a boolean flag that tells whether it was given at all.
+type optionalBool struct {
+	set, value bool
+}
+
+func (b *optionalBool) IsBoolFlag() bool { return true }
+
+func (b *optionalBool) String() string {
+	if b == nil || !b.set {
+		return ""
+	}
+	return strconv.FormatBool(b.value)
+}
+
+func (b *optionalBool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	b.set, b.value = true, v
+	return nil
+}
+
+// newClient creates a client configured by the flags. Local endpoints don't
+// need an API key, and have their models discovered so suites can target
+// allLocalModels.
+func (f *clientFlags) newClient(ctx context.Context) (*CachingClient, error) {
+	local := *f.baseURL != "" && isLocalURL(*f.baseURL)
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" && !local && !f.keyOptional {
+		return nil, errors.New("OPENAI_API_KEY environment variable not set")
+	}
+
+	config := openai.DefaultConfig(apiKey)
+	transport := DefaultTransportOptions()
+	transport.MaxIdleConnsPerHost = *f.maxIdleConns
+	transport.MaxConnsPerHost = *f.maxConns
+	transport.HTTP2 = *f.http2
+	if *f.upstreamProxy != "" {
+		proxy, err := parseProxyURL(*f.upstreamProxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = proxy
+	}
+	if *f.caBundle != "" || *f.clientCert != "" || *f.clientKey != "" {
+		tlsConfig, err := loadTLSConfig(*f.caBundle, *f.clientCert, *f.clientKey)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLS = tlsConfig
+	}
+	httpc := &http.Client{Transport: NewTransport(transport)}
+	config.HTTPClient = httpc
+	config.OrgID = *f.organization
+	if *f.project != "" {
+		config.HTTPClient = withFixedHeaders(httpc, http.Header{"Openai-Project": {*f.project}})
+	}
+	if *f.baseURL != "" {
+		config.BaseURL = *f.baseURL
+	}
+	if *f.forceUnlock {
+		if err := forceUnlock(cacheFile); err != nil {
+			return nil, err
+		}
+	}
+	client := NewCachingClientWithConfig(config, *f.cacheEnabled, *f.cacheSizeLimit)
+	if *f.cacheArchive != "" {
+		client.store = newArchiveStore(*f.cacheArchive, cacheFile)
+	}
+	client.useHTTPClient(httpc)
+	client.statsPath = *f.statsPath
+	client.maxCost = *f.maxCost
+	client.SetTTL(*f.ttl)
+	client.maxEntrySize = *f.maxEntrySize
+	client.truncateOversized = *f.truncateOversized
+	client.SetHotCacheSize(*f.hotCacheSize)
+	client.SetNormalization(Normalization{Unicode: *f.normalize, LineEndings: *f.normalize, StripBOM: *f.stripBOM})
+	client.strict = *f.strict
+	client.validateRequests = *f.validateRequests
+	client.SetPrefixMatching(*f.prefixMatch)
+	client.SetReadOnly(*f.readOnly)
+	client.SetNoTouch(*f.noTouch)
+	if f.compact.set {
+		client.SetCompact(f.compact.value)
+	}
+	client.SetTruncationReplay(*f.truncationReplay)
+	client.SetNearestKeys(*f.nearestKeys)
+	secretPolicy, err := parseSecretPolicy(*f.secrets)
+	if err != nil {
+		return nil, err
+	}
+	client.SetSecretPolicy(secretPolicy)
+	if *f.matchRules != "" {
+		rules, err := loadMatchRules(*f.matchRules)
+		if err != nil {
+			return nil, err
+		}
+		client.SetMatchRules(rules)
+	}
+	if *f.matchRules != "" || *f.truncationReplay {
+		client.OnEvent(func(e Event) {
+			if e.Relaxed != "" {
+				fmt.Fprintf(console, "Note: served from recording %s: %s\n", abbreviate(e.Hash), e.Relaxed)
+			}
+		})
+	}
+	if *f.progress {
+		client.SetProgress(os.Stderr)
+	}
+	policy, err := lookupEvictionPolicy(*f.evictionPolicy)
+	if err != nil {
+		return nil, err
+	}
+	client.SetEvictionPolicy(policy)
+	client.SetMinEntryAge(*f.minEntryAge)
+	if *f.prefixMatch {
+		client.OnEvent(func(e Event) {
+			if e.Divergence != nil {
+				fmt.Fprintf(console, "Warning: %s %s\n", abbreviate(e.Hash), e.Divergence)
+			}
+		})
+	}
+	if *f.postProcess != "" {
+		processors, err := lookupPostProcessors(strings.Split(*f.postProcess, ","))
+		if err != nil {
+			return nil, err
+		}
+		client.postProcessors = processors
+	}
+	if *f.signingKey != "" {
+		key, err := readSigningKey(*f.signingKey)
+		if err != nil {
+			return nil, err
+		}
+		client.SetSigningKey(key)
+	}
+	if *f.verifyKey != "" {
+		key, err := readVerifyKey(*f.verifyKey)
+		if err != nil {
+			return nil, err
+		}
+		client.SetVerifyKey(key)
+	}
+	if client.anthropic != nil {
+		client.anthropic.cacheSystemPrompt = *f.cacheSystemPrompt
+	}
+	if local {
+		if _, err := client.discoverLocalModels(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if *f.projectNamespace {
+		client.namespace = projectNamespace(client.namespace, *f.organization, *f.project)
+	}
+	if *f.auditPath != "" {
+		if err := client.EnableAuditLog(*f.auditPath); err != nil {
+			return nil, err
+		}
+	}
+	if *f.traceTo != "" {
+		if err := client.ForwardTraces(TraceOptions{Platform: *f.traceTo, Hits: *f.traceHits}); err != nil {
+			return nil, err
+		}
+	}
+	if *f.webhook != "" {
+		thresholds, err := parseThresholds(*f.webhookBudget)
+		if err != nil {
+			return nil, err
+		}
+		if err := client.NotifyWebhook(WebhookOptions{URL: *f.webhook, ProtectedBranches: strings.Split(*f.webhookBranches, ","), BudgetThresholds: thresholds}); err != nil {
+			return nil, err
+		}
+	}
+	if *f.markUsed != "" {
+		if err := client.MarkUsed(*f.markUsed); err != nil {
+			return nil, err
+		}
+	}
+	if *f.capture != "" {
+		if err := client.CaptureRequests(*f.capture); err != nil {
+			return nil, err
+		}
+	}
+	return client, nil
+}
+
+// projectNamespace folds an OpenAI organization and project into namespace,
+// since responses and limits can differ between projects.
+func projectNamespace(namespace, organization, project string) string {
+	var parts []string
+	if namespace != "" {
+		parts = append(parts, namespace)
+	}
+	if organization != "" {
+		parts = append(parts, "org="+organization)
+	}
+	if project != "" {
+		parts = append(parts, "project="+project)
+	}
+	return strings.Join(parts, "|")
+}
@@ -0,0 +1,268 @@
+package llmcache
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// HAR 1.2 types, covering what's needed to read Polly.js recordings and to
+// write recordings browser devtools and HTTP replay tools can load.
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string       `json:"method"`
+	URL         string       `json:"url"`
+	HTTPVersion string       `json:"httpVersion"`
+	Headers     []harNameVal `json:"headers"`
+	QueryString []harNameVal `json:"queryString"`
+	Cookies     []harNameVal `json:"cookies"`
+	PostData    *harPostData `json:"postData,omitempty"`
+	HeadersSize int          `json:"headersSize"`
+	BodySize    int          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int          `json:"status"`
+	StatusText  string       `json:"statusText"`
+	HTTPVersion string       `json:"httpVersion"`
+	Headers     []harNameVal `json:"headers"`
+	Cookies     []harNameVal `json:"cookies"`
+	Content     harContent   `json:"content"`
+	RedirectURL string       `json:"redirectURL"`
+	HeadersSize int          `json:"headersSize"`
+	BodySize    int          `json:"bodySize"`
+}
+
+type harNameVal struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+const harChatCompletionsURL = "https://api.openai.com/v1/chat/completions"
+
+func parseHAR(data []byte) ([]recordedExchange, error) {
+	var har harFile
+	if err := json.Unmarshal(data, &har); err != nil {
+		return nil, err
+	}
+	exchanges := make([]recordedExchange, 0, len(har.Log.Entries))
+	for _, e := range har.Log.Entries {
+		exchange := recordedExchange{
+			Method:       e.Request.Method,
+			URL:          e.Request.URL,
+			Status:       e.Response.Status,
+			ResponseBody: e.Response.Content.Text,
+		}
+		if e.Request.PostData != nil {
+			exchange.RequestBody = e.Request.PostData.Text
+		}
+		exchanges = append(exchanges, exchange)
+	}
+	return exchanges, nil
+}
+
+// cacheHAR returns the recorded entries of cache as HAR, oldest first. Entries
+// recorded before requests were kept in the cache have nothing to export and
+// are skipped. Responses are rebuilt as chat completions around the cached
+// content, since only the content is cached.
+func cacheHAR(cache *Cache) (*harFile, error) {
+	hashes := make([]string, 0, len(cache.Responses))
+	for hash, entry := range cache.Responses {
+		if entry.Request != nil {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		a, b := cache.Responses[hashes[i]], cache.Responses[hashes[j]]
+		if !a.Timestamp.Equal(b.Timestamp) {
+			return a.Timestamp.Before(b.Timestamp)
+		}
+		return hashes[i] < hashes[j]
+	})
+
+	har := &harFile{Log: harLog{Version: "1.2", Creator: harCreator{Name: "llm-test-cache", Version: "1"}, Entries: []harEntry{}}}
+	for _, hash := range hashes {
+		entry := cache.Responses[hash]
+		reqBody, err := json.Marshal(entry.Request)
+		if err != nil {
+			return nil, err
+		}
+		respBody, err := json.Marshal(openai.ChatCompletionResponse{
+			ID:     "cache-" + hash,
+			Object: "chat.completion",
+			Model:  entry.Request.Model,
+			Choices: []openai.ChatCompletionChoice{{
+				Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: entry.Response},
+				FinishReason: openai.FinishReasonStop,
+			}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		started := entry.Recorded
+		if started.IsZero() {
+			started = entry.Timestamp
+		}
+		har.Log.Entries = append(har.Log.Entries, harEntry{
+			StartedDateTime: started,
+			Request: harRequest{
+				Method:      "POST",
+				URL:         harChatCompletionsURL,
+				HTTPVersion: "HTTP/1.1",
+				Headers:     []harNameVal{{Name: "Content-Type", Value: "application/json"}, {Name: "X-Cache-Key", Value: hash}},
+				QueryString: []harNameVal{},
+				Cookies:     []harNameVal{},
+				PostData:    &harPostData{MimeType: "application/json", Text: string(reqBody)},
+				HeadersSize: -1,
+				BodySize:    len(reqBody),
+			},
+			Response: harResponse{
+				Status:      200,
+				StatusText:  "OK",
+				HTTPVersion: "HTTP/1.1",
+				Headers:     []harNameVal{{Name: "Content-Type", Value: "application/json"}},
+				Cookies:     []harNameVal{},
+				Content:     harContent{Size: len(respBody), MimeType: "application/json", Text: string(respBody)},
+				HeadersSize: -1,
+				BodySize:    len(respBody),
+			},
+		})
+	}
+	return har, nil
+}
+
+func writeHAR(w io.Writer, cache *Cache) error {
+	har, err := cacheHAR(cache)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(har)
+}
+
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "har", "Export format: har for recorded requests, or jsonl, npy or parquet for cached embeddings")
+	out := fs.String("out", "", "Write the export to this file instead of standard output")
+	model := fs.String("model", "", "Only export the embeddings of this model")
+	rows := fs.String("rows", "", "With -format npy, also write the key, model and input of every row of the matrix to this JSONL file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: llm-test-cache export [-format har|jsonl|npy|parquet] [-out FILE] [CACHE|@SNAPSHOT]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	write, vectors := vectorFormats[*format]
+	if *format != "har" && !vectors {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("export takes at most one cache")
+	}
+
+	path := cacheFile
+	if fs.NArg() == 1 {
+		var err error
+		if path, err = resolveCachePath(fs.Arg(0)); err != nil {
+			return err
+		}
+	}
+	cache, err := loadCacheFrom(path)
+	if err != nil {
+		return err
+	}
+	export := func(w io.Writer) error { return writeHAR(w, cache) }
+	if vectors {
+		exported, err := vectorRows(cache, *model, *format != "jsonl")
+		if err != nil {
+			return err
+		}
+		if *rows != "" {
+			if err := writeRowsFile(*rows, exported); err != nil {
+				return err
+			}
+		}
+		export = func(w io.Writer) error { return write(w, exported) }
+	}
+	if *out == "" {
+		return export(os.Stdout)
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return err
+	}
+	if err := export(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeRowsFile writes the keys, models and inputs of rows, without their
+// vectors, to the JSONL file at path.
+func writeRowsFile(path string, rows []vectorRow) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	labels := make([]vectorRow, len(rows))
+	for i, row := range rows {
+		row.Embedding = nil
+		labels[i] = row
+	}
+	if err := writeVectorsJSONL(f, labels); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
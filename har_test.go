@@ -0,0 +1,37 @@
+package llmcache
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHARRoundTrip(t *testing.T) {
+	req := openai.ChatCompletionRequest{
+		Model:    "gpt-3.5-turbo-0125",
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}},
+	}
+	hash, err := generateHash(req)
+	assert.NoError(t, err)
+	stamp := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache := &Cache{Responses: map[string]CacheEntry{
+		hash:     {Response: "Hello!", Timestamp: stamp, Request: &req},
+		"legacy": {Response: "no request recorded", Timestamp: stamp},
+	}}
+
+	var buf bytes.Buffer
+	assert.NoError(t, writeHAR(&buf, cache))
+	assert.Contains(t, buf.String(), `"version": "1.2"`)
+
+	exchanges, err := parseHAR(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Len(t, exchanges, 1, "entries without a recorded request are skipped")
+
+	imported := &Cache{Responses: map[string]CacheEntry{}}
+	_, _, err = importExchanges(imported, exchanges, stamp)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello!", imported.Responses[hash].Response, "an exported entry imports under the same key")
+}
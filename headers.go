@@ -0,0 +1,98 @@
+package llmcache
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// headerRules rewrite the headers of proxied requests, so that clients
+// sending different headers map onto consistent cache keys and upstream
+// requests.
+type headerRules struct {
+	// set is added to every upstream request, replacing what the caller
+	// sent, e.g. to inject an OpenAI-Organization.
+	set http.Header
+	// forward names the caller's headers passed on upstream, such as
+	// Idempotency-Key. Other headers are dropped.
+	forward []string
+	// key names the caller's headers that are part of the cache key. Every
+	// other header is left out of it.
+	key []string
+}
+
+// upstream returns the headers to send upstream for the caller's request r.
+func (h headerRules) upstream(r *http.Request) http.Header {
+	headers := make(http.Header)
+	for _, name := range h.forward {
+		if values := r.Header.Values(name); len(values) > 0 {
+			headers[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	for name, values := range h.set {
+		headers[name] = values
+	}
+	return headers
+}
+
+// keyPart returns the values of the key headers of r, to be made part of the
+// namespace its response is cached in.
+func (h headerRules) keyPart(r *http.Request) string {
+	var parts []string
+	for _, name := range h.key {
+		if value := r.Header.Get(name); value != "" {
+			parts = append(parts, http.CanonicalHeaderKey(name)+"="+value)
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+type upstreamHeadersKey struct{}
+
+// withUpstreamHeaders returns a context whose OpenAI requests carry headers.
+func withUpstreamHeaders(ctx context.Context, headers http.Header) context.Context {
+	return context.WithValue(ctx, upstreamHeadersKey{}, headers)
+}
+
+// headerTransport adds fixed headers, and then the upstream headers of each
+// request's context, to every request.
+type headerTransport struct {
+	base  http.RoundTripper
+	fixed http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	headers, _ := req.Context().Value(upstreamHeadersKey{}).(http.Header)
+	if len(t.fixed) > 0 || len(headers) > 0 {
+		req = req.Clone(req.Context())
+		for name, values := range t.fixed {
+			req.Header[name] = values
+		}
+		for name, values := range headers {
+			req.Header[name] = values
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+// withFixedHeaders returns a copy of client adding headers to every request.
+func withFixedHeaders(client *http.Client, headers http.Header) *http.Client {
+	wrapped := withHeaderTransport(client)
+	wrapped.Transport.(*headerTransport).fixed = headers
+	return wrapped
+}
+
+// withHeaderTransport returns a copy of client sending the upstream headers
+// of each request's context.
+func withHeaderTransport(client *http.Client) *http.Client {
+	wrapped := &http.Client{}
+	if client != nil {
+		*wrapped = *client
+	}
+	base := wrapped.Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	wrapped.Transport = &headerTransport{base: base}
+	return wrapped
+}
@@ -0,0 +1,35 @@
+package llmcache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFixedAndContextHeaders(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	t.Cleanup(server.Close)
+
+	client := withFixedHeaders(nil, http.Header{"Openai-Project": {"proj_default"}})
+	ctx := withUpstreamHeaders(context.Background(), http.Header{"Idempotency-Key": {"abc"}})
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	assert.NoError(t, err)
+	resp, err := client.Do(req)
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+	}
+	assert.Equal(t, "proj_default", received.Get("OpenAI-Project"))
+	assert.Equal(t, "abc", received.Get("Idempotency-Key"))
+}
+
+func TestProjectNamespace(t *testing.T) {
+	assert.Equal(t, "org=org-1|project=proj_a", projectNamespace("", "org-1", "proj_a"))
+	assert.Equal(t, "ollama@localhost:11434|project=proj_a", projectNamespace("ollama@localhost:11434", "", "proj_a"))
+	assert.Equal(t, "", projectNamespace("", "", ""))
+}
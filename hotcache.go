@@ -0,0 +1,268 @@
+package llmcache
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+// defaultHotCacheSize is how many bytes of responses a client keeps in memory
+// by default.
+const defaultHotCacheSize = 16 << 20
+
+// versionedStore is implemented by stores that can tell cheaply whether they
+// changed, so that entries kept in memory are only trusted until they do.
+type versionedStore interface {
+	// Version returns a value that changes whenever the store's contents do.
+	Version() (string, error)
+}
+
+// Version is made of the size and modification time of the cache file, or
+// is empty if there is no file yet.
+func (s *fileStore) Version() (string, error) {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d@%d", info.Size(), info.ModTime().UnixNano()), nil
+}
+
+func (s *archiveStore) Version() (string, error) {
+	return s.files.Version()
+}
+
+// hotCache keeps the entries a client served recently in memory, so that
+// hitting them again doesn't read and decode the whole cache. It holds at
+// most limit bytes of responses, dropping the least recently served entries
+// first. The client's own saves update it, and it is emptied whenever the
+// store's version changes otherwise, e.g. because another process recorded
+// into a shared cache or the file was edited.
+//
+// Hits served from memory don't save the cache either: their touches are
+// kept in pending until the cache is next saved, flushed or closed.
+type hotCache struct {
+	limit   int64
+	size    int64
+	version string
+	order   *list.List // of *hotEntry, most recently served first
+	entries map[string]*list.Element
+	pending map[string]pendingTouch
+	seq     int
+}
+
+type hotEntry struct {
+	hash  string
+	entry CacheEntry
+}
+
+// pendingTouch is a hit of an entry that hasn't been saved yet.
+type pendingTouch struct {
+	at    time.Time
+	hits  int
+	label string
+	// seq orders the touches, so that they are given accesses in the order
+	// they happened.
+	seq int
+}
+
+func newHotCache(limit int64) *hotCache {
+	return &hotCache{
+		limit:   limit,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		pending: make(map[string]pendingTouch),
+	}
+}
+
+// SetHotCacheSize sets how many bytes of recently served responses the client
+// keeps in memory, 16 MiB by default. A size of 0 or less turns the hot cache
+// off, so that every lookup reads the store and every hit is saved at once.
+func (c *CachingClient) SetHotCacheSize(size int64) {
+	if c.hot == nil {
+		c.hot = newHotCache(size)
+		return
+	}
+	c.hot.limit = size
+	c.hot.clear()
+}
+
+// hotEnabled reports whether the client keeps entries in memory. It needs a
+// store that can tell when it changed.
+func (c *CachingClient) hotEnabled() bool {
+	_, ok := c.store.(versionedStore)
+	return c.hot != nil && c.hot.limit > 0 && ok
+}
+
+// hotLookup returns the entry for hash kept in memory, after emptying the hot
+// cache if the store changed since it was filled.
+func (c *CachingClient) hotLookup(hash string) (CacheEntry, bool, error) {
+	if !c.hotEnabled() {
+		return CacheEntry{}, false, nil
+	}
+	version, err := c.store.(versionedStore).Version()
+	if err != nil {
+		return CacheEntry{}, false, err
+	}
+	if version != c.hot.version {
+		c.hot.clear()
+		c.hot.version = version
+		return CacheEntry{}, false, nil
+	}
+	entry, ok := c.hot.get(hash)
+	return entry, ok, nil
+}
+
+// hotFill keeps entry, just loaded from the store, in memory.
+func (c *CachingClient) hotFill(hash string, entry CacheEntry) {
+	if c.hotEnabled() {
+		c.hot.put(hash, entry)
+	}
+}
+
+// saveCache saves cache with the touches pending in memory, then brings the
+// entries kept in memory up to date with it.
+func (c *CachingClient) saveCache(cache *Cache) error {
+	c.applyTouches(cache)
+	err := c.store.Save(cache)
+	if !c.hotEnabled() || err != nil {
+		if c.hot != nil {
+			c.hot.clear()
+		}
+		return err
+	}
+	version, err := c.store.(versionedStore).Version()
+	if err != nil {
+		c.hot.clear()
+		return nil
+	}
+	c.hot.refresh(cache, version)
+	return nil
+}
+
+func (h *hotCache) get(hash string) (CacheEntry, bool) {
+	e, ok := h.entries[hash]
+	if !ok {
+		return CacheEntry{}, false
+	}
+	h.order.MoveToFront(e)
+	return e.Value.(*hotEntry).entry, true
+}
+
+// put keeps entry, unless its response alone is over the limit, then drops
+// the least recently served entries until the rest fit.
+func (h *hotCache) put(hash string, entry CacheEntry) {
+	if e, ok := h.entries[hash]; ok {
+		h.size -= int64(len(e.Value.(*hotEntry).entry.Response))
+		h.order.Remove(e)
+		delete(h.entries, hash)
+	}
+	size := int64(len(entry.Response))
+	if size > h.limit {
+		return
+	}
+	h.entries[hash] = h.order.PushFront(&hotEntry{hash: hash, entry: entry})
+	h.size += size
+	for h.size > h.limit {
+		h.evictOldest()
+	}
+}
+
+func (h *hotCache) evictOldest() {
+	oldest := h.order.Remove(h.order.Back()).(*hotEntry)
+	delete(h.entries, oldest.hash)
+	h.size -= int64(len(oldest.entry.Response))
+}
+
+// refresh replaces the entries kept in memory with their versions in cache,
+// saved as version, and drops those no longer in it.
+func (h *hotCache) refresh(cache *Cache, version string) {
+	for hash, e := range h.entries {
+		entry, ok := cache.Responses[hash]
+		if !ok {
+			h.size -= int64(len(e.Value.(*hotEntry).entry.Response))
+			h.order.Remove(e)
+			delete(h.entries, hash)
+			continue
+		}
+		h.size += int64(len(entry.Response)) - int64(len(e.Value.(*hotEntry).entry.Response))
+		e.Value.(*hotEntry).entry = entry
+	}
+	for h.size > h.limit {
+		h.evictOldest()
+	}
+	h.version = version
+}
+
+// clear drops every entry kept in memory. Pending touches are kept: they are
+// saved onto whatever the store holds by then.
+func (h *hotCache) clear() {
+	h.order.Init()
+	h.entries = make(map[string]*list.Element)
+	h.size = 0
+	h.version = ""
+}
+
+// touch records a hit of hash at at, to be saved later.
+func (h *hotCache) touch(hash string, at time.Time, label string) {
+	t := h.pending[hash]
+	t.at = at
+	t.hits++
+	if label != "" {
+		t.label = label
+	}
+	h.seq++
+	t.seq = h.seq
+	h.pending[hash] = t
+}
+
+// applyTouches updates the entries of cache hit since it was last saved. The
+// touches are forgotten: the caller must save cache.
+func (c *CachingClient) applyTouches(cache *Cache) {
+	if c.hot == nil || len(c.hot.pending) == 0 {
+		return
+	}
+	hashes := make([]string, 0, len(c.hot.pending))
+	for hash := range c.hot.pending {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return c.hot.pending[hashes[i]].seq < c.hot.pending[hashes[j]].seq
+	})
+	for _, hash := range hashes {
+		t := c.hot.pending[hash]
+		entry, ok := cache.Responses[hash]
+		if !ok {
+			// Evicted or deleted since it was served.
+			continue
+		}
+		if !c.noTouch {
+			entry.Timestamp = t.at
+			entry.Access = cache.nextAccess()
+			entry.LastHit = t.at
+			entry.Hits += t.hits
+		}
+		if t.label != "" {
+			entry.Label = t.label
+		}
+		cache.Responses[hash] = entry
+	}
+	c.hot.pending = make(map[string]pendingTouch)
+}
+
+// saveTouches saves the hits served from memory since the cache was last
+// saved.
+func (c *CachingClient) saveTouches() error {
+	if c.hot == nil || len(c.hot.pending) == 0 {
+		return nil
+	}
+	cache, err := c.store.Load()
+	if err != nil {
+		return err
+	}
+	return c.saveCache(cache)
+}
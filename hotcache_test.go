@@ -0,0 +1,75 @@
+package llmcache
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingStore is a file store counting its loads.
+type countingStore struct {
+	*fileStore
+	loads int
+}
+
+func (s *countingStore) Load() (*Cache, error) {
+	s.loads++
+	return s.fileStore.Load()
+}
+
+func TestHotCache(t *testing.T) {
+	hi := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+	bye := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Bye"}}}
+	hiHash, err := generateHash(hi)
+	assert.NoError(t, err)
+	byeHash, err := generateHash(bye)
+	assert.NoError(t, err)
+	client := newTestClient(t, &Cache{Responses: map[string]CacheEntry{
+		hiHash:  {Response: "Hello"},
+		byeHash: {Response: "Goodbye"},
+	}})
+	store := &countingStore{fileStore: client.store.(*fileStore)}
+	client.store = store
+	ctx := WithMode(context.Background(), Replay)
+
+	for i := 0; i < 3; i++ {
+		response, cached, err := client.getResponse(ctx, hi)
+		assert.NoError(t, err)
+		assert.True(t, cached)
+		assert.Equal(t, "Hello", response)
+	}
+	assert.Equal(t, 1, store.loads, "repeated hits are served from memory")
+	assert.Equal(t, 2, client.Stats().MemoryHits)
+
+	// Another process records into the cache.
+	cache, err := loadCacheFrom(store.path)
+	assert.NoError(t, err)
+	cache.Responses[hiHash] = CacheEntry{Response: "Hello again"}
+	assert.NoError(t, saveCacheTo(store.path, cache))
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(store.path, later, later))
+	response, _, err := client.getResponse(ctx, hi)
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello again", response, "a changed store empties the hot cache")
+	assert.Equal(t, 2, store.loads)
+
+	// Only one of the responses fits at a time.
+	client.SetHotCacheSize(int64(len("Hello again")))
+	for _, req := range []openai.ChatCompletionRequest{hi, bye, hi, bye} {
+		_, _, err := client.getResponse(ctx, req)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 6, store.loads, "the least recently served entry is dropped")
+
+	// The hits are saved when the client is flushed, not one by one.
+	assert.NoError(t, client.Flush())
+	cache, err = loadCacheFrom(store.path)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, cache.Responses[hiHash].Hits)
+	assert.Equal(t, 2, cache.Responses[byeHash].Hits)
+	assert.Equal(t, cache.Responses[hiHash].Access+1, cache.Responses[byeHash].Access)
+}
@@ -0,0 +1,154 @@
+package llmcache
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"gopkg.in/yaml.v3"
+)
+
+// recordedExchange is one HTTP request/response pair from another tool's
+// recording.
+type recordedExchange struct {
+	Method       string
+	URL          string
+	Status       int
+	RequestBody  string
+	ResponseBody string
+}
+
+// vcrCassette is a go-vcr cassette. Versions 1 and 2 share the fields we need.
+type vcrCassette struct {
+	Interactions []struct {
+		Request struct {
+			Body   string `yaml:"body"`
+			URL    string `yaml:"url"`
+			Method string `yaml:"method"`
+		} `yaml:"request"`
+		Response struct {
+			Body string `yaml:"body"`
+			Code int    `yaml:"code"`
+		} `yaml:"response"`
+	} `yaml:"interactions"`
+}
+
+func parseVCRCassette(data []byte) ([]recordedExchange, error) {
+	var cassette vcrCassette
+	if err := yaml.Unmarshal(data, &cassette); err != nil {
+		return nil, err
+	}
+	exchanges := make([]recordedExchange, 0, len(cassette.Interactions))
+	for _, i := range cassette.Interactions {
+		exchanges = append(exchanges, recordedExchange{
+			Method:       i.Request.Method,
+			URL:          i.Request.URL,
+			Status:       i.Response.Code,
+			RequestBody:  i.Request.Body,
+			ResponseBody: i.Response.Body,
+		})
+	}
+	return exchanges, nil
+}
+
+// importExchanges adds the successful, non-streamed chat completions among
+// exchanges to cache. Exchanges with other endpoints, failed or streamed
+// responses are skipped, as are requests the cache already holds.
+func importExchanges(cache *Cache, exchanges []recordedExchange, now time.Time) (imported, skipped int, err error) {
+	for _, e := range exchanges {
+		u, err := url.Parse(e.URL)
+		if err != nil || e.Method != "POST" || !strings.HasSuffix(u.Path, "/chat/completions") || e.Status != 200 {
+			skipped++
+			continue
+		}
+		var req openai.ChatCompletionRequest
+		var resp openai.ChatCompletionResponse
+		if json.Unmarshal([]byte(e.RequestBody), &req) != nil || req.Stream ||
+			json.Unmarshal([]byte(e.ResponseBody), &resp) != nil || len(resp.Choices) == 0 {
+			skipped++
+			continue
+		}
+		hash, err := generateHash(req)
+		if err != nil {
+			return imported, skipped, err
+		}
+		if _, found := cache.Responses[hash]; found {
+			skipped++
+			continue
+		}
+		cache.Responses[hash] = CacheEntry{
+			Response:  resp.Choices[0].Message.Content,
+			Timestamp: now,
+			Access:    cache.nextAccess(),
+			Recorded:  now,
+			Request:   &req,
+		}
+		imported++
+	}
+	return imported, skipped, nil
+}
+
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fs.String("format", "vcr", "Format of the recordings: vcr (go-vcr YAML cassettes) or har (Polly.js and browser HAR files)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: llm-test-cache import [-format vcr|har] FILE...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("import needs at least one recording")
+	}
+
+	var parse func([]byte) ([]recordedExchange, error)
+	switch *format {
+	case "vcr":
+		parse = parseVCRCassette
+	case "har", "polly":
+		parse = parseHAR
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	store := newFileStore(cacheFile)
+	defer store.Close()
+	cache, err := store.Load()
+	if err != nil {
+		return err
+	}
+	type importedFile struct {
+		File     string `json:"file"`
+		Imported int    `json:"imported"`
+		Skipped  int    `json:"skipped"`
+	}
+	files := []importedFile{}
+	for _, path := range fs.Args() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		exchanges, err := parse(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		imported, skipped, err := importExchanges(cache, exchanges, time.Now())
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		fmt.Fprintf(console, "%s: imported %d responses, skipped %d interactions\n", path, imported, skipped)
+		files = append(files, importedFile{File: path, Imported: imported, Skipped: skipped})
+	}
+	if err := store.Save(cache); err != nil {
+		return err
+	}
+	return report(files)
+}
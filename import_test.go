@@ -0,0 +1,81 @@
+package llmcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+const testCassette = `---
+version: 2
+interactions:
+- id: 0
+  request:
+    body: '{"model":"gpt-3.5-turbo-0125","messages":[{"role":"user","content":"Hi"}],"seed":1}'
+    url: https://api.openai.com/v1/chat/completions
+    method: POST
+  response:
+    body: '{"id":"chatcmpl-1","choices":[{"index":0,"message":{"role":"assistant","content":"Hello!"},"finish_reason":"stop"}]}'
+    code: 200
+- id: 1
+  request:
+    body: ""
+    url: https://api.openai.com/v1/models
+    method: GET
+  response:
+    body: '{"data":[]}'
+    code: 200
+`
+
+const testHAR = `{"log": {"entries": [{
+	"request": {"method": "POST", "url": "https://api.openai.com/v1/chat/completions",
+		"postData": {"text": "{\"model\":\"gpt-4o\",\"messages\":[{\"role\":\"user\",\"content\":\"Hi\"}]}"}},
+	"response": {"status": 200, "content": {"text": "{\"choices\":[{\"message\":{\"role\":\"assistant\",\"content\":\"Hey.\"}}]}"}}
+}, {
+	"request": {"method": "POST", "url": "https://api.openai.com/v1/chat/completions",
+		"postData": {"text": "{\"model\":\"gpt-4o\",\"messages\":[]}"}},
+	"response": {"status": 429, "content": {"text": "{\"error\":{}}"}}
+}]}}`
+
+func TestImportVCRCassette(t *testing.T) {
+	exchanges, err := parseVCRCassette([]byte(testCassette))
+	assert.NoError(t, err)
+	cache := &Cache{Responses: map[string]CacheEntry{}}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	imported, skipped, err := importExchanges(cache, exchanges, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, imported)
+	assert.Equal(t, 1, skipped)
+
+	seed := 1
+	hash, err := generateHash(openai.ChatCompletionRequest{
+		Model:    "gpt-3.5-turbo-0125",
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}},
+		Seed:     &seed,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello!", cache.Responses[hash].Response)
+	assert.Equal(t, now, cache.Responses[hash].Recorded)
+
+	imported, skipped, err = importExchanges(cache, exchanges, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, imported, "requests already cached are not imported again")
+	assert.Equal(t, 2, skipped)
+}
+
+func TestImportHAR(t *testing.T) {
+	exchanges, err := parseHAR([]byte(testHAR))
+	assert.NoError(t, err)
+	cache := &Cache{Responses: map[string]CacheEntry{}}
+
+	imported, skipped, err := importExchanges(cache, exchanges, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, imported)
+	assert.Equal(t, 1, skipped, "failed responses are skipped")
+	for _, entry := range cache.Responses {
+		assert.Equal(t, "Hey.", entry.Response)
+	}
+}
@@ -0,0 +1,70 @@
+package llmcache
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// lintMaxPromptTokens is the estimated prompt size above which a request is
+// flagged as overly long. Long prompts are expensive to record and usually
+// mean a test fixture grew by accident.
+const lintMaxPromptTokens = 8000
+
+// deprecatedModels maps retired or deprecated models to their suggested
+// replacement. Recordings of these models can't be refreshed once the model is
+// shut down.
+var deprecatedModels = map[string]string{
+	"gpt-3.5-turbo-0301":     "gpt-3.5-turbo-0125",
+	"gpt-3.5-turbo-0613":     "gpt-3.5-turbo-0125",
+	"gpt-3.5-turbo-16k-0613": "gpt-3.5-turbo-0125",
+	"gpt-4-0314":             "gpt-4o",
+	"gpt-4-32k":              "gpt-4o",
+	"gpt-4-32k-0314":         "gpt-4o",
+	"gpt-4-32k-0613":         "gpt-4o",
+	"gpt-4-vision-preview":   "gpt-4o",
+	"text-davinci-003":       "gpt-3.5-turbo-instruct",
+	"claude-2.0":             "claude-3-5-haiku-latest",
+	"claude-2.1":             "claude-3-5-haiku-latest",
+	"claude-instant-1.2":     "claude-3-5-haiku-latest",
+}
+
+// lintRequest returns warnings about properties of req that make its response
+// unlikely to be stable, and its recording therefore unlikely to be useful.
+func lintRequest(req openai.ChatCompletionRequest) []string {
+	var warnings []string
+	if req.Seed == nil {
+		warnings = append(warnings, "no seed is set, so the response may differ every time it is recorded")
+	}
+	if req.Temperature > 0 && (req.PresencePenalty != 0 || req.FrequencyPenalty != 0) {
+		warnings = append(warnings, fmt.Sprintf("presence or frequency penalties with temperature %g make sampling less repeatable", req.Temperature))
+	}
+	if replacement, found := deprecatedModels[req.Model]; found {
+		warnings = append(warnings, fmt.Sprintf("model %s is deprecated; consider %s", req.Model, replacement))
+	}
+	tokens, err := countPromptTokens(req)
+	if err != nil {
+		return append(warnings, fmt.Sprintf("counting prompt tokens: %v", err))
+	}
+	if tokens > lintMaxPromptTokens {
+		warnings = append(warnings, fmt.Sprintf("the prompt is %d tokens, over the %d token guideline", tokens, lintMaxPromptTokens))
+	}
+	return warnings
+}
+
+// lint checks req before it is recorded, printing its warnings, or failing
+// with ErrUnstableRequest instead when the client is strict.
+func (c *CachingClient) lint(hash string, req openai.ChatCompletionRequest) error {
+	warnings := lintRequest(req)
+	if len(warnings) == 0 {
+		return nil
+	}
+	if c.strict {
+		return fmt.Errorf("%w: %s: %s", ErrUnstableRequest, abbreviate(hash), strings.Join(warnings, "; "))
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(console, "Warning: %s: %s\n", abbreviate(hash), w)
+	}
+	return nil
+}
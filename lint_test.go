@@ -0,0 +1,36 @@
+package llmcache
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintRequest(t *testing.T) {
+	seed := 1
+	clean := openai.ChatCompletionRequest{
+		Model:    "gpt-4o-mini",
+		Seed:     &seed,
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}},
+	}
+	assert.Empty(t, lintRequest(clean))
+
+	unstable := openai.ChatCompletionRequest{
+		Model:           "gpt-3.5-turbo-0613",
+		Temperature:     0.7,
+		PresencePenalty: 0.5,
+		Messages:        []openai.ChatCompletionMessage{{Role: "user", Content: strings.Repeat("word ", 8000)}},
+	}
+	assert.Len(t, lintRequest(unstable), 4)
+}
+
+func TestStrictRefusesUnstableRequests(t *testing.T) {
+	client := newTestClient(t, nil)
+	client.strict = true
+	_, _, err := client.getResponse(context.Background(), openai.ChatCompletionRequest{Model: "gpt-4o"})
+	assert.ErrorIs(t, err, ErrUnstableRequest)
+	assert.Equal(t, 0, client.Stats().Misses, "a refused request is never sent")
+}
@@ -0,0 +1,278 @@
+package llmcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// promptHash hashes only the messages of req, so recordings of the same prompt
+// with different models or parameters share a prompt hash.
+func promptHash(req openai.ChatCompletionRequest) (string, error) {
+	data, err := json.Marshal(req.Messages)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// entryPromptHash returns the prompt hash stored with entry, computing it for
+// entries recorded before prompt hashes were kept. Entries without a recorded
+// request have none.
+func entryPromptHash(entry CacheEntry) string {
+	if entry.PromptHash != "" || entry.Request == nil {
+		return entry.PromptHash
+	}
+	hash, _ := promptHash(*entry.Request)
+	return hash
+}
+
+// listedEntry is one line of ls and search output.
+type listedEntry struct {
+	Hash       string
+	PromptHash string
+	Model      string
+	Prompt     string
+	Entry      CacheEntry
+}
+
+// entryFilter selects entries to list. Empty fields match everything.
+type entryFilter struct {
+	Model      string
+	PromptHash string
+	// Text must appear in the prompt or label, ignoring case.
+	Text string
+}
+
+func (f entryFilter) match(e listedEntry) bool {
+	if f.Model != "" && e.Model != f.Model {
+		return false
+	}
+	// Prompt hashes can be given abbreviated, as ls prints them.
+	if f.PromptHash != "" && !strings.HasPrefix(e.PromptHash, f.PromptHash) {
+		return false
+	}
+	text := strings.ToLower(f.Text)
+	return text == "" || strings.Contains(strings.ToLower(e.Prompt), text) || strings.Contains(strings.ToLower(e.Entry.Label), text)
+}
+
+// listEntries returns the entries of cache matching filter, grouped by prompt
+// and then ordered by model and hash.
+func listEntries(cache *Cache, filter entryFilter) []listedEntry {
+	var entries []listedEntry
+	for hash, entry := range cache.Responses {
+		e := listedEntry{Hash: hash, PromptHash: entryPromptHash(entry), Model: entryModel(entry), Entry: entry}
+		if entry.Request != nil {
+			e.Prompt = promptText(*entry.Request)
+		}
+		if filter.match(e) {
+			entries = append(entries, e)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.PromptHash != b.PromptHash {
+			return a.PromptHash < b.PromptHash
+		}
+		if a.Model != b.Model {
+			return a.Model < b.Model
+		}
+		return a.Hash < b.Hash
+	})
+	return entries
+}
+
+func abbreviate(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+
+// entryReport is a listed entry as reported by -output=json.
+type entryReport struct {
+	Key        string     `json:"key"`
+	PromptHash string     `json:"prompt_hash,omitempty"`
+	Model      string     `json:"model"`
+	Label      string     `json:"label,omitempty"`
+	Prompt     string     `json:"prompt,omitempty"`
+	Hits       int        `json:"hits"`
+	LastHit    *time.Time `json:"last_hit,omitempty"`
+	Pinned     bool       `json:"pinned,omitempty"`
+}
+
+func entryReports(entries []listedEntry) []entryReport {
+	reports := []entryReport{}
+	for _, e := range entries {
+		r := entryReport{Key: e.Hash, PromptHash: e.PromptHash, Model: e.Model, Label: e.Entry.Label, Prompt: e.Prompt, Hits: e.Entry.Hits, Pinned: e.Entry.Pinned}
+		if e.Entry.Hits > 0 {
+			lastHit := e.Entry.LastHit
+			r.LastHit = &lastHit
+		}
+		reports = append(reports, r)
+	}
+	return reports
+}
+
+// printEntries prints one line per entry, naming labelled entries by their
+// label and the others by the start of their prompt.
+func printEntries(entries []listedEntry) {
+	for _, e := range entries {
+		name := truncatePrompt(e.Prompt, 60)
+		if e.Entry.Label != "" {
+			name = "[" + e.Entry.Label + "]"
+		}
+		fmt.Fprintf(console, "%s\tprompt %s\t%s\t%d hits\t%s\n", e.Hash, abbreviate(e.PromptHash), e.Model, e.Entry.Hits, name)
+	}
+	fmt.Fprintf(console, "%d entries\n", len(entries))
+}
+
+func runList(args []string) error {
+	return listCommand("ls", args, false)
+}
+
+func runSearch(args []string) error {
+	return listCommand("search", args, true)
+}
+
+// listCommand implements ls and search, which differ only in that search takes
+// the text to look for in prompts as its first argument.
+func listCommand(name string, args []string, search bool) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	model := fs.String("model", "", "Only list entries for this model")
+	prompt := fs.String("prompt-hash", "", "Only list recordings of the prompt with this (possibly abbreviated) prompt hash")
+	samePrompt := fs.String("same-prompt", "", "Only list recordings of the same prompt as the entry with this cache key")
+	fs.Usage = func() {
+		if search {
+			fmt.Fprintln(fs.Output(), "Usage: llm-test-cache search [flags] TEXT [CACHE|@SNAPSHOT]")
+		} else {
+			fmt.Fprintln(fs.Output(), "Usage: llm-test-cache ls [flags] [CACHE|@SNAPSHOT]")
+		}
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	rest := fs.Args()
+	filter := entryFilter{Model: *model, PromptHash: *prompt}
+	if search {
+		if len(rest) == 0 {
+			fs.Usage()
+			return errors.New("search needs the text to look for")
+		}
+		filter.Text, rest = rest[0], rest[1:]
+	}
+	if len(rest) > 1 {
+		fs.Usage()
+		return fmt.Errorf("%s takes at most one cache", name)
+	}
+	path := cacheFile
+	if len(rest) == 1 {
+		var err error
+		if path, err = resolveCachePath(rest[0]); err != nil {
+			return err
+		}
+	}
+	cache, err := loadCacheFrom(path)
+	if err != nil {
+		return err
+	}
+	if *samePrompt != "" {
+		entry, err := lookup(cache, *samePrompt)
+		if err != nil {
+			return err
+		}
+		if filter.PromptHash = entryPromptHash(entry); filter.PromptHash == "" {
+			return fmt.Errorf("entry %s has no recorded request", *samePrompt)
+		}
+	}
+	entries := listEntries(cache, filter)
+	printEntries(entries)
+	return report(entryReports(entries))
+}
+
+// entriesByUse returns the entries of cache, least used first: by hit count,
+// then by when they were last hit.
+func entriesByUse(cache *Cache) []listedEntry {
+	entries := listEntries(cache, entryFilter{})
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i].Entry, entries[j].Entry
+		if a.Hits != b.Hits {
+			return a.Hits < b.Hits
+		}
+		return a.LastHit.Before(b.LastHit)
+	})
+	return entries
+}
+
+// runEntryStats prints how often each entry has been used, least used first,
+// so that dead fixtures no test uses anymore stand out.
+func runEntryStats(args []string) error {
+	if len(args) > 1 {
+		return errors.New("usage: llm-test-cache stats [CACHE|@SNAPSHOT]")
+	}
+	path := cacheFile
+	if len(args) == 1 {
+		var err error
+		if path, err = resolveCachePath(args[0]); err != nil {
+			return err
+		}
+	}
+	cache, err := loadCacheFrom(path)
+	if err != nil {
+		return err
+	}
+	hits, unused := 0, 0
+	entries := entriesByUse(cache)
+	for _, e := range entries {
+		lastHit := "never used"
+		if e.Entry.Hits == 0 {
+			unused++
+		} else {
+			lastHit = "last used " + e.Entry.LastHit.Format(time.RFC3339)
+		}
+		hits += e.Entry.Hits
+		fmt.Fprintf(console, "%s\t%d hits\t%s\t%s\n", e.Hash, e.Entry.Hits, lastHit, e.Model)
+	}
+	fmt.Fprintf(console, "%d entries, %d hits, %d never used\n", len(cache.Responses), hits, unused)
+	return report(entryReports(entries))
+}
+
+// runShow prints the entry recorded under a cache key, including its request
+// and provenance, as JSON.
+func runShow(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("usage: llm-test-cache show KEY [CACHE|@SNAPSHOT]")
+	}
+	path := cacheFile
+	if len(args) == 2 {
+		var err error
+		if path, err = resolveCachePath(args[1]); err != nil {
+			return err
+		}
+	}
+	cache, err := loadCacheFrom(path)
+	if err != nil {
+		return err
+	}
+	entry, err := lookup(cache, args[0])
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
@@ -0,0 +1,37 @@
+package llmcache
+
+import (
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListEntriesByPrompt(t *testing.T) {
+	messages := []openai.ChatCompletionMessage{{Role: "user", Content: "What is the capital of France?"}}
+	cheap := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Messages: messages}
+	smart := openai.ChatCompletionRequest{Model: "gpt-4o", Messages: messages, MaxTokens: 100}
+	other := openai.ChatCompletionRequest{Model: "gpt-4o", Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+
+	shared, err := promptHash(cheap)
+	assert.NoError(t, err)
+	stored, err := promptHash(smart)
+	assert.NoError(t, err)
+	assert.Equal(t, shared, stored, "the prompt hash ignores model and parameters")
+
+	cache := &Cache{Responses: map[string]CacheEntry{
+		"k1": {Response: "Paris", Request: &cheap},
+		"k2": {Response: "Paris.", Request: &smart, PromptHash: shared},
+		"k3": {Response: "Hello", Request: &other},
+		"k4": {Response: "unknown"},
+	}}
+
+	byPrompt := listEntries(cache, entryFilter{PromptHash: abbreviate(shared)})
+	if assert.Len(t, byPrompt, 2) {
+		assert.Equal(t, "gpt-4o", byPrompt[0].Model)
+		assert.Equal(t, "gpt-4o-mini", byPrompt[1].Model)
+	}
+
+	assert.Len(t, listEntries(cache, entryFilter{Text: "CAPITAL", Model: "gpt-4o"}), 1)
+	assert.Len(t, listEntries(cache, entryFilter{}), 4)
+}
@@ -0,0 +1,128 @@
+package llmcache
+
+import (
+	"context"
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+// The tests in this file call the real OpenAI API. They are skipped unless
+// they are asked for, with the live build tag or LLM_TEST_LIVE=1:
+//
+//	go test -tags live -run Live ./...
+
+var liveBudget = flag.Float64("live-budget", 0.05, "Fail the live tests' requests once they have cost this many US dollars in total")
+
+// liveTag is set by the live build tag, and liveSpent totals the estimated
+// cost of the live tests run so far.
+var (
+	liveTag   bool
+	liveSpent float64
+)
+
+// newLiveClient returns a client calling the real API, or skips t if live tests
+// weren't asked for. The live clients of a run share -live-budget: each may
+// spend what the previous ones left.
+func newLiveClient(t *testing.T) *CachingClient {
+	t.Helper()
+	if !liveTag && os.Getenv("LLM_TEST_LIVE") != "1" {
+		t.Skip("live test: run with -tags live or LLM_TEST_LIVE=1")
+	}
+	apiKey := os.Getenv("OPENAI_API_KEY")
+	if apiKey == "" {
+		t.Fatal("Error: OPENAI_API_KEY environment variable not set. Please set it to run the live tests.")
+	}
+	remaining := *liveBudget - liveSpent
+	if remaining <= 0 {
+		t.Skipf("the live budget of $%.4f is spent", *liveBudget)
+	}
+	client := NewCachingClient(apiKey, true, *cacheSizeLimit)
+	client.maxCost = remaining
+	t.Cleanup(func() {
+		liveSpent += client.Stats().EstimatedCost
+		t.Logf("live tests have spent an estimated $%.4f of $%.4f", liveSpent, *liveBudget)
+	})
+	return client
+}
+
+func TestLiveCacheAPIResponses(t *testing.T) {
+	client := newLiveClient(t)
+	ctx := context.Background()
+
+	models, prompts := testModels, testPrompts
+
+	seed := 12345
+
+	for _, model := range models {
+		t.Run(model, func(t *testing.T) {
+			for i := 0; i < 3; i++ {
+				for _, prompt := range prompts {
+					req := openai.ChatCompletionRequest{
+						Model: model,
+						Messages: []openai.ChatCompletionMessage{
+							{Role: "user", Content: prompt},
+						},
+						Seed:      &seed,
+						MaxTokens: *maxTokens,
+					}
+					response, cached, err := client.getResponse(ctx, req)
+					assert.NoError(t, err)
+					if i == 0 {
+						assert.False(t, cached, "First run should be a cache miss")
+					} else {
+						assert.True(t, cached, "Subsequent runs should use cache")
+					}
+					t.Logf("Response for prompt '%s': %s\n", prompt, response)
+				}
+			}
+
+			if *testCacheability {
+				// Test for deterministic responses
+				req := openai.ChatCompletionRequest{
+					Model: model,
+					Messages: []openai.ChatCompletionMessage{
+						{Role: "user", Content: prompts[0]},
+					},
+					Seed:      &seed,
+					MaxTokens: *maxTokens,
+				}
+				response1, _, err := client.getResponse(ctx, req)
+				assert.NoError(t, err)
+				response2, _, err := client.getResponse(ctx, req)
+				assert.NoError(t, err)
+				assert.Equal(t, response1, response2, "API / model is not behaving in a cacheable way")
+			}
+		})
+	}
+
+	assert.NoError(t, client.Close())
+
+	// Clear cache after tests unless keepCache flag is set
+	if !*keepCache {
+		err := clearCache()
+		assert.NoError(t, err, "Failed to clear cache")
+	}
+}
+
+func TestLiveClientsShareTheBudget(t *testing.T) {
+	t.Setenv("LLM_TEST_LIVE", "1")
+	t.Setenv("OPENAI_API_KEY", "test-key")
+	defer func(spent float64) { liveSpent = spent }(liveSpent)
+	liveSpent = *liveBudget - 0.01
+
+	t.Run("first", func(t *testing.T) {
+		client := newLiveClient(t)
+		assert.InDelta(t, 0.01, client.maxCost, 1e-9)
+		client.stats.EstimatedCost = 0.01
+	})
+	skipped := false
+	t.Run("second", func(t *testing.T) {
+		defer func() { skipped = t.Skipped() }()
+		newLiveClient(t)
+	})
+	assert.True(t, skipped, "the second client should find the budget spent")
+}
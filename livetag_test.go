@@ -0,0 +1,7 @@
+//go:build live
+
+package llmcache
+
+func init() {
+	liveTag = true
+}
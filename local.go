@@ -0,0 +1,110 @@
+package llmcache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// allLocalModels can be listed as a suite model to run against every model
+// the local endpoint serves.
+const allLocalModels = "local:*"
+
+// isLocalURL reports whether baseURL points at this machine, as Ollama and
+// vLLM endpoints usually do.
+func isLocalURL(baseURL string) bool {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+type ollamaTags struct {
+	Models []struct {
+		Name string `json:"name"`
+	} `json:"models"`
+}
+
+// discoverLocalModels asks the endpoint at c.baseURL which models it serves,
+// trying Ollama's /api/tags first and the OpenAI-compatible /models (as
+// served by vLLM) second. It also namespaces the cache by provider and host,
+// so that the same model name served by different backends is cached
+// separately.
+func (c *CachingClient) discoverLocalModels(ctx context.Context) ([]string, error) {
+	u, err := url.Parse(c.baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := "ollama"
+	models, err := c.ollamaModels(ctx, strings.TrimSuffix(strings.TrimSuffix(c.baseURL, "/"), "/v1"))
+	if err != nil {
+		provider = "openai-compatible"
+		list, listErr := c.ListModels(ctx)
+		if listErr != nil {
+			return nil, fmt.Errorf("discovering models at %s: %w", c.baseURL, errors.Join(err, listErr))
+		}
+		models = nil
+		for _, m := range list.Models {
+			models = append(models, m.ID)
+		}
+	}
+	sort.Strings(models)
+
+	c.localModels = models
+	c.namespace = provider + "@" + u.Host
+	return models, nil
+}
+
+func (c *CachingClient) ollamaModels(ctx context.Context, root string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, root+"/api/tags", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s/api/tags: unexpected status %s", root, resp.Status)
+	}
+	var tags ollamaTags
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("%s/api/tags: %w", root, err)
+	}
+	models := make([]string, 0, len(tags.Models))
+	for _, m := range tags.Models {
+		models = append(models, m.Name)
+	}
+	return models, nil
+}
+
+// resolveModels replaces allLocalModels in models with the discovered local
+// models.
+func (c *CachingClient) resolveModels(models []string) ([]string, error) {
+	var resolved []string
+	for _, model := range models {
+		if model != allLocalModels {
+			resolved = append(resolved, model)
+			continue
+		}
+		if len(c.localModels) == 0 {
+			return nil, fmt.Errorf("suite targets %q but no local models were discovered; set -base-url to a local Ollama or vLLM endpoint", allLocalModels)
+		}
+		resolved = append(resolved, c.localModels...)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,64 @@
+package llmcache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsLocalURL(t *testing.T) {
+	assert.True(t, isLocalURL("http://localhost:11434/v1"))
+	assert.True(t, isLocalURL("http://127.0.0.1:8000/v1"))
+	assert.True(t, isLocalURL("http://[::1]:8000/v1"))
+	assert.False(t, isLocalURL("https://api.openai.com/v1"))
+}
+
+func TestDiscoverOllamaModels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/tags", r.URL.Path)
+		w.Write([]byte(`{"models": [{"name": "qwen2:7b"}, {"name": "llama3:latest"}]}`))
+	}))
+	defer server.Close()
+
+	config := openai.DefaultConfig("")
+	config.BaseURL = server.URL + "/v1"
+	client := NewCachingClientWithConfig(config, true, DefaultCacheSizeLimit)
+
+	models, err := client.discoverLocalModels(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"llama3:latest", "qwen2:7b"}, models)
+	assert.Equal(t, "ollama@"+server.Listener.Addr().String(), client.namespace)
+
+	resolved, err := client.resolveModels([]string{"gpt-4o", allLocalModels})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gpt-4o", "llama3:latest", "qwen2:7b"}, resolved)
+}
+
+func TestDiscoverOpenAICompatibleModels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"object": "list", "data": [{"id": "mistral-7b-instruct"}]}`))
+	}))
+	defer server.Close()
+
+	config := openai.DefaultConfig("")
+	config.BaseURL = server.URL + "/v1"
+	client := NewCachingClientWithConfig(config, true, DefaultCacheSizeLimit)
+
+	models, err := client.discoverLocalModels(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"mistral-7b-instruct"}, models)
+	assert.Contains(t, client.namespace, "openai-compatible@")
+}
+
+func TestResolveModelsWithoutDiscovery(t *testing.T) {
+	_, err := (&CachingClient{}).resolveModels([]string{allLocalModels})
+	assert.Error(t, err)
+}
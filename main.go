@@ -1,47 +1,223 @@
-package main
+// Package llmcache caches the chat completions and embeddings of an OpenAI
+// client, so that tests replay recorded responses instead of calling the API.
+// The llm-test-cache command in cmd/llm-test-cache manages the cache files,
+// and package cachetest has assertions for use in tests.
+package llmcache
 
 import (
 	"context"
+	"crypto/ed25519"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"path/filepath"
-	"sort"
+	"sync"
 	"time"
 
 	"github.com/sashabaranov/go-openai"
 )
 
 const (
-	cacheFile             = "cache/response-cache.json"
-	defaultCacheSizeLimit = 10 * 1024 * 1024 // 10MB
+	cacheFile = "cache/response-cache.json"
+	// DefaultCacheSizeLimit is the cache size limit of the command line,
+	// 10MB.
+	DefaultCacheSizeLimit = 10 * 1024 * 1024
 )
 
 type CacheEntry struct {
-	Response  string    `json:"response"`
+	Response string `json:"response"`
+	// ResponseRef is the key in Cache.Blobs of a response shared with other
+	// entries, in the file only; Response is then empty there.
+	ResponseRef string `json:"response_ref,omitempty"`
+	// Timestamp is when the entry was last used; Recorded is when its
+	// response was fetched.
 	Timestamp time.Time `json:"timestamp"`
+	Recorded  time.Time `json:"recorded,omitempty"`
+	// Access is the cache's Sequence when the entry was last used, which
+	// orders uses exactly where timestamps may tie or come from skewed
+	// clocks. It is 0 for entries last used before caches counted uses.
+	Access  uint64                        `json:"access,omitempty"`
+	Request *openai.ChatCompletionRequest `json:"request,omitempty"`
+	// PromptHash identifies the messages alone, shared by recordings of the
+	// same prompt across models and parameters.
+	PromptHash string `json:"prompt_hash,omitempty"`
+	Namespace  string `json:"namespace,omitempty"`
+	// Label is a human-readable name for the request, set with WithLabel.
+	Label string `json:"label,omitempty"`
+	// Parent is the key of the previous turn, for turns of a Conversation.
+	Parent string `json:"parent,omitempty"`
+	// Diverged is where the request parted from the closest earlier
+	// recording, when prefix matching is enabled.
+	Diverged   *Divergence `json:"diverged,omitempty"`
+	Provenance *Provenance `json:"provenance,omitempty"`
+	// Hits is how many times the entry was served from the cache, last at
+	// LastHit.
+	Hits    int       `json:"hits,omitempty"`
+	LastHit time.Time `json:"last_hit,omitempty"`
+	// Chunks are the content chunks of a streamed response, as they were
+	// streamed.
+	Chunks []string `json:"chunks,omitempty"`
+	// Pinned entries are never evicted.
+	Pinned bool `json:"pinned,omitempty"`
+	// Signature is the base64 Ed25519 signature of the entry, when it was
+	// recorded with a signing key.
+	Signature string `json:"signature,omitempty"`
+	// ProviderCache is the provider-side prompt caching reported when the
+	// response was recorded, for providers such as Anthropic that report it.
+	ProviderCache *ProviderCacheUsage `json:"provider_cache,omitempty"`
 }
 
 type Cache struct {
 	Responses map[string]CacheEntry `json:"responses"`
+	// Sessions holds multi-turn conversations recorded as a whole.
+	Sessions map[string]SessionRecord `json:"sessions,omitempty"`
+	// Embeddings holds the vectors of embedded inputs, one per input string.
+	Embeddings map[string]EmbeddingEntry `json:"embeddings,omitempty"`
+	// Savings holds the cumulative statistics of runs, by UTC day.
+	Savings map[string]Savings `json:"savings,omitempty"`
+	// Sequence counts the uses of the cache's entries, by every machine
+	// sharing it.
+	Sequence uint64 `json:"sequence,omitempty"`
+	// Blobs holds the responses shared by several entries, by their SHA-256,
+	// in the file only.
+	Blobs map[string]string `json:"blobs,omitempty"`
 }
 
+// nextAccess returns the sequence number of a new use of an entry.
+func (c *Cache) nextAccess() uint64 {
+	c.Sequence++
+	return c.Sequence
+}
+
+// CachingClient is safe for concurrent use by multiple goroutines, so one
+// client can serve a whole test binary. Its cache is read and written by one
+// goroutine at a time, while requests to the API are sent concurrently, and
+// concurrent misses of one key share a single request. The setters configure
+// the client and must be called before it is shared. Sessions and
+// conversations belong to the goroutine that started them.
 type CachingClient struct {
-	*openai.Client
+	Client
+	// mu guards the client's state and its store. It is released while
+	// requests are sent to the API.
+	mu sync.Mutex
+	// inflight holds, by key, a channel closed once the request recording
+	// that key is done, for concurrent misses of the key to wait on.
+	inflight       map[string]chan struct{}
+	baseURL        string
+	httpc          *http.Client
+	localModels    []string
+	namespace      string
 	cacheEnabled   bool
 	cacheSizeLimit int64
+	store          Store
+	anthropic      *anthropicClient
+	bedrock        *bedrockClient
+	vertex         *vertexClient
+	stats          RunStats
+	statsPath      string
+	maxCost        float64
+	// reserved is the estimated cost of requests sent concurrently whose
+	// responses haven't been recorded yet, counted against maxCost.
+	reserved       float64
+	listeners      []func(Event)
+	eventChans     []chan Event
+	audit          *auditLog
+	usage          *usageLog
+	capture        *requestCapture
+	traces         *traceForwarder
+	webhook        *webhookNotifier
+	secretPolicy   SecretPolicy
+	secretScanner  *secretScanner
+	clock          Clock
+	ttl            time.Duration
+	evictionPolicy EvictionPolicy
+	// Entries recorded less than minEntryAge ago aren't evicted.
+	minEntryAge time.Duration
+	// Responses longer than maxEntrySize bytes are truncated if
+	// truncateOversized is set, and not cached otherwise.
+	maxEntrySize      int64
+	truncateOversized bool
+	normalization     Normalization
+	// hot keeps recently served entries in memory.
+	hot *hotCache
+	// validateRequests makes requests the model can't serve fail before
+	// they are sent.
+	validateRequests bool
+	// strict makes lint warnings about requests being recorded errors.
+	strict         bool
+	postProcessors []PostProcessor
+	prefixMatching bool
+	provenance     *Provenance
+	signingKey     ed25519.PrivateKey
+	verifyKey      ed25519.PublicKey
+	// readOnly stops the client from saving the cache: hits don't update
+	// timestamps and misses fail with ErrReadOnly.
+	readOnly bool
+	// noTouch stops hits from updating entry timestamps.
+	noTouch bool
+	// progress, if set, is where a progress bar is drawn while a suite runs.
+	progress io.Writer
+	// truncationReplay serves requests that only lower max_tokens from
+	// truncated recordings.
+	truncationReplay bool
+	matchRules       []MatchRule
+	// nearestKeys is how many similar recordings to print on a miss.
+	nearestKeys int
+	closed      bool
 }
 
+// NewCachingClient returns a client caching responses in cacheFile, evicting
+// entries once they take more than cacheSizeLimit bytes; a limit of 0 or -1
+// means entries are never evicted. When
+// ANTHROPIC_API_KEY is set, requests for Claude models are sent to Anthropic;
+// when AWS or Google Cloud credentials are configured, "bedrock/" and
+// "vertex/" models are sent to Bedrock and Vertex AI. The client must be
+// closed with Close once it is no longer needed.
 func NewCachingClient(apiKey string, cacheEnabled bool, cacheSizeLimit int64) *CachingClient {
-	client := openai.NewClient(apiKey)
-	return &CachingClient{
-		Client:         client,
-		cacheEnabled:   cacheEnabled,
-		cacheSizeLimit: cacheSizeLimit,
+	config := openai.DefaultConfig(apiKey)
+	config.HTTPClient = &http.Client{Transport: NewTransport(DefaultTransportOptions())}
+	return NewCachingClientWithConfig(config, cacheEnabled, cacheSizeLimit)
+}
+
+// NewCachingClientWithConfig is like NewCachingClient but sends OpenAI
+// requests using config, e.g. to an OpenAI-compatible endpoint at another
+// base URL.
+func NewCachingClientWithConfig(config openai.ClientConfig, cacheEnabled bool, cacheSizeLimit int64) *CachingClient {
+	httpc := config.HTTPClient
+	config.HTTPClient = withHeaderTransport(httpc)
+	c := newCachingClient(openai.NewClientWithConfig(config), cacheEnabled, cacheSizeLimit)
+	c.baseURL = config.BaseURL
+	c.httpc = httpc
+	return c
+}
+
+// newCachingClient returns a caching client sending OpenAI requests with
+// client, with the defaults every constructor shares: the cache in
+// cacheFile, normalized responses, validated requests, a hot cache and the
+// other providers whose credentials are configured.
+func newCachingClient(client Client, cacheEnabled bool, cacheSizeLimit int64) *CachingClient {
+	c := &CachingClient{
+		Client:           client,
+		cacheEnabled:     cacheEnabled,
+		cacheSizeLimit:   cacheSizeLimit,
+		store:            newFileStore(cacheFile),
+		clock:            systemClock{},
+		normalization:    DefaultNormalization,
+		validateRequests: true,
+		hot:              newHotCache(defaultHotCacheSize),
+	}
+	if key := os.Getenv("ANTHROPIC_API_KEY"); key != "" {
+		c.anthropic = newAnthropicClient(key)
 	}
+	c.bedrock = newBedrockClientFromEnv()
+	c.vertex = newVertexClientFromEnv()
+	return c
 }
 
 func generateHash(req openai.ChatCompletionRequest) (string, error) {
@@ -54,176 +230,561 @@ func generateHash(req openai.ChatCompletionRequest) (string, error) {
 }
 
 func loadCache() (*Cache, error) {
-	if _, err := os.Stat(cacheFile); os.IsNotExist(err) {
+	return loadCacheFrom(cacheFile)
+}
+
+func loadCacheFrom(path string) (*Cache, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
 		return &Cache{Responses: make(map[string]CacheEntry)}, nil
 	}
 
-	data, err := os.ReadFile(cacheFile)
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
-	var cache Cache
-	if err := json.Unmarshal(data, &cache); err != nil {
-		return nil, err
+	cache, err := readCache(f)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrCacheCorrupt, path, err)
+	}
+	return cache, nil
+}
+
+// encodeCache returns the contents of the cache file holding cache, indented
+// unless compact is set.
+func encodeCache(cache *Cache, compact bool) ([]byte, error) {
+	if compact {
+		return json.Marshal(packResponses(cache))
 	}
+	return json.MarshalIndent(packResponses(cache), "", "  ")
+}
 
-	return &cache, nil
+// saveCacheTo writes cache to the file at path, compactly unless git tracks
+// it.
+func saveCacheTo(path string, cache *Cache) error {
+	return writeCache(path, cache, compactFor(path))
 }
 
-func saveCache(cache *Cache) error {
-	if err := os.MkdirAll(filepath.Dir(cacheFile), 0755); err != nil {
+func writeCache(path string, cache *Cache, compact bool) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return err
 	}
 
-	data, err := json.MarshalIndent(cache, "", "  ")
+	data, err := encodeCache(cache, compact)
 	if err != nil {
 		return err
 	}
 
-	return os.WriteFile(cacheFile, data, 0644)
+	return writeFileAtomic(path, data, 0644)
 }
 
 func clearCache() error {
 	return os.RemoveAll(filepath.Dir(cacheFile))
 }
 
+func (c *CachingClient) httpClient() *http.Client {
+	if c.httpc != nil {
+		return c.httpc
+	}
+	return http.DefaultClient
+}
+
+// Stats returns what the client has done since it was created.
+func (c *CachingClient) Stats() RunStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
+
+// Flush makes sure every response cached so far has been written durably.
+func (c *CachingClient) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return ErrClientClosed
+	}
+	if err := c.saveTouches(); err != nil {
+		return err
+	}
+	return c.store.Flush()
+}
+
+// Close flushes and releases the store, prints a summary of the run and, if a
+// stats path is configured, writes the statistics there as JSON. The client
+// can't be used after Close; closing it again is a no-op.
+func (c *CachingClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	var errs []error
+	if c.store != nil {
+		errs = append(errs, c.saveTouches(), c.saveRunSavings(), c.store.Flush(), c.store.Close())
+	}
+	c.closeEvents()
+	if c.audit != nil {
+		errs = append(errs, c.audit.Close())
+	}
+	if c.usage != nil {
+		errs = append(errs, c.usage.Close())
+	}
+	if c.capture != nil {
+		errs = append(errs, c.capture.Close())
+	}
+	if c.traces != nil {
+		c.traces.Close()
+	}
+	if c.webhook != nil {
+		c.webhook.Close(c.stats)
+	}
+	fmt.Fprintln(console, c.stats.Summary())
+	if c.statsPath != "" {
+		errs = append(errs, writeStatsJSON(c.statsPath, c.stats))
+	}
+	return errors.Join(errs...)
+}
+
+// fetchCompletion calls the API directly, bypassing the cache. It refuses to
+// make the call once the estimated cost of the run has reached maxCost, or
+// when the locally counted prompt tokens alone would exceed it. The
+// provider cache usage is only reported by providers that cache prompts
+// themselves, and is nil otherwise. A response already fetched by a
+// concurrent recording is taken from ctx instead of calling the API again.
+// Responses are normalized as the client's Normalization says. The caller
+// holds the client's lock, which is released while the request is sent.
+func (c *CachingClient) fetchCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, *ProviderCacheUsage, error) {
+	// Whichever way a request reaches the provider, what the secret policy
+	// redacts is never sent.
+	req = c.redactSecrets(req)
+	var resp openai.ChatCompletionResponse
+	var providerCache *ProviderCacheUsage
+	var err error
+	if p := prefetchedFrom(ctx); p != nil {
+		resp, providerCache, err = p.resp, p.providerCache, p.err
+	} else {
+		if err := c.admit(req); err != nil {
+			return openai.ChatCompletionResponse{}, nil, err
+		}
+		c.unlocked(func() { resp, providerCache, err = c.send(ctx, req) })
+	}
+	if err != nil {
+		hash, _ := generateHash(req)
+		c.emit(Event{Kind: UpstreamFailed, Hash: hash, Model: req.Model, Prompt: promptText(req), Request: &req, Err: err})
+		return openai.ChatCompletionResponse{}, nil, &UpstreamError{Hash: hash, Model: req.Model, Err: err}
+	}
+	c.normalizeChoices(&resp)
+	c.stats.recordUsage(req.Model, resp.Usage)
+	if providerCache != nil {
+		c.stats.recordProviderCache(req.Model, *providerCache)
+	}
+	return resp, providerCache, nil
+}
+
+// admit checks that req may be sent: that its model can serve it, that the
+// run's budget allows it and that its prompt holds no secret the secret
+// policy blocks.
+func (c *CachingClient) admit(req openai.ChatCompletionRequest) error {
+	if c.validateRequests {
+		if err := checkCapabilities(req); err != nil {
+			return err
+		}
+	}
+	if c.maxCost > 0 {
+		spent := c.stats.EstimatedCost + c.reserved
+		if spent >= c.maxCost {
+			return fmt.Errorf("%w: estimated cost $%.4f reached the limit of $%.4f", ErrBudgetExceeded, spent, c.maxCost)
+		}
+		// Refuse requests whose prompt alone would take the run over budget.
+		if tokens, err := countPromptTokens(req); err == nil {
+			if cost := estimateCost(req.Model, openai.Usage{PromptTokens: tokens}); spent+cost > c.maxCost {
+				return fmt.Errorf("%w: the prompt of %d tokens would cost $%.4f, taking the run over the limit of $%.4f", ErrBudgetExceeded, tokens, cost, c.maxCost)
+			}
+		}
+	}
+	return c.checkSecrets(req)
+}
+
+// unlocked runs fn without the client's lock, which the caller holds, so that
+// a request to the API doesn't hold up the other goroutines using the client.
+func (c *CachingClient) unlocked(fn func()) {
+	c.mu.Unlock()
+	defer c.mu.Lock()
+	fn()
+}
+
+// send sends req to the provider serving its model. It only reads the
+// client's configuration, so recordings can send requests concurrently.
+func (c *CachingClient) send(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, *ProviderCacheUsage, error) {
+	provider, model := c.providerFor(req.Model)
+	sent := req
+	sent.Model = model
+	return provider.createChatCompletion(ctx, sent)
+}
+
 func (c *CachingClient) fetchResponse(ctx context.Context, req openai.ChatCompletionRequest) (string, bool, error) {
-	resp, err := c.CreateChatCompletion(ctx, req)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	resp, _, err := c.fetchCompletion(ctx, req)
 	if err != nil {
 		return "", false, err
 	}
 	return resp.Choices[0].Message.Content, false, nil
 }
 
+// getResponse returns the response to req, from the cache if possible, after
+// applying the client's post-processors. The boolean reports a cache hit.
 func (c *CachingClient) getResponse(ctx context.Context, req openai.ChatCompletionRequest) (string, bool, error) {
-	if !c.cacheEnabled {
-		return c.fetchResponse(ctx, req)
-	}
-
-	cache, err := loadCache()
+	result, err := c.Complete(ctx, req)
 	if err != nil {
 		return "", false, err
 	}
+	return result.Response, result.Cached, nil
+}
+
+// complete answers req, from the cache if possible, without post-processing
+// the response.
+func (c *CachingClient) complete(ctx context.Context, req openai.ChatCompletionRequest) (Result, error) {
+	if c.closed {
+		return Result{}, ErrClientClosed
+	}
+	req = c.redactSecrets(req)
+	start := c.now()
+	label := labelFrom(ctx)
+	mode, explicit := modeFrom(ctx)
+	if mode == Shadow && skipCacheFrom(ctx) {
+		c.stats.Shadowed++
+		return Result{}, fmt.Errorf("%w: the request skips the cache", ErrShadowed)
+	}
+	if skipCacheFrom(ctx) || (!c.cacheEnabled && !explicit) {
+		c.stats.Misses++
+		resp, _, err := c.fetchCompletion(ctx, req)
+		if err != nil {
+			return Result{}, err
+		}
+		hash, _ := generateHash(req)
+		c.emit(Event{Kind: LiveServed, Hash: hash, Model: req.Model, Label: label, Prompt: promptText(req), Request: &req, Response: resp.Choices[0].Message.Content, Usage: resp.Usage, Latency: c.now().Sub(start)})
+		return c.liveResult(req, resp, hash, start), nil
+	}
 
-	hash, err := generateHash(req)
+	turn, inConversation := turnFrom(ctx)
+	hash, namespace, err := c.cacheKey(ctx, req)
 	if err != nil {
-		return "", false, err
+		return Result{}, err
+	}
+	if mode != Record {
+		// A concurrent miss of the key is being recorded: its response will
+		// be a hit.
+		if err := c.awaitInflight(ctx, hash); err != nil {
+			return Result{}, err
+		}
 	}
 
-	if entry, found := cache.Responses[hash]; found {
-		entry.Timestamp = time.Now()
-		cache.Responses[hash] = entry
-		if err := saveCache(cache); err != nil {
-			return "", false, err
+	var cache *Cache
+	if mode != Record {
+		entry, hot, err := c.hotLookup(hash)
+		if err != nil {
+			return Result{}, err
+		}
+		if !hot {
+			if cache, err = c.store.Load(); err != nil {
+				return Result{}, err
+			}
+			if entry, err = lookup(cache, hash); err == nil {
+				c.hotFill(hash, entry)
+			}
+		}
+		if err == nil && c.expired(entry) {
+			err = fmt.Errorf("%w: %s expired", ErrCacheMiss, hash)
+		}
+		if err == nil && c.verifyKey != nil {
+			if err := verifyEntry(c.verifyKey, hash, entry); err != nil {
+				return Result{}, err
+			}
+		}
+		if err == nil {
+			relabelled := label != "" && label != entry.Label
+			if !c.noTouch {
+				entry.Timestamp = c.now()
+				entry.LastHit = entry.Timestamp
+				entry.Hits++
+			}
+			if label != "" {
+				entry.Label = label
+			}
+			if !c.readOnly && (!c.noTouch || relabelled) {
+				if c.hotEnabled() {
+					c.hot.touch(hash, entry.Timestamp, label)
+					c.hot.put(hash, entry)
+				} else {
+					entry.Access = cache.nextAccess()
+					cache.Responses[hash] = entry
+					if err := c.saveCache(cache); err != nil {
+						return Result{}, err
+					}
+				}
+			}
+			c.stats.Hits++
+			if hot {
+				c.stats.MemoryHits++
+			}
+			usage := c.recordSaving(req, entry.Response)
+			if recording := streamRecordingFrom(ctx); recording != nil {
+				recording.chunks = entry.Chunks
+			}
+			c.captureRequest(hash, namespace, label, req, true)
+			c.emit(Event{Kind: EntryServed, Hash: hash, Model: req.Model, Namespace: namespace, Label: label, Prompt: promptText(req), Request: &req, Response: entry.Response, Latency: c.now().Sub(start)})
+			return Result{Response: entry.Response, Completion: recordedCompletion(req, entry), Cached: true, Source: SourceCache, Hash: hash, Usage: usage, Latency: c.now().Sub(start)}, nil
+		}
+		if cache == nil {
+			// The entry was kept in memory but can't be served.
+			loaded, loadErr := c.store.Load()
+			if loadErr != nil {
+				return Result{}, loadErr
+			}
+			cache = loaded
+		}
+		if errors.Is(err, ErrCacheMiss) && !inConversation {
+			related, entry, response, relaxed, err := c.relatedRecording(cache, namespace, req)
+			if err != nil {
+				return Result{}, err
+			}
+			if related != "" {
+				if c.verifyKey != nil {
+					if err := verifyEntry(c.verifyKey, related, entry); err != nil {
+						return Result{}, err
+					}
+				}
+				c.stats.Hits++
+				c.stats.RelaxedHits++
+				usage := c.recordSaving(req, response)
+				c.captureRequest(hash, namespace, label, req, true)
+				c.emit(Event{Kind: EntryServed, Hash: related, Model: req.Model, Namespace: namespace, Label: label, Prompt: promptText(req), Request: &req, Response: response, Latency: c.now().Sub(start), Relaxed: relaxed})
+				completion := recordedCompletion(req, entry)
+				completion.Choices[0].Message.Content = response
+				return Result{Response: response, Completion: completion, Cached: true, Source: SourceFallback, Hash: related, Usage: usage, Latency: c.now().Sub(start), Relaxed: relaxed}, nil
+			}
+		}
+		if errors.Is(err, ErrCacheMiss) {
+			c.logNearest(cache, namespace, hash, req)
+			c.captureRequest(hash, namespace, label, req, false)
+		}
+		if errors.Is(err, ErrCacheMiss) && mode == Shadow {
+			c.stats.Shadowed++
+			return Result{}, fmt.Errorf("%w: %s", ErrShadowed, hash)
+		}
+		if d := c.divergence(cache, namespace, req); errors.Is(err, ErrCacheMiss) && mode == Replay && d != nil {
+			return Result{}, fmt.Errorf("%w; request %s", err, d)
+		}
+		if !errors.Is(err, ErrCacheMiss) || mode == Replay {
+			return Result{}, err
 		}
-		return entry.Response, true, nil
 	}
 
-	response, _, err := c.fetchResponse(ctx, req)
+	if c.readOnly {
+		return Result{}, fmt.Errorf("%w: not recording %s", ErrReadOnly, hash)
+	}
+	if mode == Record {
+		// Recording skips the lookup, so the request hasn't been captured yet.
+		c.captureRequest(hash, namespace, label, req, false)
+	}
+	if err := c.lint(hash, req); err != nil {
+		return Result{}, err
+	}
+	c.stats.Misses++
+	done := c.startInflight(hash)
+	defer done()
+	resp, providerCache, err := c.fetchCompletion(ctx, req)
 	if err != nil {
-		return "", false, err
+		return Result{}, err
+	}
+	// Other goroutines may have saved the cache while the request was sent.
+	if cache, err = c.store.Load(); err != nil {
+		return Result{}, err
+	}
+	response := resp.Choices[0].Message.Content
+	stored, ok := c.fitEntry(response)
+	if !ok {
+		c.emit(Event{Kind: LiveServed, Hash: hash, Model: req.Model, Namespace: namespace, Label: label, Prompt: promptText(req), Request: &req, Response: response, Usage: resp.Usage, Latency: c.now().Sub(start)})
+		return c.liveResult(req, resp, hash, start), nil
 	}
+	// Hits served from memory happened before this recording.
+	c.applyTouches(cache)
 
-	cache.Responses[hash] = CacheEntry{
-		Response:  response,
-		Timestamp: time.Now(),
+	divergence := c.divergence(cache, namespace, req)
+	entry, err := c.newEntry(cache, namespace, req, stored, resp.Model)
+	if err != nil {
+		return Result{}, err
+	}
+	entry.Label = label
+	entry.Parent = turn.parent
+	entry.Diverged = divergence
+	entry.ProviderCache = providerCache
+	if recording := streamRecordingFrom(ctx); recording != nil {
+		entry.Chunks = recording.chunks
 	}
+	if entry, err = c.signed(hash, entry); err != nil {
+		return Result{}, err
+	}
+	cache.Responses[hash] = entry
 
 	if err := c.evictIfNeeded(cache); err != nil {
-		return "", false, err
+		return Result{}, err
 	}
 
-	if err := saveCache(cache); err != nil {
-		return "", false, err
+	if err := c.saveCache(cache); err != nil {
+		return Result{}, err
 	}
+	c.hotFill(hash, entry)
+	c.emit(Event{Kind: EntryStored, Hash: hash, Model: req.Model, Namespace: namespace, Label: label, Prompt: promptText(req), Request: &req, Response: response, Usage: resp.Usage, Latency: c.now().Sub(start), Divergence: divergence})
 
-	return response, false, nil
+	return c.liveResult(req, resp, hash, start), nil
 }
 
-func (c *CachingClient) evictIfNeeded(cache *Cache) error {
-	cacheSize := int64(0)
-	for _, entry := range cache.Responses {
-		cacheSize += int64(len(entry.Response))
+// newEntry returns the entry recording response, fetched from model
+// snapshot, as the answer to req in namespace: with its prompt hash and the
+// provenance of the recording, used now. The caller sets what else it knows
+// about the recording, then signs the entry with signed.
+func (c *CachingClient) newEntry(cache *Cache, namespace string, req openai.ChatCompletionRequest, response, snapshot string) (CacheEntry, error) {
+	prompt, err := promptHash(req)
+	if err != nil {
+		return CacheEntry{}, err
 	}
+	provenance := c.recordingProvenance()
+	provenance.ModelSnapshot = snapshot
+	now := c.now()
+	return CacheEntry{
+		Response:   response,
+		Timestamp:  now,
+		Access:     cache.nextAccess(),
+		Recorded:   now,
+		Request:    &req,
+		PromptHash: prompt,
+		Namespace:  namespace,
+		Provenance: &provenance,
+	}, nil
+}
 
-	if cacheSize <= c.cacheSizeLimit {
-		return nil
+// signed returns entry, to be stored under hash, signed if the client signs
+// the entries it records.
+func (c *CachingClient) signed(hash string, entry CacheEntry) (CacheEntry, error) {
+	if c.signingKey == nil {
+		return entry, nil
 	}
+	return signEntry(c.signingKey, hash, entry)
+}
 
-	// Sort entries by timestamp
-	entries := make([]struct {
-		Hash      string
-		Timestamp time.Time
-	}, 0, len(cache.Responses))
-	for hash, entry := range cache.Responses {
-		entries = append(entries, struct {
-			Hash      string
-			Timestamp time.Time
-		}{hash, entry.Timestamp})
+// cacheKey returns the key req is cached under in ctx, and the namespace it
+// is recorded in: the namespace of ctx or else the client's, and, within a
+// conversation, the turn it continues. req must have had its secrets
+// redacted.
+func (c *CachingClient) cacheKey(ctx context.Context, req openai.ChatCompletionRequest) (string, string, error) {
+	namespace := namespaceFrom(ctx)
+	if namespace == "" {
+		namespace = c.namespace
 	}
-	sort.Slice(entries, func(i, j int) bool {
-		return entries[i].Timestamp.Before(entries[j].Timestamp)
-	})
-
-	// Evict least recently used entries
-	for cacheSize > c.cacheSizeLimit && len(entries) > 0 {
-		oldest := entries[0]
-		cacheSize -= int64(len(cache.Responses[oldest.Hash].Response))
-		delete(cache.Responses, oldest.Hash)
-		entries = entries[1:]
+	keyed := keyedRequest(req)
+	if turn, ok := turnFrom(ctx); ok {
+		hash, err := conversationKey(namespace, turn.parent, keyed, turn.fresh)
+		return hash, namespace, err
 	}
+	hash, err := generateKey(namespace, keyed)
+	return hash, namespace, err
+}
 
-	return nil
+// awaitInflight waits, without the client's lock, until no request recording
+// hash is in flight.
+func (c *CachingClient) awaitInflight(ctx context.Context, hash string) error {
+	for {
+		wait, found := c.inflight[hash]
+		if !found {
+			return nil
+		}
+		var err error
+		c.unlocked(func() {
+			select {
+			case <-wait:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
 }
 
-func main() {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		fmt.Println("Error: OPENAI_API_KEY environment variable not set.")
-		os.Exit(1)
+// startInflight marks a request recording hash as in flight, until the
+// returned function is called.
+func (c *CachingClient) startInflight(hash string) func() {
+	if c.inflight == nil {
+		c.inflight = make(map[string]chan struct{})
+	}
+	wait := make(chan struct{})
+	c.inflight[hash] = wait
+	return func() {
+		if c.inflight[hash] == wait {
+			delete(c.inflight, hash)
+		}
+		close(wait)
 	}
+}
 
-	cacheEnabled := flag.Bool("cache-requests", false, "Enable caching of requests")
-	cacheSizeLimit := flag.Int64("cache-size-limit", defaultCacheSizeLimit, "Cache size limit in bytes")
-	flag.Parse()
+// lookup returns the entry recorded under hash, or ErrCacheMiss.
+func lookup(cache *Cache, hash string) (CacheEntry, error) {
+	entry, found := cache.Responses[hash]
+	if !found {
+		return CacheEntry{}, fmt.Errorf("%w: %s", ErrCacheMiss, hash)
+	}
+	return entry, nil
+}
 
-	client := NewCachingClient(apiKey, *cacheEnabled, *cacheSizeLimit)
-	ctx := context.Background()
+// evictIfNeeded evicts entries until the cache fits its size limit. A limit
+// of zero or less means the cache is never evicted.
+func (c *CachingClient) evictIfNeeded(cache *Cache) error {
+	for _, candidate := range c.evictions(cache) {
+		delete(cache.Responses, candidate.Hash)
+		c.stats.Evictions++
+		c.emit(Event{Kind: EntryEvicted, Hash: candidate.Hash, Model: entryModel(candidate.Entry), Namespace: candidate.Entry.Namespace, Label: candidate.Entry.Label})
+	}
+	return nil
+}
 
-	models := []string{"gpt-3.5-turbo-1106", "gpt-3.5-turbo-0125"}
-	prompts := []string{
-		"Tell me a joke.",
-		"Explain the theory of relativity.",
-		"What's the capital of France?",
-		"How does a computer work?",
-		"What's the meaning of life?",
+// Main runs the llm-test-cache command line on os.Args, exiting with a
+// non-zero status if the command fails.
+func Main() {
+	args, err := parseOutputFlags(os.Args[1:])
+	if err != nil {
+		exitWithError(err)
+	}
+	if ran, err := runCommand(args); ran {
+		if err != nil {
+			exitWithError(err)
+		}
+		return
 	}
 
-	seed := 12345
-	maxTokens := 100 // Example max tokens value
+	fs := flag.NewFlagSet("llm-test-cache", flag.ContinueOnError)
+	flags := addClientFlags(fs, false)
+	suitePath := fs.String("suite", "", "Run the prompts and models declared in this suite file instead of the built-in examples")
+	filter := fs.String("filter", "", filterUsage)
+	if err := fs.Parse(args); err != nil {
+		exitWithError(err)
+	}
 
-	for _, model := range models {
-		fmt.Printf("Testing model: %s\n", model)
-		for _, prompt := range prompts {
-			req := openai.ChatCompletionRequest{
-				Model: model,
-				Messages: []openai.ChatCompletionMessage{
-					{Role: "user", Content: prompt},
-				},
-				Seed:      &seed,
-				MaxTokens: maxTokens,
-			}
-			response, cached, err := client.getResponse(ctx, req)
-			if err != nil {
-				fmt.Printf("Error fetching response for prompt '%s': %v\n", prompt, err)
-				continue
-			}
-			if cached {
-				fmt.Printf("Cached response for prompt '%s': %s\n", prompt, response)
-			} else {
-				fmt.Printf("API response for prompt '%s': %s\n", prompt, response)
-			}
+	suite := defaultSuite()
+	if *suitePath != "" {
+		if suite, err = loadSuite(*suitePath); err != nil {
+			exitWithError(err)
 		}
 	}
+	if suite.Filter, err = parseRunFilter(*filter); err != nil {
+		exitWithError(err)
+	}
+	if err := runSuiteWithFlags(interruptContext(), flags, suite); err != nil {
+		exitWithError(err)
+	}
 }
@@ -0,0 +1,177 @@
+package llmcache
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// MatchRule relaxes how one request parameter is compared when looking for a
+// recording to reuse after a cache miss, so that near-identical requests
+// don't each need recording.
+type MatchRule struct {
+	// Param is the JSON name of the parameter, e.g. max_tokens.
+	Param string `json:"param"`
+	// Ignore makes any two values of the parameter match.
+	Ignore bool `json:"ignore,omitempty"`
+	// Tolerance makes numbers within this fraction of the recorded value
+	// match, e.g. 0.1 for 10%.
+	Tolerance float64 `json:"tolerance,omitempty"`
+	// Equal lists values treated as the same; null stands for the parameter
+	// being unset.
+	Equal []any `json:"equal,omitempty"`
+}
+
+// loadMatchRules reads a JSON array of match rules from path.
+func loadMatchRules(path string) ([]MatchRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []MatchRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("match rules %s: %w", path, err)
+	}
+	for i, rule := range rules {
+		switch {
+		case rule.Param == "":
+			return nil, fmt.Errorf("match rules %s: rule %d names no param", path, i)
+		case rule.Param == "messages":
+			return nil, fmt.Errorf("match rules %s: messages can't be relaxed", path)
+		case !rule.Ignore && rule.Tolerance <= 0 && len(rule.Equal) == 0:
+			return nil, fmt.Errorf("match rules %s: rule for %s needs ignore, tolerance or equal", path, rule.Param)
+		}
+	}
+	return rules, nil
+}
+
+// SetMatchRules makes the client serve a request that misses the cache from
+// a recording of a request matching it under rules. Such relaxed matches are
+// reported in the EntryServed event and counted in the run statistics.
+func (c *CachingClient) SetMatchRules(rules []MatchRule) {
+	c.matchRules = rules
+}
+
+// requestParams returns the parameters of req, as it is keyed, as JSON
+// values.
+func requestParams(req openai.ChatCompletionRequest) (map[string]any, error) {
+	data, err := json.Marshal(keyedRequest(req))
+	if err != nil {
+		return nil, err
+	}
+	var params map[string]any
+	return params, json.Unmarshal(data, &params)
+}
+
+func formatParam(v any) string {
+	if v == nil {
+		return "unset"
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
+
+// apply reports whether recorded and got, values of the rule's parameter,
+// match under the rule.
+func (r MatchRule) apply(recorded, got any) bool {
+	if r.Ignore {
+		return true
+	}
+	if a, ok := recorded.(float64); ok && r.Tolerance > 0 {
+		if b, ok := got.(float64); ok && math.Abs(a-b) <= r.Tolerance*math.Abs(a) {
+			return true
+		}
+	}
+	in := func(v any) bool {
+		for _, e := range r.Equal {
+			if reflect.DeepEqual(e, v) {
+				return true
+			}
+		}
+		return false
+	}
+	return in(recorded) && in(got)
+}
+
+// differingParams returns the names of the parameters whose values differ
+// between a and b, in order.
+func differingParams(a, b map[string]any) []string {
+	var names []string
+	for name := range a {
+		if !reflect.DeepEqual(a[name], b[name]) {
+			names = append(names, name)
+		}
+	}
+	for name := range b {
+		if _, ok := a[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// relaxedMatch reports whether recorded matches req under rules, and if so
+// how they differ.
+func relaxedMatch(rules []MatchRule, recorded, req openai.ChatCompletionRequest) (string, bool) {
+	a, errA := requestParams(recorded)
+	b, errB := requestParams(req)
+	if errA != nil || errB != nil {
+		return "", false
+	}
+	var differences []string
+	for _, name := range differingParams(a, b) {
+		matched := false
+		for _, rule := range rules {
+			if rule.Param == name && rule.apply(a[name], b[name]) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return "", false
+		}
+		differences = append(differences, fmt.Sprintf("%s %s recorded as %s", name, formatParam(b[name]), formatParam(a[name])))
+	}
+	return strings.Join(differences, ", "), len(differences) > 0
+}
+
+// relatedRecording finds a recording to serve req from after it missed the
+// cache: one of a request matching it under the client's match rules or,
+// with truncation replay, one allowing more tokens. It returns the key of the
+// recording, the response to serve and how the recording's request differs
+// from req, or an empty key if there is none. Ties go to the lowest key.
+func (c *CachingClient) relatedRecording(cache *Cache, namespace string, req openai.ChatCompletionRequest) (string, CacheEntry, string, string, error) {
+	if len(c.matchRules) > 0 {
+		hashes := make([]string, 0, len(cache.Responses))
+		for hash := range cache.Responses {
+			hashes = append(hashes, hash)
+		}
+		sort.Strings(hashes)
+		for _, hash := range hashes {
+			entry := cache.Responses[hash]
+			if entry.Request == nil || entry.Namespace != namespace || c.expired(entry) {
+				continue
+			}
+			if how, ok := relaxedMatch(c.matchRules, *entry.Request, req); ok {
+				return hash, entry, entry.Response, how, nil
+			}
+		}
+	}
+	if c.truncationReplay {
+		if hash, entry, ok := findLongerRecording(cache, namespace, req); ok && !c.expired(entry) {
+			response, err := truncateToTokens(req.Model, entry.Response, req.MaxTokens)
+			if err != nil {
+				return "", CacheEntry{}, "", "", err
+			}
+			return hash, entry, response, fmt.Sprintf("truncated to max_tokens %d", req.MaxTokens), nil
+		}
+	}
+	return "", CacheEntry{}, "", "", nil
+}
@@ -0,0 +1,64 @@
+package llmcache
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchRules(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`[
+		{"param": "max_tokens", "tolerance": 0.1},
+		{"param": "top_p", "equal": [null, 1]}
+	]`), 0644))
+	rules, err := loadMatchRules(path)
+	assert.NoError(t, err)
+
+	client, calls := newEchoClient(t)
+	client.SetMatchRules(rules)
+	var relaxed []string
+	client.OnEvent(func(e Event) {
+		if e.Relaxed != "" {
+			relaxed = append(relaxed, e.Relaxed)
+		}
+	})
+	seed := 1
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Seed: &seed, MaxTokens: 100, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+	_, _, err = client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+
+	req.MaxTokens, req.TopP = 95, 1
+	response, cached, err := client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	assert.Equal(t, "reply to 1 messages", response)
+	assert.Equal(t, []string{"max_tokens 95 recorded as 100, top_p 1 recorded as unset"}, relaxed)
+	assert.Equal(t, 1, client.Stats().RelaxedHits)
+
+	// Outside the tolerance, or differing in an unrelaxed parameter, the
+	// request is recorded.
+	req.MaxTokens = 80
+	_, cached, err = client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	req.MaxTokens, req.Temperature = 100, 0.5
+	_, cached, err = client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, 3, *calls)
+}
+
+func TestLoadMatchRulesValidates(t *testing.T) {
+	dir := t.TempDir()
+	for _, rules := range []string{`[{"tolerance": 0.1}]`, `[{"param": "messages", "ignore": true}]`, `[{"param": "top_p"}]`, `{}`} {
+		path := filepath.Join(dir, "rules.json")
+		assert.NoError(t, os.WriteFile(path, []byte(rules), 0644))
+		_, err := loadMatchRules(path)
+		assert.Error(t, err, rules)
+	}
+}
@@ -0,0 +1,105 @@
+package llmcache
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// minNearSimilarity is how similar the messages of a recording must be to a
+// request's for it to be suggested as the one the request was meant to hit.
+const minNearSimilarity = 0.5
+
+// NearMiss is a recording similar to a request that missed the cache.
+type NearMiss struct {
+	Hash string `json:"key"`
+	// Similarity is the ROUGE-L similarity of the recorded messages to the
+	// request's.
+	Similarity float64 `json:"similarity"`
+	// Differences say how the recorded request differs, e.g. "temperature
+	// 0.7 recorded as unset".
+	Differences []string `json:"differences"`
+}
+
+func (m NearMiss) String() string {
+	return fmt.Sprintf("%s (messages %.0f%% similar): %s", abbreviate(m.Hash), m.Similarity*100, strings.Join(m.Differences, ", "))
+}
+
+func messagesText(req openai.ChatCompletionRequest) string {
+	contents := make([]string, len(req.Messages))
+	for i, m := range req.Messages {
+		contents[i] = m.Content
+	}
+	return strings.Join(contents, "\n")
+}
+
+// nearestRecordings returns up to n recordings most like req, in namespace,
+// with how each differs from it: the most similar messages first, then the
+// fewest differences, so that a stray parameter change stands out as the one
+// difference of an otherwise identical recording.
+func nearestRecordings(cache *Cache, namespace string, req openai.ChatCompletionRequest, n int) []NearMiss {
+	want, err := requestParams(req)
+	if err != nil {
+		return nil
+	}
+	text := messagesText(req)
+	var near []NearMiss
+	for hash, entry := range cache.Responses {
+		if entry.Request == nil {
+			continue
+		}
+		got, err := requestParams(*entry.Request)
+		if err != nil {
+			continue
+		}
+		var differences []string
+		if entry.Namespace != namespace {
+			differences = append(differences, fmt.Sprintf("namespace %q recorded as %q", namespace, entry.Namespace))
+		}
+		similarity := 1.0
+		for _, name := range differingParams(got, want) {
+			if name == "messages" {
+				similarity = RougeL(text, messagesText(*entry.Request))
+				differences = append(differences, "messages differ")
+				continue
+			}
+			differences = append(differences, fmt.Sprintf("%s %s recorded as %s", name, formatParam(want[name]), formatParam(got[name])))
+		}
+		if similarity < minNearSimilarity || len(differences) == 0 {
+			continue
+		}
+		near = append(near, NearMiss{Hash: hash, Similarity: similarity, Differences: differences})
+	}
+	sort.Slice(near, func(i, j int) bool {
+		a, b := near[i], near[j]
+		if a.Similarity != b.Similarity {
+			return a.Similarity > b.Similarity
+		}
+		if len(a.Differences) != len(b.Differences) {
+			return len(a.Differences) < len(b.Differences)
+		}
+		return a.Hash < b.Hash
+	})
+	if len(near) > n {
+		near = near[:n]
+	}
+	return near
+}
+
+// SetNearestKeys makes the client print, for every request that misses the
+// cache, the n recordings most like it and how they differ from it. Zero
+// turns this off.
+func (c *CachingClient) SetNearestKeys(n int) {
+	c.nearestKeys = n
+}
+
+func (c *CachingClient) logNearest(cache *Cache, namespace, hash string, req openai.ChatCompletionRequest) {
+	if c.nearestKeys <= 0 {
+		return
+	}
+	for _, m := range nearestRecordings(cache, namespace, req, c.nearestKeys) {
+		fmt.Fprintf(console, "Miss %s: nearest recording %s\n", abbreviate(hash), m)
+	}
+}
@@ -0,0 +1,58 @@
+package llmcache
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNearestRecordings(t *testing.T) {
+	seed := 1
+	recorded := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Seed: &seed, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Summarise the quarterly sales report"}}}
+	other := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Seed: &seed, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Write a haiku about autumn"}}}
+	cache := &Cache{Responses: map[string]CacheEntry{
+		"recorded": {Response: "a", Request: &recorded},
+		"other":    {Response: "b", Request: &other},
+	}}
+
+	req := recorded
+	req.Temperature = 0.7
+	near := nearestRecordings(cache, "", req, 3)
+	if assert.Len(t, near, 1) {
+		assert.Equal(t, "recorded", near[0].Hash)
+		assert.Equal(t, 1.0, near[0].Similarity)
+		assert.Equal(t, []string{"temperature 0.7 recorded as unset"}, near[0].Differences)
+	}
+
+	req.Messages = []openai.ChatCompletionMessage{{Role: "user", Content: "Summarise the quarterly sales figures"}}
+	near = nearestRecordings(cache, "nightly", req, 3)
+	if assert.Len(t, near, 1) {
+		assert.Less(t, near[0].Similarity, 1.0)
+		assert.Equal(t, []string{`namespace "nightly" recorded as ""`, "messages differ", "temperature 0.7 recorded as unset"}, near[0].Differences)
+	}
+
+	assert.Empty(t, nearestRecordings(cache, "", recorded, 3), "exact matches aren't near misses")
+}
+
+func TestMissLogsNearestKeys(t *testing.T) {
+	client, _ := newEchoClient(t)
+	seed := 1
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Seed: &seed, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+	_, _, err := client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	console = &out
+	t.Cleanup(func() { console = os.Stdout })
+	client.SetNearestKeys(1)
+	req.TopP = 0.9
+	_, cached, err := client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Contains(t, out.String(), "nearest recording")
+	assert.Contains(t, out.String(), "top_p 0.9 recorded as unset")
+}
@@ -0,0 +1,56 @@
+package llmcache
+
+import (
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Normalization says how the client normalizes live responses before caching
+// and returning them, so that a response compares byte for byte with a golden
+// file whatever the platform, or the model, wrote it with.
+type Normalization struct {
+	// Unicode composes responses to NFC, so that an "é" is one code point
+	// however the model spelt it.
+	Unicode bool
+	// LineEndings converts CRLF and lone CR line endings to LF.
+	LineEndings bool
+	// StripBOM removes byte order marks (U+FEFF), which some models emit at
+	// the start of a response and which are invisible in a diff.
+	StripBOM bool
+}
+
+// DefaultNormalization is the normalization of a new client: NFC and LF line
+// endings, keeping byte order marks.
+var DefaultNormalization = Normalization{Unicode: true, LineEndings: true}
+
+// Apply returns response normalized as n says.
+func (n Normalization) Apply(response string) string {
+	if n.StripBOM {
+		response = strings.ReplaceAll(response, "\uFEFF", "")
+	}
+	if n.LineEndings && strings.Contains(response, "\r") {
+		response = strings.ReplaceAll(response, "\r\n", "\n")
+		response = strings.ReplaceAll(response, "\r", "\n")
+	}
+	if n.Unicode {
+		response = norm.NFC.String(response)
+	}
+	return response
+}
+
+// SetNormalization sets how live responses are normalized before they are
+// cached and returned. Responses already cached are served as recorded;
+// verify-portable -fix normalizes them.
+func (c *CachingClient) SetNormalization(n Normalization) {
+	c.normalization = n
+}
+
+// normalizeChoices normalizes the content of every choice of resp.
+func (c *CachingClient) normalizeChoices(resp *openai.ChatCompletionResponse) {
+	for i := range resp.Choices {
+		resp.Choices[i].Message.Content = c.normalization.Apply(resp.Choices[i].Message.Content)
+	}
+}
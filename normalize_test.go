@@ -0,0 +1,58 @@
+package llmcache
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizationApply(t *testing.T) {
+	decomposed := "cafe\u0301\r\nline two\rline three"
+	assert.Equal(t, "café\nline two\nline three", DefaultNormalization.Apply(decomposed))
+	assert.Equal(t, decomposed, Normalization{}.Apply(decomposed))
+	assert.Equal(t, "\uFEFFhello", DefaultNormalization.Apply("\uFEFFhello"))
+	assert.Equal(t, "hello", Normalization{StripBOM: true}.Apply("\uFEFFhello"))
+}
+
+func TestLiveResponsesAreNormalized(t *testing.T) {
+	fake := newFakeOpenAI(t)
+	fake.reply = func(openai.ChatCompletionRequest) string { return "\uFEFFcafe\u0301\r\n" }
+	req := openai.ChatCompletionRequest{Model: openai.GPT3Dot5Turbo, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+
+	client := fake.newClient(t)
+	live, _, err := client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "\uFEFFcafé\n", live)
+	cached, hit, err := client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.True(t, hit)
+	assert.Equal(t, live, cached, "a hit should return what the miss did")
+
+	client = fake.newClient(t)
+	client.SetNormalization(Normalization{StripBOM: true})
+	verbatim, _, err := client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "cafe\u0301\r\n", verbatim)
+}
+
+func TestVerifyPortableNormalizesResponses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	cache := &Cache{Responses: map[string]CacheEntry{
+		"plain":  {Response: "ok"},
+		"crlf":   {Response: "one\r\ntwo"},
+		"signed": {Response: "cafe\u0301", Signature: "c2lnbmVk"},
+	}}
+	assert.NoError(t, saveCacheTo(path, cache))
+	check := checkResponseNormalization(cache)
+	assert.ErrorContains(t, check.Err, "1 signed entries aren't normalized: signed")
+
+	captureOutput(t, "verify-portable", "-quiet")
+	assert.ErrorContains(t, runVerifyPortable([]string{"-fix", path}), "1 of 2 checks failed")
+	fixed, err := loadCacheFrom(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "one\ntwo", fixed.Responses["crlf"].Response)
+	assert.Equal(t, "cafe\u0301", fixed.Responses["signed"].Response, "signed entries are left alone")
+}
@@ -0,0 +1,132 @@
+package llmcache
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Output formats of the commands: text for people, or a JSON document for
+// scripts.
+const (
+	outputText = "text"
+	outputJSON = "json"
+)
+
+var (
+	// console is where commands and the client print their human-readable
+	// output. -quiet and -output=json silence it.
+	console io.Writer = os.Stdout
+	// resultOut is where a command's JSON result is written.
+	resultOut io.Writer = os.Stdout
+	// outputFormat is set by -output.
+	outputFormat = outputText
+	// reported records whether the command has written its result.
+	reported bool
+)
+
+// Exit codes, so that CI can tell outcomes apart in the shell, e.g. to fail a
+// main branch build that made live calls.
+const (
+	exitFailed         = 1
+	exitLiveCalls      = 2
+	exitBudgetExceeded = 3
+	exitCacheCorrupt   = 4
+)
+
+// errLiveCalls is returned by a run that succeeded but sent requests to the
+// API, i.e. didn't run from the cache alone.
+var errLiveCalls = errors.New("requests were sent to the API")
+
+// exitCode returns the exit code for a command that failed with err. Bad
+// flags and arguments fail with exitFailed, so that CI doesn't mistake them
+// for live calls.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, ErrCacheCorrupt):
+		return exitCacheCorrupt
+	case errors.Is(err, ErrBudgetExceeded):
+		return exitBudgetExceeded
+	case errors.Is(err, errLiveCalls):
+		return exitLiveCalls
+	}
+	return exitFailed
+}
+
+// parseOutputFlags removes the -quiet and -output flags, which every command
+// accepts, from args and applies them. They may be spelled with one dash or
+// two and appear anywhere before a "--".
+func parseOutputFlags(args []string) ([]string, error) {
+	quiet := false
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-"), "=")
+		switch {
+		case !strings.HasPrefix(arg, "-"):
+			rest = append(rest, arg)
+		case name == "quiet" || name == "q":
+			quiet = !hasValue || value == "true"
+		case name == "output":
+			if !hasValue {
+				if i+1 == len(args) {
+					return nil, fmt.Errorf("%s needs a value: text or json", arg)
+				}
+				i++
+				value = args[i]
+			}
+			if value != outputText && value != outputJSON {
+				return nil, fmt.Errorf("unknown output %q: use text or json", value)
+			}
+			outputFormat = value
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	if quiet || outputFormat == outputJSON {
+		console = io.Discard
+	}
+	return rest, nil
+}
+
+// report writes v, the result of a command, as JSON when -output=json is set.
+func report(v any) error {
+	if outputFormat != outputJSON {
+		return nil
+	}
+	reported = true
+	enc := json.NewEncoder(resultOut)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// exitWithError reports err and exits with its exit code. Under -output=json
+// a command that hasn't written its result yet writes the error as one;
+// otherwise errors go to stderr when output is silenced. Live calls aren't an
+// error, and are only noted. -h has printed the usage already and exits 0.
+func exitWithError(err error) {
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	prefix := "Error: "
+	if exitCode(err) == exitLiveCalls {
+		prefix = "Note: "
+	}
+	switch {
+	case outputFormat == outputJSON && !reported:
+		report(map[string]string{"error": err.Error()})
+	case console == io.Discard:
+		fmt.Fprintf(os.Stderr, "%s%v\n", prefix, err)
+	default:
+		fmt.Printf("%s%v\n", prefix, err)
+	}
+	os.Exit(exitCode(err))
+}
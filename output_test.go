@@ -0,0 +1,108 @@
+package llmcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// captureOutput parses the output flags in args and collects the JSON results
+// commands report, restoring the default output when the test ends.
+func captureOutput(t *testing.T, args ...string) ([]string, *bytes.Buffer) {
+	t.Helper()
+	var out bytes.Buffer
+	t.Cleanup(func() {
+		console, resultOut, outputFormat, reported = os.Stdout, os.Stdout, outputText, false
+	})
+	rest, err := parseOutputFlags(args)
+	assert.NoError(t, err)
+	resultOut = &out
+	return rest, &out
+}
+
+func TestParseOutputFlags(t *testing.T) {
+	rest, _ := captureOutput(t, "ls", "--quiet", "-model", "gpt-4o", "cache.json")
+	assert.Equal(t, []string{"ls", "-model", "gpt-4o", "cache.json"}, rest)
+	assert.Equal(t, io.Discard, console)
+	assert.Equal(t, outputText, outputFormat)
+
+	rest, _ = captureOutput(t, "stats", "-output", "json", "--", "-quiet")
+	assert.Equal(t, []string{"stats", "--", "-quiet"}, rest)
+	assert.Equal(t, outputJSON, outputFormat)
+
+	_, err := parseOutputFlags([]string{"--output=yaml"})
+	assert.ErrorContains(t, err, "unknown output")
+	_, err = parseOutputFlags([]string{"-output"})
+	assert.Error(t, err)
+}
+
+func TestReportJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	assert.NoError(t, saveCacheTo(path, &Cache{Responses: map[string]CacheEntry{
+		"abc": {Response: "hello", Hits: 2},
+		"def": {Response: "unused"},
+	}}))
+
+	// Text output reports nothing.
+	_, out := captureOutput(t, "-quiet")
+	assert.NoError(t, runEntryStats([]string{path}))
+	assert.Empty(t, out.String())
+
+	_, out = captureOutput(t, "--output=json")
+	assert.NoError(t, runEntryStats([]string{path}))
+	var entries []entryReport
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &entries))
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, "def", entries[0].Key)
+		assert.Equal(t, 2, entries[1].Hits)
+	}
+	assert.True(t, reported)
+}
+
+func TestSuiteReport(t *testing.T) {
+	results := []CaseResult{
+		{Model: "gpt-4o-mini", Case: "ok", Response: "yes", Cached: true},
+		{Model: "gpt-4o-mini", Case: "bad", Response: "no", Failures: []string{"missing yes"}},
+	}
+	report := newSuiteReport(results, RunStats{Hits: 1, Misses: 1}, nil)
+	assert.Equal(t, 1, report.Failed)
+	assert.True(t, report.Cases[0].Passed)
+	assert.Equal(t, []string{"missing yes"}, report.Cases[1].Failures)
+	assert.Empty(t, report.Error)
+	assert.Equal(t, 1, report.Stats.Misses)
+}
+
+func TestBadFlagsAreFailures(t *testing.T) {
+	for _, args := range [][]string{{"ls", "-no-such-flag"}, {"run-suite", "-no-such-flag", "suite.json"}, {"gc", "-dry-run=maybe"}} {
+		ran, err := runCommand(args)
+		assert.True(t, ran)
+		assert.Error(t, err)
+		assert.Equal(t, exitFailed, exitCode(err), "a typo isn't a live call")
+	}
+	_, err := runCommand([]string{"ls", "-h"})
+	assert.ErrorIs(t, err, flag.ErrHelp)
+}
+
+func TestExitCodes(t *testing.T) {
+	assert.Equal(t, exitFailed, exitCode(errors.New("boom")))
+	assert.Equal(t, exitLiveCalls, exitCode(fmt.Errorf("%w: 2 requests weren't cached", errLiveCalls)))
+	assert.Equal(t, exitCacheCorrupt, exitCode(fmt.Errorf("loading: %w", ErrCacheCorrupt)))
+
+	results := []CaseResult{
+		{Case: "ok"},
+		{Case: "over", Err: &UpstreamError{Err: fmt.Errorf("%w: spent", ErrBudgetExceeded)}},
+		{Case: "bad", Failures: []string{"missing"}},
+	}
+	err := suiteFailure(results, 2)
+	assert.EqualError(t, err, "2 of 3 cases failed: budget exceeded")
+	assert.Equal(t, exitBudgetExceeded, exitCode(err))
+	assert.Equal(t, exitFailed, exitCode(suiteFailure(results[2:], 1)))
+}
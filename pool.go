@@ -0,0 +1,280 @@
+package llmcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// PoolOptions bound how a recording sends requests concurrently.
+type PoolOptions struct {
+	// Workers is how many requests are in flight at once.
+	Workers int
+	// ModelLimits caps the requests in flight per model, e.g. for models
+	// with lower rate limits.
+	ModelLimits map[string]int
+	// RequestsPerMinute spaces out the requests sent across all models, to
+	// stay under an organization's rate limit. 0 means no limit.
+	RequestsPerMinute int
+	// Retries is how many times a request failing with a rate limit or
+	// server error is retried, waiting Backoff, then twice as long, and so
+	// on, between attempts.
+	Retries int
+	Backoff time.Duration
+}
+
+const maxBackoff = time.Minute
+
+type prefetchedKey struct{}
+
+// prefetched is a response fetched outside the cache's lock, to be recorded
+// as if fetchCompletion had fetched it.
+type prefetched struct {
+	resp          openai.ChatCompletionResponse
+	providerCache *ProviderCacheUsage
+	err           error
+}
+
+func withPrefetched(ctx context.Context, p *prefetched) context.Context {
+	return context.WithValue(ctx, prefetchedKey{}, p)
+}
+
+func prefetchedFrom(ctx context.Context) *prefetched {
+	p, _ := ctx.Value(prefetchedKey{}).(*prefetched)
+	return p
+}
+
+// rateLimiter spaces events evenly at a rate per minute.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Minute / time.Duration(perMinute)}
+}
+
+// wait blocks until the next event may happen. A nil limiter never waits.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	slot := l.next
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next = slot.Add(l.interval)
+	l.mu.Unlock()
+	return sleepContext(ctx, slot.Sub(now))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryable reports whether err is a rate limit or a server error, which may
+// succeed when retried.
+func retryable(err error) bool {
+	status := 0
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	switch {
+	case errors.As(err, &apiErr):
+		status = apiErr.HTTPStatusCode
+	case errors.As(err, &reqErr):
+		status = reqErr.HTTPStatusCode
+	}
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+// backoff returns how long to wait before retry attempt n, counting from 0.
+func (o PoolOptions) backoff(n int) time.Duration {
+	d := o.Backoff << n
+	if d <= 0 || d > maxBackoff {
+		return maxBackoff
+	}
+	return d
+}
+
+// parseModelLimit parses a MODEL=N concurrency limit into limits.
+func parseModelLimit(limits map[string]int, s string) error {
+	model, n, found := strings.Cut(s, "=")
+	limit, err := strconv.Atoi(n)
+	if !found || model == "" || err != nil || limit < 1 {
+		return fmt.Errorf("invalid model limit %q: use MODEL=N", s)
+	}
+	limits[model] = limit
+	return nil
+}
+
+// recordConcurrently records requests with up to opts.Workers in flight, and
+// calls done with the outcome of each as it completes. Requests are sent
+// concurrently, while the client's lock keeps the cache to one at a time, and
+// done is called by one worker at a time. A request refused by the budget stops the
+// recording, and its error is returned once the requests in flight are done.
+func (c *CachingClient) recordConcurrently(ctx context.Context, requests []capturedRequest, opts PoolOptions, done func(captured capturedRequest, cached bool, err error)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	limits := make(map[string]chan struct{})
+	for model, n := range opts.ModelLimits {
+		limits[model] = make(chan struct{}, n)
+	}
+	limiter := newRateLimiter(opts.RequestsPerMinute)
+
+	var mu sync.Mutex
+	var stopped error
+	jobs := make(chan capturedRequest)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for captured := range jobs {
+				cached, err := c.recordOne(ctx, captured, limits[captured.Model], limiter, opts)
+				mu.Lock()
+				if errors.Is(err, ErrBudgetExceeded) && stopped == nil {
+					stopped = err
+					cancel()
+				}
+				// Requests cut short by a stop aren't outcomes.
+				if stopped == nil || !errors.Is(err, context.Canceled) {
+					done(captured, cached, err)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+feed:
+	for _, captured := range requests {
+		select {
+		case jobs <- captured:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	if stopped != nil {
+		return stopped
+	}
+	return ctx.Err()
+}
+
+// recordOne sends captured within the limits of opts, retrying rate limits and
+// server errors, and records the response. A request already cached isn't
+// sent. It reports whether the request turned out to be cached already.
+func (c *CachingClient) recordOne(ctx context.Context, captured capturedRequest, slots chan struct{}, limiter *rateLimiter, opts PoolOptions) (bool, error) {
+	reqCtx := WithNamespace(ctx, captured.Namespace)
+	if captured.Label != "" {
+		reqCtx = WithLabel(reqCtx, captured.Label)
+	}
+	c.mu.Lock()
+	req := c.redactSecrets(captured.Request)
+	hit, err := c.cachedAlready(reqCtx, req)
+	var reserved float64
+	if err == nil && !hit {
+		// The cost of the request is reserved until its response is recorded,
+		// so that requests sent meanwhile can't together overspend the budget.
+		if err = c.admit(req); err == nil {
+			reserved = worstCaseCost(req)
+			c.reserved += reserved
+		}
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+	if hit {
+		_, cached, err := c.getResponse(reqCtx, captured.Request)
+		return cached, err
+	}
+	defer func() {
+		c.mu.Lock()
+		c.reserved -= reserved
+		c.mu.Unlock()
+	}()
+	if slots != nil {
+		select {
+		case slots <- struct{}{}:
+			defer func() { <-slots }()
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+	p := &prefetched{}
+	for attempt := 0; ; attempt++ {
+		if err := limiter.wait(ctx); err != nil {
+			return false, err
+		}
+		p.resp, p.providerCache, p.err = c.send(reqCtx, req)
+		if p.err == nil || attempt >= opts.Retries || !retryable(p.err) {
+			break
+		}
+		if err := sleepContext(ctx, opts.backoff(attempt)); err != nil {
+			return false, err
+		}
+	}
+	_, cached, err := c.getResponse(withPrefetched(reqCtx, p), captured.Request)
+	return cached, err
+}
+
+// cachedAlready reports whether req, redacted, has an unexpired entry in ctx.
+// The caller holds the client's lock.
+func (c *CachingClient) cachedAlready(ctx context.Context, req openai.ChatCompletionRequest) (bool, error) {
+	if mode, _ := modeFrom(ctx); mode == Record || !c.cacheEnabled || skipCacheFrom(ctx) {
+		return false, nil
+	}
+	hash, _, err := c.cacheKey(ctx, req)
+	if err != nil {
+		return false, err
+	}
+	entry, found, err := c.hotLookup(hash)
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		cache, err := c.store.Load()
+		if err != nil {
+			return false, err
+		}
+		entry, found = cache.Responses[hash]
+	}
+	return found && !c.expired(entry), nil
+}
+
+// worstCaseCost estimates what req costs at most: its prompt, counted locally,
+// and a response using all of max_tokens.
+func worstCaseCost(req openai.ChatCompletionRequest) float64 {
+	tokens, err := countPromptTokens(req)
+	if err != nil {
+		return 0
+	}
+	return estimateCost(req.Model, openai.Usage{PromptTokens: tokens, CompletionTokens: req.MaxTokens})
+}
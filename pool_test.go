@@ -0,0 +1,194 @@
+package llmcache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordConcurrently(t *testing.T) {
+	var mu sync.Mutex
+	inFlight := map[string]int{}
+	peak := map[string]int{}
+	attempts := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		prompt := req.Messages[0].Content
+		mu.Lock()
+		attempts[prompt]++
+		// The first attempt at "flaky" is rate limited.
+		if prompt == "flaky" && attempts[prompt] == 1 {
+			mu.Unlock()
+			w.WriteHeader(http.StatusTooManyRequests)
+			json.NewEncoder(w).Encode(map[string]any{"error": map[string]string{"message": "slow down"}})
+			return
+		}
+		inFlight[req.Model]++
+		if inFlight[req.Model] > peak[req.Model] {
+			peak[req.Model] = inFlight[req.Model]
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight[req.Model]--
+		mu.Unlock()
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{
+			Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "answer to " + prompt},
+		}}})
+	}))
+	defer server.Close()
+	client := newTestClient(t, nil)
+	config := openai.DefaultConfig("test-key")
+	config.BaseURL = server.URL
+	client.Client = openai.NewClientWithConfig(config)
+
+	var requests []capturedRequest
+	for i := 0; i < 8; i++ {
+		model := openai.GPT4o
+		if i%2 == 0 {
+			model = openai.GPT3Dot5Turbo
+		}
+		prompt := fmt.Sprint(i)
+		if i == 3 {
+			prompt = "flaky"
+		}
+		req := openai.ChatCompletionRequest{Model: model, Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: prompt}}}
+		key, err := generateKey("", req)
+		assert.NoError(t, err)
+		requests = append(requests, capturedRequest{Key: key, Model: model, Request: req})
+	}
+
+	recorded := 0
+	opts := PoolOptions{Workers: 4, ModelLimits: map[string]int{openai.GPT4o: 1}, Retries: 2, Backoff: time.Millisecond}
+	err := client.recordConcurrently(context.Background(), requests, opts, func(captured capturedRequest, cached bool, err error) {
+		assert.NoError(t, err)
+		recorded++
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 8, recorded)
+	assert.Equal(t, 1, peak[openai.GPT4o])
+	assert.Greater(t, peak[openai.GPT3Dot5Turbo], 1)
+	assert.Equal(t, 2, attempts["flaky"])
+
+	cache, err := client.store.Load()
+	assert.NoError(t, err)
+	missing, _, _ := uncached(requests, cache)
+	assert.Empty(t, missing)
+	assert.Equal(t, 8, client.Stats().Misses)
+}
+
+func TestRecordConcurrentlyStopsAtBudget(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Usage:   openai.Usage{PromptTokens: 1_000_000},
+			Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "ok"}}},
+		})
+	}))
+	defer server.Close()
+	client := newTestClient(t, nil)
+	config := openai.DefaultConfig("test-key")
+	config.BaseURL = server.URL
+	client.Client = openai.NewClientWithConfig(config)
+	client.maxCost = 1
+
+	var requests []capturedRequest
+	for i := 0; i < 5; i++ {
+		req := openai.ChatCompletionRequest{Model: openai.GPT4o, Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: fmt.Sprint(i)}}}
+		requests = append(requests, capturedRequest{Model: req.Model, Request: req})
+	}
+	recorded := 0
+	err := client.recordConcurrently(context.Background(), requests, PoolOptions{Workers: 1}, func(captured capturedRequest, cached bool, err error) {
+		if err == nil {
+			recorded++
+		}
+	})
+	assert.ErrorIs(t, err, ErrBudgetExceeded)
+	assert.Equal(t, 1, recorded)
+}
+
+func TestRecordConcurrentlyReservesTheBudget(t *testing.T) {
+	var mu sync.Mutex
+	sent := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		sent++
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Usage:   openai.Usage{PromptTokens: 1_000_000},
+			Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "ok"}}},
+		})
+	}))
+	defer server.Close()
+	client := newTestClient(t, nil)
+	config := openai.DefaultConfig("test-key")
+	config.BaseURL = server.URL
+	client.Client = openai.NewClientWithConfig(config)
+
+	var requests []capturedRequest
+	for i := 0; i < 4; i++ {
+		req := openai.ChatCompletionRequest{Model: openai.GPT4o, MaxTokens: 1000, Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: fmt.Sprint(i)}}}
+		requests = append(requests, capturedRequest{Model: req.Model, Request: req})
+	}
+	// The budget covers two requests at most, so no more than two are sent
+	// even though none has cost anything when the others are admitted.
+	client.maxCost = 2 * worstCaseCost(requests[0].Request)
+	err := client.recordConcurrently(context.Background(), requests, PoolOptions{Workers: 4}, func(captured capturedRequest, cached bool, err error) {})
+	assert.ErrorIs(t, err, ErrBudgetExceeded)
+	assert.LessOrEqual(t, sent, 2)
+	assert.InDelta(t, 0, client.reserved, 1e-9)
+}
+
+func TestRecordConcurrentlySkipsCachedRequests(t *testing.T) {
+	sent := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent++
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{
+			Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "live"},
+		}}})
+	}))
+	defer server.Close()
+	req := openai.ChatCompletionRequest{Model: openai.GPT4o, Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Hi"}}}
+	key, err := generateKey("", req)
+	assert.NoError(t, err)
+	client := newTestClient(t, &Cache{Responses: map[string]CacheEntry{key: {Response: "recorded", Request: &req}}})
+	config := openai.DefaultConfig("test-key")
+	config.BaseURL = server.URL
+	client.Client = openai.NewClientWithConfig(config)
+
+	var hit bool
+	err = client.recordConcurrently(context.Background(), []capturedRequest{{Key: key, Model: req.Model, Request: req}}, PoolOptions{Workers: 1}, func(captured capturedRequest, cached bool, err error) {
+		assert.NoError(t, err)
+		hit = cached
+	})
+	assert.NoError(t, err)
+	assert.True(t, hit)
+	assert.Zero(t, sent)
+}
+
+func TestRateLimiterSpacesRequests(t *testing.T) {
+	limiter := newRateLimiter(600)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, limiter.wait(context.Background()))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	assert.NoError(t, (*rateLimiter)(nil).wait(context.Background()))
+}
+
+func TestParseModelLimit(t *testing.T) {
+	limits := map[string]int{}
+	assert.NoError(t, parseModelLimit(limits, "gpt-4o=2"))
+	assert.Equal(t, map[string]int{"gpt-4o": 2}, limits)
+	assert.Error(t, parseModelLimit(limits, "gpt-4o"))
+	assert.Error(t, parseModelLimit(limits, "gpt-4o=0"))
+}
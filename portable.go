@@ -0,0 +1,186 @@
+package llmcache
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// checkEncoding checks that the cache file data is UTF-8 without a byte order
+// mark, which editors on Windows like to add and which JSON parsers reject.
+func checkEncoding(data []byte) doctorCheck {
+	check := doctorCheck{Name: "cache file is UTF-8 without a byte order mark"}
+	switch {
+	case bytes.HasPrefix(data, utf8BOM):
+		check.Err = errors.New("the file starts with a UTF-8 byte order mark")
+		check.Fix = "run verify-portable -fix, and save the file without a BOM in your editor"
+	case !utf8.Valid(data):
+		check.Err = errors.New("the file isn't valid UTF-8")
+		check.Fix = "restore it from version control; it was re-encoded, e.g. as UTF-16 or a code page"
+	}
+	return check
+}
+
+// checkLineEndings checks that the cache file data has LF line endings. JSON
+// can't hold a raw carriage return inside a string, so any is a line ending
+// converted by a CRLF checkout.
+func checkLineEndings(data []byte, path string) doctorCheck {
+	check := doctorCheck{Name: "cache file has LF line endings"}
+	if n := bytes.Count(data, []byte("\r\n")); n > 0 {
+		check.Err = fmt.Errorf("%d lines end in CRLF, as git writes them with core.autocrlf on Windows", n)
+		check.Fix = fmt.Sprintf("add %q to .gitattributes so git never converts it, then run verify-portable -fix", path+" -text")
+	}
+	return check
+}
+
+// checkRoundTrip checks that cache, parsed from data, is written back
+// byte for byte, compactly if compact is set, so that saving it on any
+// platform doesn't rewrite the file.
+func checkRoundTrip(data []byte, cache *Cache, compact bool) doctorCheck {
+	check := doctorCheck{Name: "cache file round-trips unchanged"}
+	written, err := encodeCache(cache, compact)
+	if err != nil {
+		check.Err, check.Fix = err, "restore it from version control"
+		return check
+	}
+	if !bytes.Equal(written, data) {
+		check.Err = fmt.Errorf("saving the cache rewrites it, from byte %d", mismatchOffset(written, data))
+		check.Fix = "run verify-portable -fix and commit the result, so every platform writes the same file"
+	}
+	return check
+}
+
+// checkPromptLineEndings checks that no recorded prompt contains a carriage
+// return. Prompts read from files checked out with CRLF line endings hash to
+// different cache keys on Windows than elsewhere, so they miss on one
+// platform or the other.
+func checkPromptLineEndings(cache *Cache) doctorCheck {
+	check := doctorCheck{Name: "recorded prompts have the same keys on every platform"}
+	var keys []string
+	for _, e := range listEntries(cache, entryFilter{}) {
+		if e.Entry.Request == nil {
+			continue
+		}
+		for _, m := range e.Entry.Request.Messages {
+			if strings.Contains(m.Content, "\r") {
+				keys = append(keys, abbreviate(e.Hash))
+				break
+			}
+		}
+	}
+	if len(keys) > 0 {
+		check.Err = fmt.Errorf("the prompts of %d entries contain carriage returns: %s", len(keys), strings.Join(keys, ", "))
+		check.Fix = "check out prompt files with LF line endings (e.g. \"* text eol=lf\" in .gitattributes) and re-record these entries"
+	}
+	return check
+}
+
+// checkResponseNormalization checks that the recorded responses are
+// normalized as new recordings are, so that they compare byte for byte with
+// golden files on every platform. Signed entries can't be fixed without
+// re-signing them, so they are reported apart.
+func checkResponseNormalization(cache *Cache) doctorCheck {
+	check := doctorCheck{Name: "recorded responses are NFC with LF line endings"}
+	var keys, signed []string
+	for _, e := range listEntries(cache, entryFilter{}) {
+		if DefaultNormalization.Apply(e.Entry.Response) == e.Entry.Response {
+			continue
+		}
+		if e.Entry.Signature != "" {
+			signed = append(signed, abbreviate(e.Hash))
+		} else {
+			keys = append(keys, abbreviate(e.Hash))
+		}
+	}
+	switch {
+	case len(signed) > 0:
+		check.Err = fmt.Errorf("the responses of %d signed entries aren't normalized: %s", len(signed), strings.Join(signed, ", "))
+		check.Fix = "re-record these entries; normalizing them would invalidate their signatures"
+	case len(keys) > 0:
+		check.Err = fmt.Errorf("the responses of %d entries aren't normalized: %s", len(keys), strings.Join(keys, ", "))
+		check.Fix = "run verify-portable -fix"
+	}
+	return check
+}
+
+// normalizeResponses normalizes the responses of the unsigned entries of cache
+// in place.
+func normalizeResponses(cache *Cache) {
+	for hash, entry := range cache.Responses {
+		if entry.Signature == "" {
+			entry.Response = DefaultNormalization.Apply(entry.Response)
+			cache.Responses[hash] = entry
+		}
+	}
+}
+
+func mismatchOffset(a, b []byte) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// portableCache returns cache file data as it reads on a platform that
+// doesn't convert it: without a byte order mark and with LF line endings.
+func portableCache(data []byte) []byte {
+	data = bytes.TrimPrefix(data, utf8BOM)
+	return bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+}
+
+// runVerifyPortable checks that a cache file reads and rewrites identically
+// on every platform, so that developers on Windows, macOS and Linux, and CI,
+// can share it.
+func runVerifyPortable(args []string) error {
+	fs := flag.NewFlagSet("verify-portable", flag.ContinueOnError)
+	fix := fs.Bool("fix", false, "Rewrite the cache file in its portable form: no byte order mark, LF line endings, normalized responses, as the cache writes it")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: llm-test-cache verify-portable [-fix] [CACHE]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("verify-portable takes at most one cache")
+	}
+	path := cacheFile
+	if fs.NArg() == 1 {
+		path = fs.Arg(0)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	checks := []doctorCheck{checkEncoding(data), checkLineEndings(data, path)}
+	cache, err := decodeCache(portableCache(data))
+	if err != nil {
+		checks = append(checks, doctorCheck{
+			Name: "cache file parses",
+			Err:  fmt.Errorf("%w: %w", ErrCacheCorrupt, err),
+			Fix:  "restore it with snapshot rollback or from version control",
+		})
+		return printChecks(checks)
+	}
+	checks = append(checks, checkRoundTrip(data, cache, compactFor(path)), checkResponseNormalization(cache), checkPromptLineEndings(cache))
+	if *fix {
+		normalizeResponses(cache)
+		if err := saveCacheTo(path, cache); err != nil {
+			return err
+		}
+		fmt.Fprintf(console, "Rewrote %s\n", path)
+		// The file is fixed, but prompts with carriage returns, and signed
+		// responses that aren't normalized, need re-recording.
+		checks = []doctorCheck{checkResponseNormalization(cache), checkPromptLineEndings(cache)}
+	}
+	return printChecks(checks)
+}
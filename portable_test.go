@@ -0,0 +1,39 @@
+package llmcache
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyPortable(t *testing.T) {
+	captureOutput(t, "verify-portable", "-quiet")
+	// The cache is committed, so it is written indented.
+	path := trackedCachePath(t)
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+	cache := &Cache{Responses: map[string]CacheEntry{"key": {Response: "line one\nline two", Request: &req}}}
+	assert.NoError(t, saveCacheTo(path, cache))
+	assert.NoError(t, runVerifyPortable([]string{path}))
+
+	// A CRLF checkout with a byte order mark still parses, but isn't
+	// portable until fixed.
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	converted := append(append([]byte{}, utf8BOM...), bytes.ReplaceAll(data, []byte("\n"), []byte("\r\n"))...)
+	assert.NoError(t, os.WriteFile(path, converted, 0644))
+	assert.ErrorContains(t, runVerifyPortable([]string{path}), "3 of 5 checks failed")
+	assert.NoError(t, runVerifyPortable([]string{"-fix", path}))
+	fixed, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, data, fixed)
+}
+
+func TestCheckPromptLineEndings(t *testing.T) {
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Summarise:\r\nthe report"}}}
+	cache := &Cache{Responses: map[string]CacheEntry{"abc": {Response: "ok", Request: &req}}}
+	check := checkPromptLineEndings(cache)
+	assert.ErrorContains(t, check.Err, "1 entries contain carriage returns: abc")
+}
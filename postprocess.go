@@ -0,0 +1,77 @@
+package llmcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PostProcessor transforms a response before it is returned. Post-processors
+// run on every response, live or cached, while the cache keeps the response
+// exactly as the API returned it.
+type PostProcessor func(response string) string
+
+// postProcessors are the post-processors suites and flags can name.
+var postProcessors = map[string]PostProcessor{
+	"trim":         strings.TrimSpace,
+	"strip_fences": StripFences,
+	"extract_json": ExtractJSON,
+}
+
+// StripFences removes markdown code fence lines, keeping the code between
+// them.
+func StripFences(response string) string {
+	lines := strings.Split(response, "\n")
+	kept := lines[:0]
+	for _, line := range lines {
+		if !strings.HasPrefix(strings.TrimSpace(line), "```") {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n")
+}
+
+// ExtractJSON returns the first JSON object or array in response, or response
+// unchanged if it contains none.
+func ExtractJSON(response string) string {
+	for i, ch := range response {
+		if ch != '{' && ch != '[' {
+			continue
+		}
+		var raw json.RawMessage
+		if err := json.NewDecoder(strings.NewReader(response[i:])).Decode(&raw); err == nil {
+			var compact bytes.Buffer
+			if json.Compact(&compact, raw) == nil {
+				return compact.String()
+			}
+		}
+	}
+	return response
+}
+
+// lookupPostProcessors returns the named post-processors, in order.
+func lookupPostProcessors(names []string) ([]PostProcessor, error) {
+	var processors []PostProcessor
+	for _, name := range names {
+		p, found := postProcessors[strings.TrimSpace(name)]
+		if !found {
+			return nil, fmt.Errorf("unknown post-processor %q", name)
+		}
+		processors = append(processors, p)
+	}
+	return processors, nil
+}
+
+func applyPostProcessors(processors []PostProcessor, response string) string {
+	for _, p := range processors {
+		response = p(response)
+	}
+	return response
+}
+
+// AddPostProcessor appends p to the post-processors applied to every response
+// the client returns.
+func (c *CachingClient) AddPostProcessor(p PostProcessor) {
+	c.postProcessors = append(c.postProcessors, p)
+}
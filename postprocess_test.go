@@ -0,0 +1,41 @@
+package llmcache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPostProcessors(t *testing.T) {
+	response := "Here you go:\n```json\n{\"capital\": \"Paris\"}\n```\n"
+	assert.Equal(t, "Here you go:\n{\"capital\": \"Paris\"}\n", StripFences(response))
+	assert.Equal(t, `{"capital":"Paris"}`, ExtractJSON(response))
+	assert.Equal(t, "no json here", ExtractJSON("no json here"))
+	assert.Equal(t, "[1,2]", ExtractJSON("{broken [1, 2] trailing"))
+
+	_, err := lookupPostProcessors([]string{"trim", "shout"})
+	assert.Error(t, err)
+}
+
+func TestPostProcessingAppliesToCachedResponses(t *testing.T) {
+	req := openai.ChatCompletionRequest{
+		Model:    "gpt-3.5-turbo-0125",
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Capital of France as JSON"}},
+	}
+	hash, err := generateHash(req)
+	assert.NoError(t, err)
+	raw := "```json\n{\"capital\": \"Paris\"}\n```"
+	client := newTestClient(t, &Cache{Responses: map[string]CacheEntry{hash: {Response: raw}}})
+	client.AddPostProcessor(ExtractJSON)
+
+	response, cached, err := client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	assert.Equal(t, `{"capital":"Paris"}`, response)
+
+	cache, err := client.store.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, raw, cache.Responses[hash].Response, "the cache keeps the raw response")
+}
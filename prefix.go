@@ -0,0 +1,93 @@
+package llmcache
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Divergence locates where a request parts from the recording sharing the
+// longest message prefix with it.
+type Divergence struct {
+	// Hash is the key of the closest recording.
+	Hash string `json:"hash"`
+	// Message is the index of the first message that differs; the messages
+	// before it match the recording.
+	Message  int    `json:"message"`
+	Recorded string `json:"recorded,omitempty"`
+	Got      string `json:"got,omitempty"`
+}
+
+func (d Divergence) String() string {
+	return fmt.Sprintf("diverges from recording %s at message %d: recorded %q, got %q",
+		abbreviate(d.Hash), d.Message+1, truncatePrompt(d.Recorded, 60), truncatePrompt(d.Got, 60))
+}
+
+// sameParams reports whether a and b differ at most in their messages.
+func sameParams(a, b openai.ChatCompletionRequest) bool {
+	a.Messages, b.Messages = nil, nil
+	x, errA := json.Marshal(a)
+	y, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(x) == string(y)
+}
+
+func commonPrefix(a, b []openai.ChatCompletionMessage) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n].Role == b[n].Role && a[n].Content == b[n].Content {
+		n++
+	}
+	return n
+}
+
+// findDivergence finds the recording within namespace with the same model and
+// parameters as req sharing the longest message prefix with it, so that no
+// other namespace's messages are reported. Only a recording that shares
+// at least one message but differs later counts; ties go to the lowest hash so
+// the result doesn't depend on map iteration.
+func findDivergence(cache *Cache, namespace string, req openai.ChatCompletionRequest) (Divergence, bool) {
+	var best Divergence
+	found := false
+	for hash, entry := range cache.Responses {
+		if entry.Request == nil || entry.Namespace != namespace || !sameParams(*entry.Request, req) {
+			continue
+		}
+		recorded := entry.Request.Messages
+		n := commonPrefix(recorded, req.Messages)
+		if n == 0 || (n == len(recorded) && n == len(req.Messages)) {
+			continue
+		}
+		if found && (n < best.Message || (n == best.Message && hash > best.Hash)) {
+			continue
+		}
+		best, found = Divergence{Hash: hash, Message: n}, true
+		if n < len(recorded) {
+			best.Recorded = recorded[n].Content
+		}
+		if n < len(req.Messages) {
+			best.Got = req.Messages[n].Content
+		}
+	}
+	return best, found
+}
+
+// SetPrefixMatching makes the client look for the longest recorded prefix of
+// every multi-message request it misses, flagging where the dialogue diverged
+// from its recording: on the new entry, in the EntryStored event, and in the
+// error of a replay miss.
+func (c *CachingClient) SetPrefixMatching(enabled bool) {
+	c.prefixMatching = enabled
+}
+
+// divergence returns where req diverges from its closest recording in
+// namespace, or nil if prefix matching is disabled or nothing was recorded
+// close to it.
+func (c *CachingClient) divergence(cache *Cache, namespace string, req openai.ChatCompletionRequest) *Divergence {
+	if !c.prefixMatching || len(req.Messages) < 2 {
+		return nil
+	}
+	if d, found := findDivergence(cache, namespace, req); found {
+		return &d
+	}
+	return nil
+}
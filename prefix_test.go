@@ -0,0 +1,48 @@
+package llmcache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixMatchingFlagsDivergence(t *testing.T) {
+	dialogue := func(turn3 string) openai.ChatCompletionRequest {
+		return openai.ChatCompletionRequest{
+			Model: "gpt-4o-mini",
+			Messages: []openai.ChatCompletionMessage{
+				{Role: "user", Content: "Hi"},
+				{Role: "assistant", Content: "Hello!"},
+				{Role: "user", Content: turn3},
+			},
+		}
+	}
+	recorded := dialogue("Tell me a joke")
+	hash, err := generateHash(recorded)
+	assert.NoError(t, err)
+	client := newTestClient(t, &Cache{Responses: map[string]CacheEntry{hash: {Response: "Knock knock.", Request: &recorded}}})
+	client.SetPrefixMatching(true)
+
+	_, _, err = client.getResponse(WithMode(context.Background(), Replay), dialogue("Tell me a story"))
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	assert.Contains(t, err.Error(), "at message 3")
+
+	cache, err := client.store.Load()
+	assert.NoError(t, err)
+	d, found := findDivergence(cache, "", dialogue("Tell me a story"))
+	assert.True(t, found)
+	assert.Equal(t, Divergence{Hash: hash, Message: 2, Recorded: "Tell me a joke", Got: "Tell me a story"}, d)
+
+	other := dialogue("Tell me a story")
+	other.Model = "gpt-4o"
+	_, found = findDivergence(cache, "", other)
+	assert.False(t, found, "only recordings with the same parameters are compared")
+
+	_, found = findDivergence(cache, "team-b", dialogue("Tell me a story"))
+	assert.False(t, found, "recordings of other namespaces are never compared")
+	_, _, err = client.getResponse(WithNamespace(WithMode(context.Background(), Replay), "team-b"), dialogue("Tell me a story"))
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	assert.NotContains(t, err.Error(), "Tell me a joke")
+}
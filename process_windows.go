@@ -0,0 +1,19 @@
+//go:build windows
+
+package llmcache
+
+import "os"
+
+// processAlive reports whether a process with the given PID is running. On
+// Windows, finding a process opens it, which fails once it has exited.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
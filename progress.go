@@ -0,0 +1,104 @@
+package llmcache
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// progressWidth is the number of cells in a progress bar.
+const progressWidth = 24
+
+// progressBar redraws one line as a suite runs, so that a long recording
+// session shows how far it has got, what it has cost so far and how long it
+// has left instead of a wall of output. A nil progressBar draws nothing.
+type progressBar struct {
+	client     *CachingClient
+	w          io.Writer
+	total      int
+	done       int
+	cached     int
+	errors     int
+	rerecorded int
+	start      time.Time
+}
+
+// SetProgress makes the client draw a progress bar on w while it runs a
+// suite; w is normally a terminal. A nil w disables it.
+func (c *CachingClient) SetProgress(w io.Writer) {
+	c.progress = w
+}
+
+// newProgressBar starts a progress bar over total cases, or returns nil if
+// the client shows none.
+func (c *CachingClient) newProgressBar(total int) *progressBar {
+	if c.progress == nil || total == 0 {
+		return nil
+	}
+	bar := &progressBar{client: c, w: c.progress, total: total, start: c.now()}
+	bar.draw()
+	return bar
+}
+
+// advance counts a finished case and redraws the bar.
+func (b *progressBar) advance(result CaseResult) {
+	if b == nil {
+		return
+	}
+	b.done++
+	switch {
+	case result.Err != nil:
+		b.errors++
+	case result.Cached:
+		b.cached++
+	}
+	if result.Diagnosis != "" {
+		b.rerecorded++
+	}
+	b.draw()
+}
+
+// finish ends the bar's line, so that what is printed next starts on its own.
+func (b *progressBar) finish() {
+	if b == nil {
+		return
+	}
+	fmt.Fprintln(b.w)
+}
+
+func (b *progressBar) draw() {
+	fmt.Fprintf(b.w, "\r%s\x1b[K", b.line())
+}
+
+// line renders the bar: the cases done, how many were served from the cache,
+// recorded or failed, the estimated cost so far and the time left, estimated
+// from the time taken per case so far.
+func (b *progressBar) line() string {
+	filled := progressWidth * b.done / b.total
+	var line strings.Builder
+	fmt.Fprintf(&line, "[%s%s] %d/%d  %d cached  %d recorded",
+		strings.Repeat("#", filled), strings.Repeat("-", progressWidth-filled),
+		b.done, b.total, b.cached, b.done-b.cached-b.errors)
+	if b.rerecorded > 0 {
+		fmt.Fprintf(&line, "  %d re-recorded", b.rerecorded)
+	}
+	if b.errors > 0 {
+		fmt.Fprintf(&line, "  %d errors", b.errors)
+	}
+	fmt.Fprintf(&line, "  $%.4f", b.client.Stats().EstimatedCost)
+	if b.done > 0 && b.done < b.total {
+		elapsed := b.client.now().Sub(b.start)
+		left := elapsed / time.Duration(b.done) * time.Duration(b.total-b.done)
+		fmt.Fprintf(&line, "  ETA %s", left.Round(time.Second))
+	}
+	return line.String()
+}
+
+// isTerminal reports whether f is a terminal, where a progress bar can be
+// redrawn in place.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,50 @@
+package llmcache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgressBarLine(t *testing.T) {
+	client := newTestClient(t, nil)
+	clock := NewFrozenClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	client.SetClock(clock)
+	var out bytes.Buffer
+	client.SetProgress(&out)
+
+	bar := client.newProgressBar(4)
+	clock.Advance(10 * time.Second)
+	bar.advance(CaseResult{Cached: true})
+	clock.Advance(10 * time.Second)
+	client.stats.EstimatedCost = 0.0125
+	bar.advance(CaseResult{Err: errors.New("boom")})
+	assert.Equal(t, "[############------------] 2/4  1 cached  0 recorded  1 errors  $0.0125  ETA 20s", bar.line())
+
+	bar.advance(CaseResult{})
+	bar.advance(CaseResult{})
+	assert.Equal(t, "[########################] 4/4  1 cached  2 recorded  1 errors  $0.0125", bar.line())
+	assert.Equal(t, 5, strings.Count(out.String(), "\r"))
+}
+
+func TestSuiteDrawsProgress(t *testing.T) {
+	client, _ := newEchoClient(t)
+	var out bytes.Buffer
+	client.SetProgress(&out)
+	suite := &Suite{
+		Models: []string{"gpt-4o-mini"},
+		Cases:  []SuiteCase{{Name: "first", Prompt: "One"}, {Name: "second", Prompt: "Two"}},
+	}
+	_, err := client.runSuite(context.Background(), suite)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "2/2  0 cached  2 recorded")
+	assert.True(t, strings.HasSuffix(out.String(), "\n"))
+
+	// A client without a progress writer draws nothing.
+	assert.Nil(t, newTestClient(t, nil).newProgressBar(2))
+}
@@ -0,0 +1,58 @@
+package llmcache
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// version is the version of llm-test-cache, recorded with every entry.
+const version = "0.9.0"
+
+// Provenance records who and what created a cache entry, for deciding whether
+// a recording can be trusted.
+type Provenance struct {
+	Host      string `json:"host,omitempty"`
+	GitCommit string `json:"git_commit,omitempty"`
+	// Version is the llm-test-cache version and Library the go-openai version
+	// that recorded the entry.
+	Version string `json:"version"`
+	Library string `json:"library,omitempty"`
+	// ModelSnapshot is the exact model the API reported answering with, e.g.
+	// gpt-4o-2024-08-06 for a request for gpt-4o.
+	ModelSnapshot string `json:"model_snapshot,omitempty"`
+}
+
+// recordingProvenance describes this process. It is gathered once per client,
+// since none of it changes during a run.
+func (c *CachingClient) recordingProvenance() Provenance {
+	if c.provenance != nil {
+		return *c.provenance
+	}
+	p := Provenance{Version: version}
+	p.Host, _ = os.Hostname()
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == "github.com/sashabaranov/go-openai" {
+				p.Library = dep.Version
+			}
+		}
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				p.GitCommit = setting.Value
+			}
+		}
+	}
+	// Prefer the commit of the repository the tests run in, which is what
+	// the recording belongs to.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if out, err := exec.CommandContext(ctx, "git", "rev-parse", "HEAD").Output(); err == nil {
+		p.GitCommit = strings.TrimSpace(string(out))
+	}
+	c.provenance = &p
+	return p
+}
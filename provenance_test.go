@@ -0,0 +1,32 @@
+package llmcache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEntriesRecordProvenance(t *testing.T) {
+	client, _ := newEchoClient(t)
+	seed := 1
+	req := openai.ChatCompletionRequest{
+		Model:    "gpt-4o-mini",
+		Seed:     &seed,
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}},
+	}
+	_, _, err := client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+
+	cache, err := client.store.Load()
+	assert.NoError(t, err)
+	hash, err := generateHash(req)
+	assert.NoError(t, err)
+	provenance := cache.Responses[hash].Provenance
+	if assert.NotNil(t, provenance) {
+		assert.Equal(t, version, provenance.Version)
+		assert.Equal(t, "gpt-4o-mini-2024-07-18", provenance.ModelSnapshot)
+		assert.NotEmpty(t, provenance.Host)
+	}
+}
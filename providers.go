@@ -0,0 +1,55 @@
+package llmcache
+
+import (
+	"context"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// chatProvider sends chat completion requests to one model provider. Adapters
+// for providers with their own APIs translate to and from the OpenAI types, so
+// the cache works the same whichever provider answered. The provider cache
+// usage is nil for providers that don't report any.
+type chatProvider interface {
+	createChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, *ProviderCacheUsage, error)
+}
+
+// openaiProvider sends requests through the go-openai client, to OpenAI or an
+// OpenAI-compatible endpoint.
+type openaiProvider struct {
+	client Client
+}
+
+func (p openaiProvider) createChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, *ProviderCacheUsage, error) {
+	if req.Stream {
+		resp, err := p.createStreamedCompletion(ctx, req)
+		return resp, nil, err
+	}
+	resp, err := p.client.CreateChatCompletion(ctx, req)
+	return resp, nil, err
+}
+
+// Models served by cloud platforms are addressed with a platform prefix, e.g.
+// "bedrock/anthropic.claude-3-haiku-20240307-v1:0" or
+// "vertex/google/gemini-2.0-flash-001". The prefix is part of the cache key,
+// so the same model on different platforms is cached separately, and is
+// stripped before the request is sent.
+const (
+	bedrockPrefix = "bedrock/"
+	vertexPrefix  = "vertex/"
+)
+
+// providerFor returns the provider that serves model and the model name to
+// send it.
+func (c *CachingClient) providerFor(model string) (chatProvider, string) {
+	switch {
+	case c.bedrock != nil && strings.HasPrefix(model, bedrockPrefix):
+		return c.bedrock, strings.TrimPrefix(model, bedrockPrefix)
+	case c.vertex != nil && strings.HasPrefix(model, vertexPrefix):
+		return c.vertex, strings.TrimPrefix(model, vertexPrefix)
+	case c.anthropic != nil && isAnthropicModel(model):
+		return c.anthropic, model
+	}
+	return openaiProvider{client: c.Client}, model
+}
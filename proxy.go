@@ -0,0 +1,280 @@
+package llmcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// proxy serves the OpenAI chat completions API from the cache, so that tests
+// in any language can use it by pointing their client's base URL at it. Each
+// caller's recordings are kept in a namespace of their own, derived from
+// their API key, so teams sharing a proxy never see each other's responses.
+type proxy struct {
+	// queue bounds how many requests the client serves at once, letting
+	// interactive requests in ahead of bulk ones.
+	queue           laneQueue
+	client          *CachingClient
+	namespaceHeader string
+	headers         headerRules
+	// chunking is how streamed responses are replayed, unless a request asks
+	// for another mode with an X-Cache-Chunking header.
+	chunking string
+	// authTokens, if any, are the bearer tokens callers must present. Each
+	// team can be given its own, which then also names its namespace.
+	authTokens []string
+}
+
+// defaultProxyConcurrency is how many requests the proxy serves at once by
+// default.
+const defaultProxyConcurrency = 8
+
+func newProxy(client *CachingClient, namespaceHeader string) *proxy {
+	return &proxy{client: client, namespaceHeader: namespaceHeader, queue: laneQueue{limit: defaultProxyConcurrency}}
+}
+
+// authorized reports whether r presents one of the proxy's auth tokens, or
+// whether the proxy needs none.
+func (p *proxy) authorized(r *http.Request) bool {
+	if len(p.authTokens) == 0 {
+		return true
+	}
+	key, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	ok := false
+	for _, token := range p.authTokens {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1 {
+			ok = true
+		}
+	}
+	return ok
+}
+
+// readAuthTokens reads the tokens in path, one per line.
+func readAuthTokens(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if token := strings.TrimSpace(line); token != "" {
+			tokens = append(tokens, token)
+		}
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%s has no tokens", path)
+	}
+	return tokens, nil
+}
+
+// tenant returns the namespace of the caller of r: a hash of their API key,
+// which is never stored itself, followed by the value of the namespace
+// header, if set. The header only names a namespace within the key's, so no
+// caller can reach the entries of another key by sending its header. Both are
+// within the client's own namespace, e.g. its project's, which the tenant
+// replaces as the namespace of the request.
+func (p *proxy) tenant(r *http.Request) string {
+	tenant := ""
+	if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && key != "" {
+		hash := sha256.Sum256([]byte(key))
+		tenant = "key-" + abbreviate(hex.EncodeToString(hash[:]))
+	}
+	if p.namespaceHeader != "" {
+		if namespace := r.Header.Get(p.namespaceHeader); namespace != "" {
+			tenant += "/" + namespace
+		}
+	}
+	if p.client.namespace != "" {
+		if tenant == "" {
+			return p.client.namespace
+		}
+		tenant = p.client.namespace + "|" + tenant
+	}
+	return tenant
+}
+
+func (p *proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !p.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeProxyError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+		return
+	}
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/chat/completions") {
+		writeProxyError(w, http.StatusNotFound, fmt.Sprintf("%s %s is not supported", r.Method, r.URL.Path))
+		return
+	}
+	var req openai.ChatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeProxyError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	namespace := p.tenant(r)
+	if part := p.headers.keyPart(r); part != "" {
+		namespace += "|" + part
+	}
+	ctx := withUpstreamHeaders(WithNamespace(r.Context(), namespace), p.headers.upstream(r))
+	ctx, recording := withStreamRecording(ctx)
+	priority, err := parseLane(r.Header.Get("X-Cache-Priority"))
+	if err != nil {
+		writeProxyError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := p.queue.acquire(r.Context(), priority); err != nil {
+		// The caller has gone away.
+		return
+	}
+	response, cached, err := p.client.getResponse(ctx, req)
+	p.queue.release()
+	if err != nil {
+		writeProxyError(w, proxyStatus(err), err.Error())
+		return
+	}
+
+	if cached {
+		w.Header().Set("X-Cache", "HIT")
+	} else {
+		w.Header().Set("X-Cache", "MISS")
+	}
+	if req.Stream {
+		mode := p.chunking
+		if requested := r.Header.Get("X-Cache-Chunking"); requested != "" {
+			mode = requested
+		}
+		chunks, err := rechunk(mode, req.Model, recording.chunks, response)
+		if err != nil {
+			writeProxyError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeSSE(w, req.Model, p.client.now().Unix(), chunks)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(chatCompletion(req.Model, p.client.now().Unix(), response))
+}
+
+// proxyStatus maps the errors of the caching client to HTTP status codes.
+func proxyStatus(err error) int {
+	var upstream *UpstreamError
+	switch {
+	case errors.Is(err, ErrCacheMiss):
+		return http.StatusNotFound
+	case errors.Is(err, ErrBudgetExceeded):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrReadOnly), errors.Is(err, ErrUnstableRequest), errors.Is(err, ErrUntrustedEntry), errors.Is(err, ErrSecretBlocked):
+		return http.StatusForbidden
+	case errors.As(err, &upstream), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+// writeProxyError writes err in the shape of an OpenAI API error.
+func writeProxyError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]string{"message": message, "type": "llm_test_cache_error"},
+	})
+}
+
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	flags := addClientFlags(fs, true)
+	addr := fs.String("addr", "localhost:8080", "Address to listen on")
+	namespaceHeader := fs.String("namespace-header", "X-Cache-Namespace", "Header naming a namespace within the caller's API key namespace, e.g. per test suite")
+	tlsCert := fs.String("tls-cert", "", "Serve HTTPS with the certificate in this PEM file (requires -tls-key)")
+	tlsKey := fs.String("tls-key", "", "Private key of the -tls-cert certificate")
+	authTokens := fs.String("auth-tokens", "", "Only serve callers presenting one of the bearer tokens in this file, one per line")
+	concurrency := fs.Int("max-concurrent", defaultProxyConcurrency, "Serve at most this many requests at once; the others wait, interactive ones first")
+	chunking := fs.String("chunking", chunkExact, "Replay streamed responses in their recorded chunks (exact), one token per chunk (token) or in one chunk (single)")
+	var headers headerRules
+	fs.Func("set-header", "Add `NAME:VALUE` to every upstream request, replacing the caller's value (repeatable)", func(v string) error {
+		name, value, ok := strings.Cut(v, ":")
+		if !ok {
+			return errors.New("want NAME:VALUE")
+		}
+		if headers.set == nil {
+			headers.set = make(http.Header)
+		}
+		headers.set.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+		return nil
+	})
+	fs.Func("forward-header", "Pass the caller's `NAME` header on upstream, e.g. Idempotency-Key (repeatable)", func(v string) error {
+		headers.forward = append(headers.forward, v)
+		return nil
+	})
+	fs.Func("key-header", "Make the caller's `NAME` header part of the cache key; other headers never are (repeatable)", func(v string) error {
+		headers.key = append(headers.key, v)
+		return nil
+	})
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: llm-test-cache serve [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		return errors.New("-tls-cert and -tls-key must be given together")
+	}
+	var tokens []string
+	if *authTokens != "" {
+		var err error
+		if tokens, err = readAuthTokens(*authTokens); err != nil {
+			return err
+		}
+	}
+
+	ctx := interruptContext()
+	client, err := flags.newClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	handler := newProxy(client, *namespaceHeader)
+	handler.authTokens = tokens
+	handler.headers = headers
+	handler.chunking = *chunking
+	handler.queue.limit = *concurrency
+	scheme := "http"
+	if *tlsCert != "" {
+		scheme = "https"
+	}
+	if !isLocalURL(scheme+"://"+*addr) && (*authTokens == "" || *tlsCert == "") {
+		fmt.Fprintln(console, "Warning: serving beyond localhost without both -tls-cert and -auth-tokens exposes the cache and your API budget")
+	}
+	fmt.Fprintf(console, "Serving the OpenAI chat completions API on %s://%s/v1\n", scheme, *addr)
+	if err := report(map[string]string{"base_url": scheme + "://" + *addr + "/v1"}); err != nil {
+		return err
+	}
+	// On a signal, stop accepting requests but let those in flight finish
+	// recording before the client is closed.
+	server := &http.Server{Addr: *addr, Handler: handler}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	if *tlsCert != "" {
+		err = server.ListenAndServeTLS(*tlsCert, *tlsKey)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,211 @@
+package llmcache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProxyNamespacesTenants(t *testing.T) {
+	client, calls := newEchoClient(t)
+	server := httptest.NewServer(newProxy(client, "X-Cache-Namespace"))
+	t.Cleanup(server.Close)
+
+	callerWithKey := func(key string) *openai.Client {
+		config := openai.DefaultConfig(key)
+		config.BaseURL = server.URL + "/v1"
+		return openai.NewClientWithConfig(config)
+	}
+	seed := 1
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Seed: &seed, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+
+	for _, key := range []string{"team-a", "team-b", "team-a"} {
+		resp, err := callerWithKey(key).CreateChatCompletion(context.Background(), req)
+		assert.NoError(t, err)
+		assert.Equal(t, "reply to 1 messages", resp.Choices[0].Message.Content)
+	}
+	assert.Equal(t, 2, *calls, "each team records its own response and replays it")
+
+	cache, err := client.store.Load()
+	assert.NoError(t, err)
+	namespaces := map[string]bool{}
+	for _, entry := range cache.Responses {
+		namespaces[entry.Namespace] = true
+	}
+	assert.Len(t, namespaces, 2)
+	assert.NotContains(t, namespaces, "team-a", "API keys must not be stored")
+}
+
+func TestProxyNamespaceHeaderStaysWithinTheKey(t *testing.T) {
+	client, calls := newEchoClient(t)
+	server := httptest.NewServer(newProxy(client, "X-Cache-Namespace"))
+	t.Cleanup(server.Close)
+
+	body := `{"model":"gpt-4o-mini","seed":1,"messages":[{"role":"user","content":"Hi"}]}`
+	send := func(key, namespace string) {
+		r, err := http.NewRequest(http.MethodPost, server.URL+"/v1/chat/completions", strings.NewReader(body))
+		assert.NoError(t, err)
+		r.Header.Set("Authorization", "Bearer "+key)
+		r.Header.Set("X-Cache-Namespace", namespace)
+		resp, err := http.DefaultClient.Do(r)
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			resp.Body.Close()
+		}
+	}
+	send("team-a", "suite")
+	send("team-b", "suite")
+	assert.Equal(t, 2, *calls, "another key's namespace header doesn't reach its entries")
+	send("team-a", "other-suite")
+	assert.Equal(t, 3, *calls, "the header namespaces entries within the key")
+	send("team-a", "suite")
+	assert.Equal(t, 3, *calls)
+}
+
+func TestProxyTenantsStayWithinTheClientNamespace(t *testing.T) {
+	client, calls := newEchoClient(t)
+	server := httptest.NewServer(newProxy(client, "X-Cache-Namespace"))
+	t.Cleanup(server.Close)
+	config := openai.DefaultConfig("team-a")
+	config.BaseURL = server.URL + "/v1"
+	caller := openai.NewClientWithConfig(config)
+	seed := 1
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Seed: &seed, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+
+	// The same key in two projects records its responses separately.
+	for _, project := range []string{"one", "two", "one"} {
+		client.namespace = projectNamespace("", "acme", project)
+		_, err := caller.CreateChatCompletion(context.Background(), req)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 2, *calls)
+
+	cache, err := client.store.Load()
+	assert.NoError(t, err)
+	for _, entry := range cache.Responses {
+		assert.True(t, strings.HasPrefix(entry.Namespace, "org=acme|project="), entry.Namespace)
+	}
+}
+
+func TestProxyServesRequestsConcurrently(t *testing.T) {
+	fake := newFakeOpenAI(t)
+	fake.delay = 300 * time.Millisecond
+	server := httptest.NewServer(newProxy(fake.newClient(t), ""))
+	t.Cleanup(server.Close)
+	config := openai.DefaultConfig("test-key")
+	config.BaseURL = server.URL + "/v1"
+	caller := openai.NewClientWithConfig(config)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for _, prompt := range []string{"one", "two", "three", "four"} {
+		wg.Add(1)
+		go func(prompt string) {
+			defer wg.Done()
+			_, err := caller.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+				Model:    "gpt-4o-mini",
+				Messages: []openai.ChatCompletionMessage{{Role: "user", Content: prompt}},
+			})
+			assert.NoError(t, err)
+		}(prompt)
+	}
+	wg.Wait()
+	assert.Equal(t, 4, fake.Calls())
+	assert.Less(t, time.Since(start), 900*time.Millisecond, "distinct misses are sent at once, not one after another")
+}
+
+func TestProxyRefusesToRecordReadOnly(t *testing.T) {
+	client, _ := newEchoClient(t)
+	client.SetReadOnly(true)
+	server := httptest.NewServer(newProxy(client, ""))
+	t.Cleanup(server.Close)
+
+	config := openai.DefaultConfig("test-key")
+	config.BaseURL = server.URL + "/v1"
+	_, err := openai.NewClientWithConfig(config).CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+		Model:    "gpt-4o-mini",
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}},
+	})
+	var apiErr *openai.APIError
+	if assert.ErrorAs(t, err, &apiErr) {
+		assert.Equal(t, 403, apiErr.HTTPStatusCode)
+	}
+}
+
+func TestProxyRequiresAuthToken(t *testing.T) {
+	client, calls := newEchoClient(t)
+	handler := newProxy(client, "")
+	handler.authTokens = []string{"team-a-token", "team-b-token"}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+	for key, status := range map[string]int{"team-b-token": 200, "team-c-token": 401, "": 401} {
+		config := openai.DefaultConfig(key)
+		config.BaseURL = server.URL + "/v1"
+		_, err := openai.NewClientWithConfig(config).CreateChatCompletion(context.Background(), req)
+		if status == 200 {
+			assert.NoError(t, err)
+			continue
+		}
+		var apiErr *openai.APIError
+		if assert.ErrorAs(t, err, &apiErr, "key %q", key) {
+			assert.Equal(t, status, apiErr.HTTPStatusCode)
+		}
+	}
+	assert.Equal(t, 1, *calls)
+}
+
+func TestProxyRewritesHeaders(t *testing.T) {
+	var received []http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = append(received, r.Header.Clone())
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: "ok"}}}})
+	}))
+	t.Cleanup(upstream.Close)
+	client := newTestClient(t, nil)
+	config := openai.DefaultConfig("proxy-key")
+	config.BaseURL = upstream.URL
+	config.HTTPClient = withHeaderTransport(nil)
+	client.Client = openai.NewClientWithConfig(config)
+
+	handler := newProxy(client, "")
+	handler.headers = headerRules{
+		set:     http.Header{"Openai-Organization": {"org-team"}},
+		forward: []string{"Idempotency-Key"},
+		key:     []string{"X-Prompt-Version"},
+	}
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	send := func(headers map[string]string) {
+		body := `{"model":"gpt-4o-mini","seed":1,"messages":[{"role":"user","content":"Hi"}]}`
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/v1/chat/completions", strings.NewReader(body))
+		assert.NoError(t, err)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+	}
+	send(map[string]string{"Idempotency-Key": "abc", "OpenAI-Organization": "org-caller", "User-Agent": "one"})
+	send(map[string]string{"User-Agent": "another"})
+	send(map[string]string{"X-Prompt-Version": "2"})
+
+	if assert.Len(t, received, 2, "headers outside the key rules must not change the cache key") {
+		assert.Equal(t, "abc", received[0].Get("Idempotency-Key"))
+		assert.Equal(t, "org-team", received[0].Get("OpenAI-Organization"))
+		assert.Empty(t, received[1].Get("Idempotency-Key"))
+	}
+}
@@ -0,0 +1,103 @@
+package llmcache
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// lane is the priority of a request waiting for the proxy's client.
+type lane int
+
+const (
+	// laneInteractive is for developers running tests by hand, who are
+	// waiting for the answer.
+	laneInteractive lane = iota
+	// laneBulk is for recording jobs, which only wait for interactive
+	// requests to be served first.
+	laneBulk
+	numLanes
+)
+
+// parseLane parses the value of an X-Cache-Priority header. Requests that
+// don't say are interactive.
+func parseLane(s string) (lane, error) {
+	switch s {
+	case "", "interactive":
+		return laneInteractive, nil
+	case "bulk":
+		return laneBulk, nil
+	}
+	return 0, fmt.Errorf("unknown priority %q: use interactive or bulk", s)
+}
+
+// laneQueue admits up to limit requests at a time to the client, which
+// sends them concurrently. The priority of a request only decides who gets
+// in next: a free slot goes to the longest waiting interactive request
+// before any bulk request. A zero limit admits one request at a time.
+type laneQueue struct {
+	mu      sync.Mutex
+	limit   int
+	active  int
+	waiting [numLanes][]chan struct{}
+}
+
+// full reports whether every slot is taken or promised to a waiting request.
+func (q *laneQueue) full() bool {
+	limit := q.limit
+	if limit <= 0 {
+		limit = 1
+	}
+	for _, waiting := range q.waiting {
+		if len(waiting) > 0 {
+			return true
+		}
+	}
+	return q.active >= limit
+}
+
+// acquire waits until the caller may use the client, or ctx is done.
+func (q *laneQueue) acquire(ctx context.Context, l lane) error {
+	q.mu.Lock()
+	if !q.full() {
+		q.active++
+		q.mu.Unlock()
+		return nil
+	}
+	granted := make(chan struct{})
+	q.waiting[l] = append(q.waiting[l], granted)
+	q.mu.Unlock()
+
+	select {
+	case <-granted:
+		return nil
+	case <-ctx.Done():
+	}
+	q.mu.Lock()
+	for i, ch := range q.waiting[l] {
+		if ch == granted {
+			q.waiting[l] = append(q.waiting[l][:i], q.waiting[l][i+1:]...)
+			q.mu.Unlock()
+			return ctx.Err()
+		}
+	}
+	q.mu.Unlock()
+	// The slot was granted as ctx was done: pass it on.
+	q.release()
+	return ctx.Err()
+}
+
+// release hands the caller's slot to the next waiting request, or frees it.
+func (q *laneQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for l := range q.waiting {
+		if len(q.waiting[l]) > 0 {
+			next := q.waiting[l][0]
+			q.waiting[l] = q.waiting[l][1:]
+			close(next)
+			return
+		}
+	}
+	q.active--
+}
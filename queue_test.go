@@ -0,0 +1,69 @@
+package llmcache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLaneQueuePrefersInteractive(t *testing.T) {
+	var q laneQueue
+	assert.NoError(t, q.acquire(context.Background(), laneBulk))
+
+	order := make(chan string, 3)
+	wait := func(name string, l lane) {
+		assert.NoError(t, q.acquire(context.Background(), l))
+		order <- name
+		q.release()
+	}
+	go wait("bulk", laneBulk)
+	// Let the bulk request queue up before the interactive ones.
+	assert.Eventually(t, func() bool { q.mu.Lock(); defer q.mu.Unlock(); return len(q.waiting[laneBulk]) == 1 }, time.Second, time.Millisecond)
+	go wait("interactive 1", laneInteractive)
+	assert.Eventually(t, func() bool { q.mu.Lock(); defer q.mu.Unlock(); return len(q.waiting[laneInteractive]) == 1 }, time.Second, time.Millisecond)
+	go wait("interactive 2", laneInteractive)
+	assert.Eventually(t, func() bool { q.mu.Lock(); defer q.mu.Unlock(); return len(q.waiting[laneInteractive]) == 2 }, time.Second, time.Millisecond)
+
+	q.release()
+	assert.Equal(t, "interactive 1", <-order)
+	assert.Equal(t, "interactive 2", <-order)
+	assert.Equal(t, "bulk", <-order)
+	assert.Eventually(t, func() bool { q.mu.Lock(); defer q.mu.Unlock(); return q.active == 0 }, time.Second, time.Millisecond)
+}
+
+func TestLaneQueueCancelledWait(t *testing.T) {
+	var q laneQueue
+	assert.NoError(t, q.acquire(context.Background(), laneInteractive))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, q.acquire(ctx, laneBulk), context.DeadlineExceeded)
+	assert.Empty(t, q.waiting[laneBulk])
+	q.release()
+	assert.Zero(t, q.active)
+}
+
+func TestLaneQueueAdmitsUpToItsLimit(t *testing.T) {
+	q := laneQueue{limit: 2}
+	assert.NoError(t, q.acquire(context.Background(), laneBulk))
+	assert.NoError(t, q.acquire(context.Background(), laneBulk), "a second request gets in at once")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, q.acquire(ctx, laneInteractive), context.DeadlineExceeded, "a third one waits")
+
+	q.release()
+	assert.NoError(t, q.acquire(context.Background(), laneInteractive))
+	assert.Equal(t, 2, q.active)
+}
+
+func TestParseLane(t *testing.T) {
+	l, err := parseLane("")
+	assert.NoError(t, err)
+	assert.Equal(t, laneInteractive, l)
+	l, err = parseLane("bulk")
+	assert.NoError(t, err)
+	assert.Equal(t, laneBulk, l)
+	_, err = parseLane("urgent")
+	assert.Error(t, err)
+}
@@ -0,0 +1,16 @@
+//go:build !windows
+
+package llmcache
+
+import "os"
+
+// replaceFile renames src over dst, which is atomic on Unix even while other
+// processes have dst open.
+func replaceFile(src, dst string) error {
+	return os.Rename(src, dst)
+}
+
+// removeFile removes the file at path.
+func removeFile(path string) error {
+	return os.Remove(path)
+}
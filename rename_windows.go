@@ -0,0 +1,59 @@
+//go:build windows
+
+package llmcache
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// errSharingViolation is ERROR_SHARING_VIOLATION, which the syscall package
+// doesn't define.
+const errSharingViolation syscall.Errno = 32
+
+// fileInUse reports whether err means another process has the file open.
+// Windows refuses to rename over or remove such a file, e.g. while a test
+// process reads the cache or a virus scanner inspects it, until the other
+// handle is closed.
+func fileInUse(err error) bool {
+	return errors.Is(err, syscall.ERROR_ACCESS_DENIED) || errors.Is(err, errSharingViolation)
+}
+
+// retryInUse runs op until it succeeds, fails for another reason than the
+// file being in use, or has been retried for about a second.
+func retryInUse(op func() error) error {
+	delay := 10 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || !fileInUse(err) || attempt == 6 {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// replaceFile renames src over dst, retrying while dst is in use. If it stays
+// in use, dst is overwritten in place instead, which isn't atomic but is
+// better than failing the run.
+func replaceFile(src, dst string) error {
+	err := retryInUse(func() error { return os.Rename(src, dst) })
+	if err == nil || !fileInUse(err) {
+		return err
+	}
+	data, readErr := os.ReadFile(src)
+	if readErr != nil {
+		return err
+	}
+	if err := retryInUse(func() error { return os.WriteFile(dst, data, 0644) }); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// removeFile removes the file at path, retrying while it is in use.
+func removeFile(path string) error {
+	return retryInUse(func() error { return os.Remove(path) })
+}
@@ -0,0 +1,90 @@
+package llmcache
+
+import (
+	"context"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Source tells where the response of a Result came from.
+type Source string
+
+const (
+	// SourceCache is the recording of the request itself.
+	SourceCache Source = "cache"
+	// SourceLive is the API.
+	SourceLive Source = "live"
+	// SourceFallback is the recording of a near-identical request, reused
+	// under -match-rules or -replay-truncated.
+	SourceFallback Source = "fallback"
+)
+
+// Result is everything known about the answer to one request, for callers
+// that need more than its text.
+type Result struct {
+	// Response is the content of the answer, after post-processing.
+	Response string
+	// Completion is the full response: as the API returned it for live
+	// results, and rebuilt from the recording, with the model snapshot and
+	// time it was recorded, for cached ones. Its content isn't
+	// post-processed.
+	Completion openai.ChatCompletionResponse
+	// Cached reports a cache hit, from the request's own recording or a
+	// fallback.
+	Cached bool
+	Source Source
+	// Hash is the cache key of the entry the response was served from, or of
+	// the request for live results.
+	Hash    string
+	Latency time.Duration
+	// Usage is the token usage the API reported for live results, and the
+	// tokens counted locally, which weren't spent, for cached ones.
+	Usage openai.Usage
+	// Cost is the estimated cost of the request in US dollars, 0 for cached
+	// results.
+	Cost float64
+	// Relaxed says how the request of a fallback differed from the
+	// recording that answered it.
+	Relaxed string
+}
+
+// Complete answers req like CreateChatCompletion, from the cache if possible,
+// and returns the Result with where the answer came from and what it cost.
+func (c *CachingClient) Complete(ctx context.Context, req openai.ChatCompletionRequest) (Result, error) {
+	c.mu.Lock()
+	result, err := c.complete(ctx, req)
+	c.mu.Unlock()
+	if err != nil {
+		return Result{}, err
+	}
+	result.Response = applyPostProcessors(c.postProcessors, result.Response)
+	return result, nil
+}
+
+// liveResult returns the Result of req answered by the API with resp since
+// start.
+func (c *CachingClient) liveResult(req openai.ChatCompletionRequest, resp openai.ChatCompletionResponse, hash string, start time.Time) Result {
+	return Result{
+		Response:   resp.Choices[0].Message.Content,
+		Completion: resp,
+		Source:     SourceLive,
+		Hash:       hash,
+		Latency:    c.now().Sub(start),
+		Usage:      resp.Usage,
+		Cost:       estimateCost(req.Model, resp.Usage),
+	}
+}
+
+// recordedCompletion rebuilds the response to req recorded in entry.
+func recordedCompletion(req openai.ChatCompletionRequest, entry CacheEntry) openai.ChatCompletionResponse {
+	model := req.Model
+	if entry.Provenance != nil && entry.Provenance.ModelSnapshot != "" {
+		model = entry.Provenance.ModelSnapshot
+	}
+	var created int64
+	if !entry.Recorded.IsZero() {
+		created = entry.Recorded.Unix()
+	}
+	return chatCompletion(model, created, entry.Response)
+}
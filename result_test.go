@@ -0,0 +1,63 @@
+package llmcache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComplete(t *testing.T) {
+	fake := newFakeOpenAI(t)
+	fake.snapshot = "-2024-07-18"
+	client := fake.newClient(t)
+	clock := NewFrozenClock(time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC))
+	client.SetClock(clock)
+	client.AddPostProcessor(func(response string) string { return "<" + response + ">" })
+	client.SetTruncationReplay(true)
+	seed := 1
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Seed: &seed, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi there"}}}
+	hash, err := generateKey("", keyedRequest(req))
+	assert.NoError(t, err)
+
+	live, err := client.Complete(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, SourceLive, live.Source)
+	assert.False(t, live.Cached)
+	assert.Equal(t, hash, live.Hash)
+	assert.Equal(t, "<gpt-4o-mini (seed 1, temperature 0) answers: Hi there>", live.Response)
+	assert.Equal(t, "gpt-4o-mini (seed 1, temperature 0) answers: Hi there", live.Completion.Choices[0].Message.Content, "the completion isn't post-processed")
+	assert.Equal(t, "gpt-4o-mini-2024-07-18", live.Completion.Model)
+	assert.Equal(t, 2, live.Usage.PromptTokens)
+	assert.Equal(t, estimateCost(req.Model, live.Usage), live.Cost)
+	assert.Greater(t, live.Cost, 0.0)
+
+	clock.Advance(time.Hour)
+	cached, err := client.Complete(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, SourceCache, cached.Source)
+	assert.True(t, cached.Cached)
+	assert.Equal(t, hash, cached.Hash)
+	assert.Equal(t, live.Response, cached.Response)
+	assert.Equal(t, "gpt-4o-mini-2024-07-18", cached.Completion.Model, "a hit reports the snapshot that was recorded")
+	assert.Equal(t, clock.Now().Add(-time.Hour).Unix(), cached.Completion.Created)
+	assert.Greater(t, cached.Usage.PromptTokens, 0, "a hit counts the tokens it saved")
+	assert.Zero(t, cached.Cost)
+
+	req.MaxTokens = 2
+	fallback, err := client.Complete(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, SourceFallback, fallback.Source)
+	assert.True(t, fallback.Cached)
+	assert.Equal(t, hash, fallback.Hash, "a fallback reports the recording that served it")
+	assert.NotEmpty(t, fallback.Relaxed)
+	assert.Equal(t, "<gpt>", fallback.Response, "truncated to 2 tokens")
+	assert.Equal(t, 1, fake.Calls())
+
+	resp, err := client.CreateChatCompletion(Bypass(context.Background()), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "gpt-4o-mini-2024-07-18", resp.Model)
+	assert.Equal(t, 2, resp.Usage.PromptTokens, "live responses are returned whole")
+}
@@ -0,0 +1,122 @@
+package llmcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// runState is the progress of a warm or suite run, saved to a file after
+// every item so that a run interrupted by a crash, a rate limit or its budget
+// resumes where it stopped: items it completed are skipped without being
+// looked up or paid for again, and items that failed are retried.
+type runState struct {
+	path string
+	// Digest identifies the manifest or suite the progress belongs to. A
+	// state saved for another version of it is discarded.
+	Digest    string               `json:"digest"`
+	Completed map[string]itemState `json:"completed"`
+	// Failed maps the keys of failed items to their last error.
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+// itemState is the outcome of a completed item, kept to report it again on
+// resume.
+type itemState struct {
+	Cached   bool     `json:"cached,omitempty"`
+	Response string   `json:"response,omitempty"`
+	Failures []string `json:"failures,omitempty"`
+}
+
+// loadRunState loads the progress of a run over the file input from path,
+// starting afresh if there is none or it was saved for a different input.
+func loadRunState(path, input string) (*runState, error) {
+	data, err := os.ReadFile(input)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	fresh := &runState{path: path, Digest: hex.EncodeToString(sum[:]), Completed: make(map[string]itemState), Failed: make(map[string]string)}
+	data, err = os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fresh, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state runState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("run state %s: %w", path, err)
+	}
+	if state.Digest != fresh.Digest {
+		fmt.Fprintf(console, "Warning: %s is the progress of a different version of %s; starting over\n", path, input)
+		return fresh, nil
+	}
+	state.path = path
+	if state.Completed == nil {
+		state.Completed = make(map[string]itemState)
+	}
+	if state.Failed == nil {
+		state.Failed = make(map[string]string)
+	}
+	return &state, nil
+}
+
+// completed returns the outcome of the item with key if a previous run
+// completed it. A nil state has completed nothing.
+func (s *runState) completed(key string) (itemState, bool) {
+	if s == nil {
+		return itemState{}, false
+	}
+	item, ok := s.Completed[key]
+	return item, ok
+}
+
+// complete records that the item with key completed with item.
+func (s *runState) complete(key string, item itemState) error {
+	if s == nil {
+		return nil
+	}
+	delete(s.Failed, key)
+	s.Completed[key] = item
+	return s.save()
+}
+
+// fail records that the item with key failed with err, to be retried.
+func (s *runState) fail(key string, err error) error {
+	if s == nil {
+		return nil
+	}
+	s.Failed[key] = err.Error()
+	return s.save()
+}
+
+func (s *runState) save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, data, 0644)
+}
+
+// finish removes the state of a run that got through every item without
+// failures, so that the next run starts afresh, and keeps it otherwise.
+func (s *runState) finish() error {
+	if s == nil || len(s.Failed) > 0 {
+		return nil
+	}
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// resumed reports how many items a previous run completed, if any.
+func (s *runState) resumed(total int) {
+	if s == nil || len(s.Completed) == 0 {
+		return
+	}
+	fmt.Fprintf(console, "Resuming from %s: %d of %d items already completed, %d failed before\n", s.path, len(s.Completed), total, len(s.Failed))
+}
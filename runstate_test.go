@@ -0,0 +1,76 @@
+package llmcache
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResumeSuite(t *testing.T) {
+	dir := t.TempDir()
+	suitePath := filepath.Join(dir, "suite.json")
+	assert.NoError(t, os.WriteFile(suitePath, []byte(`{"cases": []}`), 0644))
+	statePath := filepath.Join(dir, "state.json")
+	suite := &Suite{
+		Models: []string{"gpt-4o-mini"},
+		Cases:  []SuiteCase{{Name: "first", Prompt: "One"}, {Name: "second", Prompt: "Two"}},
+	}
+	runs, err := suite.expand()
+	assert.NoError(t, err)
+
+	// A previous run completed the first case and failed the second.
+	state, err := loadRunState(statePath, suitePath)
+	assert.NoError(t, err)
+	assert.NoError(t, state.complete(runs[0].Hash, itemState{Response: "earlier"}))
+	assert.NoError(t, state.fail(runs[1].Hash, errors.New("rate limited")))
+
+	suite.State, err = loadRunState(statePath, suitePath)
+	assert.NoError(t, err)
+	assert.Contains(t, suite.State.Failed, runs[1].Hash)
+	client, calls := newEchoClient(t)
+	results, err := client.runSuite(context.Background(), suite)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, *calls)
+	if assert.Len(t, results, 2) {
+		assert.True(t, results[0].Resumed)
+		assert.Equal(t, "earlier", results[0].Response)
+		assert.False(t, results[1].Resumed)
+		assert.True(t, results[1].Passed())
+	}
+	assert.Empty(t, suite.State.Failed)
+	assert.Len(t, suite.State.Completed, 2)
+
+	assert.NoError(t, suite.State.finish())
+	assert.NoFileExists(t, statePath)
+}
+
+func TestRunStateOfChangedInput(t *testing.T) {
+	dir := t.TempDir()
+	manifest := filepath.Join(dir, "manifest.jsonl")
+	statePath := filepath.Join(dir, "state.json")
+	assert.NoError(t, os.WriteFile(manifest, []byte("{}\n"), 0644))
+	state, err := loadRunState(statePath, manifest)
+	assert.NoError(t, err)
+	assert.NoError(t, state.complete("abc", itemState{}))
+	assert.NoError(t, state.fail("def", errors.New("server error")))
+	assert.NoError(t, state.finish())
+	assert.FileExists(t, statePath, "a run with failures keeps its state")
+
+	state, err = loadRunState(statePath, manifest)
+	assert.NoError(t, err)
+	_, done := state.completed("abc")
+	assert.True(t, done)
+
+	assert.NoError(t, os.WriteFile(manifest, []byte("{}\n{}\n"), 0644))
+	state, err = loadRunState(statePath, manifest)
+	assert.NoError(t, err)
+	assert.Empty(t, state.Completed)
+
+	_, done = (*runState)(nil).completed("abc")
+	assert.False(t, done)
+	assert.NoError(t, (*runState)(nil).complete("abc", itemState{}))
+}
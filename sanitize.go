@@ -0,0 +1,217 @@
+package llmcache
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// secretPattern is a kind of secret or personal data that shouldn't be
+// committed with a cache.
+type secretPattern struct {
+	Name     string
+	Severity Severity
+	re       *regexp.Regexp
+}
+
+// Severity is how harmful leaking a kind of secret is.
+type Severity int
+
+const (
+	// SeverityHigh is for credentials and keys, which grant access.
+	SeverityHigh Severity = iota
+	// SeverityLow is for personal data such as email addresses.
+	SeverityLow
+)
+
+func (s Severity) String() string {
+	if s == SeverityLow {
+		return "low"
+	}
+	return "high"
+}
+
+// secretPatterns are the patterns every scan looks for.
+var secretPatterns = []secretPattern{
+	{Name: "private key", re: regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`)},
+	{Name: "AWS access key", re: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{Name: "OpenAI or Anthropic API key", re: regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}`)},
+	{Name: "GitHub token", re: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})`)},
+	{Name: "Slack token", re: regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`)},
+	{Name: "Google API key", re: regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`)},
+	{Name: "JSON web token", re: regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`)},
+	{Name: "email address", Severity: SeverityLow, re: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9-]+(?:\.[A-Za-z0-9-]+)*\.[A-Za-z]{2,}\b`)},
+}
+
+// defaultAllowed matches the placeholders of documentation, which aren't
+// secrets.
+var defaultAllowed = regexp.MustCompile(`@example\.(?:com|org|net)$`)
+
+// parseSecretPattern parses a configured pattern, NAME=REGEXP or a bare
+// REGEXP named after itself.
+func parseSecretPattern(s string) (secretPattern, error) {
+	name, expr, found := strings.Cut(s, "=")
+	if !found {
+		name, expr = s, s
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return secretPattern{}, fmt.Errorf("invalid secret pattern %q: %w", s, err)
+	}
+	return secretPattern{Name: name, re: re}, nil
+}
+
+// secretScanner finds secrets in text with the built-in and configured
+// patterns, skipping matches of an allow pattern.
+type secretScanner struct {
+	patterns []secretPattern
+	allow    []*regexp.Regexp
+}
+
+func newSecretScanner(extra []secretPattern, allow []*regexp.Regexp) *secretScanner {
+	return &secretScanner{
+		patterns: append(append([]secretPattern{}, secretPatterns...), extra...),
+		allow:    append([]*regexp.Regexp{defaultAllowed}, allow...),
+	}
+}
+
+// secretMatch is a secret found in a text.
+type secretMatch struct {
+	Pattern  string
+	Severity Severity
+	// Start and End are the byte offsets of the secret.
+	Start, End int
+}
+
+// scan returns the secrets in text, in order.
+func (s *secretScanner) scan(text string) []secretMatch {
+	var matches []secretMatch
+	for _, p := range s.patterns {
+		for _, loc := range p.re.FindAllStringIndex(text, -1) {
+			if !s.allowed(text[loc[0]:loc[1]]) {
+				matches = append(matches, secretMatch{Pattern: p.Name, Severity: p.Severity, Start: loc[0], End: loc[1]})
+			}
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Start < matches[j].Start })
+	return matches
+}
+
+func (s *secretScanner) allowed(match string) bool {
+	for _, re := range s.allow {
+		if re.MatchString(match) {
+			return true
+		}
+	}
+	return false
+}
+
+// maskSecret shows just enough of a secret to find it again.
+func maskSecret(secret string) string {
+	runes := []rune(secret)
+	if len(runes) <= 8 {
+		return strings.Repeat("*", len(runes))
+	}
+	return string(runes[:4]) + "…" + string(runes[len(runes)-2:])
+}
+
+// secretFinding is a secret found in a cache.
+type secretFinding struct {
+	Hash    string `json:"key"`
+	Field   string `json:"field"`
+	Pattern string `json:"pattern"`
+	Match   string `json:"match"`
+}
+
+// scanCache returns the secrets in the requests, responses and labels of
+// every entry, session and embedded input of cache, sorted by key.
+func (s *secretScanner) scanCache(cache *Cache) []secretFinding {
+	var findings []secretFinding
+	check := func(hash, field, text string) {
+		for _, m := range s.scan(text) {
+			findings = append(findings, secretFinding{Hash: hash, Field: field, Pattern: m.Pattern, Match: maskSecret(text[m.Start:m.End])})
+		}
+	}
+	for hash, entry := range cache.Responses {
+		check(hash, "response", entry.Response)
+		check(hash, "label", entry.Label)
+		if entry.Request != nil {
+			if data, err := json.Marshal(entry.Request); err == nil {
+				check(hash, "request", string(data))
+			}
+		}
+	}
+	for name, session := range cache.Sessions {
+		if data, err := json.Marshal(session); err == nil {
+			check(name, "session", string(data))
+		}
+	}
+	for hash, entry := range cache.Embeddings {
+		check(hash, "embedding input", entry.Input)
+	}
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Hash < findings[j].Hash })
+	return findings
+}
+
+// runSanitize checks that cache files contain no API keys, email addresses
+// or configured secret patterns, e.g. as a pre-commit hook, and fails with the
+// keys of the entries that do.
+func runSanitize(args []string) error {
+	fs := flag.NewFlagSet("sanitize", flag.ContinueOnError)
+	var extra []secretPattern
+	var allow []*regexp.Regexp
+	fs.Func("pattern", "Also look for `NAME=REGEXP`, e.g. internal-host=corp\\.example\\.net (repeatable)", func(v string) error {
+		p, err := parseSecretPattern(v)
+		extra = append(extra, p)
+		return err
+	})
+	fs.Func("allow", "Ignore matches of `REGEXP`, e.g. noreply@ addresses (repeatable)", func(v string) error {
+		re, err := regexp.Compile(v)
+		allow = append(allow, re)
+		return err
+	})
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: llm-test-cache sanitize [flags] [CACHE|@SNAPSHOT...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{cacheFile}
+	}
+
+	scanner := newSecretScanner(extra, allow)
+	var findings []secretFinding
+	var offending []string
+	for _, arg := range paths {
+		path, err := resolveCachePath(arg)
+		if err != nil {
+			return err
+		}
+		cache, err := loadCacheFrom(path)
+		if err != nil {
+			return err
+		}
+		found := scanner.scanCache(cache)
+		for i, f := range found {
+			fmt.Fprintf(console, "%s: %s: %s in %s: %s\n", arg, f.Hash, f.Pattern, f.Field, f.Match)
+			if i == 0 || found[i-1].Hash != f.Hash {
+				offending = append(offending, f.Hash)
+			}
+		}
+		findings = append(findings, found...)
+	}
+	if err := report(map[string]any{"findings": append([]secretFinding{}, findings...)}); err != nil {
+		return err
+	}
+	if len(offending) > 0 {
+		return fmt.Errorf("%d entries contain secrets: %s", len(offending), strings.Join(offending, ", "))
+	}
+	fmt.Fprintln(console, "No secrets found")
+	return nil
+}
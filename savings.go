@@ -0,0 +1,180 @@
+package llmcache
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// savingsDay is the layout of the dates Cache.Savings is keyed by.
+const savingsDay = "2006-01-02"
+
+// Savings are the cumulative statistics of the runs of one day, in UTC: what
+// was sent to the API and what the cache avoided sending.
+type Savings struct {
+	Runs             int     `json:"runs"`
+	Hits             int     `json:"hits"`
+	Misses           int     `json:"misses"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	Cost             float64 `json:"cost_usd"`
+	// SavedPromptTokens and SavedCompletionTokens are counted locally for
+	// the requests served from the cache.
+	SavedPromptTokens     int     `json:"saved_prompt_tokens"`
+	SavedCompletionTokens int     `json:"saved_completion_tokens"`
+	Saved                 float64 `json:"saved_usd"`
+}
+
+func (s *Savings) add(o Savings) {
+	s.Runs += o.Runs
+	s.Hits += o.Hits
+	s.Misses += o.Misses
+	s.PromptTokens += o.PromptTokens
+	s.CompletionTokens += o.CompletionTokens
+	s.Cost += o.Cost
+	s.SavedPromptTokens += o.SavedPromptTokens
+	s.SavedCompletionTokens += o.SavedCompletionTokens
+	s.Saved += o.Saved
+}
+
+// runSavings returns the savings of one run with stats.
+func runSavings(stats RunStats) Savings {
+	return Savings{
+		Runs:                  1,
+		Hits:                  stats.Hits,
+		Misses:                stats.Misses,
+		PromptTokens:          stats.PromptTokens,
+		CompletionTokens:      stats.CompletionTokens,
+		Cost:                  stats.EstimatedCost,
+		SavedPromptTokens:     stats.CachedPromptTokens,
+		SavedCompletionTokens: stats.CachedCompletionTokens,
+		Saved:                 stats.EstimatedSavings,
+	}
+}
+
+// recordSaving counts what serving req from the cache with response avoided
+// sending to the API, and returns it as the usage the request would have had.
+func (c *CachingClient) recordSaving(req openai.ChatCompletionRequest, response string) openai.Usage {
+	prompt, err := countPromptTokens(req)
+	if err != nil {
+		return openai.Usage{}
+	}
+	enc, err := encodingFor(req.Model)
+	if err != nil {
+		return openai.Usage{}
+	}
+	usage := openai.Usage{PromptTokens: prompt, CompletionTokens: len(enc.Encode(response, nil, nil))}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	c.stats.CachedPromptTokens += usage.PromptTokens
+	c.stats.CachedCompletionTokens += usage.CompletionTokens
+	c.stats.EstimatedSavings += estimateCost(req.Model, usage)
+	return usage
+}
+
+// saveRunSavings adds the statistics of the run to the savings of the day in
+// the store. Runs that may not change the cache, because it is read-only or
+// committed as fixtures with -no-touch, runs of clients that don't cache, and
+// runs that made no requests aren't recorded.
+func (c *CachingClient) saveRunSavings() error {
+	if !c.cacheEnabled || c.readOnly || c.noTouch || c.stats.Hits+c.stats.Misses == 0 {
+		return nil
+	}
+	cache, err := c.store.Load()
+	if err != nil {
+		return err
+	}
+	if cache.Savings == nil {
+		cache.Savings = make(map[string]Savings)
+	}
+	day := c.now().UTC().Format(savingsDay)
+	savings := cache.Savings[day]
+	savings.add(runSavings(c.stats))
+	cache.Savings[day] = savings
+	return c.saveCache(cache)
+}
+
+// savingsPeriod is the savings of a day or week.
+type savingsPeriod struct {
+	Period string `json:"period"`
+	Savings
+}
+
+// savingsBy sums the daily savings of cache into days or ISO weeks, oldest
+// first.
+func savingsBy(cache *Cache, by string) ([]savingsPeriod, error) {
+	totals := make(map[string]Savings)
+	for day, savings := range cache.Savings {
+		period := day
+		if by == "week" {
+			t, err := time.Parse(savingsDay, day)
+			if err != nil {
+				return nil, fmt.Errorf("%w: savings of %q: %w", ErrCacheCorrupt, day, err)
+			}
+			year, week := t.ISOWeek()
+			period = fmt.Sprintf("%d-W%02d", year, week)
+		}
+		total := totals[period]
+		total.add(savings)
+		totals[period] = total
+	}
+	periods := make([]savingsPeriod, 0, len(totals))
+	for period, savings := range totals {
+		periods = append(periods, savingsPeriod{Period: period, Savings: savings})
+	}
+	sort.Slice(periods, func(i, j int) bool { return periods[i].Period < periods[j].Period })
+	return periods, nil
+}
+
+// runSavingsReport prints the tokens and estimated dollars the cache saved,
+// per day or week.
+func runSavingsReport(args []string) error {
+	fs := flag.NewFlagSet("savings", flag.ContinueOnError)
+	by := fs.String("by", "day", "Sum the savings per day or week")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: llm-test-cache savings [flags] [CACHE|@SNAPSHOT]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *by != "day" && *by != "week" {
+		return fmt.Errorf("unknown period %q: use day or week", *by)
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("savings takes at most one cache")
+	}
+	path := cacheFile
+	if fs.NArg() == 1 {
+		var err error
+		if path, err = resolveCachePath(fs.Arg(0)); err != nil {
+			return err
+		}
+	}
+	cache, err := loadCacheFrom(path)
+	if err != nil {
+		return err
+	}
+	periods, err := savingsBy(cache, *by)
+	if err != nil {
+		return err
+	}
+	if len(periods) == 0 {
+		fmt.Fprintln(console, "No runs recorded yet")
+		return report(periods)
+	}
+	var total Savings
+	fmt.Fprintf(console, "%-10s %6s %8s %8s %14s %10s %10s\n", "PERIOD", "RUNS", "HITS", "MISSES", "TOKENS SAVED", "SAVED", "SPENT")
+	for _, p := range periods {
+		total.add(p.Savings)
+		fmt.Fprintf(console, "%-10s %6d %8d %8d %14d %10s %10s\n", p.Period, p.Runs, p.Hits, p.Misses,
+			p.SavedPromptTokens+p.SavedCompletionTokens, fmt.Sprintf("$%.2f", p.Saved), fmt.Sprintf("$%.2f", p.Cost))
+	}
+	fmt.Fprintf(console, "%-10s %6d %8d %8d %14d %10s %10s\n", "total", total.Runs, total.Hits, total.Misses,
+		total.SavedPromptTokens+total.SavedCompletionTokens, fmt.Sprintf("$%.2f", total.Saved), fmt.Sprintf("$%.2f", total.Cost))
+	return report(periods)
+}
@@ -0,0 +1,92 @@
+package llmcache
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunSavingsPersisted(t *testing.T) {
+	client, _ := newEchoClient(t)
+	path := client.store.(*fileStore).path
+	clock := NewFrozenClock(time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC))
+	client.SetClock(clock)
+
+	req := openai.ChatCompletionRequest{Model: openai.GPT4o, Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}}}
+	for i := 0; i < 3; i++ {
+		_, _, err := client.getResponse(context.Background(), req)
+		assert.NoError(t, err)
+	}
+	stats := client.Stats()
+	assert.Equal(t, 10, stats.CachedCompletionTokens, "two hits of a five-token response")
+	assert.Greater(t, stats.EstimatedSavings, 0.0)
+	assert.NoError(t, client.Close())
+
+	// A second run a week later, and one of committed fixtures that isn't
+	// counted.
+	for _, noTouch := range []bool{false, true} {
+		clock.Advance(7 * 24 * time.Hour)
+		again := newTestClient(t, nil)
+		again.store = newFileStore(path)
+		again.SetClock(clock)
+		again.SetNoTouch(noTouch)
+		_, _, err := again.getResponse(context.Background(), req)
+		assert.NoError(t, err)
+		assert.NoError(t, again.Close())
+	}
+
+	cache, err := loadCacheFrom(path)
+	assert.NoError(t, err)
+	assert.Len(t, cache.Savings, 2)
+	assert.Contains(t, cache.Savings, "2024-03-11")
+	first := cache.Savings["2024-03-04"]
+	assert.Equal(t, 1, first.Runs)
+	assert.Equal(t, 2, first.Hits)
+	assert.Equal(t, 1, first.Misses)
+	assert.InDelta(t, stats.EstimatedSavings, first.Saved, 1e-12)
+
+	weeks, err := savingsBy(cache, "week")
+	assert.NoError(t, err)
+	if assert.Len(t, weeks, 2) {
+		assert.Equal(t, "2024-W10", weeks[0].Period)
+		assert.Equal(t, 1, weeks[1].Hits)
+	}
+}
+
+func TestUncachedClientLeavesNoCacheFile(t *testing.T) {
+	client, calls := newEchoClient(t)
+	client.cacheEnabled = false
+	path := client.store.(*fileStore).path
+	req := openai.ChatCompletionRequest{Model: openai.GPT4o, Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}}}
+	_, _, err := client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, *calls)
+	assert.NoError(t, client.Close())
+
+	assert.NoFileExists(t, path)
+	assert.NoFileExists(t, client.store.(*fileStore).lockPath())
+}
+
+func TestSavingsCommand(t *testing.T) {
+	path := t.TempDir() + "/cache.json"
+	assert.NoError(t, saveCacheTo(path, &Cache{Responses: map[string]CacheEntry{}, Savings: map[string]Savings{
+		"2024-03-04": {Runs: 2, Hits: 10, Misses: 1, SavedPromptTokens: 900, SavedCompletionTokens: 100, Saved: 1.5, Cost: 0.25},
+		"2024-03-05": {Runs: 1, Hits: 4, SavedPromptTokens: 300, Saved: 0.5},
+	}}))
+	_, out := captureOutput(t, "-output=json")
+	assert.NoError(t, runSavingsReport([]string{"-by", "week", path}))
+
+	var periods []savingsPeriod
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &periods))
+	if assert.Len(t, periods, 1) {
+		assert.Equal(t, "2024-W10", periods[0].Period)
+		assert.Equal(t, 3, periods[0].Runs)
+		assert.Equal(t, 14, periods[0].Hits)
+		assert.InDelta(t, 2.0, periods[0].Saved, 1e-9)
+	}
+	assert.Error(t, runSavingsReport([]string{"-by", "month", path}))
+}
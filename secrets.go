@@ -0,0 +1,197 @@
+package llmcache
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// SecretAction is what the client does with a secret found in a prompt about
+// to be sent to the API.
+type SecretAction int
+
+const (
+	// SecretIgnore sends the prompt as it is.
+	SecretIgnore SecretAction = iota
+	// SecretWarn sends the prompt and prints a warning.
+	SecretWarn
+	// SecretRedact replaces the secret with a placeholder naming its kind,
+	// before the request is keyed, so the secret is neither sent nor cached.
+	SecretRedact
+	// SecretBlock refuses to send the request, failing with
+	// ErrSecretBlocked.
+	SecretBlock
+)
+
+var secretActions = map[string]SecretAction{
+	"off":    SecretIgnore,
+	"warn":   SecretWarn,
+	"redact": SecretRedact,
+	"block":  SecretBlock,
+}
+
+// SecretPolicy chooses the action for the secrets of each severity.
+type SecretPolicy struct {
+	High SecretAction
+	Low  SecretAction
+}
+
+func (p SecretPolicy) action(severity Severity) SecretAction {
+	if severity == SeverityLow {
+		return p.Low
+	}
+	return p.High
+}
+
+// parseSecretPolicy parses a policy such as "high=block,low=redact".
+// Severities it doesn't mention are ignored.
+func parseSecretPolicy(s string) (SecretPolicy, error) {
+	var policy SecretPolicy
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		severity, name, _ := strings.Cut(field, "=")
+		action, ok := secretActions[name]
+		if !ok {
+			return SecretPolicy{}, fmt.Errorf("unknown secret action %q: use off, warn, redact or block", name)
+		}
+		switch severity {
+		case "high":
+			policy.High = action
+		case "low":
+			policy.Low = action
+		default:
+			return SecretPolicy{}, fmt.Errorf("unknown secret severity %q: use high or low", severity)
+		}
+	}
+	return policy, nil
+}
+
+// SetSecretPolicy makes the client scan the prompts it sends to the API for
+// likely secrets, such as API keys, private keys and email addresses, and warn
+// about, redact or block them as policy says for their severity.
+func (c *CachingClient) SetSecretPolicy(policy SecretPolicy) {
+	c.secretPolicy = policy
+	if c.secretScanner == nil {
+		c.secretScanner = newSecretScanner(nil, nil)
+	}
+}
+
+// forEachPrompt calls fn with every text of req that is sent to the model,
+// replacing it with what fn returns. It copies the messages it changes, so the
+// caller's request is left alone.
+func forEachPrompt(req openai.ChatCompletionRequest, fn func(string) string) openai.ChatCompletionRequest {
+	messages := make([]openai.ChatCompletionMessage, len(req.Messages))
+	for i, m := range req.Messages {
+		m.Content = fn(m.Content)
+		if m.MultiContent != nil {
+			parts := make([]openai.ChatMessagePart, len(m.MultiContent))
+			for j, part := range m.MultiContent {
+				part.Text = fn(part.Text)
+				parts[j] = part
+			}
+			m.MultiContent = parts
+		}
+		if m.ToolCalls != nil {
+			calls := make([]openai.ToolCall, len(m.ToolCalls))
+			for j, call := range m.ToolCalls {
+				call.Function.Arguments = fn(call.Function.Arguments)
+				calls[j] = call
+			}
+			m.ToolCalls = calls
+		}
+		messages[i] = m
+	}
+	req.Messages = messages
+	return req
+}
+
+// redactSecrets returns req with the secrets its policy redacts replaced.
+func (c *CachingClient) redactSecrets(req openai.ChatCompletionRequest) openai.ChatCompletionRequest {
+	if c.secretPolicy.High != SecretRedact && c.secretPolicy.Low != SecretRedact {
+		return req
+	}
+	return forEachPrompt(req, c.redactText)
+}
+
+// redactEmbeddingSecrets returns req with the secrets its policy redacts
+// replaced in its input strings.
+func (c *CachingClient) redactEmbeddingSecrets(req openai.EmbeddingRequest) openai.EmbeddingRequest {
+	inputs, ok := embeddingInputs(req)
+	if !ok || (c.secretPolicy.High != SecretRedact && c.secretPolicy.Low != SecretRedact) {
+		return req
+	}
+	redacted := make([]string, len(inputs))
+	for i, input := range inputs {
+		redacted[i] = c.redactText(input)
+	}
+	if _, single := req.Input.(string); single {
+		req.Input = redacted[0]
+	} else {
+		req.Input = redacted
+	}
+	return req
+}
+
+func (c *CachingClient) redactText(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range c.secretScanner.scan(text) {
+		if m.Start < last || c.secretPolicy.action(m.Severity) != SecretRedact {
+			continue
+		}
+		b.WriteString(text[last:m.Start])
+		b.WriteString("[REDACTED " + m.Pattern + "]")
+		last = m.End
+	}
+	if last == 0 {
+		return text
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// checkSecrets warns about the secrets left in req that its policy warns
+// about, and fails with ErrSecretBlocked if it has one the policy blocks.
+func (c *CachingClient) checkSecrets(req openai.ChatCompletionRequest) error {
+	var blocked error
+	forEachPrompt(req, func(text string) string {
+		if err := c.checkText(req.Model, text); blocked == nil {
+			blocked = err
+		}
+		return text
+	})
+	return blocked
+}
+
+// checkEmbeddingSecrets is checkSecrets for the input strings of req.
+func (c *CachingClient) checkEmbeddingSecrets(req openai.EmbeddingRequest) error {
+	inputs, _ := embeddingInputs(req)
+	var blocked error
+	for _, input := range inputs {
+		if err := c.checkText(string(req.Model), input); blocked == nil {
+			blocked = err
+		}
+	}
+	return blocked
+}
+
+func (c *CachingClient) checkText(model, text string) error {
+	if c.secretScanner == nil {
+		return nil
+	}
+	var blocked error
+	for _, m := range c.secretScanner.scan(text) {
+		switch c.secretPolicy.action(m.Severity) {
+		case SecretWarn:
+			fmt.Fprintf(console, "Warning: sending a %s to %s: %s\n", m.Pattern, model, maskSecret(text[m.Start:m.End]))
+		case SecretBlock:
+			if blocked == nil {
+				blocked = fmt.Errorf("%w: the prompt contains a %s: %s", ErrSecretBlocked, m.Pattern, maskSecret(text[m.Start:m.End]))
+			}
+		}
+	}
+	return blocked
+}
@@ -0,0 +1,160 @@
+package llmcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// SessionTurn is one request of a recorded session and the full message the
+// model answered with, including any tool calls.
+type SessionTurn struct {
+	Request  openai.ChatCompletionRequest `json:"request"`
+	Response openai.ChatCompletionMessage `json:"response"`
+}
+
+// SessionRecord is a multi-turn conversation recorded under one name.
+type SessionRecord struct {
+	Turns []SessionTurn `json:"turns"`
+	// Signature is the signing key's signature of the name and turns.
+	Signature string `json:"signature,omitempty"`
+}
+
+// Session records or replays a multi-turn conversation, typically an agent
+// loop calling tools, as a whole. A session that has been recorded before is
+// replayed turn by turn, and every request must match the recorded one, so a
+// change in the tool outputs the caller sends back is reported instead of
+// silently served a stale answer. Sessions are never evicted.
+type Session struct {
+	client    *CachingClient
+	name      string
+	recording bool
+	turns     []SessionTurn
+	next      int
+}
+
+// StartSession starts the session called name, replaying it if it has been
+// recorded and recording it otherwise. In Record mode any existing recording
+// is replaced; in Replay mode a missing recording fails with ErrCacheMiss.
+func (c *CachingClient) StartSession(ctx context.Context, name string) (*Session, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, ErrClientClosed
+	}
+	cache, err := c.store.Load()
+	if err != nil {
+		return nil, err
+	}
+	mode, _ := modeFrom(ctx)
+	record, found := cache.Sessions[name]
+	if mode == Replay && !found {
+		return nil, fmt.Errorf("%w: session %s", ErrCacheMiss, name)
+	}
+	if !found || mode == Record {
+		return &Session{client: c, name: name, recording: true}, nil
+	}
+	if c.verifyKey != nil {
+		if err := verifySession(c.verifyKey, name, record); err != nil {
+			return nil, err
+		}
+	}
+	return &Session{client: c, name: name, turns: record.Turns}, nil
+}
+
+// Recording reports whether the session is being recorded rather than
+// replayed.
+func (s *Session) Recording() bool {
+	return s.recording
+}
+
+// Next returns the model's answer to req, the next turn of the session.
+func (s *Session) Next(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionMessage, error) {
+	s.client.mu.Lock()
+	defer s.client.mu.Unlock()
+	// Turns are recorded and compared as they are sent.
+	req = s.client.redactSecrets(req)
+	if s.recording {
+		s.client.stats.Misses++
+		resp, _, err := s.client.fetchCompletion(ctx, req)
+		if err != nil {
+			return openai.ChatCompletionMessage{}, err
+		}
+		message := resp.Choices[0].Message
+		s.turns = append(s.turns, SessionTurn{Request: req, Response: message})
+		return message, nil
+	}
+
+	if s.next >= len(s.turns) {
+		return openai.ChatCompletionMessage{}, fmt.Errorf("%w: session %s has only %d recorded turns", ErrSessionDiverged, s.name, len(s.turns))
+	}
+	turn := s.turns[s.next]
+	if err := compareTurn(turn.Request, req); err != nil {
+		return openai.ChatCompletionMessage{}, fmt.Errorf("%w: session %s, turn %d: %w", ErrSessionDiverged, s.name, s.next+1, err)
+	}
+	s.next++
+	s.client.stats.Hits++
+	return turn.Response, nil
+}
+
+// Close saves a recorded session. Closing a replayed session checks that
+// every recorded turn was replayed.
+func (s *Session) Close() error {
+	s.client.mu.Lock()
+	defer s.client.mu.Unlock()
+	if !s.recording {
+		if s.next < len(s.turns) {
+			return fmt.Errorf("%w: session %s ended after %d of %d recorded turns", ErrSessionDiverged, s.name, s.next, len(s.turns))
+		}
+		return nil
+	}
+	if s.client.readOnly {
+		return fmt.Errorf("%w: not saving session %s", ErrReadOnly, s.name)
+	}
+	cache, err := s.client.store.Load()
+	if err != nil {
+		return err
+	}
+	if cache.Sessions == nil {
+		cache.Sessions = make(map[string]SessionRecord)
+	}
+	record := SessionRecord{Turns: s.turns}
+	if s.client.signingKey != nil {
+		if record, err = signSession(s.client.signingKey, s.name, record); err != nil {
+			return err
+		}
+	}
+	cache.Sessions[s.name] = record
+	return s.client.saveCache(cache)
+}
+
+// compareTurn explains how got differs from the recorded request, pointing
+// out tool outputs in particular.
+func compareTurn(recorded, got openai.ChatCompletionRequest) error {
+	want, err := generateHash(recorded)
+	if err != nil {
+		return err
+	}
+	have, err := generateHash(got)
+	if err != nil {
+		return err
+	}
+	if want == have {
+		return nil
+	}
+	for i := 0; i < len(recorded.Messages) && i < len(got.Messages); i++ {
+		r, g := recorded.Messages[i], got.Messages[i]
+		if r.Role == openai.ChatMessageRoleTool && g.Role == openai.ChatMessageRoleTool && r.Content != g.Content {
+			return fmt.Errorf("tool output for call %s differs: recorded %q, got %q", g.ToolCallID, r.Content, g.Content)
+		}
+		if r.Role != g.Role || r.Content != g.Content || r.ToolCallID != g.ToolCallID {
+			return fmt.Errorf("message %d differs from the recording", i+1)
+		}
+	}
+	if len(recorded.Messages) != len(got.Messages) {
+		return fmt.Errorf("recorded %d messages, got %d", len(recorded.Messages), len(got.Messages))
+	}
+	return errors.New("request parameters differ from the recording")
+}
@@ -0,0 +1,85 @@
+package llmcache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+// weatherAgent runs a two-turn tool-use loop: the model asks for the weather
+// tool, the caller answers with forecast, and the model replies.
+func weatherAgent(t *testing.T, session *Session, forecast string) (string, error) {
+	t.Helper()
+	ctx := context.Background()
+	req := openai.ChatCompletionRequest{
+		Model:    "gpt-4o-mini",
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Weather in Paris?"}},
+	}
+	call, err := session.Next(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	if !assert.Len(t, call.ToolCalls, 1) {
+		return "", nil
+	}
+	req.Messages = append(req.Messages, call, openai.ChatCompletionMessage{
+		Role:       openai.ChatMessageRoleTool,
+		ToolCallID: call.ToolCalls[0].ID,
+		Content:    forecast,
+	})
+	answer, err := session.Next(ctx, req)
+	return answer.Content, err
+}
+
+func TestSessionRecordAndReplay(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		var req openai.ChatCompletionRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		message := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "Sunny in Paris."}
+		if len(req.Messages) == 1 {
+			message = openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, ToolCalls: []openai.ToolCall{{
+				ID: "call_1", Type: openai.ToolTypeFunction,
+				Function: openai.FunctionCall{Name: "weather", Arguments: `{"city":"Paris"}`},
+			}}}
+		}
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{Message: message}}})
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, nil)
+	config := openai.DefaultConfig("test-key")
+	config.BaseURL = server.URL
+	client.Client = openai.NewClientWithConfig(config)
+
+	session, err := client.StartSession(context.Background(), "weather/paris")
+	assert.NoError(t, err)
+	assert.True(t, session.Recording())
+	answer, err := weatherAgent(t, session, "sunny")
+	assert.NoError(t, err)
+	assert.Equal(t, "Sunny in Paris.", answer)
+	assert.NoError(t, session.Close())
+	assert.Equal(t, 2, calls)
+
+	session, err = client.StartSession(WithMode(context.Background(), Replay), "weather/paris")
+	assert.NoError(t, err)
+	assert.False(t, session.Recording())
+	answer, err = weatherAgent(t, session, "sunny")
+	assert.NoError(t, err)
+	assert.Equal(t, "Sunny in Paris.", answer)
+	assert.NoError(t, session.Close())
+	assert.Equal(t, 2, calls, "a replayed session makes no API calls")
+
+	session, err = client.StartSession(context.Background(), "weather/paris")
+	assert.NoError(t, err)
+	_, err = weatherAgent(t, session, "raining")
+	assert.ErrorIs(t, err, ErrSessionDiverged)
+	assert.Contains(t, err.Error(), "tool output for call call_1 differs")
+	assert.ErrorIs(t, session.Close(), ErrSessionDiverged, "the second turn was never replayed")
+}
@@ -0,0 +1,240 @@
+package llmcache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// capturedRequest is one line of a request manifest: a request a run made,
+// with what it would cost to record.
+type capturedRequest struct {
+	Key       string `json:"key"`
+	Namespace string `json:"namespace,omitempty"`
+	Label     string `json:"label,omitempty"`
+	Model     string `json:"model"`
+	Cached    bool   `json:"cached"`
+	// PromptTokens is counted locally, and MaxCost assumes the response uses
+	// all of max_tokens; both are 0 when they can't be estimated.
+	PromptTokens int                          `json:"prompt_tokens,omitempty"`
+	MaxCost      float64                      `json:"max_cost_usd,omitempty"`
+	Request      openai.ChatCompletionRequest `json:"request"`
+}
+
+// requestCapture appends the requests of a run to a manifest file, once per
+// key.
+type requestCapture struct {
+	file *os.File
+	seen map[string]bool
+	err  error
+}
+
+func (r *requestCapture) record(captured capturedRequest) {
+	if r.seen[captured.Key] || r.err != nil {
+		return
+	}
+	r.seen[captured.Key] = true
+	data, err := json.Marshal(captured)
+	if err != nil {
+		r.err = err
+		return
+	}
+	_, r.err = fmt.Fprintln(r.file, string(data))
+}
+
+func (r *requestCapture) Close() error {
+	if err := r.file.Close(); r.err == nil {
+		r.err = err
+	}
+	return r.err
+}
+
+// CaptureRequests appends every request the client handles, with its cache
+// key and whether it is cached, to the manifest file at path, for warm to
+// record. Together with Shadow mode, this lists everything a test suite would
+// request without calling the API. The file is closed by Close.
+func (c *CachingClient) CaptureRequests(path string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	c.capture = &requestCapture{file: file, seen: make(map[string]bool)}
+	return nil
+}
+
+func (c *CachingClient) captureRequest(hash, namespace, label string, req openai.ChatCompletionRequest, cached bool) {
+	if c.capture == nil {
+		return
+	}
+	captured := capturedRequest{Key: hash, Namespace: namespace, Label: label, Model: req.Model, Cached: cached, Request: req}
+	if tokens, err := countPromptTokens(req); err == nil {
+		captured.PromptTokens = tokens
+		if req.MaxTokens > 0 {
+			captured.MaxCost = estimateCost(req.Model, openai.Usage{PromptTokens: tokens, CompletionTokens: req.MaxTokens})
+		}
+	}
+	c.capture.record(captured)
+}
+
+// readManifest reads the requests of a manifest written by CaptureRequests,
+// once per key, in the order they were first captured.
+func readManifest(path string) ([]capturedRequest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var requests []capturedRequest
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var captured capturedRequest
+		if err := json.Unmarshal(scanner.Bytes(), &captured); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if !seen[captured.Key] {
+			seen[captured.Key] = true
+			requests = append(requests, captured)
+		}
+	}
+	return requests, scanner.Err()
+}
+
+// uncached returns the requests of a manifest that still aren't in cache, and
+// their total prompt tokens and maximum cost.
+func uncached(requests []capturedRequest, cache *Cache) (missing []capturedRequest, promptTokens int, maxCost float64) {
+	for _, captured := range requests {
+		if _, found := cache.Responses[captured.Key]; found {
+			continue
+		}
+		missing = append(missing, captured)
+		promptTokens += captured.PromptTokens
+		maxCost += captured.MaxCost
+	}
+	return missing, promptTokens, maxCost
+}
+
+// runWarm records the requests of a manifest that aren't cached yet, so that
+// a later run replays them all.
+func runWarm(args []string) error {
+	fs := flag.NewFlagSet("warm", flag.ContinueOnError)
+	flags := addClientFlags(fs, true)
+	manifest := fs.String("manifest", "", "Record the requests in this manifest, written by a run with -capture, that aren't cached yet")
+	dryRun := fs.Bool("dry-run", false, "Only list the requests that would be recorded and what they would cost at most")
+	pool := PoolOptions{ModelLimits: make(map[string]int), Backoff: time.Second}
+	fs.IntVar(&pool.Workers, "concurrency", 4, "Send up to this many requests at once")
+	fs.Func("model-limit", "Send at most `N` requests at once to MODEL, given as MODEL=N (repeatable)", func(v string) error {
+		return parseModelLimit(pool.ModelLimits, v)
+	})
+	fs.IntVar(&pool.RequestsPerMinute, "rpm", 0, "Send at most this many requests per minute across all models, e.g. the organization's rate limit (0 means no limit)")
+	fs.IntVar(&pool.Retries, "retries", 3, "Retry requests failing with a rate limit or server error this many times, backing off exponentially")
+	statePath := fs.String("state", "", "Save progress to this file and resume from it, skipping the requests an interrupted warm already recorded")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: llm-test-cache warm -manifest FILE [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *manifest == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return errors.New("warm needs -manifest")
+	}
+	requests, err := readManifest(*manifest)
+	if err != nil {
+		return err
+	}
+	var state *runState
+	if *statePath != "" {
+		if state, err = loadRunState(*statePath, *manifest); err != nil {
+			return err
+		}
+		state.resumed(len(requests))
+	}
+	// Requests a previous run completed aren't even looked up.
+	var pending []capturedRequest
+	for _, captured := range requests {
+		if _, done := state.completed(captured.Key); !done {
+			pending = append(pending, captured)
+		}
+	}
+	cache, err := loadCache()
+	if err != nil {
+		return err
+	}
+	missing, promptTokens, maxCost := uncached(pending, cache)
+	for _, captured := range missing {
+		fmt.Fprintf(console, "record %s %s %s\n", captured.Key, captured.Model, captured.Label)
+	}
+	fmt.Fprintf(console, "%d requests: %d already cached, %d to record; %d prompt tokens, at most $%.4f\n",
+		len(requests), len(requests)-len(missing), len(missing), promptTokens, maxCost)
+	result := struct {
+		Requests     int     `json:"requests"`
+		ToRecord     int     `json:"to_record"`
+		Recorded     int     `json:"recorded"`
+		PromptTokens int     `json:"prompt_tokens"`
+		MaxCost      float64 `json:"max_cost_usd"`
+		DryRun       bool    `json:"dry_run"`
+		Failed       int     `json:"failed"`
+	}{Requests: len(requests), ToRecord: len(missing), PromptTokens: promptTokens, MaxCost: maxCost, DryRun: *dryRun}
+	if *dryRun {
+		return report(result)
+	}
+	if len(missing) == 0 {
+		return errors.Join(state.finish(), report(result))
+	}
+
+	ctx := interruptContext()
+	client, err := flags.newClient(ctx)
+	if err != nil {
+		return err
+	}
+	bar := client.newProgressBar(len(missing))
+	var stateErr error
+	runErr := client.recordConcurrently(ctx, missing, pool, func(captured capturedRequest, cached bool, err error) {
+		bar.advance(CaseResult{Cached: cached, Err: err})
+		if err != nil {
+			result.Failed++
+			fmt.Fprintf(console, "ERROR %s: %v\n", captured.Key, err)
+			if stateErr == nil {
+				stateErr = state.fail(captured.Key, err)
+			}
+			return
+		}
+		result.Recorded++
+		if stateErr == nil {
+			stateErr = state.complete(captured.Key, itemState{Cached: cached})
+		}
+	})
+	bar.finish()
+	closeErr := client.Close()
+	if runErr == nil && stateErr == nil {
+		stateErr = state.finish()
+	}
+	if err := report(result); err != nil {
+		return err
+	}
+	if err := errors.Join(runErr, closeErr, stateErr); err != nil {
+		return err
+	}
+	if result.Failed > 0 {
+		return fmt.Errorf("%d of %d requests failed", result.Failed, len(missing))
+	}
+	return nil
+}
+
+// runShadow runs the built-in examples, or a suite, without calling the API,
+// capturing the requests it would make.
+func runShadow(args []string) error {
+	return runModeCommand("shadow", Shadow, args)
+}
@@ -0,0 +1,57 @@
+package llmcache
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShadowModeCapturesRequests(t *testing.T) {
+	client, calls := newEchoClient(t)
+	seed := 1
+	cachedReq := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Seed: &seed, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+	_, _, err := client.getResponse(context.Background(), cachedReq)
+	assert.NoError(t, err)
+
+	manifest := filepath.Join(t.TempDir(), "manifest.jsonl")
+	assert.NoError(t, client.CaptureRequests(manifest))
+	ctx := WithMode(context.Background(), Shadow)
+	response, cached, err := client.getResponse(ctx, cachedReq)
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	assert.Equal(t, "reply to 1 messages", response)
+
+	newReq := cachedReq
+	newReq.MaxTokens = 50
+	newReq.Messages = []openai.ChatCompletionMessage{{Role: "user", Content: "Hello there"}}
+	for i := 0; i < 2; i++ {
+		_, _, err = client.getResponse(WithLabel(ctx, "greeting"), newReq)
+		assert.ErrorIs(t, err, ErrShadowed)
+	}
+	assert.Equal(t, 1, *calls, "shadow mode never calls the API")
+	assert.Equal(t, 2, client.Stats().Shadowed)
+	cache, err := client.store.Load()
+	assert.NoError(t, err)
+	assert.NoError(t, client.Close())
+
+	requests, err := readManifest(manifest)
+	assert.NoError(t, err)
+	if !assert.Len(t, requests, 2, "each request is captured once") {
+		return
+	}
+	assert.True(t, requests[0].Cached)
+	assert.False(t, requests[1].Cached)
+	assert.Equal(t, "greeting", requests[1].Label)
+	assert.Greater(t, requests[1].MaxCost, 0.0)
+
+	missing, promptTokens, maxCost := uncached(requests, cache)
+	assert.Equal(t, []capturedRequest{requests[1]}, missing)
+	assert.Equal(t, requests[1].PromptTokens, promptTokens)
+	assert.Equal(t, requests[1].MaxCost, maxCost)
+	key, err := generateHash(newReq)
+	assert.NoError(t, err)
+	assert.Equal(t, key, missing[0].Key, "warm records captured requests under the key they missed")
+}
@@ -0,0 +1,28 @@
+package llmcache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// interruptContext returns a context cancelled by the first SIGINT or
+// SIGTERM, so that a command can stop cleanly: requests in flight are
+// abandoned without caching anything partial, and the command's client is
+// closed, flushing what was cached so far and releasing the lock. A second
+// signal exits at once.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		fmt.Fprintln(os.Stderr, "Interrupted: finishing up; interrupt again to exit at once")
+		cancel()
+		<-signals
+		os.Exit(130)
+	}()
+	return ctx
+}
@@ -0,0 +1,337 @@
+package llmcache
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// signedFields are the parts of an entry a signature covers: everything that
+// decides what is replayed for a request. Timestamp and Label change on hits
+// and are left out.
+type signedFields struct {
+	Key       string                        `json:"key"`
+	Response  string                        `json:"response"`
+	Request   *openai.ChatCompletionRequest `json:"request,omitempty"`
+	Recorded  time.Time                     `json:"recorded"`
+	Namespace string                        `json:"namespace,omitempty"`
+	Parent    string                        `json:"parent,omitempty"`
+}
+
+func signingPayload(hash string, entry CacheEntry) ([]byte, error) {
+	return json.Marshal(signedFields{
+		Key:       hash,
+		Response:  entry.Response,
+		Request:   entry.Request,
+		Recorded:  entry.Recorded,
+		Namespace: entry.Namespace,
+		Parent:    entry.Parent,
+	})
+}
+
+// signedSession is what the signature of a session covers: its name and
+// every recorded turn.
+type signedSession struct {
+	Name  string        `json:"name"`
+	Turns []SessionTurn `json:"turns"`
+}
+
+// signedEmbedding is what the signature of an embedding covers. Hits change
+// on hits and are left out.
+type signedEmbedding struct {
+	Key        string    `json:"key"`
+	Model      string    `json:"model"`
+	Dimensions int       `json:"dimensions,omitempty"`
+	Input      string    `json:"input"`
+	Embedding  []float32 `json:"embedding"`
+	Namespace  string    `json:"namespace,omitempty"`
+	Recorded   time.Time `json:"recorded"`
+}
+
+func embeddingPayload(key string, entry EmbeddingEntry) ([]byte, error) {
+	return json.Marshal(signedEmbedding{
+		Key:        key,
+		Model:      entry.Model,
+		Dimensions: entry.Dimensions,
+		Input:      entry.Input,
+		Embedding:  entry.Embedding,
+		Namespace:  entry.Namespace,
+		Recorded:   entry.Recorded,
+	})
+}
+
+// signEntry returns entry signed with key as recorded under hash.
+func signEntry(key ed25519.PrivateKey, hash string, entry CacheEntry) (CacheEntry, error) {
+	payload, err := signingPayload(hash, entry)
+	if err != nil {
+		return entry, err
+	}
+	entry.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))
+	return entry, nil
+}
+
+// signSession returns the session recorded as name signed with key.
+func signSession(key ed25519.PrivateKey, name string, record SessionRecord) (SessionRecord, error) {
+	payload, err := json.Marshal(signedSession{Name: name, Turns: record.Turns})
+	if err != nil {
+		return record, err
+	}
+	record.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))
+	return record, nil
+}
+
+// signEmbedding returns entry signed with key as recorded under hash.
+func signEmbedding(key ed25519.PrivateKey, hash string, entry EmbeddingEntry) (EmbeddingEntry, error) {
+	payload, err := embeddingPayload(hash, entry)
+	if err != nil {
+		return entry, err
+	}
+	entry.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))
+	return entry, nil
+}
+
+// verifyEntry checks that entry carries a valid signature by key, failing
+// with ErrUntrustedEntry otherwise.
+func verifyEntry(key ed25519.PublicKey, hash string, entry CacheEntry) error {
+	payload, err := signingPayload(hash, entry)
+	if err != nil {
+		return err
+	}
+	return verifySignature(key, hash, entry.Signature, payload)
+}
+
+// verifySession is verifyEntry for the session recorded as name.
+func verifySession(key ed25519.PublicKey, name string, record SessionRecord) error {
+	payload, err := json.Marshal(signedSession{Name: name, Turns: record.Turns})
+	if err != nil {
+		return err
+	}
+	return verifySignature(key, "session "+name, record.Signature, payload)
+}
+
+// verifyEmbedding is verifyEntry for an embedding.
+func verifyEmbedding(key ed25519.PublicKey, hash string, entry EmbeddingEntry) error {
+	payload, err := embeddingPayload(hash, entry)
+	if err != nil {
+		return err
+	}
+	return verifySignature(key, "embedding "+hash, entry.Signature, payload)
+}
+
+// verifySignature checks that signature is a signature of payload by key,
+// naming what was signed in the error.
+func verifySignature(key ed25519.PublicKey, what, signature string, payload []byte) error {
+	if signature == "" {
+		return fmt.Errorf("%w: %s is not signed", ErrUntrustedEntry, what)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("%w: %s has a malformed signature", ErrUntrustedEntry, what)
+	}
+	if !ed25519.Verify(key, payload, decoded) {
+		return fmt.Errorf("%w: %s has an invalid signature", ErrUntrustedEntry, what)
+	}
+	return nil
+}
+
+// SetSigningKey makes the client sign every entry, session and embedding it
+// records with key.
+func (c *CachingClient) SetSigningKey(key ed25519.PrivateKey) {
+	c.signingKey = key
+}
+
+// SetVerifyKey makes the client refuse to replay entries, sessions and
+// embeddings that aren't signed by the private half of key, failing with
+// ErrUntrustedEntry.
+func (c *CachingClient) SetVerifyKey(key ed25519.PublicKey) {
+	c.verifyKey = key
+}
+
+// readKey reads a base64-encoded key of size bytes from path.
+func readKey(path string, size int) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != size {
+		return nil, fmt.Errorf("%s is not a base64-encoded %d-byte key", path, size)
+	}
+	return key, nil
+}
+
+func readSigningKey(path string) (ed25519.PrivateKey, error) {
+	key, err := readKey(path, ed25519.PrivateKeySize)
+	return ed25519.PrivateKey(key), err
+}
+
+func readVerifyKey(path string) (ed25519.PublicKey, error) {
+	key, err := readKey(path, ed25519.PublicKeySize)
+	return ed25519.PublicKey(key), err
+}
+
+// generateKeys writes a new key pair to name.key and name.pub.
+func generateKeys(name string) error {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(name+".key", []byte(base64.StdEncoding.EncodeToString(private)+"\n"), 0600); err != nil {
+		return err
+	}
+	return os.WriteFile(name+".pub", []byte(base64.StdEncoding.EncodeToString(public)+"\n"), 0644)
+}
+
+// untrustedEntries returns the errors of the entries, sessions and embeddings
+// in cache not validly signed by key: entries first, each kind ordered by
+// key.
+func untrustedEntries(cache *Cache, key ed25519.PublicKey) []error {
+	var errs []error
+	for _, hash := range sortedKeys(cache.Responses) {
+		if err := verifyEntry(key, hash, cache.Responses[hash]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, name := range sortedKeys(cache.Sessions) {
+		if err := verifySession(key, name, cache.Sessions[name]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, hash := range sortedKeys(cache.Embeddings) {
+		if err := verifyEmbedding(key, hash, cache.Embeddings[hash]); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// signedCount is how many signed things cache holds: entries, sessions and
+// embeddings.
+func signedCount(cache *Cache) int {
+	return len(cache.Responses) + len(cache.Sessions) + len(cache.Embeddings)
+}
+
+// runSign signs every entry of a cache, or generates a key pair.
+func runSign(args []string) error {
+	fs := flag.NewFlagSet("sign", flag.ContinueOnError)
+	keyPath := fs.String("key", "", "Sign with the private key in this file")
+	generate := fs.String("generate", "", "Generate a key pair, written to NAME.key and NAME.pub, instead of signing")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: llm-test-cache sign -key FILE [CACHE] | sign -generate NAME")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *generate != "" {
+		if err := generateKeys(*generate); err != nil {
+			return err
+		}
+		fmt.Fprintf(console, "Wrote %s.key and %s.pub\n", *generate, *generate)
+		return report(map[string]string{"private_key": *generate + ".key", "public_key": *generate + ".pub"})
+	}
+	if *keyPath == "" || fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("sign needs -key")
+	}
+	key, err := readSigningKey(*keyPath)
+	if err != nil {
+		return err
+	}
+	path := cacheFile
+	if fs.NArg() == 1 {
+		path = fs.Arg(0)
+	}
+	store := newFileStore(path)
+	defer store.Close()
+	cache, err := store.Load()
+	if err != nil {
+		return err
+	}
+	for hash, entry := range cache.Responses {
+		if cache.Responses[hash], err = signEntry(key, hash, entry); err != nil {
+			return err
+		}
+	}
+	for name, record := range cache.Sessions {
+		if cache.Sessions[name], err = signSession(key, name, record); err != nil {
+			return err
+		}
+	}
+	for hash, entry := range cache.Embeddings {
+		if cache.Embeddings[hash], err = signEmbedding(key, hash, entry); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(console, "Signed %d entries\n", signedCount(cache))
+	if err := store.Save(cache); err != nil {
+		return err
+	}
+	return report(map[string]int{"signed": signedCount(cache)})
+}
+
+// runVerify checks that every entry of a cache or snapshot is signed by a
+// key, failing if any isn't.
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	keyPath := fs.String("key", "", "Verify against the public key in this file")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: llm-test-cache verify -key FILE [CACHE|@SNAPSHOT]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *keyPath == "" || fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("verify needs -key")
+	}
+	key, err := readVerifyKey(*keyPath)
+	if err != nil {
+		return err
+	}
+	path := cacheFile
+	if fs.NArg() == 1 {
+		if path, err = resolveCachePath(fs.Arg(0)); err != nil {
+			return err
+		}
+	}
+	cache, err := loadCacheFrom(path)
+	if err != nil {
+		return err
+	}
+	errs := untrustedEntries(cache, key)
+	untrusted := []string{}
+	for _, err := range errs {
+		fmt.Fprintln(console, err)
+		untrusted = append(untrusted, err.Error())
+	}
+	if err := report(map[string]any{"entries": signedCount(cache), "untrusted": untrusted}); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d entries are untrusted", len(errs), signedCount(cache))
+	}
+	fmt.Fprintf(console, "All %d entries are signed\n", signedCount(cache))
+	return nil
+}
@@ -0,0 +1,111 @@
+package llmcache
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignedEntries(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	client, calls := newEchoClient(t)
+	client.SetSigningKey(private)
+	client.SetVerifyKey(public)
+	seed := 1
+	req := openai.ChatCompletionRequest{
+		Model:    "gpt-4o-mini",
+		Seed:     &seed,
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}},
+	}
+
+	_, _, err = client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	_, cached, err := client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	assert.Equal(t, 1, *calls)
+
+	cache, err := client.store.Load()
+	assert.NoError(t, err)
+	assert.Empty(t, untrustedEntries(cache, public))
+
+	// A tampered response no longer matches its signature.
+	hash, err := generateHash(req)
+	assert.NoError(t, err)
+	entry := cache.Responses[hash]
+	entry.Response = "tampered"
+	cache.Responses[hash] = entry
+	assert.NoError(t, client.store.Save(cache))
+	_, _, err = client.getResponse(context.Background(), req)
+	assert.ErrorIs(t, err, ErrUntrustedEntry)
+
+	// So does an entry signed by another key.
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	assert.Len(t, untrustedEntries(cache, other), 1)
+}
+
+func TestVerifyRejectsUnsignedEntries(t *testing.T) {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	cache := &Cache{Responses: map[string]CacheEntry{"a": {Response: "unsigned"}}}
+	errs := untrustedEntries(cache, public)
+	if assert.Len(t, errs, 1) {
+		assert.ErrorIs(t, errs[0], ErrUntrustedEntry)
+		assert.Contains(t, errs[0].Error(), "not signed")
+	}
+}
+
+func TestSignedSessionsAndEmbeddings(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	client, _ := newEchoClient(t)
+	client.SetSigningKey(private)
+	client.SetVerifyKey(public)
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+	session, err := client.StartSession(ctx, "greeting")
+	assert.NoError(t, err)
+	_, err = session.Next(ctx, req)
+	assert.NoError(t, err)
+	assert.NoError(t, session.Close())
+	_, err = client.StartSession(ctx, "greeting")
+	assert.NoError(t, err, "a signed session replays")
+
+	cache, err := client.store.Load()
+	assert.NoError(t, err)
+	assert.Empty(t, untrustedEntries(cache, public))
+	record := cache.Sessions["greeting"]
+	record.Turns[0].Response.Content = "tampered"
+	cache.Sessions["greeting"] = record
+	assert.NoError(t, client.store.Save(cache))
+	_, err = client.StartSession(ctx, "greeting")
+	assert.ErrorIs(t, err, ErrUntrustedEntry)
+
+	embedder, _ := newEmbeddingClient(t)
+	embedder.SetSigningKey(private)
+	embedder.SetVerifyKey(public)
+	embed := openai.EmbeddingRequestStrings{Model: openai.SmallEmbedding3, Input: []string{"a"}}
+	_, err = embedder.CreateEmbeddings(ctx, embed)
+	assert.NoError(t, err)
+	_, err = embedder.CreateEmbeddings(ctx, embed)
+	assert.NoError(t, err, "a signed embedding replays")
+
+	cache, err = embedder.store.Load()
+	assert.NoError(t, err)
+	assert.Empty(t, untrustedEntries(cache, public))
+	for key, entry := range cache.Embeddings {
+		entry.Embedding = []float32{42}
+		cache.Embeddings[key] = entry
+	}
+	assert.NoError(t, embedder.store.Save(cache))
+	_, err = embedder.CreateEmbeddings(ctx, embed)
+	assert.ErrorIs(t, err, ErrUntrustedEntry)
+	assert.Len(t, untrustedEntries(cache, public), 1)
+}
@@ -0,0 +1,146 @@
+package llmcache
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// SimilarityMetric scores how close a candidate text is to a reference text,
+// from 0 (unrelated) to 1 (identical).
+type SimilarityMetric func(candidate, reference string) float64
+
+// similarityTokens lowercases s and splits it into words, dropping punctuation
+// so that formatting differences don't dominate the score.
+func similarityTokens(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// RougeL returns the ROUGE-L F1 score of candidate against reference, based on
+// the longest common subsequence of their words.
+func RougeL(candidate, reference string) float64 {
+	c := similarityTokens(candidate)
+	r := similarityTokens(reference)
+	if len(c) == 0 || len(r) == 0 {
+		if len(c) == len(r) {
+			return 1
+		}
+		return 0
+	}
+
+	prev := make([]int, len(r)+1)
+	curr := make([]int, len(r)+1)
+	for i := 1; i <= len(c); i++ {
+		for j := 1; j <= len(r); j++ {
+			if c[i-1] == r[j-1] {
+				curr[j] = prev[j-1] + 1
+			} else {
+				curr[j] = max(prev[j], curr[j-1])
+			}
+		}
+		prev, curr = curr, prev
+	}
+	lcs := float64(prev[len(r)])
+	if lcs == 0 {
+		return 0
+	}
+	precision := lcs / float64(len(c))
+	recall := lcs / float64(len(r))
+	return 2 * precision * recall / (precision + recall)
+}
+
+// BLEU returns the sentence-level BLEU score of candidate against reference
+// using up to 4-grams with add-one smoothing and the standard brevity penalty.
+func BLEU(candidate, reference string) float64 {
+	c := similarityTokens(candidate)
+	r := similarityTokens(reference)
+	if len(c) == 0 || len(r) == 0 {
+		if len(c) == len(r) {
+			return 1
+		}
+		return 0
+	}
+
+	const maxN = 4
+	logSum := 0.0
+	for n := 1; n <= maxN; n++ {
+		refCounts := ngramCounts(r, n)
+		matches, total := 0, 0
+		for gram, count := range ngramCounts(c, n) {
+			matches += min(count, refCounts[gram])
+			total += count
+		}
+		logSum += math.Log(float64(matches+1) / float64(total+1))
+	}
+
+	brevity := 1.0
+	if len(c) < len(r) {
+		brevity = math.Exp(1 - float64(len(r))/float64(len(c)))
+	}
+	return brevity * math.Exp(logSum/maxN)
+}
+
+func ngramCounts(tokens []string, n int) map[string]int {
+	counts := make(map[string]int)
+	for i := 0; i+n <= len(tokens); i++ {
+		counts[strings.Join(tokens[i:i+n], " ")]++
+	}
+	return counts
+}
+
+// CosineSimilarity returns the cosine of the angle between two vectors, or 0
+// if either is empty or they differ in length.
+func CosineSimilarity(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// MissingFacts returns the patterns from facts that don't match response.
+// Each fact is a regular expression; matching is case-insensitive.
+func MissingFacts(response string, facts []string) ([]string, error) {
+	var missing []string
+	for _, fact := range facts {
+		re, err := regexp.Compile("(?i)" + fact)
+		if err != nil {
+			return nil, fmt.Errorf("invalid fact pattern %q: %w", fact, err)
+		}
+		if !re.MatchString(response) {
+			missing = append(missing, fact)
+		}
+	}
+	return missing, nil
+}
+
+// EmbeddingSimilarity embeds a and b with model and returns the cosine
+// similarity of the two vectors.
+func (c *CachingClient) EmbeddingSimilarity(ctx context.Context, model openai.EmbeddingModel, a, b string) (float64, error) {
+	resp, err := c.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Input: []string{a, b},
+		Model: model,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Data) != 2 {
+		return 0, fmt.Errorf("expected 2 embeddings, got %d", len(resp.Data))
+	}
+	return CosineSimilarity(resp.Data[0].Embedding, resp.Data[1].Embedding), nil
+}
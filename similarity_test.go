@@ -0,0 +1,35 @@
+package llmcache
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRougeL(t *testing.T) {
+	assert.Equal(t, 1.0, RougeL("Paris is the capital.", "paris is the capital"))
+	assert.Equal(t, 0.0, RougeL("apples", "oranges"))
+	assert.InDelta(t, 0.6, RougeL("the cat sat down", "the cat sat on the mat"), 1e-9)
+}
+
+func TestBLEU(t *testing.T) {
+	assert.InDelta(t, 1.0, BLEU("the cat sat on the mat", "the cat sat on the mat"), 1e-9)
+	assert.Less(t, BLEU("a dog ran", "the cat sat on the mat"), 0.2)
+	// Shorter candidates are penalised even when every word matches.
+	assert.Less(t, BLEU("the cat", "the cat sat on the mat"), BLEU("the cat sat on the", "the cat sat on the mat"))
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	assert.InDelta(t, 1.0, CosineSimilarity([]float32{1, 2, 3}, []float32{2, 4, 6}), 1e-6)
+	assert.InDelta(t, 0.0, CosineSimilarity([]float32{1, 0}, []float32{0, 1}), 1e-6)
+	assert.Equal(t, 0.0, CosineSimilarity([]float32{1}, []float32{1, 2}))
+}
+
+func TestMissingFacts(t *testing.T) {
+	missing, err := MissingFacts("Water boils at 100 degrees Celsius.", []string{`100\s*degrees`, "celsius", "fahrenheit"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"fahrenheit"}, missing)
+
+	_, err = MissingFacts("anything", []string{"("})
+	assert.Error(t, err)
+}
@@ -0,0 +1,174 @@
+package llmcache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// snapshotDir lives outside the cache directory so that clearCache, which
+// removes the whole cache directory, never takes the snapshots with it.
+const snapshotDir = "cache-snapshots"
+
+var snapshotNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// reservedOnWindows reports whether Windows reserves name for a device, with
+// any extension, so that a file by that name can't be created there.
+func reservedOnWindows(name string) bool {
+	base, _, _ := strings.Cut(strings.ToUpper(name), ".")
+	switch base {
+	case "CON", "PRN", "AUX", "NUL":
+		return true
+	}
+	return len(base) == 4 && (strings.HasPrefix(base, "COM") || strings.HasPrefix(base, "LPT")) && base[3] >= '1' && base[3] <= '9'
+}
+
+type snapshotInfo struct {
+	Name    string    `json:"name"`
+	Entries int       `json:"entries"`
+	Created time.Time `json:"created"`
+}
+
+func snapshotPath(name string) (string, error) {
+	if !snapshotNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid snapshot name %q: use letters, digits, '.', '_' and '-'", name)
+	}
+	// Snapshots are shared between platforms, so names Windows can't store
+	// are refused everywhere.
+	if reservedOnWindows(name) {
+		return "", fmt.Errorf("invalid snapshot name %q: it is reserved on Windows", name)
+	}
+	return filepath.Join(snapshotDir, name+".json"), nil
+}
+
+// resolveCachePath maps "@name" to the file of the named snapshot and returns
+// any other argument unchanged, so commands taking cache files also accept
+// snapshots.
+func resolveCachePath(arg string) (string, error) {
+	if name, ok := strings.CutPrefix(arg, "@"); ok {
+		return snapshotPath(name)
+	}
+	return arg, nil
+}
+
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(dst, data, 0644)
+}
+
+// createSnapshot copies the current cache file to a named snapshot. Existing
+// snapshots are never overwritten.
+func createSnapshot(name string) error {
+	path, err := snapshotPath(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("snapshot %q already exists", name)
+	}
+	if _, err := os.Stat(cacheFile); os.IsNotExist(err) {
+		return fmt.Errorf("no cache file at %s to snapshot", cacheFile)
+	}
+	return copyFile(cacheFile, path)
+}
+
+// rollbackSnapshot replaces the current cache file with the named snapshot.
+func rollbackSnapshot(name string) error {
+	path, err := snapshotPath(name)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return fmt.Errorf("snapshot %q does not exist", name)
+	}
+	return copyFile(path, cacheFile)
+}
+
+func deleteSnapshot(name string) error {
+	path, err := snapshotPath(name)
+	if err != nil {
+		return err
+	}
+	return removeFile(path)
+}
+
+func listSnapshots() ([]snapshotInfo, error) {
+	files, err := filepath.Glob(filepath.Join(snapshotDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]snapshotInfo, 0, len(files))
+	for _, file := range files {
+		stat, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		cache, err := loadCacheFrom(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading snapshot %s: %w", file, err)
+		}
+		snapshots = append(snapshots, snapshotInfo{
+			Name:    strings.TrimSuffix(filepath.Base(file), ".json"),
+			Entries: len(cache.Responses),
+			Created: stat.ModTime(),
+		})
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Created.Before(snapshots[j].Created)
+	})
+	return snapshots, nil
+}
+
+func runSnapshot(args []string) error {
+	usage := "usage: llm-test-cache snapshot create|rollback|delete NAME | snapshot list"
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	if args[0] == "list" {
+		snapshots, err := listSnapshots()
+		if err != nil {
+			return err
+		}
+		for _, s := range snapshots {
+			fmt.Fprintf(console, "%s\t%d entries\t%s\n", s.Name, s.Entries, s.Created.Format(time.RFC3339))
+		}
+		return report(append([]snapshotInfo{}, snapshots...))
+	}
+
+	if len(args) != 2 {
+		return errors.New(usage)
+	}
+	name := args[1]
+	switch args[0] {
+	case "create":
+		if err := createSnapshot(name); err != nil {
+			return err
+		}
+		fmt.Fprintf(console, "Created snapshot %s\n", name)
+	case "rollback":
+		if err := rollbackSnapshot(name); err != nil {
+			return err
+		}
+		fmt.Fprintf(console, "Rolled cache back to snapshot %s\n", name)
+	case "delete":
+		if err := deleteSnapshot(name); err != nil {
+			return err
+		}
+		fmt.Fprintf(console, "Deleted snapshot %s\n", name)
+	default:
+		return errors.New(usage)
+	}
+	return report(map[string]string{"snapshot": name, "action": args[0]})
+}
@@ -0,0 +1,38 @@
+package llmcache
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotPath(t *testing.T) {
+	path, err := snapshotPath("pre-gpt4o-upgrade")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(snapshotDir, "pre-gpt4o-upgrade.json"), path)
+
+	for _, name := range []string{"", "../escape", "a/b", ".hidden", `a\b`, "CON", "nul.backup", "com1"} {
+		_, err := snapshotPath(name)
+		assert.Error(t, err, "name %q should be rejected", name)
+	}
+}
+
+func TestResolveCachePath(t *testing.T) {
+	path, err := resolveCachePath("@baseline")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(snapshotDir, "baseline.json"), path)
+
+	path, err = resolveCachePath("other/cache.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "other/cache.json", path)
+}
+
+func TestReservedOnWindows(t *testing.T) {
+	for _, name := range []string{"CON", "aux", "Lpt9", "prn.json"} {
+		assert.True(t, reservedOnWindows(name), name)
+	}
+	for _, name := range []string{"console", "COM0", "COM10", "nightly"} {
+		assert.False(t, reservedOnWindows(name), name)
+	}
+}
@@ -0,0 +1,152 @@
+package llmcache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// modelPrice is the list price of a model in US dollars per million tokens.
+type modelPrice struct {
+	Prompt     float64
+	Completion float64
+}
+
+// modelPrices holds approximate list prices used for cost estimates. Models
+// are matched by the longest name they contain, so dated snapshots fall back
+// to their family and platform model IDs such as
+// "bedrock/anthropic.claude-3-haiku-20240307-v1:0" to the underlying model.
+var modelPrices = map[string]modelPrice{
+	"gpt-3.5-turbo":      {Prompt: 0.50, Completion: 1.50},
+	"gpt-3.5-turbo-1106": {Prompt: 1.00, Completion: 2.00},
+	"gpt-4":              {Prompt: 30.00, Completion: 60.00},
+	"gpt-4-turbo":        {Prompt: 10.00, Completion: 30.00},
+	"gpt-4o":             {Prompt: 2.50, Completion: 10.00},
+	"gpt-4o-mini":        {Prompt: 0.15, Completion: 0.60},
+	"claude-3-haiku":     {Prompt: 0.25, Completion: 1.25},
+	"claude-3-5-haiku":   {Prompt: 0.80, Completion: 4.00},
+	"claude-3-5-sonnet":  {Prompt: 3.00, Completion: 15.00},
+	"claude-3-7-sonnet":  {Prompt: 3.00, Completion: 15.00},
+	"claude-sonnet-4":    {Prompt: 3.00, Completion: 15.00},
+	"claude-3-opus":      {Prompt: 15.00, Completion: 75.00},
+	"claude-opus-4":      {Prompt: 15.00, Completion: 75.00},
+	"gemini-1.5-flash":   {Prompt: 0.075, Completion: 0.30},
+	"gemini-1.5-pro":     {Prompt: 1.25, Completion: 5.00},
+	"gemini-2.0-flash":   {Prompt: 0.10, Completion: 0.40},
+	// Embeddings only bill their input.
+	"text-embedding-ada-002": {Prompt: 0.10},
+	"text-embedding-3-small": {Prompt: 0.02},
+	"text-embedding-3-large": {Prompt: 0.13},
+}
+
+// Anthropic bills prompt cache writes and reads relative to the model's prompt
+// price.
+const (
+	providerCacheWriteMultiplier = 1.25
+	providerCacheReadMultiplier  = 0.10
+)
+
+func priceFor(model string) (modelPrice, bool) {
+	best := ""
+	for name := range modelPrices {
+		if strings.Contains(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return modelPrice{}, false
+	}
+	return modelPrices[best], true
+}
+
+// estimateCost returns the estimated cost in US dollars of usage on model, or
+// 0 if the model's price is unknown.
+func estimateCost(model string, usage openai.Usage) float64 {
+	price, _ := priceFor(model)
+	return (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) / 1_000_000
+}
+
+// RunStats counts what happened during one run of a CachingClient.
+type RunStats struct {
+	Hits      int `json:"hits"`
+	Misses    int `json:"misses"`
+	Evictions int `json:"evictions"`
+	// Oversized counts responses over the maximum entry size.
+	Oversized        int `json:"oversized,omitempty"`
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	// CachedPromptTokens counts, locally, the prompt tokens of requests served
+	// from the cache, which would otherwise have been sent to the API.
+	CachedPromptTokens int `json:"cached_prompt_tokens,omitempty"`
+	// CachedCompletionTokens counts the tokens of the responses served from
+	// the cache, and EstimatedSavings what the hits would have cost.
+	CachedCompletionTokens int     `json:"cached_completion_tokens,omitempty"`
+	EstimatedSavings       float64 `json:"estimated_savings_usd,omitempty"`
+	// RelaxedHits counts the hits served from the recording of a different
+	// request, by match rules or truncation replay.
+	RelaxedHits int `json:"relaxed_hits,omitempty"`
+	// MemoryHits counts the hits served from the hot cache, without reading
+	// the store.
+	MemoryHits int `json:"memory_hits,omitempty"`
+	// EmbeddingHits and EmbeddingMisses count embedded inputs, which are
+	// cached one by one.
+	EmbeddingHits   int `json:"embedding_hits,omitempty"`
+	EmbeddingMisses int `json:"embedding_misses,omitempty"`
+	// Shadowed counts the misses not sent to the API in Shadow mode.
+	Shadowed      int     `json:"shadowed,omitempty"`
+	EstimatedCost float64 `json:"estimated_cost_usd"`
+	// Provider-side prompt cache tokens, as reported by providers that cache
+	// prompts themselves.
+	ProviderCacheCreationTokens int `json:"provider_cache_creation_tokens,omitempty"`
+	ProviderCacheReadTokens     int `json:"provider_cache_read_tokens,omitempty"`
+}
+
+func (s *RunStats) recordUsage(model string, usage openai.Usage) {
+	s.PromptTokens += usage.PromptTokens
+	s.CompletionTokens += usage.CompletionTokens
+	s.EstimatedCost += estimateCost(model, usage)
+}
+
+func (s *RunStats) recordProviderCache(model string, usage ProviderCacheUsage) {
+	s.ProviderCacheCreationTokens += usage.CacheCreationInputTokens
+	s.ProviderCacheReadTokens += usage.CacheReadInputTokens
+	price, _ := priceFor(model)
+	s.EstimatedCost += (float64(usage.CacheCreationInputTokens)*providerCacheWriteMultiplier +
+		float64(usage.CacheReadInputTokens)*providerCacheReadMultiplier) * price.Prompt / 1_000_000
+}
+
+func (s RunStats) Summary() string {
+	summary := fmt.Sprintf("Run summary: %d hits, %d misses, %d evictions; %d prompt and %d completion tokens sent to the API, estimated cost $%.4f.",
+		s.Hits, s.Misses, s.Evictions, s.PromptTokens, s.CompletionTokens, s.EstimatedCost)
+	if s.EstimatedSavings > 0 {
+		summary += fmt.Sprintf(" Cache hits saved an estimated $%.4f.", s.EstimatedSavings)
+	}
+	if s.Oversized > 0 {
+		summary += fmt.Sprintf(" %d responses exceeded the maximum entry size.", s.Oversized)
+	}
+	if s.EmbeddingHits > 0 || s.EmbeddingMisses > 0 {
+		summary += fmt.Sprintf(" %d of %d embedded inputs were cached.", s.EmbeddingHits, s.EmbeddingHits+s.EmbeddingMisses)
+	}
+	if s.Shadowed > 0 {
+		summary += fmt.Sprintf(" %d requests weren't sent, in shadow mode.", s.Shadowed)
+	}
+	if s.RelaxedHits > 0 {
+		summary += fmt.Sprintf(" %d hits were relaxed matches.", s.RelaxedHits)
+	}
+	if s.ProviderCacheCreationTokens > 0 || s.ProviderCacheReadTokens > 0 {
+		summary += fmt.Sprintf(" The provider cached %d prompt tokens and served %d from its own cache.",
+			s.ProviderCacheCreationTokens, s.ProviderCacheReadTokens)
+	}
+	return summary
+}
+
+func writeStatsJSON(path string, s RunStats) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
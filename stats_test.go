@@ -0,0 +1,34 @@
+package llmcache
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateCost(t *testing.T) {
+	usage := openai.Usage{PromptTokens: 1_000_000, CompletionTokens: 1_000_000}
+	assert.InDelta(t, 2.00, estimateCost("gpt-3.5-turbo-0125", usage), 1e-9)
+	assert.InDelta(t, 3.00, estimateCost("gpt-3.5-turbo-1106", usage), 1e-9)
+	assert.InDelta(t, 0.75, estimateCost("gpt-4o-mini-2024-07-18", usage), 1e-9)
+	assert.Equal(t, 0.0, estimateCost("unknown-model", usage))
+}
+
+func TestCloseWritesStats(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.json")
+	client := &CachingClient{statsPath: path}
+	client.stats.Hits = 3
+	client.stats.recordUsage("gpt-3.5-turbo-0125", openai.Usage{PromptTokens: 10, CompletionTokens: 20})
+
+	assert.NoError(t, client.Close())
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	var stats RunStats
+	assert.NoError(t, json.Unmarshal(data, &stats))
+	assert.Equal(t, client.Stats(), stats)
+}
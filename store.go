@@ -0,0 +1,300 @@
+package llmcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Store persists the cache between runs. A store is opened lazily by its first
+// Load or Save and must be closed with Close to release any resources it holds.
+type Store interface {
+	Load() (*Cache, error)
+	Save(cache *Cache) error
+	// Flush makes sure everything saved so far is durable.
+	Flush() error
+	Close() error
+}
+
+var errStoreClosed = errors.New("store is closed")
+
+// fileStore keeps the cache in a single JSON file. While open it holds a lock
+// file next to the cache so that two processes never interleave their
+// read-modify-write cycles on the same cache.
+//
+// A read-only file store never takes the lock, so it works on read-only file
+// systems, and refuses to save.
+type fileStore struct {
+	path     string
+	readOnly bool
+	locked   bool
+	closed   bool
+	// compact is whether the file is written without indentation, decided
+	// by compactFor on the first save unless set.
+	compact *bool
+}
+
+// NewFileStore returns a store keeping the cache in the JSON file at path,
+// locked against other processes while it is open, as clients keep
+// cache/response-cache.json.
+func NewFileStore(path string) Store {
+	return newFileStore(path)
+}
+
+func newFileStore(path string) *fileStore {
+	return &fileStore{path: path}
+}
+
+func (s *fileStore) lockPath() string {
+	return s.path + ".lock"
+}
+
+// heldLocks counts, by lock file, the stores of this process holding it, so
+// that several clients can use one cache, as they could before it was
+// locked. The lock only keeps other processes out: clients in one process
+// don't serialize their saves against each other.
+var heldLocks = struct {
+	sync.Mutex
+	stores map[string]int
+}{stores: make(map[string]int)}
+
+// lock creates the lock file, recording our PID and host in it, unless this
+// store or another one of this process already holds it. A lock left behind
+// by a process on this host that is no longer running is removed and taken
+// over.
+func (s *fileStore) lock() error {
+	if s.closed {
+		return errStoreClosed
+	}
+	if s.locked {
+		return nil
+	}
+	heldLocks.Lock()
+	defer heldLocks.Unlock()
+	if heldLocks.stores[s.lockKey()] > 0 {
+		heldLocks.stores[s.lockKey()]++
+		s.locked = true
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.lockPath(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) && staleLock(s.lockPath()) {
+		removeFile(s.lockPath())
+		f, err = os.OpenFile(s.lockPath(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	}
+	if os.IsExist(err) {
+		holder := "another process"
+		if pid, host, err := readLock(s.lockPath()); err == nil {
+			holder = fmt.Sprintf("process %d on %s", pid, host)
+		}
+		return fmt.Errorf("%w: %s is held by %s; use -force-unlock if no other run is active", ErrStoreLocked, s.path, holder)
+	}
+	if err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	_, err = fmt.Fprintf(f, "%d\n%s\n", os.Getpid(), host)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		removeFile(s.lockPath())
+		return err
+	}
+	heldLocks.stores[s.lockKey()]++
+	s.locked = true
+	return nil
+}
+
+// lockKey identifies the lock file in heldLocks, whatever path it was opened
+// by.
+func (s *fileStore) lockKey() string {
+	if abs, err := filepath.Abs(s.lockPath()); err == nil {
+		return abs
+	}
+	return s.lockPath()
+}
+
+// readLock returns the PID and host recorded in the lock file at path. Locks
+// written before hosts were recorded have no host.
+func readLock(path string) (int, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, "", err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, "", fmt.Errorf("%s is empty", path)
+	}
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, "", fmt.Errorf("%s has no PID: %w", path, err)
+	}
+	host := ""
+	if len(fields) > 1 {
+		host = fields[1]
+	}
+	return pid, host, nil
+}
+
+// staleLock reports whether the lock file at path was left behind by a
+// process on this host that is no longer running. Locks held from other
+// hosts, e.g. on a shared volume, can't be checked and are never stale.
+func staleLock(path string) bool {
+	pid, host, err := readLock(path)
+	if err != nil {
+		return false
+	}
+	if self, _ := os.Hostname(); host != "" && host != self {
+		return false
+	}
+	return !processAlive(pid)
+}
+
+// forceUnlock removes the lock on the cache at path, whoever holds it.
+func forceUnlock(path string) error {
+	if err := removeFile(path + ".lock"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *fileStore) Load() (*Cache, error) {
+	if s.closed {
+		return nil, errStoreClosed
+	}
+	if !s.readOnly {
+		if err := s.lock(); err != nil {
+			return nil, err
+		}
+	}
+	return loadCacheFrom(s.path)
+}
+
+func (s *fileStore) Save(cache *Cache) error {
+	if s.readOnly {
+		return fmt.Errorf("%w: %s", ErrReadOnly, s.path)
+	}
+	if err := s.lock(); err != nil {
+		return err
+	}
+	if s.compact == nil {
+		compact := compactFor(s.path)
+		s.compact = &compact
+	}
+	return writeCache(s.path, cache, *s.compact)
+}
+
+// compactFor reports whether the cache file at path is written compactly by
+// default, which is unless git tracks it: indentation doubles the size of a
+// large cache, but committed fixtures are kept indented for readable diffs.
+func compactFor(path string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err := exec.CommandContext(ctx, "git", "-C", filepath.Dir(path), "ls-files", "--error-unmatch", "--", filepath.Base(path)).Run()
+	return err != nil
+}
+
+func (s *fileStore) Flush() error {
+	if s.closed {
+		return errStoreClosed
+	}
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// Close releases the lock file, once no other store of this process holds
+// it. Closing a closed store is a no-op.
+func (s *fileStore) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if !s.locked {
+		return nil
+	}
+	s.locked = false
+	heldLocks.Lock()
+	defer heldLocks.Unlock()
+	if heldLocks.stores[s.lockKey()]--; heldLocks.stores[s.lockKey()] > 0 {
+		return nil
+	}
+	delete(heldLocks.stores, s.lockKey())
+	if err := removeFile(s.lockPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// over path, so that a crash or a concurrent reader never sees a partly
+// written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, perm)
+	}
+	if err == nil {
+		err = replaceFile(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// SetCacheFile makes the client keep its cache in the file at path instead
+// of cache/response-cache.json. Call it before the client is first used.
+func (c *CachingClient) SetCacheFile(path string) {
+	c.store = newFileStore(path)
+}
+
+// SetReadOnly stops the client from ever writing its cache, for caches
+// checked in as fixtures: hits don't update timestamps, and requests that
+// would be recorded fail with ErrReadOnly. A file store is also made
+// read-only, so it stops taking its lock.
+func (c *CachingClient) SetReadOnly(readOnly bool) {
+	c.readOnly = readOnly
+	switch s := c.store.(type) {
+	case *fileStore:
+		s.readOnly = readOnly
+	case *archiveStore:
+		s.files.readOnly = readOnly
+	}
+}
+
+// SetCompact makes a file store write the cache without indentation, or
+// indented, instead of choosing by whether git tracks the file.
+func (c *CachingClient) SetCompact(compact bool) {
+	switch s := c.store.(type) {
+	case *fileStore:
+		s.compact = &compact
+	case *archiveStore:
+		s.files.compact = &compact
+	}
+}
@@ -0,0 +1,232 @@
+package llmcache
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestClient returns a caching client backed by a temporary cache file
+// seeded with cache, which is closed when the test ends.
+func newTestClient(t *testing.T, cache *Cache) *CachingClient {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cache.json")
+	if cache != nil {
+		assert.NoError(t, saveCacheTo(path, cache))
+	}
+	client := NewCachingClient("test-key", true, DefaultCacheSizeLimit)
+	client.store = newFileStore(path)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// newEchoClient returns a test client whose API answers every request with
+// the number of messages it received, from a dated snapshot of the requested
+// model.
+func newEchoClient(t *testing.T) (*CachingClient, *int) {
+	t.Helper()
+	fake := newFakeOpenAI(t)
+	fake.snapshot = "-2024-07-18"
+	fake.reply = func(req openai.ChatCompletionRequest) string {
+		return fmt.Sprintf("reply to %d messages", len(req.Messages))
+	}
+	return fake.newClient(t), &fake.calls
+}
+
+func TestFileStoreLocking(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	store := newFileStore(path)
+
+	cache := &Cache{Responses: map[string]CacheEntry{"abc": {Response: "hello"}}}
+	assert.NoError(t, store.Save(cache))
+	assert.FileExists(t, store.lockPath())
+
+	other := newFileStore(path)
+	loaded, err := other.Load()
+	assert.NoError(t, err, "stores of one process share the lock")
+	assert.Equal(t, "hello", loaded.Responses["abc"].Response)
+
+	assert.NoError(t, store.Flush())
+	assert.NoError(t, store.Close())
+	assert.FileExists(t, store.lockPath(), "the other store still holds the lock")
+	assert.NoError(t, store.Close(), "closing twice is a no-op")
+	_, err = store.Load()
+	assert.ErrorIs(t, err, errStoreClosed)
+	assert.NoError(t, other.Close())
+	assert.NoFileExists(t, store.lockPath())
+
+	// Another process running on this host holds the lock.
+	host, _ := os.Hostname()
+	assert.NoError(t, os.WriteFile(path+".lock", []byte(fmt.Sprintf("%d\n%s\n", os.Getppid(), host)), 0644))
+	_, err = newFileStore(path).Load()
+	assert.ErrorIs(t, err, ErrStoreLocked, "a store must not open a cache locked by another process")
+	assert.NoError(t, forceUnlock(path))
+}
+
+func TestClientsOfOneProcessShareACache(t *testing.T) {
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+	first, calls := newEchoClient(t)
+	second, _ := newEchoClient(t)
+	second.store = newFileStore(first.store.(*fileStore).path)
+
+	_, cached, err := first.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	response, cached, err := second.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.True(t, cached, "the second client reads the first one's recording")
+	assert.Equal(t, "reply to 1 messages", response)
+	assert.Equal(t, 1, *calls)
+}
+
+func TestSaveReplacesAtomically(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cache.json")
+	for _, response := range []string{"first", "second"} {
+		assert.NoError(t, saveCacheTo(path, &Cache{Responses: map[string]CacheEntry{"abc": {Response: response}}}))
+	}
+	cache, err := loadCacheFrom(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "second", cache.Responses["abc"].Response)
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1, "no temporary files are left behind")
+}
+
+// deadPID is above any PID limit, so no process ever has it.
+const deadPID = 1 << 30
+
+func TestStaleLockRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	host, _ := os.Hostname()
+	assert.NoError(t, os.WriteFile(path+".lock", []byte(fmt.Sprintf("%d\n%s\n", deadPID, host)), 0644))
+	store := newFileStore(path)
+	_, err := store.Load()
+	assert.NoError(t, err, "a lock left by a dead process is taken over")
+	pid, _, err := readLock(path + ".lock")
+	assert.NoError(t, err)
+	assert.Equal(t, os.Getpid(), pid)
+	assert.NoError(t, store.Close())
+
+	// Locks from other hosts can't be checked, so they are respected.
+	assert.NoError(t, os.WriteFile(path+".lock", []byte(fmt.Sprintf("%d\nanother-host\n", deadPID)), 0644))
+	_, err = newFileStore(path).Load()
+	assert.ErrorIs(t, err, ErrStoreLocked)
+	assert.Contains(t, err.Error(), "another-host")
+
+	assert.NoError(t, forceUnlock(path))
+	other := newFileStore(path)
+	_, err = other.Load()
+	assert.NoError(t, err)
+	assert.NoError(t, other.Close())
+}
+
+func TestReadOnlyClient(t *testing.T) {
+	seed := 1
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Seed: &seed, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+	hash, err := generateHash(req)
+	assert.NoError(t, err)
+	recorded := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	client, calls := newEchoClient(t)
+	path := filepath.Join(t.TempDir(), "cache.json")
+	assert.NoError(t, saveCacheTo(path, &Cache{Responses: map[string]CacheEntry{hash: {Response: "cached", Timestamp: recorded}}}))
+	before, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	client.store = newFileStore(path)
+	client.SetReadOnly(true)
+
+	response, cached, err := client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	assert.Equal(t, "cached", response)
+
+	req.Messages[0].Content = "Hello"
+	_, _, err = client.getResponse(context.Background(), req)
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.Zero(t, *calls, "a read-only client must not pay for responses it can't record")
+
+	after, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, string(before), string(after), "hits must not update timestamps")
+	assert.NoFileExists(t, path+".lock")
+	assert.ErrorIs(t, client.store.Save(&Cache{}), ErrReadOnly)
+}
+
+func TestCachingClientLifecycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.json")
+	req := openai.ChatCompletionRequest{
+		Model:    "gpt-3.5-turbo-0125",
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}},
+	}
+	hash, err := generateHash(req)
+	assert.NoError(t, err)
+	seed := &Cache{Responses: map[string]CacheEntry{hash: {Response: "Hello!", Timestamp: time.Now()}}}
+	assert.NoError(t, saveCacheTo(path, seed))
+
+	client := NewCachingClient("test-key", true, DefaultCacheSizeLimit)
+	client.store = newFileStore(path)
+
+	response, cached, err := client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	assert.Equal(t, "Hello!", response)
+	assert.NoError(t, client.Flush())
+
+	assert.NoError(t, client.Close())
+	_, err = os.Stat(path + ".lock")
+	assert.True(t, os.IsNotExist(err), "Close must release the lock")
+	assert.NoError(t, client.Close())
+
+	_, _, err = client.getResponse(context.Background(), req)
+	assert.ErrorIs(t, err, ErrClientClosed)
+	assert.ErrorIs(t, client.Flush(), ErrClientClosed)
+}
+
+// trackedCachePath returns the path of a cache file in a new git repository,
+// added to its index.
+func trackedCachePath(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cache.json")
+	assert.NoError(t, os.WriteFile(path, []byte("{}"), 0644))
+	for _, args := range [][]string{{"init", "-q"}, {"add", "cache.json"}} {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			t.Skipf("git %v: %v: %s", args, err, out)
+		}
+	}
+	return path
+}
+
+func TestCompactUnlessTracked(t *testing.T) {
+	cache := &Cache{Responses: map[string]CacheEntry{"key": {Response: "hello"}}}
+	untracked := filepath.Join(t.TempDir(), "cache.json")
+	assert.NoError(t, saveCacheTo(untracked, cache))
+	data, err := os.ReadFile(untracked)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "\n")
+
+	tracked := trackedCachePath(t)
+	assert.NoError(t, saveCacheTo(tracked, cache))
+	data, err = os.ReadFile(tracked)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), "\n  \"responses\": {")
+
+	// SetCompact overrides the choice.
+	client := newTestClient(t, nil)
+	client.store = newFileStore(tracked)
+	client.SetCompact(true)
+	assert.NoError(t, client.store.Save(cache))
+	data, err = os.ReadFile(tracked)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(data), "\n")
+	loaded, err := loadCacheFrom(tracked)
+	assert.NoError(t, err)
+	assert.Equal(t, cache.Responses, loaded.Responses)
+}
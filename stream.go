@@ -0,0 +1,164 @@
+package llmcache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// streamRecording carries the content chunks of a streamed response between
+// the provider, the cache and the proxy: a streamed response is recorded with
+// its chunk boundaries, and replayed with the same ones.
+type streamRecording struct {
+	chunks []string
+}
+
+type streamKey struct{}
+
+// withStreamRecording returns a context whose streamed response has its
+// chunks, recorded or replayed, collected in the returned recording.
+func withStreamRecording(ctx context.Context) (context.Context, *streamRecording) {
+	recording := &streamRecording{}
+	return context.WithValue(ctx, streamKey{}, recording), recording
+}
+
+func streamRecordingFrom(ctx context.Context) *streamRecording {
+	recording, _ := ctx.Value(streamKey{}).(*streamRecording)
+	return recording
+}
+
+// createStreamedCompletion streams req and assembles the chunks into a
+// regular response, recording the chunk boundaries in ctx's recording.
+func (p openaiProvider) createStreamedCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	stream, err := p.client.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	defer stream.Close()
+
+	recording := streamRecordingFrom(ctx)
+	var content strings.Builder
+	resp := openai.ChatCompletionResponse{Object: "chat.completion"}
+	finish := openai.FinishReasonStop
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+		resp.ID, resp.Created, resp.Model = chunk.ID, chunk.Created, chunk.Model
+		if chunk.Usage != nil {
+			resp.Usage = *chunk.Usage
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		if delta := chunk.Choices[0].Delta.Content; delta != "" {
+			content.WriteString(delta)
+			if recording != nil {
+				recording.chunks = append(recording.chunks, delta)
+			}
+		}
+		if reason := chunk.Choices[0].FinishReason; reason != "" {
+			finish = reason
+		}
+	}
+	resp.Choices = []openai.ChatCompletionChoice{{
+		Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content.String()},
+		FinishReason: finish,
+	}}
+	return resp, nil
+}
+
+// writeSSE writes response to w as a stream of server-sent events, one per
+// chunk, the way the chat completions API streams: a first chunk carrying the
+// role, the content chunks, a final chunk with the finish reason, and [DONE].
+func writeSSE(w http.ResponseWriter, model string, created int64, chunks []string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher, _ := w.(http.Flusher)
+	send := func(delta openai.ChatCompletionStreamChoiceDelta, finish openai.FinishReason) {
+		data, _ := json.Marshal(openai.ChatCompletionStreamResponse{
+			Object:  "chat.completion.chunk",
+			Created: created,
+			Model:   model,
+			Choices: []openai.ChatCompletionStreamChoice{{Delta: delta, FinishReason: finish}},
+		})
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	send(openai.ChatCompletionStreamChoiceDelta{Role: openai.ChatMessageRoleAssistant}, "")
+	for _, chunk := range chunks {
+		send(openai.ChatCompletionStreamChoiceDelta{Content: chunk}, "")
+	}
+	send(openai.ChatCompletionStreamChoiceDelta{}, openai.FinishReasonStop)
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	if flusher != nil {
+		flusher.Flush()
+	}
+}
+
+// Chunking modes for replaying streamed responses. Different client bugs
+// surface under different chunking: exact replays the recorded chunks, token
+// streams one token per chunk, and single sends the whole response at once.
+// Responses recorded without streaming, or changed by post-processing so
+// their chunks no longer add up to them, are streamed one token per chunk in
+// exact mode, so callers can switch to streaming without re-recording.
+const (
+	chunkExact  = "exact"
+	chunkToken  = "token"
+	chunkSingle = "single"
+)
+
+// rechunk returns the chunks to stream response in under mode.
+func rechunk(mode, model string, recorded []string, response string) ([]string, error) {
+	switch mode {
+	case chunkExact, "":
+		if len(recorded) > 0 && strings.Join(recorded, "") == response {
+			return recorded, nil
+		}
+		return tokenChunks(model, response)
+	case chunkSingle:
+		if response == "" {
+			return nil, nil
+		}
+		return []string{response}, nil
+	case chunkToken:
+		return tokenChunks(model, response)
+	}
+	return nil, fmt.Errorf("unknown chunking %q: use exact, token or single", mode)
+}
+
+// tokenChunks splits response into one chunk per token of model's encoding.
+// A token can end inside a multi-byte character, which is then carried over
+// into the next chunk.
+func tokenChunks(model, response string) ([]string, error) {
+	enc, err := encodingFor(model)
+	if err != nil {
+		return nil, err
+	}
+	var chunks []string
+	var pending []byte
+	for _, token := range enc.Encode(response, nil, nil) {
+		pending = append(pending, enc.Decode([]int{token})...)
+		if utf8.Valid(pending) {
+			chunks = append(chunks, string(pending))
+			pending = nil
+		}
+	}
+	if len(pending) > 0 {
+		chunks = append(chunks, string(pending))
+	}
+	return chunks, nil
+}
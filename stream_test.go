@@ -0,0 +1,153 @@
+package llmcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+// newStreamingClient returns a test client whose API streams chunks in reply
+// to every request, and counts the requests.
+func newStreamingClient(t *testing.T, chunks ...string) (*CachingClient, *int) {
+	t.Helper()
+	calls := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range chunks {
+			fmt.Fprintf(w, "data: {\"model\":\"gpt-4o-mini-2024-07-18\",\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", chunk)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(upstream.Close)
+
+	client := newTestClient(t, nil)
+	config := openai.DefaultConfig("test-key")
+	config.BaseURL = upstream.URL
+	client.Client = openai.NewClientWithConfig(config)
+	return client, &calls
+}
+
+func TestProxyReplaysStreamChunks(t *testing.T) {
+	client, calls := newStreamingClient(t, "Hel", "lo", " world")
+	server := httptest.NewServer(newProxy(client, ""))
+	t.Cleanup(server.Close)
+	config := openai.DefaultConfig("test-key")
+	config.BaseURL = server.URL + "/v1"
+	caller := openai.NewClientWithConfig(config)
+	seed := 1
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Seed: &seed, Stream: true, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+
+	for i := 0; i < 2; i++ {
+		stream, err := caller.CreateChatCompletionStream(context.Background(), req)
+		if !assert.NoError(t, err) {
+			return
+		}
+		var chunks []string
+		for {
+			chunk, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			assert.NoError(t, err)
+			if chunk.Choices[0].Delta.Content != "" {
+				chunks = append(chunks, chunk.Choices[0].Delta.Content)
+			}
+		}
+		stream.Close()
+		assert.Equal(t, []string{"Hel", "lo", " world"}, chunks, "request %d", i+1)
+	}
+	assert.Equal(t, 1, *calls)
+
+	cache, err := client.store.Load()
+	assert.NoError(t, err)
+	for _, entry := range cache.Responses {
+		assert.Equal(t, "Hello world", entry.Response)
+		assert.Equal(t, []string{"Hel", "lo", " world"}, entry.Chunks)
+	}
+}
+
+func TestExactChunkingFallsBackToTokens(t *testing.T) {
+	chunks, err := rechunk(chunkExact, "gpt-4o-mini", []string{"a", "b"}, "ab")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, chunks)
+
+	tokens, err := rechunk(chunkToken, "gpt-4o-mini", nil, "Hello, cached world")
+	assert.NoError(t, err)
+	chunks, err = rechunk(chunkExact, "gpt-4o-mini", []string{"a", "b"}, "Hello, cached world")
+	assert.NoError(t, err)
+	assert.Equal(t, tokens, chunks, "post-processed responses don't match their chunks")
+	chunks, err = rechunk(chunkExact, "gpt-4o-mini", nil, "Hello, cached world")
+	assert.NoError(t, err)
+	assert.Equal(t, tokens, chunks, "responses recorded without streaming have no chunks")
+}
+
+func TestRechunk(t *testing.T) {
+	recorded := []string{"Hello", " wor", "ld"}
+	exact, err := rechunk(chunkExact, "gpt-4o-mini", recorded, "Hello world")
+	assert.NoError(t, err)
+	assert.Equal(t, recorded, exact)
+
+	single, err := rechunk(chunkSingle, "gpt-4o-mini", recorded, "Hello world")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Hello world"}, single)
+
+	for _, response := range []string{"Hello world", "Grüße aus Köln 🌍"} {
+		tokens, err := rechunk(chunkToken, "gpt-4o-mini", recorded, response)
+		assert.NoError(t, err)
+		assert.Greater(t, len(tokens), 1)
+		assert.Equal(t, response, strings.Join(tokens, ""))
+		for _, token := range tokens {
+			assert.True(t, utf8.ValidString(token), "chunk %q splits a character", token)
+		}
+	}
+
+	_, err = rechunk("words", "gpt-4o-mini", recorded, "Hello world")
+	assert.Error(t, err)
+}
+
+func TestProxyStreamsPlainRecordings(t *testing.T) {
+	client, calls := newEchoClient(t)
+	server := httptest.NewServer(newProxy(client, ""))
+	t.Cleanup(server.Close)
+	config := openai.DefaultConfig("test-key")
+	config.BaseURL = server.URL + "/v1"
+	caller := openai.NewClientWithConfig(config)
+	seed := 1
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Seed: &seed, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+
+	resp, err := caller.CreateChatCompletion(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "reply to 1 messages", resp.Choices[0].Message.Content)
+
+	// The plain recording is replayed as a stream, one token per chunk.
+	req.Stream = true
+	stream, err := caller.CreateChatCompletionStream(context.Background(), req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer stream.Close()
+	var chunks []string
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		assert.NoError(t, err)
+		if chunk.Choices[0].Delta.Content != "" {
+			chunks = append(chunks, chunk.Choices[0].Delta.Content)
+		}
+	}
+	assert.Equal(t, 1, *calls)
+	assert.Greater(t, len(chunks), 1)
+	assert.Equal(t, "reply to 1 messages", strings.Join(chunks, ""))
+}
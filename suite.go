@@ -0,0 +1,627 @@
+package llmcache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Suite is a declarative set of prompts run against each of its models, with
+// assertions checked against every response.
+type Suite struct {
+	Models    []string `json:"models"`
+	Seed      *int     `json:"seed,omitempty"`
+	MaxTokens int      `json:"max_tokens,omitempty"`
+	Matrix    *Matrix  `json:"matrix,omitempty"`
+	// PostProcess names post-processors applied to every response before its
+	// assertions are checked.
+	PostProcess []string `json:"post_process,omitempty"`
+	// RerecordFailures re-records cached responses that fail their
+	// assertions and checks them again, to tell stale recordings from
+	// prompt regressions.
+	RerecordFailures bool        `json:"rerecord_failures,omitempty"`
+	Cases            []SuiteCase `json:"cases"`
+	// Filter, set by -filter, limits the suite to the runs it selects.
+	Filter runFilter `json:"-"`
+	// State, set by -state, records the progress of the run, and the runs a
+	// previous, interrupted one completed are reported without running them
+	// again.
+	State *runState `json:"-"`
+}
+
+// Matrix declares parameter grids. Every case is run for every combination of
+// the listed values; an empty list falls back to the suite-level setting.
+type Matrix struct {
+	Temperatures []float32 `json:"temperatures,omitempty"`
+	MaxTokens    []int     `json:"max_tokens,omitempty"`
+	Seeds        []int     `json:"seeds,omitempty"`
+}
+
+type SuiteCase struct {
+	Name       string      `json:"name"`
+	System     string      `json:"system,omitempty"`
+	Prompt     string      `json:"prompt"`
+	Assertions []Assertion `json:"assertions,omitempty"`
+	// PostProcess names post-processors applied after the suite's own.
+	PostProcess []string `json:"post_process,omitempty"`
+}
+
+// Assertion checks a response. Type is one of contains, not_contains, regex,
+// similar (ROUGE-L against Value, at least Threshold) or judge (Value is the
+// criteria passed to an LLM judge).
+type Assertion struct {
+	Type      string  `json:"type"`
+	Value     string  `json:"value"`
+	Threshold float64 `json:"threshold,omitempty"`
+	Model     string  `json:"model,omitempty"`
+}
+
+// suiteRun is one expanded combination of model, case and parameters.
+type suiteRun struct {
+	Model   string
+	Case    SuiteCase
+	Params  string
+	Request openai.ChatCompletionRequest
+	Hash    string
+}
+
+type CaseResult struct {
+	Model    string
+	Case     string
+	Params   string
+	Prompt   string
+	Response string
+	Cached   bool
+	Failures []string
+	Err      error
+	// Diagnosis explains a re-recorded failure.
+	Diagnosis string
+	// Resumed is set when the case was completed by a previous run and
+	// reported from its saved state.
+	Resumed bool
+}
+
+func (r CaseResult) Passed() bool {
+	return r.Err == nil && len(r.Failures) == 0
+}
+
+// Shadowed reports whether the case wasn't run because its request isn't
+// cached and the run is in Shadow mode.
+func (r CaseResult) Shadowed() bool {
+	return errors.Is(r.Err, ErrShadowed)
+}
+
+// defaultSuite is what main runs when no suite file is given.
+func defaultSuite() *Suite {
+	seed := 12345
+	suite := &Suite{
+		Models:    []string{"gpt-3.5-turbo-1106", "gpt-3.5-turbo-0125"},
+		Seed:      &seed,
+		MaxTokens: 100,
+	}
+	for _, prompt := range []string{
+		"Tell me a joke.",
+		"Explain the theory of relativity.",
+		"What's the capital of France?",
+		"How does a computer work?",
+		"What's the meaning of life?",
+	} {
+		suite.Cases = append(suite.Cases, SuiteCase{Name: prompt, Prompt: prompt})
+	}
+	return suite
+}
+
+func loadSuite(path string) (*Suite, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var suite Suite
+	if err := json.Unmarshal(data, &suite); err != nil {
+		return nil, fmt.Errorf("parsing suite %s: %w", path, err)
+	}
+	if len(suite.Models) == 0 {
+		return nil, fmt.Errorf("suite %s declares no models", path)
+	}
+	for i, sc := range suite.Cases {
+		if sc.Prompt == "" {
+			return nil, fmt.Errorf("suite %s: case %d has no prompt", path, i)
+		}
+		if sc.Name == "" {
+			suite.Cases[i].Name = sc.Prompt
+		}
+	}
+	return &suite, nil
+}
+
+func (s *Suite) request(model string, sc SuiteCase) openai.ChatCompletionRequest {
+	var messages []openai.ChatCompletionMessage
+	if sc.System != "" {
+		messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: sc.System})
+	}
+	messages = append(messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: sc.Prompt})
+	return openai.ChatCompletionRequest{
+		Model:     model,
+		Messages:  messages,
+		Seed:      s.Seed,
+		MaxTokens: s.MaxTokens,
+	}
+}
+
+// expand returns the cartesian product of models, cases and matrix
+// parameters selected by the suite's filter. Combinations that produce
+// identical requests, such as a temperature listed twice, are only returned
+// once.
+func (s *Suite) expand() ([]suiteRun, error) {
+	var m Matrix
+	if s.Matrix != nil {
+		m = *s.Matrix
+	}
+	temperatures := m.Temperatures
+	if len(temperatures) == 0 {
+		temperatures = []float32{0}
+	}
+	maxTokens := m.MaxTokens
+	if len(maxTokens) == 0 {
+		maxTokens = []int{s.MaxTokens}
+	}
+	var seeds []*int
+	for i := range m.Seeds {
+		seeds = append(seeds, &m.Seeds[i])
+	}
+	if len(seeds) == 0 {
+		seeds = []*int{s.Seed}
+	}
+
+	var runs []suiteRun
+	seen := make(map[string]bool)
+	for _, model := range s.Models {
+		for _, sc := range s.Cases {
+			for _, temperature := range temperatures {
+				for _, tokens := range maxTokens {
+					for _, seed := range seeds {
+						run := suiteRun{Model: model, Case: sc, Params: m.label(temperature, tokens, seed)}
+						if !s.Filter.match(run) {
+							continue
+						}
+						req := s.request(model, sc)
+						req.Temperature = temperature
+						req.MaxTokens = tokens
+						req.Seed = seed
+						hash, err := generateHash(req)
+						if err != nil {
+							return nil, err
+						}
+						if seen[hash] {
+							continue
+						}
+						seen[hash] = true
+						run.Request, run.Hash = req, hash
+						runs = append(runs, run)
+					}
+				}
+			}
+		}
+	}
+	if len(runs) == 0 && s.Filter != nil {
+		return nil, errors.New("no cases match -filter")
+	}
+	return runs, nil
+}
+
+// label describes the matrix parameters of one combination, listing only the
+// dimensions the matrix actually varies.
+func (m Matrix) label(temperature float32, maxTokens int, seed *int) string {
+	var parts []string
+	if len(m.Temperatures) > 0 {
+		parts = append(parts, fmt.Sprintf("temperature=%g", temperature))
+	}
+	if len(m.MaxTokens) > 0 {
+		parts = append(parts, fmt.Sprintf("max_tokens=%d", maxTokens))
+	}
+	if len(m.Seeds) > 0 && seed != nil {
+		parts = append(parts, fmt.Sprintf("seed=%d", *seed))
+	}
+	return strings.Join(parts, " ")
+}
+
+// checkAssertion returns a description of the failure, or "" if response
+// satisfies a.
+func (c *CachingClient) checkAssertion(ctx context.Context, a Assertion, prompt, response string) (string, error) {
+	switch a.Type {
+	case "contains":
+		if !strings.Contains(strings.ToLower(response), strings.ToLower(a.Value)) {
+			return fmt.Sprintf("does not contain %q", a.Value), nil
+		}
+	case "not_contains":
+		if strings.Contains(strings.ToLower(response), strings.ToLower(a.Value)) {
+			return fmt.Sprintf("contains %q", a.Value), nil
+		}
+	case "regex":
+		re, err := regexp.Compile(a.Value)
+		if err != nil {
+			return "", fmt.Errorf("invalid regex %q: %w", a.Value, err)
+		}
+		if !re.MatchString(response) {
+			return fmt.Sprintf("does not match /%s/", a.Value), nil
+		}
+	case "similar":
+		if score := RougeL(response, a.Value); score < a.Threshold {
+			return fmt.Sprintf("ROUGE-L %.3f below %.3f", score, a.Threshold), nil
+		}
+	case "judge":
+		model := a.Model
+		if model == "" {
+			model = "gpt-4o-mini"
+		}
+		verdict, err := c.judge(ctx, model, a.Value, prompt, response)
+		if err != nil {
+			return "", err
+		}
+		if !verdict.Pass {
+			return fmt.Sprintf("judge: %s", verdict.Reason), nil
+		}
+	default:
+		return "", fmt.Errorf("unknown assertion type %q", a.Type)
+	}
+	return "", nil
+}
+
+// runSuite runs every expanded combination of suite and checks its
+// assertions. Errors are recorded per case so one failing request doesn't stop
+// the rest of the suite.
+func (c *CachingClient) runSuite(ctx context.Context, suite *Suite) ([]CaseResult, error) {
+	runs, err := suite.expand()
+	if err != nil {
+		return nil, err
+	}
+	bar := c.newProgressBar(len(runs))
+	defer bar.finish()
+	mode, _ := modeFrom(ctx)
+	var results []CaseResult
+	suite.State.resumed(len(runs))
+	for _, run := range runs {
+		if err := ctx.Err(); err != nil {
+			return results, fmt.Errorf("stopped after %d of %d cases: %w", len(results), len(runs), err)
+		}
+		if item, done := suite.State.completed(run.Hash); done {
+			result := CaseResult{Model: run.Model, Case: run.Case.Name, Params: run.Params, Prompt: run.Case.Prompt,
+				Response: item.Response, Cached: item.Cached, Failures: item.Failures, Resumed: true}
+			results = append(results, result)
+			bar.advance(result)
+			continue
+		}
+		result := c.runCase(ctx, suite, run, false)
+		// Replay and Shadow runs never call the API, so they can't re-record.
+		if suite.RerecordFailures && mode != Shadow && mode != Replay && result.Err == nil && result.Cached && !result.Passed() {
+			// Tell a stale recording from a regression by re-recording the
+			// response live and checking it again.
+			result = c.runCase(ctx, suite, run, true)
+			if result.Err == nil {
+				result.Diagnosis = "prompt regression: fails live too"
+				if result.Passed() {
+					result.Diagnosis = "stale recording: re-recorded and passes"
+				}
+			}
+		}
+		if err := saveProgress(suite.State, run.Hash, result); err != nil {
+			return results, err
+		}
+		results = append(results, result)
+		bar.advance(result)
+	}
+	return results, nil
+}
+
+// saveProgress records the outcome of the run with key in state. Shadowed
+// runs weren't run, so they aren't recorded either way.
+func saveProgress(state *runState, key string, result CaseResult) error {
+	switch {
+	case result.Shadowed():
+		return nil
+	case result.Err != nil:
+		return state.fail(key, result.Err)
+	default:
+		return state.complete(key, itemState{Cached: result.Cached, Response: result.Response, Failures: result.Failures})
+	}
+}
+
+// runCase fetches the response of run, from the cache unless rerecord is set,
+// and checks the case's assertions against it.
+func (c *CachingClient) runCase(ctx context.Context, suite *Suite, run suiteRun, rerecord bool) CaseResult {
+	sc := run.Case
+	result := CaseResult{Model: run.Model, Case: sc.Name, Params: run.Params, Prompt: sc.Prompt}
+	runCtx := caseContext(ctx, run)
+	if rerecord {
+		runCtx = WithMode(runCtx, Record)
+	}
+	result.Response, result.Cached, result.Err = c.getResponse(runCtx, run.Request)
+	if result.Err != nil {
+		return result
+	}
+	processors, err := lookupPostProcessors(append(append([]string(nil), suite.PostProcess...), sc.PostProcess...))
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Response = applyPostProcessors(processors, result.Response)
+	for _, a := range sc.Assertions {
+		failure, err := c.checkAssertion(ctx, a, sc.Prompt, result.Response)
+		if err != nil {
+			result.Err = err
+			break
+		}
+		if failure != "" {
+			result.Failures = append(result.Failures, failure)
+		}
+	}
+	return result
+}
+
+// caseContext returns the context run is requested in.
+func caseContext(ctx context.Context, run suiteRun) context.Context {
+	if run.Case.Name != "" {
+		return WithLabel(ctx, run.Case.Name)
+	}
+	return ctx
+}
+
+// planSuite reports, without calling the API, which expanded combinations of
+// suite are already recorded in the client's cache and which running it in
+// ctx would record. The runs' hashes are the keys they are cached under.
+func (c *CachingClient) planSuite(ctx context.Context, suite *Suite) (cached, missing []suiteRun, err error) {
+	runs, err := suite.expand()
+	if err != nil {
+		return nil, nil, err
+	}
+	cache, err := c.store.Load()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, run := range runs {
+		if run.Hash, _, err = c.cacheKey(caseContext(ctx, run), c.redactSecrets(run.Request)); err != nil {
+			return nil, nil, err
+		}
+		if _, found := cache.Responses[run.Hash]; found {
+			cached = append(cached, run)
+		} else {
+			missing = append(missing, run)
+		}
+	}
+	return cached, missing, nil
+}
+
+// printSuiteResults prints one line per case and returns the number of cases
+// that failed.
+func printSuiteResults(results []CaseResult) int {
+	failed := 0
+	model := ""
+	for _, r := range results {
+		if r.Model != model {
+			model = r.Model
+			fmt.Fprintf(console, "Testing model: %s\n", model)
+		}
+		name := r.Case
+		if r.Params != "" {
+			name = fmt.Sprintf("%s [%s]", r.Case, r.Params)
+		}
+		if r.Shadowed() {
+			fmt.Fprintf(console, "SHADOW %s: not cached, not sent\n", name)
+			continue
+		}
+		if r.Err != nil {
+			failed++
+			fmt.Fprintf(console, "ERROR %s: %v\n", name, r.Err)
+			continue
+		}
+		source := "API"
+		if r.Cached {
+			source = "Cached"
+		}
+		if r.Resumed {
+			source = "Resumed"
+		}
+		status := "PASS"
+		if !r.Passed() {
+			failed++
+			status = "FAIL"
+		}
+		fmt.Fprintf(console, "%s %s (%s): %s\n", status, name, source, r.Response)
+		for _, failure := range r.Failures {
+			fmt.Fprintf(console, "    %s\n", failure)
+		}
+		if r.Diagnosis != "" {
+			fmt.Fprintf(console, "    %s\n", r.Diagnosis)
+		}
+	}
+	return failed
+}
+
+// caseReport is a CaseResult as reported by -output=json.
+type caseReport struct {
+	Model     string   `json:"model"`
+	Case      string   `json:"case"`
+	Params    string   `json:"params,omitempty"`
+	Cached    bool     `json:"cached"`
+	Passed    bool     `json:"passed"`
+	Shadowed  bool     `json:"shadowed,omitempty"`
+	Resumed   bool     `json:"resumed,omitempty"`
+	Response  string   `json:"response,omitempty"`
+	Failures  []string `json:"failures,omitempty"`
+	Diagnosis string   `json:"diagnosis,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// suiteReport is the result of a suite run under -output=json.
+type suiteReport struct {
+	Cases  []caseReport `json:"cases"`
+	Failed int          `json:"failed"`
+	Stats  RunStats     `json:"stats"`
+	Error  string       `json:"error,omitempty"`
+}
+
+func newSuiteReport(results []CaseResult, stats RunStats, err error) suiteReport {
+	report := suiteReport{Cases: []caseReport{}, Stats: stats}
+	for _, r := range results {
+		c := caseReport{Model: r.Model, Case: r.Case, Params: r.Params, Cached: r.Cached, Passed: r.Passed(), Shadowed: r.Shadowed(), Resumed: r.Resumed, Response: r.Response, Failures: r.Failures, Diagnosis: r.Diagnosis}
+		if r.Err != nil && !c.Shadowed {
+			c.Error = r.Err.Error()
+		}
+		if !c.Passed && !c.Shadowed {
+			report.Failed++
+		}
+		report.Cases = append(report.Cases, c)
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	return report
+}
+
+// runSuiteWithFlags runs suite with a client configured by flags, prints the
+// results and fails if any case failed.
+func runSuiteWithFlags(ctx context.Context, flags *clientFlags, suite *Suite) error {
+	client, err := flags.newClient(ctx)
+	if err != nil {
+		return err
+	}
+	if suite.Models, err = client.resolveModels(suite.Models); err != nil {
+		client.Close()
+		return err
+	}
+	// An interrupted run still reports the cases it finished.
+	results, runErr := client.runSuite(ctx, suite)
+	failed := printSuiteResults(results)
+	closeErr := client.Close()
+	if runErr == nil {
+		closeErr = errors.Join(closeErr, suite.State.finish())
+	}
+	if err := report(newSuiteReport(results, client.Stats(), errors.Join(runErr, closeErr))); err != nil {
+		return err
+	}
+	if runErr != nil {
+		return runErr
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	if failed > 0 {
+		return suiteFailure(results, failed)
+	}
+	if shadowed := client.Stats().Shadowed; shadowed > 0 {
+		fmt.Fprintf(console, "%d cases passed; %d requests would be recorded\n", len(results)-countShadowed(results), shadowed)
+		return nil
+	}
+	fmt.Fprintf(console, "All %d cases passed\n", len(results))
+	if misses := client.Stats().Misses; misses > 0 {
+		return fmt.Errorf("%w: %d requests weren't cached", errLiveCalls, misses)
+	}
+	return nil
+}
+
+func countShadowed(results []CaseResult) int {
+	n := 0
+	for _, r := range results {
+		if r.Shadowed() {
+			n++
+		}
+	}
+	return n
+}
+
+// suiteFailure returns the error of a run in which failed cases failed,
+// wrapping a corrupt cache or exhausted budget, which have exit codes of their
+// own, if a case ran into one.
+func suiteFailure(results []CaseResult, failed int) error {
+	err := fmt.Errorf("%d of %d cases failed", failed, len(results))
+	for _, sentinel := range []error{ErrCacheCorrupt, ErrBudgetExceeded} {
+		for _, r := range results {
+			if errors.Is(r.Err, sentinel) {
+				return fmt.Errorf("%w: %w", err, sentinel)
+			}
+		}
+	}
+	return err
+}
+
+func runSuiteCommand(args []string) error {
+	fs := flag.NewFlagSet("run-suite", flag.ContinueOnError)
+	flags := addClientFlags(fs, true)
+	plan := fs.Bool("plan", false, "List the expanded requests and which of them are already cached, without calling the API")
+	rerecord := fs.Bool("rerecord-failures", false, "Re-record cached responses that fail their assertions and check them again")
+	filter := fs.String("filter", "", filterUsage)
+	statePath := fs.String("state", "", "Save progress to this file and resume from it, reporting the cases an interrupted run already completed without running them again")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: llm-test-cache run-suite [flags] SUITE.json")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("run-suite needs a suite file")
+	}
+	suite, err := loadSuite(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *rerecord {
+		suite.RerecordFailures = true
+	}
+	if suite.Filter, err = parseRunFilter(*filter); err != nil {
+		return err
+	}
+	if *statePath != "" && !*plan {
+		if suite.State, err = loadRunState(*statePath, fs.Arg(0)); err != nil {
+			return err
+		}
+	}
+	if *plan {
+		flags.keyOptional = true
+		client, err := flags.newClient(context.Background())
+		if err != nil {
+			return err
+		}
+		// Only the store is closed: closing the client would print the
+		// summary of an empty run.
+		defer client.store.Close()
+		cached, missing, err := client.planSuite(context.Background(), suite)
+		if err != nil {
+			return err
+		}
+		type plannedRequest struct {
+			Key      string   `json:"key"`
+			Model    string   `json:"model"`
+			Case     string   `json:"case"`
+			Params   string   `json:"params,omitempty"`
+			Warnings []string `json:"warnings,omitempty"`
+			Invalid  string   `json:"invalid,omitempty"`
+		}
+		toRecord := []plannedRequest{}
+		for _, run := range missing {
+			fmt.Fprintf(console, "record %s %s %s %s\n", run.Hash, run.Model, run.Case.Name, run.Params)
+			warnings := lintRequest(run.Request)
+			for _, w := range warnings {
+				fmt.Fprintf(console, "  warning: %s\n", w)
+			}
+			planned := plannedRequest{Key: run.Hash, Model: run.Model, Case: run.Case.Name, Params: run.Params, Warnings: warnings}
+			if err := checkCapabilities(run.Request); err != nil {
+				fmt.Fprintf(console, "  error: %v\n", err)
+				planned.Invalid = err.Error()
+			}
+			toRecord = append(toRecord, planned)
+		}
+		fmt.Fprintf(console, "%d requests: %d already cached, %d to record\n", len(cached)+len(missing), len(cached), len(missing))
+		return report(map[string]any{"cached": len(cached), "to_record": toRecord})
+	}
+	return runSuiteWithFlags(interruptContext(), flags, suite)
+}
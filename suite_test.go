@@ -0,0 +1,199 @@
+package llmcache
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadSuite(t *testing.T) {
+	suite, err := loadSuite("examples/suite.json")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gpt-3.5-turbo-0125"}, suite.Models)
+	assert.Len(t, suite.Cases, 2)
+
+	req := suite.request("gpt-3.5-turbo-0125", suite.Cases[1])
+	assert.Len(t, req.Messages, 2)
+	assert.Equal(t, 12345, *req.Seed)
+	assert.Equal(t, 100, req.MaxTokens)
+
+	path := filepath.Join(t.TempDir(), "suite.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"cases": [{"prompt": "hi"}]}`), 0644))
+	_, err = loadSuite(path)
+	assert.Error(t, err, "a suite without models should be rejected")
+}
+
+func TestCheckAssertion(t *testing.T) {
+	client := &CachingClient{}
+	ctx := context.Background()
+	response := "The capital of France is Paris."
+
+	cases := []struct {
+		assertion Assertion
+		pass      bool
+	}{
+		{Assertion{Type: "contains", Value: "paris"}, true},
+		{Assertion{Type: "contains", Value: "Lyon"}, false},
+		{Assertion{Type: "not_contains", Value: "Berlin"}, true},
+		{Assertion{Type: "regex", Value: `capital of \w+`}, true},
+		{Assertion{Type: "similar", Value: "Paris is the capital of France.", Threshold: 0.5}, true},
+		{Assertion{Type: "similar", Value: "Bananas are yellow.", Threshold: 0.5}, false},
+	}
+	for _, c := range cases {
+		failure, err := client.checkAssertion(ctx, c.assertion, "", response)
+		assert.NoError(t, err)
+		assert.Equal(t, c.pass, failure == "", "%+v", c.assertion)
+	}
+
+	_, err := client.checkAssertion(ctx, Assertion{Type: "bogus"}, "", response)
+	assert.Error(t, err)
+}
+
+func TestSuiteExpandMatrix(t *testing.T) {
+	suite := &Suite{
+		Models:    []string{"model-a", "model-b"},
+		MaxTokens: 50,
+		Matrix: &Matrix{
+			Temperatures: []float32{0, 0.7, 0},
+			Seeds:        []int{1, 2},
+		},
+		Cases: []SuiteCase{{Name: "joke", Prompt: "Tell me a joke."}},
+	}
+
+	runs, err := suite.expand()
+	assert.NoError(t, err)
+	// 2 models x 2 distinct temperatures x 2 seeds; the repeated temperature is deduplicated.
+	assert.Len(t, runs, 8)
+	assert.Equal(t, "temperature=0 seed=1", runs[0].Params)
+	assert.Equal(t, 50, runs[0].Request.MaxTokens)
+	assert.Equal(t, 2, *runs[1].Request.Seed)
+
+	cache := &Cache{Responses: map[string]CacheEntry{runs[0].Hash: {Response: "recorded"}}}
+	cached, missing, err := newTestClient(t, cache).planSuite(context.Background(), suite)
+	assert.NoError(t, err)
+	assert.Len(t, cached, 1)
+	assert.Len(t, missing, 7)
+}
+
+func TestPlanMatchesRun(t *testing.T) {
+	seed := 1
+	suite := &Suite{
+		Models: []string{"gpt-4o-mini"},
+		Seed:   &seed,
+		Cases:  []SuiteCase{{Name: "greeting", Prompt: "Hi"}, {Name: "farewell", Prompt: "Bye"}},
+	}
+	client, calls := newEchoClient(t)
+	client.namespace = "team-a"
+	ctx := context.Background()
+
+	_, missing, err := client.planSuite(ctx, suite)
+	assert.NoError(t, err)
+	assert.Len(t, missing, 2)
+	_, err = client.runSuite(ctx, suite)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, *calls)
+
+	cached, missing, err := client.planSuite(ctx, suite)
+	assert.NoError(t, err)
+	assert.Len(t, cached, 2, "the plan keys requests as the run does, within the client's namespace")
+	assert.Empty(t, missing)
+}
+
+func TestRerecordFailures(t *testing.T) {
+	seed := 1
+	suite := &Suite{
+		Models:           []string{"gpt-4o-mini"},
+		Seed:             &seed,
+		RerecordFailures: true,
+		Cases: []SuiteCase{
+			{Name: "stale", Prompt: "One", Assertions: []Assertion{{Type: "contains", Value: "reply"}}},
+			{Name: "regressed", Prompt: "Two", Assertions: []Assertion{{Type: "contains", Value: "never"}}},
+		},
+	}
+	runs, err := suite.expand()
+	assert.NoError(t, err)
+	client, calls := newEchoClient(t)
+	cache := &Cache{Responses: map[string]CacheEntry{}}
+	for _, run := range runs {
+		cache.Responses[run.Hash] = CacheEntry{Response: "old recording"}
+	}
+	assert.NoError(t, client.store.Save(cache))
+
+	results, err := client.runSuite(context.Background(), suite)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, *calls)
+	if assert.Len(t, results, 2) {
+		assert.True(t, results[0].Passed())
+		assert.Contains(t, results[0].Diagnosis, "stale recording")
+		assert.False(t, results[1].Passed())
+		assert.Contains(t, results[1].Diagnosis, "prompt regression")
+	}
+}
+
+func TestReplaySuiteDoesNotRerecord(t *testing.T) {
+	seed := 1
+	suite := &Suite{
+		Models:           []string{"gpt-4o-mini"},
+		Seed:             &seed,
+		RerecordFailures: true,
+		Cases:            []SuiteCase{{Name: "stale", Prompt: "One", Assertions: []Assertion{{Type: "contains", Value: "reply"}}}},
+	}
+	runs, err := suite.expand()
+	assert.NoError(t, err)
+	fake := newFakeOpenAI(t)
+	fake.reply = func(req openai.ChatCompletionRequest) string {
+		t.Errorf("the API was called during a replay: %s", promptText(req))
+		return "reply"
+	}
+	client := fake.newClient(t)
+	assert.NoError(t, client.store.Save(&Cache{Responses: map[string]CacheEntry{runs[0].Hash: {Response: "old recording"}}}))
+
+	results, err := client.runSuite(WithMode(context.Background(), Replay), suite)
+	assert.NoError(t, err)
+	assert.Zero(t, fake.Calls())
+	if assert.Len(t, results, 1) {
+		assert.False(t, results[0].Passed(), "the failing recording is reported as it is")
+		assert.Empty(t, results[0].Diagnosis)
+	}
+}
+
+func TestInterruptedSuite(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The API is interrupted while answering the first case.
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		io.Copy(io.Discard, r.Body)
+		cancel()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	client := newTestClient(t, nil)
+	config := openai.DefaultConfig("test-key")
+	config.BaseURL = server.URL
+	client.Client = openai.NewClientWithConfig(config)
+
+	suite := &Suite{
+		Models: []string{"gpt-4o-mini"},
+		Cases:  []SuiteCase{{Name: "first", Prompt: "One"}, {Name: "second", Prompt: "Two"}},
+	}
+	results, err := client.runSuite(ctx, suite)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, calls)
+	if assert.Len(t, results, 1) {
+		assert.ErrorIs(t, results[0].Err, context.Canceled)
+	}
+	assert.NoError(t, client.Close())
+	cache, err := loadCacheFrom(client.store.(*fileStore).path)
+	assert.NoError(t, err)
+	assert.Empty(t, cache.Responses)
+	assert.NoFileExists(t, client.store.(*fileStore).lockPath())
+}
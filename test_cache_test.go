@@ -1,4 +1,4 @@
-package main
+package llmcache
 
 import (
 	"context"
@@ -14,7 +14,7 @@ var (
 	keepCache        = flag.Bool("keep-cache", false, "Keep the cache after tests for manual inspection")
 	maxTokens        = flag.Int("max-tokens", 0, "Maximum tokens for the ChatCompletion request")
 	testCacheability = flag.Bool("test-cacheability", false, "Test if the API configuration is deterministic")
-	cacheSizeLimit   = flag.Int64("cache-size-limit", defaultCacheSizeLimit, "Cache size limit in bytes")
+	cacheSizeLimit   = flag.Int64("cache-size-limit", DefaultCacheSizeLimit, "Cache size limit in bytes")
 )
 
 func TestMain(m *testing.M) {
@@ -22,73 +22,43 @@ func TestMain(m *testing.M) {
 	os.Exit(m.Run())
 }
 
-func TestCacheAPIResponses(t *testing.T) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		t.Fatal("Error: OPENAI_API_KEY environment variable not set. Please set it to run the tests.")
-	} else {
-		t.Log("Found OPENAI_API_KEY environment variable.")
-	}
-
-	client := NewCachingClient(apiKey, true, *cacheSizeLimit)
-	ctx := context.Background()
-
-	models := []string{"gpt-3.5-turbo-1106", "gpt-3.5-turbo-0125"}
-	prompts := []string{
+// testModels and testPrompts are the requests TestCacheAPIResponses makes, and
+// its live counterpart.
+var (
+	testModels  = []string{"gpt-3.5-turbo-1106", "gpt-3.5-turbo-0125"}
+	testPrompts = []string{
 		"Tell me a joke.",
 		"Explain the theory of relativity.",
 		"What's the capital of France?",
 		"How does a computer work?",
 		"What's the meaning of life?",
 	}
+)
 
+func TestCacheAPIResponses(t *testing.T) {
+	fake := newFakeOpenAI(t)
+	client := fake.newClient(t)
+	ctx := context.Background()
 	seed := 12345
 
-	for _, model := range models {
+	for _, model := range testModels {
 		t.Run(model, func(t *testing.T) {
 			for i := 0; i < 3; i++ {
-				for _, prompt := range prompts {
+				for _, prompt := range testPrompts {
 					req := openai.ChatCompletionRequest{
-						Model: model,
-						Messages: []openai.ChatCompletionMessage{
-							{Role: "user", Content: prompt},
-						},
-						Seed:      &seed,
-						MaxTokens: *maxTokens,
+						Model:    model,
+						Messages: []openai.ChatCompletionMessage{{Role: "user", Content: prompt}},
+						Seed:     &seed,
 					}
 					response, cached, err := client.getResponse(ctx, req)
 					assert.NoError(t, err)
-					if i == 0 {
-						assert.False(t, cached, "First run should be a cache miss")
-					} else {
-						assert.True(t, cached, "Subsequent runs should use cache")
-					}
-					t.Logf("Response for prompt '%s': %s\n", prompt, response)
+					assert.Equal(t, i > 0, cached, "only the first run should be a cache miss")
+					assert.Equal(t, deterministicReply(req), response)
 				}
 			}
-
-			if *testCacheability {
-				// Test for deterministic responses
-				req := openai.ChatCompletionRequest{
-					Model: model,
-					Messages: []openai.ChatCompletionMessage{
-						{Role: "user", Content: prompts[0]},
-					},
-					Seed:      &seed,
-					MaxTokens: *maxTokens,
-				}
-				response1, _, err := client.getResponse(ctx, req)
-				assert.NoError(t, err)
-				response2, _, err := client.getResponse(ctx, req)
-				assert.NoError(t, err)
-				assert.Equal(t, response1, response2, "API / model is not behaving in a cacheable way")
-			}
 		})
 	}
 
-	// Clear cache after tests unless keepCache flag is set
-	if !*keepCache {
-		err := clearCache()
-		assert.NoError(t, err, "Failed to clear cache")
-	}
+	assert.Equal(t, len(testModels)*len(testPrompts), fake.Calls())
+	assert.NoError(t, client.Close())
 }
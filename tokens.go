@@ -0,0 +1,61 @@
+package llmcache
+
+import (
+	"sync"
+
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+	"github.com/sashabaranov/go-openai"
+)
+
+// Chat messages carry a few tokens of framing on top of their content, as
+// described in OpenAI's guide to counting tokens.
+const (
+	tokensPerMessage = 3
+	tokensPerName    = 1
+	tokensPerReply   = 3
+)
+
+var (
+	encodingsMu sync.Mutex
+	encodings   = map[string]*tiktoken.Tiktoken{}
+	loaderOnce  sync.Once
+)
+
+// encodingFor returns the tokenizer for model. The encodings are embedded in
+// the binary, so counting tokens never touches the network. Models tiktoken
+// doesn't know, such as Claude or local models, are counted with cl100k_base,
+// which is close enough for budgeting.
+func encodingFor(model string) (*tiktoken.Tiktoken, error) {
+	loaderOnce.Do(func() { tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader()) })
+	encodingsMu.Lock()
+	defer encodingsMu.Unlock()
+	if enc, found := encodings[model]; found {
+		return enc, nil
+	}
+	enc, err := tiktoken.EncodingForModel(model)
+	if err != nil {
+		if enc, err = tiktoken.GetEncoding(tiktoken.MODEL_CL100K_BASE); err != nil {
+			return nil, err
+		}
+	}
+	encodings[model] = enc
+	return enc, nil
+}
+
+// countPromptTokens counts the prompt tokens of req locally, whether or not it
+// will be served from the cache.
+func countPromptTokens(req openai.ChatCompletionRequest) (int, error) {
+	enc, err := encodingFor(req.Model)
+	if err != nil {
+		return 0, err
+	}
+	tokens := tokensPerReply
+	for _, m := range req.Messages {
+		tokens += tokensPerMessage + len(enc.Encode(m.Role, nil, nil)) + len(enc.Encode(m.Content, nil, nil))
+		if m.Name != "" {
+			tokens += tokensPerName + len(enc.Encode(m.Name, nil, nil))
+		}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,46 @@
+package llmcache
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountPromptTokens(t *testing.T) {
+	req := openai.ChatCompletionRequest{
+		Model:    "gpt-4o",
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hello world"}},
+	}
+	tokens, err := countPromptTokens(req)
+	assert.NoError(t, err)
+	// 3 for the reply, 3 framing the message, 1 for the role, 2 for the content.
+	assert.Equal(t, 9, tokens)
+
+	req.Model = "claude-3-5-haiku-20241022"
+	_, err = countPromptTokens(req)
+	assert.NoError(t, err, "unknown models fall back to cl100k_base")
+}
+
+func TestBudgetRefusesExpensivePrompt(t *testing.T) {
+	client := newTestClient(t, nil)
+	client.maxCost = 0.001
+	seed := 1
+	req := openai.ChatCompletionRequest{
+		Model:    "gpt-4",
+		Seed:     &seed,
+		Messages: []openai.ChatCompletionMessage{{Role: "user", Content: strings.Repeat("token ", 1000)}},
+	}
+	_, _, err := client.getResponse(context.Background(), req)
+	assert.ErrorIs(t, err, ErrBudgetExceeded)
+}
+
+func TestContextWindow(t *testing.T) {
+	window, known := contextWindow("gpt-4o-mini-2024-07-18")
+	assert.True(t, known)
+	assert.Equal(t, 128000, window)
+	_, known = contextWindow("llama3")
+	assert.False(t, known)
+}
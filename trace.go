@@ -0,0 +1,295 @@
+package llmcache
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// TraceOptions configure forwarding traces to an observability platform.
+type TraceOptions struct {
+	// Platform is "helicone" or "langsmith".
+	Platform string
+	// APIKey defaults to $HELICONE_API_KEY or $LANGSMITH_API_KEY.
+	APIKey string
+	// Endpoint replaces the platform's API URL, e.g. for a self-hosted
+	// instance. It defaults to $LANGSMITH_ENDPOINT for LangSmith.
+	Endpoint string
+	// Project is the LangSmith project runs are logged to, by default
+	// $LANGSMITH_PROJECT or "default".
+	Project string
+	// Hits also forwards requests answered from the cache, marked as hits.
+	Hits bool
+}
+
+const (
+	heliconeLogURL      = "https://api.worker.helicone.ai/custom/v1/log"
+	langsmithURL        = "https://api.smith.langchain.com"
+	traceQueueSize      = 64
+	traceResponseLength = 1 << 10
+)
+
+// traceForwarder posts a trace per request in the background, so that a slow
+// platform doesn't slow tests down.
+type traceForwarder struct {
+	platform string
+	httpc    *http.Client
+	encode   func(Event) (*http.Request, error)
+	hits     bool
+	queue    chan Event
+	done     chan struct{}
+	sent     int
+	failed   int
+	err      error
+}
+
+// ForwardTraces sends the metadata of every request the client sends to the
+// API, and with opts.Hits of those it answers from the cache, to Helicone or
+// LangSmith, so the platform still sees the traffic the cache hides. Traces
+// are sent in the background; Close waits for them and warns about failures
+// instead of failing the run.
+func (c *CachingClient) ForwardTraces(opts TraceOptions) error {
+	var encode func(Event) (*http.Request, error)
+	switch opts.Platform {
+	case "helicone":
+		if opts.APIKey == "" {
+			opts.APIKey = os.Getenv("HELICONE_API_KEY")
+		}
+		if opts.Endpoint == "" {
+			opts.Endpoint = heliconeLogURL
+		}
+		encode = func(e Event) (*http.Request, error) { return heliconeTrace(opts, e) }
+	case "langsmith":
+		if opts.APIKey == "" {
+			opts.APIKey = os.Getenv("LANGSMITH_API_KEY")
+		}
+		if opts.Endpoint == "" {
+			opts.Endpoint = os.Getenv("LANGSMITH_ENDPOINT")
+		}
+		if opts.Endpoint == "" {
+			opts.Endpoint = langsmithURL
+		}
+		if opts.Project == "" {
+			opts.Project = os.Getenv("LANGSMITH_PROJECT")
+		}
+		if opts.Project == "" {
+			opts.Project = "default"
+		}
+		encode = func(e Event) (*http.Request, error) { return langsmithTrace(opts, e) }
+	default:
+		return fmt.Errorf("unknown trace platform %q: use helicone or langsmith", opts.Platform)
+	}
+	if opts.APIKey == "" {
+		return fmt.Errorf("forwarding traces to %s needs an API key", opts.Platform)
+	}
+	f := &traceForwarder{
+		platform: opts.Platform,
+		httpc:    c.httpClient(),
+		encode:   encode,
+		hits:     opts.Hits,
+		queue:    make(chan Event, traceQueueSize),
+		done:     make(chan struct{}),
+	}
+	go f.run()
+	c.traces = f
+	c.OnEvent(f.record)
+	return nil
+}
+
+func (f *traceForwarder) record(e Event) {
+	switch e.Kind {
+	case EntryStored, LiveServed, UpstreamFailed:
+	case EntryServed:
+		if !f.hits {
+			return
+		}
+	default:
+		return
+	}
+	f.queue <- e
+}
+
+func (f *traceForwarder) run() {
+	defer close(f.done)
+	for e := range f.queue {
+		f.sent++
+		if err := f.send(e); err != nil {
+			f.failed++
+			if f.err == nil {
+				f.err = err
+			}
+		}
+	}
+}
+
+func (f *traceForwarder) send(e Event) error {
+	req, err := f.encode(e)
+	if err != nil {
+		return err
+	}
+	resp, err := f.httpc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, traceResponseLength))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// Close waits for the queued traces to be sent and warns if any couldn't be.
+func (f *traceForwarder) Close() {
+	close(f.queue)
+	<-f.done
+	if f.failed > 0 {
+		fmt.Fprintf(console, "Warning: %d of %d traces couldn't be forwarded to %s: %v\n", f.failed, f.sent, f.platform, f.err)
+	}
+}
+
+// traceSpan returns when the request of e started and ended.
+func traceSpan(e Event) (time.Time, time.Time) {
+	return e.Time.Add(-e.Latency), e.Time
+}
+
+// traceResponse returns the response of e in the shape of the API's, or nil
+// for failed requests.
+func traceResponse(e Event) any {
+	if e.Kind == UpstreamFailed {
+		return nil
+	}
+	resp := chatCompletion(e.Model, e.Time.Unix(), e.Response)
+	resp.Usage = e.Usage
+	return resp
+}
+
+func cacheStatus(e Event) string {
+	if e.Kind == EntryServed {
+		return "hit"
+	}
+	return "miss"
+}
+
+// postJSON returns a POST request of v as JSON to url.
+func postJSON(url string, v any) (*http.Request, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req, nil
+}
+
+type heliconeTime struct {
+	Seconds      int64 `json:"seconds"`
+	Milliseconds int64 `json:"milliseconds"`
+}
+
+func heliconeTimeOf(t time.Time) heliconeTime {
+	return heliconeTime{Seconds: t.Unix(), Milliseconds: int64(t.Nanosecond() / int(time.Millisecond))}
+}
+
+// heliconeTrace logs e through Helicone's custom logging API. Cache status,
+// key and label are sent as Helicone properties.
+func heliconeTrace(opts TraceOptions, e Event) (*http.Request, error) {
+	start, end := traceSpan(e)
+	meta := map[string]string{
+		"Helicone-Property-Cache":     cacheStatus(e),
+		"Helicone-Property-Cache-Key": e.Hash,
+	}
+	if e.Label != "" {
+		meta["Helicone-Property-Label"] = e.Label
+	}
+	if e.Namespace != "" {
+		meta["Helicone-Property-Namespace"] = e.Namespace
+	}
+	status, response := http.StatusOK, traceResponse(e)
+	if e.Kind == UpstreamFailed {
+		status = http.StatusBadGateway
+		response = map[string]any{"error": map[string]string{"message": e.Err.Error()}}
+	}
+	req, err := postJSON(opts.Endpoint, map[string]any{
+		"providerRequest": map[string]any{
+			"url":  "llm-test-cache",
+			"json": e.Request,
+			"meta": meta,
+		},
+		"providerResponse": map[string]any{
+			"json":    response,
+			"status":  status,
+			"headers": map[string]string{},
+		},
+		"timing": map[string]any{
+			"startTime": heliconeTimeOf(start),
+			"endTime":   heliconeTimeOf(end),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+opts.APIKey)
+	return req, nil
+}
+
+// langsmithTrace logs e as a finished LLM run in the LangSmith project of
+// opts, tagged with its cache status.
+func langsmithTrace(opts TraceOptions, e Event) (*http.Request, error) {
+	id, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+	start, end := traceSpan(e)
+	name := e.Label
+	if name == "" {
+		name = "ChatCompletion"
+	}
+	run := map[string]any{
+		"id":           id,
+		"name":         name,
+		"run_type":     "llm",
+		"inputs":       e.Request,
+		"start_time":   start.UTC().Format(time.RFC3339Nano),
+		"end_time":     end.UTC().Format(time.RFC3339Nano),
+		"session_name": opts.Project,
+		"tags":         []string{"llm-test-cache", "cache-" + cacheStatus(e)},
+		"extra": map[string]any{"metadata": map[string]string{
+			"cache":         cacheStatus(e),
+			"cache_key":     e.Hash,
+			"namespace":     e.Namespace,
+			"ls_model_name": e.Model,
+		}},
+	}
+	if e.Kind == UpstreamFailed {
+		run["error"] = e.Err.Error()
+	} else {
+		run["outputs"] = traceResponse(e)
+	}
+	req, err := postJSON(strings.TrimSuffix(opts.Endpoint, "/")+"/runs", run)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Api-Key", opts.APIKey)
+	return req, nil
+}
+
+// newUUID returns a random version 4 UUID.
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
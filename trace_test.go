@@ -0,0 +1,88 @@
+package llmcache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+// newTraceServer returns a server recording the path, headers and JSON body
+// of every trace posted to it.
+func newTraceServer(t *testing.T) (*httptest.Server, func() []*http.Request, func() []map[string]any) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []*http.Request
+	var bodies []map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, r)
+		bodies = append(bodies, body)
+	}))
+	t.Cleanup(server.Close)
+	return server,
+		func() []*http.Request { mu.Lock(); defer mu.Unlock(); return requests },
+		func() []map[string]any { mu.Lock(); defer mu.Unlock(); return bodies }
+}
+
+func TestForwardTracesToLangSmith(t *testing.T) {
+	server, requests, bodies := newTraceServer(t)
+	client, _ := newEchoClient(t)
+	assert.NoError(t, client.ForwardTraces(TraceOptions{Platform: "langsmith", APIKey: "ls-key", Endpoint: server.URL, Project: "tests"}))
+
+	req := openai.ChatCompletionRequest{Model: openai.GPT3Dot5Turbo, Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}}}
+	for i := 0; i < 2; i++ {
+		_, _, err := client.getResponse(WithLabel(context.Background(), "greeting"), req)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, client.Close())
+
+	// Only the miss is forwarded.
+	if assert.Len(t, requests(), 1) {
+		assert.Equal(t, "/runs", requests()[0].URL.Path)
+		assert.Equal(t, "ls-key", requests()[0].Header.Get("X-Api-Key"))
+		run := bodies()[0]
+		assert.Equal(t, "greeting", run["name"])
+		assert.Equal(t, "llm", run["run_type"])
+		assert.Equal(t, "tests", run["session_name"])
+		assert.Equal(t, []any{"llm-test-cache", "cache-miss"}, run["tags"])
+		assert.Equal(t, "reply to 1 messages", run["outputs"].(map[string]any)["choices"].([]any)[0].(map[string]any)["message"].(map[string]any)["content"])
+	}
+}
+
+func TestForwardTracesToHelicone(t *testing.T) {
+	server, requests, bodies := newTraceServer(t)
+	client, _ := newEchoClient(t)
+	assert.NoError(t, client.ForwardTraces(TraceOptions{Platform: "helicone", APIKey: "hc-key", Endpoint: server.URL, Hits: true}))
+
+	req := openai.ChatCompletionRequest{Model: openai.GPT3Dot5Turbo, Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}}}
+	for i := 0; i < 2; i++ {
+		_, _, err := client.getResponse(context.Background(), req)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, client.Close())
+
+	if assert.Len(t, requests(), 2) {
+		assert.Equal(t, "Bearer hc-key", requests()[0].Header.Get("Authorization"))
+		var statuses []any
+		for _, body := range bodies() {
+			statuses = append(statuses, body["providerRequest"].(map[string]any)["meta"].(map[string]any)["Helicone-Property-Cache"])
+		}
+		assert.Equal(t, []any{"miss", "hit"}, statuses)
+	}
+}
+
+func TestForwardTracesNeedsKey(t *testing.T) {
+	t.Setenv("HELICONE_API_KEY", "")
+	client := newTestClient(t, nil)
+	assert.Error(t, client.ForwardTraces(TraceOptions{Platform: "helicone"}))
+	assert.ErrorContains(t, client.ForwardTraces(TraceOptions{Platform: "datadog", APIKey: "x"}), "unknown trace platform")
+}
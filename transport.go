@@ -0,0 +1,130 @@
+package llmcache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// TransportOptions tunes the connections the client makes to the API. Large
+// recording runs send many requests to the same host, which the defaults of
+// net/http, keeping only two idle connections per host, turn into a stream of
+// new connections and TLS handshakes.
+type TransportOptions struct {
+	// MaxIdleConnsPerHost is how many keep-alive connections are kept open
+	// per host between requests.
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost limits the connections per host, including those in
+	// use; 0 means no limit.
+	MaxConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept open.
+	IdleConnTimeout time.Duration
+	// HTTP2 lets connections use HTTP/2 where the server supports it.
+	HTTP2 bool
+	// Proxy is the HTTP, HTTPS or SOCKS5 proxy requests are sent through.
+	// Without one, the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment
+	// variables are respected.
+	Proxy *url.URL
+	// TLS configures the TLS connections, e.g. with the CA and client
+	// certificate of a gateway using private PKI; see loadTLSConfig.
+	TLS *tls.Config
+}
+
+// DefaultTransportOptions are the options of the clients NewCachingClient
+// creates.
+func DefaultTransportOptions() TransportOptions {
+	return TransportOptions{
+		MaxIdleConnsPerHost: 32,
+		IdleConnTimeout:     90 * time.Second,
+		HTTP2:               true,
+	}
+}
+
+// NewTransport returns a transport configured by opts, for clients passed to
+// NewCachingClientWithConfig in an openai.ClientConfig.
+func NewTransport(opts TransportOptions) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 0
+	transport.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+	transport.MaxConnsPerHost = opts.MaxConnsPerHost
+	transport.IdleConnTimeout = opts.IdleConnTimeout
+	transport.ForceAttemptHTTP2 = opts.HTTP2
+	if opts.TLS != nil {
+		transport.TLSClientConfig = opts.TLS
+	}
+	if opts.Proxy != nil {
+		transport.Proxy = http.ProxyURL(opts.Proxy)
+	}
+	if !opts.HTTP2 {
+		// A non-nil, empty TLSNextProto map disables HTTP/2.
+		transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return transport
+}
+
+// parseProxyURL parses the URL of an upstream proxy, e.g.
+// http://proxy.internal:3128 or socks5://localhost:1080.
+func parseProxyURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("unsupported proxy %q: use an http, https or socks5 URL", raw)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy %q has no host", raw)
+	}
+	return u, nil
+}
+
+// useHTTPClient makes the clients of the other providers send their requests
+// with httpc too.
+func (c *CachingClient) useHTTPClient(httpc *http.Client) {
+	if c.anthropic != nil {
+		c.anthropic.httpClient = httpc
+	}
+	if c.bedrock != nil {
+		c.bedrock.httpClient = httpc
+	}
+}
+
+// loadTLSConfig returns the TLS configuration for connecting to a gateway
+// using private PKI: trusting the certificates in the PEM bundle at caFile as
+// well as the system roots, and presenting the client certificate in
+// certFile, with its key in keyFile, for mutual TLS. Empty paths are skipped.
+func loadTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("a client certificate needs both a certificate and a key file")
+	}
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s has no PEM certificates", caFile)
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
@@ -0,0 +1,149 @@
+package llmcache
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransportReusesConnections(t *testing.T) {
+	var conns int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.Start()
+	t.Cleanup(server.Close)
+
+	client := &http.Client{Transport: NewTransport(DefaultTransportOptions())}
+	for i := 0; i < 5; i++ {
+		resp, err := client.Get(server.URL)
+		if assert.NoError(t, err) {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&conns))
+}
+
+func TestTransportOptions(t *testing.T) {
+	opts := DefaultTransportOptions()
+	opts.MaxConnsPerHost = 4
+	opts.HTTP2 = false
+	transport := NewTransport(opts)
+	assert.Equal(t, 4, transport.MaxConnsPerHost)
+	assert.Equal(t, opts.MaxIdleConnsPerHost, transport.MaxIdleConnsPerHost)
+	assert.False(t, transport.ForceAttemptHTTP2)
+	assert.NotNil(t, transport.TLSNextProto, "an empty TLSNextProto disables HTTP/2")
+}
+
+func TestTransportUsesUpstreamProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		io.WriteString(w, "via proxy")
+	}))
+	t.Cleanup(proxy.Close)
+
+	proxyURL, err := parseProxyURL(proxy.URL)
+	assert.NoError(t, err)
+	opts := DefaultTransportOptions()
+	opts.Proxy = proxyURL
+	client := &http.Client{Transport: NewTransport(opts)}
+	resp, err := client.Get("http://api.example.invalid/v1/models")
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "via proxy", string(body))
+	}
+	assert.Equal(t, []string{"http://api.example.invalid/v1/models"}, proxied)
+
+	_, err = parseProxyURL("socks5://localhost:1080")
+	assert.NoError(t, err)
+	_, err = parseProxyURL("ftp://proxy.internal")
+	assert.Error(t, err)
+}
+
+// writeClientCert writes a self-signed client certificate and its key to dir,
+// returning their paths and the certificate.
+func writeClientCert(t *testing.T, dir string) (string, string, *x509.Certificate) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ci-runner"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certPath, keyPath := filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key")
+	assert.NoError(t, os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certPath, keyPath, cert
+}
+
+func TestTransportMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath, clientCert := writeClientCert(t, dir)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.PeerCertificates[0].Subject.CommonName)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	caPath := filepath.Join(dir, "gateway-ca.pem")
+	assert.NoError(t, os.WriteFile(caPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600))
+
+	tlsConfig, err := loadTLSConfig(caPath, certPath, keyPath)
+	if !assert.NoError(t, err) {
+		return
+	}
+	opts := DefaultTransportOptions()
+	opts.TLS = tlsConfig
+	resp, err := (&http.Client{Transport: NewTransport(opts)}).Get(server.URL)
+	if assert.NoError(t, err) {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(t, "ci-runner", string(body))
+	}
+
+	withoutCert, err := loadTLSConfig(caPath, "", "")
+	assert.NoError(t, err)
+	opts.TLS = withoutCert
+	_, err = (&http.Client{Transport: NewTransport(opts)}).Get(server.URL)
+	assert.Error(t, err, "the gateway requires a client certificate")
+
+	_, err = loadTLSConfig("", certPath, "")
+	assert.Error(t, err)
+}
@@ -0,0 +1,79 @@
+package llmcache
+
+import (
+	"encoding/json"
+	"math"
+	"unicode/utf8"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// SetTruncationReplay makes the client serve a request that misses the cache,
+// but only lowers the max_tokens of a recorded request, from that recording
+// truncated to the new limit instead of calling the API. A response that ended
+// within the limit is served whole, as the API would have returned it.
+func (c *CachingClient) SetTruncationReplay(enabled bool) {
+	c.truncationReplay = enabled
+}
+
+// withoutTokenLimit returns req as it is keyed, without its token limit.
+func withoutTokenLimit(req openai.ChatCompletionRequest) openai.ChatCompletionRequest {
+	req.MaxTokens = 0
+	return keyedRequest(req)
+}
+
+// findLongerRecording finds the recording in namespace of a request that
+// differs from req only in allowing more tokens. The recording with the
+// lowest limit above req's wins, since it is the one truncation changes least;
+// ties go to the lowest hash so the result doesn't depend on map iteration.
+func findLongerRecording(cache *Cache, namespace string, req openai.ChatCompletionRequest) (string, CacheEntry, bool) {
+	if req.MaxTokens <= 0 {
+		return "", CacheEntry{}, false
+	}
+	want, err := json.Marshal(withoutTokenLimit(req))
+	if err != nil {
+		return "", CacheEntry{}, false
+	}
+	var bestHash string
+	var best CacheEntry
+	bestLimit := 0
+	for hash, entry := range cache.Responses {
+		if entry.Request == nil || entry.Namespace != namespace {
+			continue
+		}
+		limit := entry.Request.MaxTokens
+		if limit == 0 {
+			limit = math.MaxInt
+		}
+		if limit <= req.MaxTokens {
+			continue
+		}
+		got, err := json.Marshal(withoutTokenLimit(*entry.Request))
+		if err != nil || string(got) != string(want) {
+			continue
+		}
+		if bestHash != "" && (limit > bestLimit || (limit == bestLimit && hash > bestHash)) {
+			continue
+		}
+		bestHash, best, bestLimit = hash, entry, limit
+	}
+	return bestHash, best, bestHash != ""
+}
+
+// truncateToTokens returns the first n tokens of response in model's
+// encoding, without a partial character at the end.
+func truncateToTokens(model, response string, n int) (string, error) {
+	enc, err := encodingFor(model)
+	if err != nil {
+		return "", err
+	}
+	tokens := enc.Encode(response, nil, nil)
+	if len(tokens) <= n {
+		return response, nil
+	}
+	truncated := enc.Decode(tokens[:n])
+	for !utf8.ValidString(truncated) {
+		truncated = truncated[:len(truncated)-1]
+	}
+	return truncated, nil
+}
@@ -0,0 +1,62 @@
+package llmcache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncationReplay(t *testing.T) {
+	client, calls := newEchoClient(t)
+	client.SetTruncationReplay(true)
+	seed := 1
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Seed: &seed, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+	full, _, err := client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "reply to 1 messages", full)
+
+	req.MaxTokens = 2
+	truncated, cached, err := client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	assert.Equal(t, "reply to", truncated)
+	assert.Equal(t, 1, *calls)
+
+	// A limit the recording already fits in serves it whole.
+	req.MaxTokens = 100
+	whole, cached, err := client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.True(t, cached)
+	assert.Equal(t, full, whole)
+
+	// A recording can't be extended past its own limit, nor truncated for
+	// a request that differs in more than max_tokens.
+	seed = 2
+	req.MaxTokens = 2
+	_, cached, err = client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	req.MaxTokens = 3
+	_, cached, err = client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, 3, *calls)
+
+	client.SetTruncationReplay(false)
+	seed = 1
+	req.MaxTokens = 1
+	_, cached, err = client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, 4, *calls)
+}
+
+func TestTruncateToTokens(t *testing.T) {
+	truncated, err := truncateToTokens("gpt-4o-mini", "Grüße aus Köln 🌍 und Bonn", 6)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, truncated)
+	assert.True(t, len(truncated) < len("Grüße aus Köln 🌍 und Bonn"))
+	assert.Contains(t, "Grüße aus Köln 🌍 und Bonn", truncated)
+}
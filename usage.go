@@ -0,0 +1,128 @@
+package llmcache
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// usageLog appends the key of every entry used during a run to a file, once
+// per run. Several test processes can share the file, so that together they
+// record every entry the test suite still uses.
+type usageLog struct {
+	file *os.File
+	seen map[string]bool
+	err  error
+}
+
+func (u *usageLog) record(e Event) {
+	if (e.Kind != EntryServed && e.Kind != EntryStored) || u.seen[e.Hash] || u.err != nil {
+		return
+	}
+	u.seen[e.Hash] = true
+	_, u.err = fmt.Fprintln(u.file, e.Hash)
+}
+
+func (u *usageLog) Close() error {
+	if err := u.file.Close(); u.err == nil {
+		u.err = err
+	}
+	return u.err
+}
+
+// MarkUsed appends the key of every entry the client serves or records to the
+// file at path, for prune -unused to delete the entries no test uses anymore.
+// The file is closed by Close.
+func (c *CachingClient) MarkUsed(path string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	c.usage = &usageLog{file: file, seen: make(map[string]bool)}
+	c.OnEvent(c.usage.record)
+	return nil
+}
+
+func readUsedKeys(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	used := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if key := strings.TrimSpace(scanner.Text()); key != "" {
+			used[key] = true
+		}
+	}
+	return used, scanner.Err()
+}
+
+// pruneUnused deletes the entries of cache that aren't in used, except pinned
+// ones, and returns their keys.
+func pruneUnused(cache *Cache, used map[string]bool) []string {
+	var pruned []string
+	for _, e := range listEntries(cache, entryFilter{}) {
+		if !used[e.Hash] && !e.Entry.Pinned {
+			delete(cache.Responses, e.Hash)
+			pruned = append(pruned, e.Hash)
+		}
+	}
+	return pruned
+}
+
+// runPrune deletes the entries a test run didn't use, as recorded with
+// -mark-used.
+func runPrune(args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ContinueOnError)
+	unused := fs.String("unused", "", "Delete the entries whose keys aren't in this file, written by a run with -mark-used")
+	dryRun := fs.Bool("dry-run", false, "Only list the entries that would be deleted")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: llm-test-cache prune -unused FILE [-dry-run] [CACHE]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *unused == "" || fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("prune needs -unused")
+	}
+	used, err := readUsedKeys(*unused)
+	if err != nil {
+		return err
+	}
+	path := cacheFile
+	if fs.NArg() == 1 {
+		path = fs.Arg(0)
+	}
+	store := newFileStore(path)
+	store.readOnly = *dryRun
+	defer store.Close()
+	cache, err := store.Load()
+	if err != nil {
+		return err
+	}
+	pruned := pruneUnused(cache, used)
+	for _, hash := range pruned {
+		fmt.Fprintln(console, hash)
+	}
+	result := struct {
+		Pruned []string `json:"pruned"`
+		Kept   int      `json:"kept"`
+		DryRun bool     `json:"dry_run"`
+	}{Pruned: append([]string{}, pruned...), Kept: len(cache.Responses), DryRun: *dryRun}
+	if *dryRun {
+		fmt.Fprintf(console, "Would prune %d unused entries, keeping %d\n", len(pruned), len(cache.Responses))
+		return report(result)
+	}
+	fmt.Fprintf(console, "Pruned %d unused entries, keeping %d\n", len(pruned), len(cache.Responses))
+	if err := store.Save(cache); err != nil {
+		return err
+	}
+	return report(result)
+}
@@ -0,0 +1,37 @@
+package llmcache
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPruneUnusedEntries(t *testing.T) {
+	client, _ := newEchoClient(t)
+	usedPath := filepath.Join(t.TempDir(), "used.txt")
+	assert.NoError(t, client.MarkUsed(usedPath))
+	seed := 1
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Seed: &seed, Messages: []openai.ChatCompletionMessage{{Role: "user", Content: "Hi"}}}
+	for i := 0; i < 2; i++ {
+		_, _, err := client.getResponse(context.Background(), req)
+		assert.NoError(t, err)
+	}
+	cache, err := client.store.Load()
+	assert.NoError(t, err)
+	assert.NoError(t, client.Close())
+
+	used, err := readUsedKeys(usedPath)
+	assert.NoError(t, err)
+	assert.Len(t, used, 1, "each entry is marked once per run")
+
+	hash, err := generateHash(req)
+	assert.NoError(t, err)
+	cache.Responses["stale"] = CacheEntry{Response: "no test asks for this"}
+	cache.Responses["pinned"] = CacheEntry{Response: "kept anyway", Pinned: true}
+	assert.Equal(t, []string{"stale"}, pruneUnused(cache, used))
+	assert.Contains(t, cache.Responses, hash)
+	assert.Contains(t, cache.Responses, "pinned")
+}
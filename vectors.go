@@ -0,0 +1,315 @@
+package llmcache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// vectorFormats are the export formats of cached embeddings.
+var vectorFormats = map[string]func(io.Writer, []vectorRow) error{
+	"jsonl":   writeVectorsJSONL,
+	"npy":     writeVectorsNPY,
+	"parquet": writeVectorsParquet,
+}
+
+// vectorRow is one cached embedding as exported.
+type vectorRow struct {
+	Key       string    `json:"key"`
+	Model     string    `json:"model"`
+	Namespace string    `json:"namespace,omitempty"`
+	Input     string    `json:"input"`
+	Embedding []float32 `json:"embedding,omitempty"`
+}
+
+// vectorRows returns the cached embeddings of model, or of every model if it
+// is empty, ordered by model, input and key. Formats that store a matrix need
+// every vector to have the same length, so model must be given when the cache
+// holds vectors of several lengths.
+func vectorRows(cache *Cache, model string, matrix bool) ([]vectorRow, error) {
+	var rows []vectorRow
+	lengths := make(map[int]bool)
+	for key, e := range cache.Embeddings {
+		if model != "" && e.Model != model {
+			continue
+		}
+		rows = append(rows, vectorRow{Key: key, Model: e.Model, Namespace: e.Namespace, Input: e.Input, Embedding: e.Embedding})
+		lengths[len(e.Embedding)] = true
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if a.Model != b.Model {
+			return a.Model < b.Model
+		}
+		if a.Input != b.Input {
+			return a.Input < b.Input
+		}
+		return a.Key < b.Key
+	})
+	if matrix && len(lengths) > 1 {
+		return nil, fmt.Errorf("the cached vectors have %d different lengths; export one model at a time with -model", len(lengths))
+	}
+	if matrix && lengths[0] {
+		return nil, fmt.Errorf("the cache holds empty vectors")
+	}
+	return rows, nil
+}
+
+func writeVectorsJSONL(w io.Writer, rows []vectorRow) error {
+	enc := json.NewEncoder(w)
+	for _, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeVectorsNPY writes the vectors of rows as a NumPy .npy file holding a
+// float32 matrix with one row per vector. The keys and inputs of the rows
+// aren't part of it; export them in the same order with -rows.
+func writeVectorsNPY(w io.Writer, rows []vectorRow) error {
+	dims := 0
+	if len(rows) > 0 {
+		dims = len(rows[0].Embedding)
+	}
+	header := fmt.Sprintf("{'descr': '<f4', 'fortran_order': False, 'shape': (%d, %d), }", len(rows), dims)
+	// The magic, version, header length and header are padded with spaces
+	// to a multiple of 64 bytes, ending in a newline.
+	const preamble = 10
+	padding := 64 - (preamble+len(header)+1)%64
+	if padding == 64 {
+		padding = 0
+	}
+	header += string(bytes.Repeat([]byte(" "), padding)) + "\n"
+
+	var buf bytes.Buffer
+	buf.WriteString("\x93NUMPY\x01\x00")
+	binary.Write(&buf, binary.LittleEndian, uint16(len(header)))
+	buf.WriteString(header)
+	for _, row := range rows {
+		binary.Write(&buf, binary.LittleEndian, row.Embedding)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeVectorsParquet writes rows as an uncompressed Parquet file with one
+// row group and the columns key, model, namespace, input and embedding, a
+// list of floats.
+func writeVectorsParquet(w io.Writer, rows []vectorRow) error {
+	var file bytes.Buffer
+	file.WriteString("PAR1")
+	var chunks []parquetChunk
+
+	byteArrays := func(get func(vectorRow) string) []byte {
+		var data bytes.Buffer
+		for _, row := range rows {
+			s := get(row)
+			binary.Write(&data, binary.LittleEndian, uint32(len(s)))
+			data.WriteString(s)
+		}
+		return data.Bytes()
+	}
+	for _, column := range []struct {
+		name string
+		get  func(vectorRow) string
+	}{
+		{"key", func(r vectorRow) string { return r.Key }},
+		{"model", func(r vectorRow) string { return r.Model }},
+		{"namespace", func(r vectorRow) string { return r.Namespace }},
+		{"input", func(r vectorRow) string { return r.Input }},
+	} {
+		chunks = append(chunks, writeParquetPage(&file, parquetByteArray, []string{column.name}, len(rows), byteArrays(column.get)))
+	}
+
+	// Every vector is a non-empty list, so every value is defined, and only
+	// the first value of each row starts a new list.
+	values := 0
+	var floats bytes.Buffer
+	var repetition []byte
+	for _, row := range rows {
+		binary.Write(&floats, binary.LittleEndian, row.Embedding)
+		repetition = append(repetition, rleRun(1, 0)...)
+		if len(row.Embedding) > 1 {
+			repetition = append(repetition, rleRun(len(row.Embedding)-1, 1)...)
+		}
+		values += len(row.Embedding)
+	}
+	var data bytes.Buffer
+	binary.Write(&data, binary.LittleEndian, uint32(len(repetition)))
+	data.Write(repetition)
+	definition := rleRun(values, 1)
+	binary.Write(&data, binary.LittleEndian, uint32(len(definition)))
+	data.Write(definition)
+	data.Write(floats.Bytes())
+	chunks = append(chunks, writeParquetPage(&file, parquetFloat, []string{"embedding", "list", "element"}, values, data.Bytes()))
+
+	var columns []any
+	size := int64(0)
+	for _, chunk := range chunks {
+		columns = append(columns, chunk.metadata)
+		size += chunk.size
+	}
+	required, repeated := int32(0), int32(2)
+	utf8, list := int32(0), int32(3)
+	schema := []any{
+		tStruct{{4, "schema"}, {5, int32(5)}},
+		tStruct{{1, parquetByteArray}, {3, required}, {4, "key"}, {6, utf8}},
+		tStruct{{1, parquetByteArray}, {3, required}, {4, "model"}, {6, utf8}},
+		tStruct{{1, parquetByteArray}, {3, required}, {4, "namespace"}, {6, utf8}},
+		tStruct{{1, parquetByteArray}, {3, required}, {4, "input"}, {6, utf8}},
+		tStruct{{3, required}, {4, "embedding"}, {5, int32(1)}, {6, list}, {10, tStruct{{3, tStruct{}}}}},
+		tStruct{{3, repeated}, {4, "list"}, {5, int32(1)}},
+		tStruct{{1, parquetFloat}, {3, required}, {4, "element"}},
+	}
+	footer := tStruct{
+		{1, int32(1)},
+		{2, tList{compactStruct, schema}},
+		{3, int64(len(rows))},
+		{4, tList{compactStruct, []any{tStruct{
+			{1, tList{compactStruct, columns}},
+			{2, size},
+			{3, int64(len(rows))},
+		}}}},
+		{6, "llm-test-cache"},
+	}
+	start := file.Len()
+	footer.encode(&file)
+	binary.Write(&file, binary.LittleEndian, uint32(file.Len()-start))
+	file.WriteString("PAR1")
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+// Parquet physical types and encodings.
+const (
+	parquetFloat     int32 = 4
+	parquetByteArray int32 = 6
+	parquetPlain     int32 = 0
+	parquetRLE       int32 = 3
+)
+
+// parquetChunk is a column chunk written to a Parquet file.
+type parquetChunk struct {
+	metadata tStruct
+	size     int64
+}
+
+// writeParquetPage appends an uncompressed, plain-encoded data page of the
+// column at path to file, as the column's only chunk.
+func writeParquetPage(file *bytes.Buffer, typ int32, path []string, values int, data []byte) parquetChunk {
+	offset := int64(file.Len())
+	header := tStruct{
+		{1, int32(0)}, // DATA_PAGE
+		{2, int32(len(data))},
+		{3, int32(len(data))},
+		{5, tStruct{{1, int32(values)}, {2, parquetPlain}, {3, parquetRLE}, {4, parquetRLE}}},
+	}
+	header.encode(file)
+	file.Write(data)
+	size := int64(file.Len()) - offset
+	var names []any
+	for _, name := range path {
+		names = append(names, name)
+	}
+	return parquetChunk{size: size, metadata: tStruct{
+		{2, offset},
+		{3, tStruct{
+			{1, typ},
+			{2, tList{compactI32, []any{parquetPlain, parquetRLE}}},
+			{3, tList{compactBinary, names}},
+			{4, int32(0)}, // UNCOMPRESSED
+			{5, int64(values)},
+			{6, size},
+			{7, size},
+			{9, offset},
+		}},
+	}}
+}
+
+// rleRun encodes n repetitions of a level of bit width 1 as a run of the
+// RLE/bit-packing hybrid encoding.
+func rleRun(n int, level byte) []byte {
+	return append(binary.AppendUvarint(nil, uint64(n)<<1), level)
+}
+
+// Thrift compact protocol types.
+const (
+	compactI32    byte = 5
+	compactI64    byte = 6
+	compactBinary byte = 8
+	compactList   byte = 9
+	compactStruct byte = 12
+)
+
+// tStruct is a Thrift struct, encoded with the compact protocol Parquet uses
+// for its metadata. Field values are int32, int64, string, tList or tStruct.
+type tStruct []tField
+
+type tField struct {
+	id    int16
+	value any
+}
+
+type tList struct {
+	elem  byte
+	items []any
+}
+
+func (s tStruct) encode(buf *bytes.Buffer) {
+	last := int16(0)
+	for _, f := range s {
+		typ := compactType(f.value)
+		if delta := f.id - last; delta > 0 && delta <= 15 {
+			buf.WriteByte(byte(delta)<<4 | typ)
+		} else {
+			buf.WriteByte(typ)
+			buf.Write(binary.AppendVarint(nil, int64(f.id)))
+		}
+		last = f.id
+		encodeCompact(buf, f.value)
+	}
+	buf.WriteByte(0)
+}
+
+func compactType(v any) byte {
+	switch v.(type) {
+	case int32:
+		return compactI32
+	case int64:
+		return compactI64
+	case string:
+		return compactBinary
+	case tList:
+		return compactList
+	}
+	return compactStruct
+}
+
+func encodeCompact(buf *bytes.Buffer, v any) {
+	switch v := v.(type) {
+	case int32:
+		buf.Write(binary.AppendVarint(nil, int64(v)))
+	case int64:
+		buf.Write(binary.AppendVarint(nil, v))
+	case string:
+		buf.Write(binary.AppendUvarint(nil, uint64(len(v))))
+		buf.WriteString(v)
+	case tList:
+		if len(v.items) < 15 {
+			buf.WriteByte(byte(len(v.items))<<4 | v.elem)
+		} else {
+			buf.WriteByte(0xf0 | v.elem)
+			buf.Write(binary.AppendUvarint(nil, uint64(len(v.items))))
+		}
+		for _, item := range v.items {
+			encodeCompact(buf, item)
+		}
+	case tStruct:
+		v.encode(buf)
+	}
+}
@@ -0,0 +1,78 @@
+package llmcache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testVectorCache() *Cache {
+	return &Cache{Responses: map[string]CacheEntry{}, Embeddings: map[string]EmbeddingEntry{
+		"k2": {Model: "text-embedding-3-small", Input: "world", Embedding: []float32{4, 5, 6}},
+		"k1": {Model: "text-embedding-3-small", Input: "hello", Embedding: []float32{1, 2, 3}},
+		"k3": {Model: "text-embedding-3-large", Input: "hello", Embedding: []float32{7, 8}},
+	}}
+}
+
+func TestVectorRows(t *testing.T) {
+	cache := testVectorCache()
+	_, err := vectorRows(cache, "", true)
+	assert.ErrorContains(t, err, "2 different lengths")
+
+	rows, err := vectorRows(cache, "", false)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+
+	rows, err = vectorRows(cache, "text-embedding-3-small", true)
+	assert.NoError(t, err)
+	if assert.Len(t, rows, 2) {
+		assert.Equal(t, "hello", rows[0].Input)
+		assert.Equal(t, "world", rows[1].Input)
+	}
+}
+
+func TestWriteVectorsNPY(t *testing.T) {
+	rows, err := vectorRows(testVectorCache(), "text-embedding-3-small", true)
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	assert.NoError(t, writeVectorsNPY(&buf, rows))
+	data := buf.Bytes()
+
+	assert.Equal(t, "\x93NUMPY\x01\x00", string(data[:8]))
+	headerLen := int(binary.LittleEndian.Uint16(data[8:10]))
+	assert.Zero(t, (10+headerLen)%64, "the header is padded to 64 bytes")
+	header := string(data[10 : 10+headerLen])
+	assert.Contains(t, header, "'shape': (2, 3)")
+	assert.True(t, strings.HasSuffix(header, "\n"))
+
+	values := make([]float32, 6)
+	assert.NoError(t, binary.Read(bytes.NewReader(data[10+headerLen:]), binary.LittleEndian, values))
+	assert.Equal(t, []float32{1, 2, 3, 4, 5, 6}, values)
+}
+
+func TestWriteVectorsParquet(t *testing.T) {
+	rows, err := vectorRows(testVectorCache(), "text-embedding-3-small", true)
+	assert.NoError(t, err)
+	var buf bytes.Buffer
+	assert.NoError(t, writeVectorsParquet(&buf, rows))
+	data := buf.Bytes()
+
+	assert.Equal(t, "PAR1", string(data[:4]))
+	assert.Equal(t, "PAR1", string(data[len(data)-4:]))
+	footerLen := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := data[len(data)-8-footerLen : len(data)-8]
+	assert.Equal(t, byte(0x15), footer[0], "the footer starts with the format version")
+	assert.Equal(t, byte(0), footer[len(footer)-1], "and ends with the end of its struct")
+	for _, name := range []string{"key", "input", "embedding", "element", "llm-test-cache"} {
+		assert.Contains(t, string(footer), name)
+	}
+}
+
+func TestCompactEncoding(t *testing.T) {
+	var buf bytes.Buffer
+	tStruct{{1, int32(1)}, {3, int64(-2)}, {20, "ab"}, {21, tList{compactI32, []any{int32(3)}}}}.encode(&buf)
+	assert.Equal(t, []byte{0x15, 0x02, 0x26, 0x03, 0x08, 0x28, 0x02, 'a', 'b', 0x19, 0x15, 0x06, 0x00}, buf.Bytes())
+}
@@ -0,0 +1,142 @@
+package llmcache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	vertexDefaultLocation = "us-central1"
+	gceTokenURL           = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+	// gcloud doesn't report when its tokens expire; they last an hour.
+	gcloudTokenLifetime = 50 * time.Minute
+)
+
+// vertexClient sends chat completion requests to the OpenAI-compatible
+// endpoint of Vertex AI, authenticating with a short-lived OAuth access token.
+type vertexClient struct {
+	client *openai.Client
+}
+
+// newVertexClientFromEnv returns a Vertex AI client for the project in
+// VERTEX_PROJECT or GOOGLE_CLOUD_PROJECT, or nil if neither is set. The
+// location defaults to us-central1 and can be set with VERTEX_LOCATION.
+func newVertexClientFromEnv() *vertexClient {
+	project := os.Getenv("VERTEX_PROJECT")
+	if project == "" {
+		project = os.Getenv("GOOGLE_CLOUD_PROJECT")
+	}
+	if project == "" {
+		return nil
+	}
+	location := os.Getenv("VERTEX_LOCATION")
+	if location == "" {
+		location = vertexDefaultLocation
+	}
+	baseURL := fmt.Sprintf("https://%s-aiplatform.googleapis.com/v1beta1/projects/%s/locations/%s/endpoints/openapi", location, project, location)
+	return newVertexClient(baseURL, &googleTokenSource{httpClient: http.DefaultClient, now: time.Now})
+}
+
+func newVertexClient(baseURL string, tokens tokenSource) *vertexClient {
+	config := openai.DefaultConfig("")
+	config.BaseURL = baseURL
+	config.HTTPClient = &http.Client{Transport: &bearerTransport{tokens: tokens, base: http.DefaultTransport}}
+	return &vertexClient{client: openai.NewClientWithConfig(config)}
+}
+
+func (v *vertexClient) createChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, *ProviderCacheUsage, error) {
+	resp, err := v.client.CreateChatCompletion(ctx, req)
+	return resp, nil, err
+}
+
+type tokenSource interface {
+	token(ctx context.Context) (string, error)
+}
+
+// bearerTransport authenticates every request with a token from tokens,
+// replacing the empty API key the OpenAI client would send.
+type bearerTransport struct {
+	tokens tokenSource
+	base   http.RoundTripper
+}
+
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.tokens.token(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.base.RoundTrip(req)
+}
+
+// googleTokenSource finds Google Cloud credentials the way the gcloud-based
+// tooling does: an explicit GOOGLE_OAUTH_ACCESS_TOKEN, then the logged-in
+// gcloud account, then the GCE metadata server. Tokens are reused until
+// shortly before they expire.
+type googleTokenSource struct {
+	httpClient *http.Client
+	now        func() time.Time
+
+	mu      sync.Mutex
+	cached  string
+	expires time.Time
+}
+
+func (s *googleTokenSource) token(ctx context.Context) (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cached != "" && s.now().Before(s.expires) {
+		return s.cached, nil
+	}
+
+	out, gcloudErr := exec.CommandContext(ctx, "gcloud", "auth", "print-access-token").Output()
+	if token := strings.TrimSpace(string(out)); gcloudErr == nil && token != "" {
+		s.cached, s.expires = token, s.now().Add(gcloudTokenLifetime)
+		return token, nil
+	}
+	token, lifetime, metadataErr := s.metadataToken(ctx)
+	if metadataErr != nil {
+		return "", fmt.Errorf("vertex: no Google Cloud credentials; set GOOGLE_OAUTH_ACCESS_TOKEN or run gcloud auth login: %w", errors.Join(gcloudErr, metadataErr))
+	}
+	// Refresh a minute early so a token never expires mid-request.
+	s.cached, s.expires = token, s.now().Add(lifetime-time.Minute)
+	return token, nil
+}
+
+func (s *googleTokenSource) metadataToken(ctx context.Context) (string, time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gceTokenURL, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("metadata server: unexpected status %s", resp.Status)
+	}
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", 0, fmt.Errorf("metadata server: %w", err)
+	}
+	return body.AccessToken, time.Duration(body.ExpiresIn) * time.Second, nil
+}
@@ -0,0 +1,45 @@
+package llmcache
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+type staticToken string
+
+func (s staticToken) token(context.Context) (string, error) {
+	return string(s), nil
+}
+
+func TestVertexAdapter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/chat/completions", r.URL.Path)
+		assert.Equal(t, "Bearer ya29.test", r.Header.Get("Authorization"))
+		var req openai.ChatCompletionRequest
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "google/gemini-2.0-flash-001", req.Model)
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Model:   req.Model,
+			Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "Paris."}}},
+		})
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, nil)
+	client.vertex = newVertexClient(server.URL, staticToken("ya29.test"))
+
+	req := openai.ChatCompletionRequest{
+		Model:    "vertex/google/gemini-2.0-flash-001",
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "Capital of France?"}},
+	}
+	response, cached, err := client.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.False(t, cached)
+	assert.Equal(t, "Paris.", response)
+}
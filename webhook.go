@@ -0,0 +1,221 @@
+package llmcache
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WebhookOptions configure notifications about a run, posted to a Slack
+// incoming webhook or any URL accepting JSON.
+type WebhookOptions struct {
+	URL string
+	// ProtectedBranches are the branches, or path.Match patterns such as
+	// "release/*", on which a run calling the API is notified when the
+	// client closes. They default to main and master.
+	ProtectedBranches []string
+	// BudgetThresholds are estimated costs in US dollars, each notified
+	// once when the run's cost reaches it.
+	BudgetThresholds []float64
+}
+
+const webhookTimeout = 10 * time.Second
+
+// webhookNotification is the JSON posted to a webhook. Slack webhooks are
+// only sent the text.
+type webhookNotification struct {
+	// Event is live_calls, budget or drift.
+	Event     string   `json:"event"`
+	Text      string   `json:"text"`
+	Branch    string   `json:"branch,omitempty"`
+	LiveCalls int      `json:"live_calls,omitempty"`
+	Cost      float64  `json:"estimated_cost_usd,omitempty"`
+	Threshold float64  `json:"threshold_usd,omitempty"`
+	Drifted   []string `json:"drifted,omitempty"`
+}
+
+// postWebhook posts n to the webhook at rawURL.
+func postWebhook(rawURL string, n webhookNotification) error {
+	var body any = n
+	if u, err := url.Parse(rawURL); err == nil && u.Host == "hooks.slack.com" {
+		body = map[string]string{"text": n.Text}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Post(rawURL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook: %s", resp.Status)
+	}
+	return nil
+}
+
+// currentBranch returns the branch being tested: the branch a CI system
+// reports, or else the checked-out git branch.
+func currentBranch() string {
+	// GITHUB_HEAD_REF is the source branch of a pull request, and
+	// GITHUB_REF_NAME the branch pushed to otherwise.
+	for _, name := range []string{"GITHUB_HEAD_REF", "GITHUB_REF_NAME", "CI_COMMIT_REF_NAME", "BUILDKITE_BRANCH", "CIRCLE_BRANCH"} {
+		if branch := os.Getenv(name); branch != "" {
+			return branch
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, "git", "rev-parse", "--abbrev-ref", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// branchProtected reports whether branch matches one of patterns.
+func branchProtected(branch string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, branch); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseThresholds parses a comma-separated list of dollar amounts.
+func parseThresholds(s string) ([]float64, error) {
+	var thresholds []float64
+	for _, field := range strings.Split(s, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		threshold, err := strconv.ParseFloat(strings.TrimPrefix(field, "$"), 64)
+		if err != nil || threshold <= 0 {
+			return nil, fmt.Errorf("invalid budget threshold %q: use a positive number of dollars", field)
+		}
+		thresholds = append(thresholds, threshold)
+	}
+	return thresholds, nil
+}
+
+// webhookNotifier watches a client's requests for what its webhook should
+// hear about.
+type webhookNotifier struct {
+	url        string
+	branch     string
+	protected  bool
+	thresholds []float64
+	// crossed is how many of the sorted thresholds the run's cost has
+	// reached.
+	crossed   int
+	liveCalls int
+	failed    int
+	err       error
+}
+
+// NotifyWebhook posts to opts.URL when the estimated cost of the run reaches
+// each of opts.BudgetThresholds and, when the client closes, if the run sent
+// requests to the API on a protected branch. Failed notifications produce a
+// warning instead of failing the run.
+func (c *CachingClient) NotifyWebhook(opts WebhookOptions) error {
+	if opts.URL == "" {
+		return fmt.Errorf("webhook needs a URL")
+	}
+	if len(opts.ProtectedBranches) == 0 {
+		opts.ProtectedBranches = []string{"main", "master"}
+	}
+	thresholds := append([]float64{}, opts.BudgetThresholds...)
+	sort.Float64s(thresholds)
+	n := &webhookNotifier{url: opts.URL, branch: currentBranch(), thresholds: thresholds}
+	n.protected = branchProtected(n.branch, opts.ProtectedBranches)
+	c.webhook = n
+	c.OnEvent(func(e Event) {
+		if e.Kind != EntryStored && e.Kind != LiveServed {
+			return
+		}
+		n.liveCalls++
+		n.checkBudget(c.stats.EstimatedCost)
+	})
+	return nil
+}
+
+func (n *webhookNotifier) send(notification webhookNotification) {
+	notification.Branch = n.branch
+	if err := postWebhook(n.url, notification); err != nil {
+		n.failed++
+		if n.err == nil {
+			n.err = err
+		}
+	}
+}
+
+// checkBudget notifies the thresholds that cost has newly reached. Thresholds
+// crossed at once are notified together.
+func (n *webhookNotifier) checkBudget(cost float64) {
+	crossed := n.crossed
+	for crossed < len(n.thresholds) && cost >= n.thresholds[crossed] {
+		crossed++
+	}
+	if crossed == n.crossed {
+		return
+	}
+	n.crossed = crossed
+	threshold := n.thresholds[crossed-1]
+	n.send(webhookNotification{
+		Event:     "budget",
+		Text:      fmt.Sprintf("llm-test-cache: the run on %s has spent an estimated $%.2f, reaching the $%.2f threshold", n.describeBranch(), cost, threshold),
+		Cost:      cost,
+		Threshold: threshold,
+	})
+}
+
+func (n *webhookNotifier) describeBranch() string {
+	if n.branch == "" {
+		return "an unknown branch"
+	}
+	return n.branch
+}
+
+// Close notifies live calls on a protected branch and warns about failed
+// notifications.
+func (n *webhookNotifier) Close(stats RunStats) {
+	if n.protected && n.liveCalls > 0 {
+		n.send(webhookNotification{
+			Event:     "live_calls",
+			Text:      fmt.Sprintf("llm-test-cache: the run on protected branch %s sent %d requests to the API, an estimated $%.2f", n.branch, n.liveCalls, stats.EstimatedCost),
+			LiveCalls: n.liveCalls,
+			Cost:      stats.EstimatedCost,
+		})
+	}
+	if n.failed > 0 {
+		fmt.Fprintf(console, "Warning: %d webhook notifications failed: %v\n", n.failed, n.err)
+	}
+}
+
+// notifyDrift posts the entries whose live responses differ from their
+// recordings to the webhook at rawURL.
+func notifyDrift(rawURL string, diffs []EntryDiff) error {
+	drifted := make([]string, 0, len(diffs))
+	for _, d := range diffs {
+		drifted = append(drifted, d.Hash)
+	}
+	return postWebhook(rawURL, webhookNotification{
+		Event:   "drift",
+		Text:    fmt.Sprintf("llm-test-cache: %d recorded responses drifted from the live API", len(diffs)),
+		Branch:  currentBranch(),
+		Drifted: drifted,
+	})
+}
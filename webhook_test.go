@@ -0,0 +1,99 @@
+package llmcache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+// newWebhookServer returns a server recording the notifications posted to it.
+func newWebhookServer(t *testing.T) (*httptest.Server, func() []webhookNotification) {
+	t.Helper()
+	var mu sync.Mutex
+	var notifications []webhookNotification
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n webhookNotification
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&n))
+		mu.Lock()
+		defer mu.Unlock()
+		notifications = append(notifications, n)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []webhookNotification { mu.Lock(); defer mu.Unlock(); return notifications }
+}
+
+func TestWebhookNotifiesBudgetAndLiveCalls(t *testing.T) {
+	t.Setenv("GITHUB_HEAD_REF", "")
+	t.Setenv("GITHUB_REF_NAME", "main")
+	webhook, notifications := newWebhookServer(t)
+	// Each request costs $2.50 on gpt-4o.
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Usage:   openai.Usage{PromptTokens: 1_000_000},
+			Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "ok"}}},
+		})
+	}))
+	defer api.Close()
+	client := newTestClient(t, nil)
+	config := openai.DefaultConfig("test-key")
+	config.BaseURL = api.URL
+	client.Client = openai.NewClientWithConfig(config)
+	assert.NoError(t, client.NotifyWebhook(WebhookOptions{URL: webhook.URL, BudgetThresholds: []float64{4, 1, 5}}))
+
+	for i := 0; i < 3; i++ {
+		req := openai.ChatCompletionRequest{Model: openai.GPT4o, Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: fmt.Sprint(i)}}}
+		_, _, err := client.getResponse(context.Background(), req)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, client.Close())
+
+	var events []string
+	var thresholds []float64
+	for _, n := range notifications() {
+		events = append(events, n.Event)
+		thresholds = append(thresholds, n.Threshold)
+		assert.Equal(t, "main", n.Branch)
+	}
+	// $2.50 reaches $1, $5.00 reaches both $4 and $5, and $7.50 nothing new.
+	assert.Equal(t, []string{"budget", "budget", "live_calls"}, events)
+	assert.Equal(t, []float64{1, 5, 0}, thresholds)
+	assert.Equal(t, 3, notifications()[2].LiveCalls)
+}
+
+func TestWebhookIgnoresUnprotectedBranches(t *testing.T) {
+	t.Setenv("GITHUB_HEAD_REF", "feature/cache")
+	webhook, notifications := newWebhookServer(t)
+	client, _ := newEchoClient(t)
+	assert.NoError(t, client.NotifyWebhook(WebhookOptions{URL: webhook.URL, ProtectedBranches: []string{"main", "release/*"}}))
+	_, _, err := client.getResponse(context.Background(), openai.ChatCompletionRequest{Model: openai.GPT4o, Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}}})
+	assert.NoError(t, err)
+	assert.NoError(t, client.Close())
+	assert.Empty(t, notifications())
+
+	assert.True(t, branchProtected("release/1.2", []string{"main", "release/*"}))
+	assert.False(t, branchProtected("release/1.2/fix", []string{"release/*"}))
+}
+
+func TestNotifyDrift(t *testing.T) {
+	webhook, notifications := newWebhookServer(t)
+	assert.NoError(t, notifyDrift(webhook.URL, []EntryDiff{{Hash: "abc"}, {Hash: "def"}}))
+	if assert.Len(t, notifications(), 1) {
+		assert.Equal(t, "drift", notifications()[0].Event)
+		assert.Equal(t, []string{"abc", "def"}, notifications()[0].Drifted)
+	}
+}
+
+func TestParseThresholds(t *testing.T) {
+	thresholds, err := parseThresholds("1, $5,20.5")
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{1, 5, 20.5}, thresholds)
+	_, err = parseThresholds("1,lots")
+	assert.Error(t, err)
+}
@@ -0,0 +1,127 @@
+package llmcache
+
+import (
+	"context"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Option configures a client created by WrapClient.
+type Option func(*CachingClient)
+
+// WithCacheSizeLimit makes the client evict entries once they take more than
+// limit bytes; a limit of 0 or -1 means entries are never evicted.
+func WithCacheSizeLimit(limit int64) Option {
+	return func(c *CachingClient) {
+		c.cacheSizeLimit = limit
+	}
+}
+
+// WithoutCaching makes the client send every request to the API, as if it had
+// been created with caching disabled.
+func WithoutCaching() Option {
+	return func(c *CachingClient) {
+		c.cacheEnabled = false
+	}
+}
+
+// WithCacheFile makes the client cache responses in the file at path instead
+// of cacheFile.
+func WithCacheFile(path string) Option {
+	return func(c *CachingClient) {
+		c.store = newFileStore(path)
+	}
+}
+
+// WithCacheArchive makes the client restore its cache file from the gzipped
+// tarball at archive before first using it, unless the file exists, and save
+// it back there on Close, for CI systems that persist files between runs. It
+// archives the file of an earlier WithCacheFile option, and cacheFile
+// otherwise.
+func WithCacheArchive(archive string) Option {
+	return func(c *CachingClient) {
+		path := cacheFile
+		if s, ok := c.store.(*fileStore); ok {
+			path = s.path
+		}
+		c.store = newArchiveStore(archive, path)
+	}
+}
+
+// WithStore makes the client persist the cache in store.
+func WithStore(store Store) Option {
+	return func(c *CachingClient) {
+		c.store = store
+	}
+}
+
+// WithClock replaces the system clock the client uses.
+func WithClock(clock Clock) Option {
+	return func(c *CachingClient) {
+		c.clock = clock
+	}
+}
+
+// WrapClient returns a caching client that sends OpenAI requests with client,
+// keeping whatever it was configured with: an Azure endpoint, a custom
+// transport, extra headers. client may also be a mock. Responses are cached in cacheFile with the default
+// size limit unless opts say otherwise. As with NewCachingClient, Claude,
+// "bedrock/" and "vertex/" models go to their providers when credentials are
+// configured, and the client must be closed with Close.
+//
+// Since the transport of client is left alone, serving through the client
+// doesn't pass on the caller headers named by -forward-header.
+func WrapClient(client Client, opts ...Option) *CachingClient {
+	c := newCachingClient(client, true, DefaultCacheSizeLimit)
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Client is the part of the go-openai client API that a CachingClient
+// decorates. *openai.Client implements it, and so can mocks and other
+// compatible clients in tests. Mocks that can't build an
+// *openai.ChatCompletionStream may return an error from
+// CreateChatCompletionStream; only streamed requests call it.
+type Client interface {
+	CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (*openai.ChatCompletionStream, error)
+	CreateEmbeddings(ctx context.Context, conv openai.EmbeddingRequestConverter) (openai.EmbeddingResponse, error)
+	ListModels(ctx context.Context) (openai.ModelsList, error)
+}
+
+var _ Client = (*openai.Client)(nil)
+
+var _ Client = (*CachingClient)(nil)
+
+// CreateChatCompletion answers req from the cache, calling the API on a miss
+// as the mode of ctx allows, so that a CachingClient can stand in for the
+// Client it decorates. Live responses are returned whole; only the content of
+// a response is recorded, so cached ones are rebuilt from it, without usage.
+// Complete also tells where the response came from.
+func (c *CachingClient) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	result, err := c.Complete(ctx, req)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	completion := result.Completion
+	if len(completion.Choices) > 0 {
+		completion.Choices[0].Message.Content = result.Response
+	}
+	return completion, nil
+}
+
+// chatCompletion returns a chat completion response with content as its only
+// choice.
+func chatCompletion(model string, created int64, content string) openai.ChatCompletionResponse {
+	return openai.ChatCompletionResponse{
+		Object:  "chat.completion",
+		Created: created,
+		Model:   model,
+		Choices: []openai.ChatCompletionChoice{{
+			Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content},
+			FinishReason: openai.FinishReasonStop,
+		}},
+	}
+}
@@ -0,0 +1,138 @@
+package llmcache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrapClient(t *testing.T) {
+	var calls int
+	var gateway string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		gateway = r.Header.Get("Helicone-Auth")
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{
+			Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "wrapped"},
+		}}})
+	}))
+	defer server.Close()
+
+	config := openai.DefaultConfig("test-key")
+	config.BaseURL = server.URL
+	config.HTTPClient = withFixedHeaders(http.DefaultClient, http.Header{"Helicone-Auth": {"Bearer gateway"}})
+	path := filepath.Join(t.TempDir(), "cache.json")
+	client := WrapClient(openai.NewClientWithConfig(config), WithCacheFile(path))
+	defer client.Close()
+
+	req := openai.ChatCompletionRequest{Model: openai.GPT3Dot5Turbo, Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}}}
+	for i := 0; i < 2; i++ {
+		response, _, err := client.getResponse(context.Background(), req)
+		assert.NoError(t, err)
+		assert.Equal(t, "wrapped", response)
+	}
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "Bearer gateway", gateway)
+	assert.NoError(t, client.Flush())
+
+	cache, err := loadCacheFrom(path)
+	assert.NoError(t, err)
+	assert.Len(t, cache.Responses, 1)
+
+	uncached := WrapClient(openai.NewClientWithConfig(config), WithCacheFile(path), WithoutCaching())
+	defer uncached.Close()
+	_, _, err = uncached.getResponse(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+// mockClient answers chat completions with a fixed reply and embeds each input
+// as its length, counting the calls.
+type mockClient struct {
+	reply string
+	calls int
+}
+
+func (m *mockClient) CreateChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	m.calls++
+	return openai.ChatCompletionResponse{Model: req.Model, Choices: []openai.ChatCompletionChoice{{
+		Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: m.reply},
+	}}}, nil
+}
+
+func (m *mockClient) CreateChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest) (*openai.ChatCompletionStream, error) {
+	return nil, errors.New("mock doesn't stream")
+}
+
+func (m *mockClient) CreateEmbeddings(ctx context.Context, conv openai.EmbeddingRequestConverter) (openai.EmbeddingResponse, error) {
+	m.calls++
+	req := conv.Convert()
+	var resp openai.EmbeddingResponse
+	for i, input := range req.Input.([]string) {
+		resp.Data = append(resp.Data, openai.Embedding{Index: i, Embedding: []float32{float32(len(input))}})
+	}
+	return resp, nil
+}
+
+func (m *mockClient) ListModels(ctx context.Context) (openai.ModelsList, error) {
+	return openai.ModelsList{}, nil
+}
+
+func TestWrapClientDecoratesMock(t *testing.T) {
+	mock := &mockClient{reply: "mocked"}
+	client := WrapClient(mock, WithCacheFile(filepath.Join(t.TempDir(), "cache.json")))
+	defer client.Close()
+
+	req := openai.ChatCompletionRequest{Model: openai.GPT3Dot5Turbo, Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}}}
+	for i := 0; i < 2; i++ {
+		resp, err := client.CreateChatCompletion(context.Background(), req)
+		assert.NoError(t, err)
+		assert.Equal(t, "mocked", resp.Choices[0].Message.Content)
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := client.CreateEmbeddings(context.Background(), openai.EmbeddingRequest{Input: []string{"abc"}, Model: openai.SmallEmbedding3})
+		assert.NoError(t, err)
+		assert.Equal(t, []float32{3}, resp.Data[0].Embedding)
+	}
+	assert.Equal(t, 2, mock.calls)
+}
+
+func TestWrapClientHasTheDefaultsOfNewCachingClient(t *testing.T) {
+	fake := newFakeOpenAI(t)
+	config := openai.DefaultConfig("test-key")
+	config.BaseURL = fake.server.URL
+	dir := t.TempDir()
+	created := NewCachingClientWithConfig(config, true, DefaultCacheSizeLimit)
+	created.store = newFileStore(filepath.Join(dir, "created.json"))
+	defer created.Close()
+	wrapped := WrapClient(openai.NewClientWithConfig(config), WithCacheFile(filepath.Join(dir, "wrapped.json")))
+	defer wrapped.Close()
+
+	req := openai.ChatCompletionRequest{Model: "gpt-4o-mini", Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "cafe\u0301\r\n"}}}
+	fake.reply = func(req openai.ChatCompletionRequest) string { return req.Messages[0].Content }
+	var hashes, responses []string
+	for _, client := range []*CachingClient{created, wrapped} {
+		result, err := client.Complete(context.Background(), req)
+		assert.NoError(t, err)
+		hashes = append(hashes, result.Hash)
+		responses = append(responses, result.Response)
+	}
+	assert.Equal(t, hashes[0], hashes[1], "both clients key a request alike")
+	assert.Equal(t, []string{"caf\u00e9\n", "caf\u00e9\n"}, responses, "both clients normalize responses")
+
+	invalid := req
+	invalid.MaxTokens = 1 << 20
+	for _, client := range []*CachingClient{created, wrapped} {
+		_, err := client.Complete(context.Background(), invalid)
+		assert.ErrorIs(t, err, ErrRequestInvalid)
+	}
+	assert.Equal(t, 2, fake.Calls(), "invalid requests are never sent")
+	assert.Equal(t, created.hot.limit, wrapped.hot.limit)
+}